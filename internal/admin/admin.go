@@ -0,0 +1,102 @@
+/*
+Package admin contains endpoints for operators served on the admin listener. They must not be
+exposed to instances.
+*/
+package admin
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+)
+
+// Flusher is a cache that can evict its entries.
+type Flusher interface {
+	// Flush evicts the entries for ip, or every entry if ip is empty, and returns the number of
+	// entries evicted.
+	Flush(ip string) int
+}
+
+// FlushResponse is the response body of the flush cache endpoint.
+type FlushResponse struct {
+	// Evicted is the total number of entries evicted across all caches.
+	Evicted int `json:"evicted"`
+}
+
+// ConfigureFlushCache configures router with a POST /admin/flush-cache endpoint that flushes
+// every cache so operators can force a refresh after updating a hardware record. The optional ip
+// query parameter scopes the flush to a single IP. Requests must present token as a bearer token.
+func ConfigureFlushCache(router gin.IRouter, token string, logger logr.Logger, caches ...Flusher) {
+	router.POST("/admin/flush-cache", Authenticate(token), func(ctx *gin.Context) {
+		ip := ctx.Query("ip")
+		if ip != "" && net.ParseIP(ip) == nil {
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid ip: " + ip})
+			return
+		}
+
+		var resp FlushResponse
+		for _, c := range caches {
+			resp.Evicted += c.Flush(ip)
+		}
+
+		logger.Info("Flushed caches", "ip", ip, "evicted", resp.Evicted)
+
+		ctx.JSON(http.StatusOK, resp)
+	})
+}
+
+// ConfigureReload configures router with a POST /admin/reload endpoint that calls reload so
+// operators can apply configuration changes without restarting. If reload returns an error, the
+// configuration is considered rejected and the error is returned with a 400. Requests must present
+// token as a bearer token.
+func ConfigureReload(router gin.IRouter, token string, logger logr.Logger, reload func() error) {
+	router.POST("/admin/reload", Authenticate(token), func(ctx *gin.Context) {
+		if err := reload(); err != nil {
+			logger.Error(err, "Rejected configuration reload")
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Info("Reloaded configuration")
+
+		ctx.Status(http.StatusNoContent)
+	})
+}
+
+// pprofProfiles are the runtime profiles served by ConfigurePprof in addition to the CPU profile
+// and execution trace.
+var pprofProfiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+// ConfigurePprof configures router with the net/http/pprof endpoints beneath /debug/pprof/ so
+// operators can profile Hegel, for example during boot storms. Profiles disclose details of the
+// process and the CPU profile and trace are costly to produce so they must never be served to
+// instances.
+func ConfigurePprof(router gin.IRouter) {
+	router.GET("/debug/pprof/", gin.WrapF(pprof.Index))
+	router.GET("/debug/pprof/cmdline", gin.WrapF(pprof.Cmdline))
+	router.GET("/debug/pprof/profile", gin.WrapF(pprof.Profile))
+	router.GET("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
+	router.POST("/debug/pprof/symbol", gin.WrapF(pprof.Symbol))
+	router.GET("/debug/pprof/trace", gin.WrapF(pprof.Trace))
+	for _, name := range pprofProfiles {
+		router.GET("/debug/pprof/"+name, gin.WrapH(pprof.Handler(name)))
+	}
+}
+
+// Authenticate returns a handler that aborts requests that don't present token as a bearer token
+// in the Authorization header. An empty token rejects every request.
+func Authenticate(token string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		presented, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		ctx.Next()
+	}
+}
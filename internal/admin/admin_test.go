@@ -0,0 +1,261 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/tinkerbell/hegel/internal/admin"
+	"github.com/tinkerbell/hegel/internal/backend/negativecache"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestFlushCache(t *testing.T) {
+	client := &fakeClient{}
+	cache := negativecache.New(client, time.Hour, prometheus.NewRegistry())
+
+	router := gin.New()
+	ConfigureFlushCache(router, "secret", logr.Discard(), cache)
+
+	lookup := func(ip string) {
+		t.Helper()
+		if _, err := cache.GetEC2Instance(context.Background(), ip); !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected ErrInstanceNotFound; Received: %v", err)
+		}
+	}
+
+	lookup("10.10.10.10")
+	lookup("10.10.10.11")
+	lookup("10.10.10.10")
+	if client.calls != 2 {
+		t.Fatalf("Expected 2 backend calls; Received: %v", client.calls)
+	}
+
+	// A scoped flush only evicts the requested IP.
+	expectEvicted(t, router, "/admin/flush-cache?ip=10.10.10.10", 1)
+
+	lookup("10.10.10.10")
+	lookup("10.10.10.11")
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+
+	// An unscoped flush evicts everything.
+	expectEvicted(t, router, "/admin/flush-cache", 2)
+
+	lookup("10.10.10.10")
+	lookup("10.10.10.11")
+	if client.calls != 5 {
+		t.Fatalf("Expected 5 backend calls; Received: %v", client.calls)
+	}
+}
+
+func TestFlushCacheRejectsRequests(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Path          string
+		Authorization string
+		Expect        int
+	}{
+		{
+			Name:   "NoToken",
+			Path:   "/admin/flush-cache",
+			Expect: http.StatusUnauthorized,
+		},
+		{
+			Name:          "WrongToken",
+			Path:          "/admin/flush-cache",
+			Authorization: "Bearer wrong",
+			Expect:        http.StatusUnauthorized,
+		},
+		{
+			Name:          "InvalidIP",
+			Path:          "/admin/flush-cache?ip=invalid",
+			Authorization: "Bearer secret",
+			Expect:        http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			flusher := &fakeFlusher{}
+
+			router := gin.New()
+			ConfigureFlushCache(router, "secret", logr.Discard(), flusher)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, tc.Path, nil)
+			if tc.Authorization != "" {
+				r.Header.Set("Authorization", tc.Authorization)
+			}
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.Expect {
+				t.Fatalf("Expected: %d; Received: %d", tc.Expect, w.Code)
+			}
+
+			if flusher.calls != 0 {
+				t.Fatalf("Expected no flushes; Received: %d", flusher.calls)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Authorization string
+		Err           error
+		ExpectCode    int
+		ExpectCalls   int
+	}{
+		{
+			Name:          "Reloaded",
+			Authorization: "Bearer secret",
+			ExpectCode:    http.StatusNoContent,
+			ExpectCalls:   1,
+		},
+		{
+			Name:          "Rejected",
+			Authorization: "Bearer secret",
+			Err:           errors.New("invalid configuration"),
+			ExpectCode:    http.StatusBadRequest,
+			ExpectCalls:   1,
+		},
+		{
+			Name:       "NoToken",
+			ExpectCode: http.StatusUnauthorized,
+		},
+		{
+			Name:          "WrongToken",
+			Authorization: "Bearer wrong",
+			ExpectCode:    http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var calls int
+			reload := func() error {
+				calls++
+				return tc.Err
+			}
+
+			router := gin.New()
+			ConfigureReload(router, "secret", logr.Discard(), reload)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+			if tc.Authorization != "" {
+				r.Header.Set("Authorization", tc.Authorization)
+			}
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+
+			if calls != tc.ExpectCalls {
+				t.Fatalf("Expected %d reloads; Received: %d", tc.ExpectCalls, calls)
+			}
+		})
+	}
+}
+
+func expectEvicted(t *testing.T, router *gin.Engine, path string, evicted int) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, path, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var resp FlushResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Evicted != evicted {
+		t.Fatalf("Expected %d evicted; Received: %d", evicted, resp.Evicted)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	router := gin.New()
+	ConfigurePprof(router)
+
+	cases := []struct {
+		Path         string
+		ExpectPrefix string
+	}{
+		{Path: "/debug/pprof/", ExpectPrefix: "<html>"},
+		{Path: "/debug/pprof/cmdline"},
+		{Path: "/debug/pprof/goroutine?debug=1", ExpectPrefix: "goroutine profile:"},
+		{Path: "/debug/pprof/heap?debug=1", ExpectPrefix: "heap profile:"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+			if !strings.HasPrefix(w.Body.String(), tc.ExpectPrefix) {
+				t.Fatalf("Expected body prefix: %q; Received: %.40q", tc.ExpectPrefix, w.Body.String())
+			}
+		})
+	}
+}
+
+type fakeFlusher struct {
+	calls int
+}
+
+func (f *fakeFlusher) Flush(string) int {
+	f.calls++
+	return 0
+}
+
+// fakeClient never finds an instance.
+type fakeClient struct {
+	calls int
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	f.calls++
+	return ec2.Instance{}, ec2.ErrInstanceNotFound
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return ec2.Instance{}, ec2.ErrInstanceNotFound
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return true
+}
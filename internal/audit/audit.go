@@ -0,0 +1,153 @@
+/*
+Package audit provides a machine readable log of the requests Hegel serves so operators can replay
+exactly what an instance requested, and what it received, when debugging provisioning offline.
+Unlike request logging, entries are written as newline delimited JSON and identify responses by
+a hash of their body.
+*/
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/http/request"
+)
+
+// DefaultSampleRate is the fraction of requests recorded if no sample rate is configured.
+const DefaultSampleRate = 1.0
+
+// Entry is an audit log record describing a request and its response.
+type Entry struct {
+	// Time is when the request was received.
+	Time time.Time `json:"time"`
+
+	// ClientIP is the IP address the instance was identified by.
+	ClientIP string `json:"client_ip"`
+
+	Method string `json:"method"`
+
+	// Path is the requested path including any query.
+	Path string `json:"path"`
+
+	Status int `json:"status"`
+
+	// ResponseSHA256 is the hex encoded SHA-256 hash of the response body.
+	ResponseSHA256 string `json:"response_sha256"`
+}
+
+// Log writes an Entry for each sampled request to a writer. It is safe for concurrent use.
+type Log struct {
+	logger logr.Logger
+	rate   float64
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Option configures a Log.
+type Option func(*Log)
+
+// WithSampleRate configures the fraction of requests recorded, between 0 and 1, so boot storms
+// don't produce huge logs. Defaults to DefaultSampleRate.
+func WithSampleRate(rate float64) Option {
+	return func(l *Log) {
+		l.rate = rate
+	}
+}
+
+// New creates a Log that writes entries to w. Failures to write entries are logged to logger.
+func New(w io.Writer, logger logr.Logger, opts ...Option) *Log {
+	l := &Log{
+		logger: logger,
+		rate:   DefaultSampleRate,
+		enc:    json.NewEncoder(w),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// Middleware creates a gin middleware that records an Entry for sampled requests once they're
+// served. It should precede middleware that identify the instance so requests they abort are
+// recorded; the client IP is read after the request is served so it reflects the identified
+// instance.
+func (l *Log) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !l.sample() {
+			ctx.Next()
+			return
+		}
+
+		start := time.Now()
+		w := &hashWriter{ResponseWriter: ctx.Writer, hash: sha256.New()}
+		ctx.Writer = w
+
+		ctx.Next()
+
+		// The remote address may be invalid, for example from a Unix socket without an
+		// identity, in which case the raw address is recorded.
+		clientIP, err := request.RemoteAddrIP(ctx.Request)
+		if err != nil {
+			clientIP = ctx.Request.RemoteAddr
+		}
+
+		l.write(Entry{
+			Time:           start.UTC(),
+			ClientIP:       clientIP,
+			Method:         ctx.Request.Method,
+			Path:           ctx.Request.URL.RequestURI(),
+			Status:         ctx.Writer.Status(),
+			ResponseSHA256: hex.EncodeToString(w.hash.Sum(nil)),
+		})
+	}
+}
+
+// sample reports whether a request should be recorded.
+func (l *Log) sample() bool {
+	switch {
+	case l.rate >= 1:
+		return true
+	case l.rate <= 0:
+		return false
+	default:
+		return rand.Float64() < l.rate //nolint:gosec // Sampling doesn't need a secure source.
+	}
+}
+
+func (l *Log) write(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.enc.Encode(e); err != nil {
+		l.logger.Error(err, "Failed to write audit log entry", "path", e.Path)
+	}
+}
+
+// hashWriter hashes the response body as it's written.
+type hashWriter struct {
+	gin.ResponseWriter
+	hash hash.Hash
+}
+
+func (w *hashWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.hash.Write(b[:n])
+	return n, err
+}
+
+func (w *hashWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.hash.Write([]byte(s[:n]))
+	return n, err
+}
@@ -0,0 +1,123 @@
+package audit_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	. "github.com/tinkerbell/hegel/internal/audit"
+)
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	var buf bytes.Buffer
+	router := gin.New()
+	router.Use(New(&buf, logr.Discard()).Middleware())
+
+	// Identify the instance after the audit middleware so the recorded client IP is the
+	// identified instance.
+	router.Use(func(ctx *gin.Context) {
+		ctx.Request.RemoteAddr = "10.10.10.10:0"
+	})
+	router.GET("/2009-04-04/meta-data/hostname", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "sm01")
+	})
+	router.NoRoute(func(ctx *gin.Context) {
+		ctx.String(http.StatusNotFound, "metadata item not found")
+	})
+
+	for _, path := range []string{"/2009-04-04/meta-data/hostname", "/2009-04-04/meta-data/bogus?recursive=true"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+
+	expect := []Entry{
+		{
+			ClientIP:       "10.10.10.10",
+			Method:         http.MethodGet,
+			Path:           "/2009-04-04/meta-data/hostname",
+			Status:         http.StatusOK,
+			ResponseSHA256: sum("sm01"),
+		},
+		{
+			ClientIP:       "10.10.10.10",
+			Method:         http.MethodGet,
+			Path:           "/2009-04-04/meta-data/bogus?recursive=true",
+			Status:         http.StatusNotFound,
+			ResponseSHA256: sum("metadata item not found"),
+		},
+	}
+
+	entries := decode(t, &buf)
+	if !cmp.Equal(entries, expect, cmpopts.IgnoreFields(Entry{}, "Time")) {
+		t.Fatal(cmp.Diff(expect, entries, cmpopts.IgnoreFields(Entry{}, "Time")))
+	}
+
+	for _, e := range entries {
+		if e.Time.IsZero() {
+			t.Fatalf("Expected entry time; Received: %v", e)
+		}
+	}
+}
+
+func TestMiddlewareSampleRate(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	cases := []struct {
+		Name     string
+		Rate     float64
+		Expected int
+	}{
+		{Name: "All", Rate: 1, Expected: 10},
+		{Name: "None", Rate: 0, Expected: 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			router := gin.New()
+			router.Use(New(&buf, logr.Discard(), WithSampleRate(tc.Rate)).Middleware())
+			router.GET("/", func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, "ok")
+			})
+
+			for i := 0; i < 10; i++ {
+				router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			if entries := decode(t, &buf); len(entries) != tc.Expected {
+				t.Fatalf("Expected: %v entries; Received: %v", tc.Expected, len(entries))
+			}
+		})
+	}
+}
+
+// decode decodes the newline delimited entries in buf.
+func decode(t *testing.T, buf *bytes.Buffer) []Entry {
+	t.Helper()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
@@ -0,0 +1,62 @@
+/*
+Package ambiguous reports lookups that match more than one hardware record.
+
+Records should never share an identifier such as an IP. When they do, which record a lookup returns
+is hard to reason about. Backends resolve such lookups with a Reporter so the same record is
+returned every time and operators are told about the misconfiguration through logs and metrics.
+*/
+package ambiguous
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+// errAmbiguous is logged for lookups matching more than one record.
+var errAmbiguous = errors.New("lookup matched multiple hardware records")
+
+// Reporter chooses a record for lookups matching more than one record and reports them. A nil
+// *Reporter chooses records without reporting.
+type Reporter struct {
+	logger  logr.Logger
+	lookups prometheus.Counter
+}
+
+// NewReporter creates a Reporter that logs ambiguous lookups with logger and counts them with a
+// counter registered with registrar.
+func NewReporter(logger logr.Logger, registrar prometheus.Registerer) *Reporter {
+	return &Reporter{logger: logger, lookups: metrics.RegisterAmbiguousLookups(registrar)}
+}
+
+// Choose returns the index in ids of the record chosen for a lookup of value, such as an IP,
+// that matched the records identified by ids. The record with the lowest identifier is chosen so
+// the choice doesn't depend on the order records are retrieved in. Records with equal identifiers
+// are chosen in order. If more than one record matched, the lookup is logged with every identifier
+// and counted.
+func (r *Reporter) Choose(value string, ids []string) int {
+	if len(ids) < 2 {
+		return 0
+	}
+
+	order := make([]int, len(ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return ids[order[a]] < ids[order[b]] })
+	chosen := order[0]
+
+	if r != nil {
+		r.logger.Error(errAmbiguous, "Serving the record with the lowest identifier",
+			"value", value,
+			"records", ids,
+			"chosen", ids[chosen],
+		)
+		r.lookups.Inc()
+	}
+
+	return chosen
+}
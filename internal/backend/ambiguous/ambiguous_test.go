@@ -0,0 +1,70 @@
+package ambiguous_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/ambiguous"
+)
+
+func TestChoose(t *testing.T) {
+	cases := []struct {
+		Name         string
+		IDs          []string
+		Expect       int
+		ExpectReport bool
+	}{
+		{Name: "Single", IDs: []string{"sm02"}, Expect: 0},
+		{Name: "LowestFirst", IDs: []string{"sm01", "sm02"}, Expect: 0, ExpectReport: true},
+		{Name: "LowestLast", IDs: []string{"sm03", "sm02", "sm01"}, Expect: 2, ExpectReport: true},
+		{Name: "EqualIDsInOrder", IDs: []string{"sm02", "sm01", "sm01"}, Expect: 1, ExpectReport: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var logged []string
+			logger := funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})
+
+			registry := prometheus.NewRegistry()
+			r := NewReporter(logger, registry)
+
+			if chosen := r.Choose("10.10.10.10", tc.IDs); chosen != tc.Expect {
+				t.Fatalf("Expected: %d; Received: %d", tc.Expect, chosen)
+			}
+
+			count := "0"
+			if tc.ExpectReport {
+				count = "1"
+
+				if len(logged) != 1 {
+					t.Fatalf("Expected 1 log; Received: %v", logged)
+				}
+				for _, id := range tc.IDs {
+					if !strings.Contains(logged[0], id) {
+						t.Fatalf("Expected log to identify %v; Received: %v", id, logged[0])
+					}
+				}
+			} else if len(logged) != 0 {
+				t.Fatalf("Unexpected log: %v", logged)
+			}
+
+			expect := `
+# HELP backend_ambiguous_lookups_total Count of instance lookups that matched more than one hardware record
+# TYPE backend_ambiguous_lookups_total counter
+backend_ambiguous_lookups_total ` + count + "\n"
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestChooseNilReporter(t *testing.T) {
+	var r *Reporter
+	if chosen := r.Choose("10.10.10.10", []string{"sm02", "sm01"}); chosen != 1 {
+		t.Fatalf("Expected: 1; Received: %d", chosen)
+	}
+}
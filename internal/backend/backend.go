@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	"github.com/tinkerbell/hegel/internal/backend/flatfile"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
@@ -36,21 +37,32 @@ func New(ctx context.Context, opts Options) (Client, error) {
 
 	switch {
 	case opts.Flatfile != nil:
-		return flatfile.FromYAMLFile(opts.Flatfile.Path)
+		fileclient, err := flatfile.FromYAMLFile(opts.Flatfile.Path)
+		if err != nil {
+			return nil, err
+		}
+		fileclient.Ambiguous = opts.Ambiguous
+		return fileclient, nil
 
 	case opts.Kubernetes != nil:
 		kubeclient, err := kubernetes.NewBackend(ctx, kubernetes.Config{
 			Kubeconfig:       opts.Kubernetes.Kubeconfig,
 			APIServerAddress: opts.Kubernetes.APIServerAddress,
 			Namespace:        opts.Kubernetes.Namespace,
+
+			UserDataFragmentAnnotations: opts.Kubernetes.UserDataFragmentAnnotations,
+			FieldMappings:               opts.Kubernetes.FieldMappings,
+			UserDataStateMappings:       opts.Kubernetes.UserDataStateMappings,
+			UserDataPhaseMappings:       opts.Kubernetes.UserDataPhaseMappings,
+			CacheSyncTimeout:            opts.Kubernetes.CacheSyncTimeout,
+			Ambiguous:                   opts.Ambiguous,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("kubernetes client: %v", err)
 		}
-		if ok := kubeclient.WaitForCacheSync(ctx); !ok {
-			return nil, errors.New("failed to sync kubernetes cache")
-		}
 
+		// Unless a sync timeout is configured, the cache syncs in the background. Until it has,
+		// the backend isn't ready and lookups fail with ec2.ErrBackendNotReady.
 		return kubeclient, nil
 
 	default:
@@ -63,6 +75,10 @@ func New(ctx context.Context, opts Options) (Client, error) {
 type Options struct {
 	Flatfile   *Flatfile
 	Kubernetes *kubernetes.Config
+
+	// Ambiguous reports lookups by IP that match more than one hardware record. It applies to
+	// whichever backend is configured. Optional.
+	Ambiguous *ambiguous.Reporter
 }
 
 func (o Options) validate() error {
@@ -3,10 +3,23 @@ package backend_test
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
 	. "github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func TestNew(t *testing.T) {
@@ -44,3 +57,154 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+// TestDataModelEquivalence validates equivalent data from each backend data model is served the
+// same by the EC2 frontend.
+func TestDataModelEquivalence(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	ff, err := flatfile.FromYAML(strings.NewReader(`
+- userdata: "#cloud-config"
+  macs: ["3C-EC-EF-4C-4F-54"]
+  metadata:
+    id: "sm01"
+    hostname: "sm01"
+    localHostname: "sm01"
+    plan: "c3.small.x86"
+    facility: "sv15"
+    tags: ["tag1", "tag2"]
+    publicKeys: ["key1", "key2"]
+    ipv4:
+      local: "10.10.10.10"
+      public: "139.178.0.10"
+    ipv6:
+      public: "2001:0db8:0:0:0:0:0:1"
+    os:
+      slug: "ubuntu_22_04"
+      distro: "ubuntu"
+      version: "22.04"
+      imageTag: "ubuntu-22.04"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ffInstance, err := ff.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userData := "#cloud-config"
+	kubeInstance := kubernetes.ToEC2Instance(tinkv1.Hardware{
+		Spec: tinkv1.HardwareSpec{
+			UserData: &userData,
+			Interfaces: []tinkv1.Interface{
+				{DHCP: &tinkv1.DHCP{MAC: "3c:ec:ef:4c:4f:54", IP: &tinkv1.IP{Address: "10.10.10.10"}}},
+			},
+			Metadata: &tinkv1.HardwareMetadata{
+				Facility: &tinkv1.MetadataFacility{PlanSlug: "c3.small.x86", FacilityCode: "sv15"},
+				Instance: &tinkv1.MetadataInstance{
+					ID:       "sm01",
+					Hostname: "sm01",
+					Tags:     []string{"tag1", "tag2"},
+					SSHKeys:  []string{"key1", "key2"},
+					OperatingSystem: &tinkv1.MetadataInstanceOperatingSystem{
+						Slug:     "ubuntu_22_04",
+						Distro:   "ubuntu",
+						Version:  "22.04",
+						ImageTag: "ubuntu-22.04",
+					},
+					Ips: []*tinkv1.MetadataInstanceIP{
+						{Address: "139.178.0.10", Family: 4, Public: true},
+						{Address: "10.10.10.10", Family: 4},
+						{Address: "2001:db8::1", Family: 6, Public: true},
+					},
+				},
+			},
+		},
+	})
+
+	if !cmp.Equal(ffInstance, kubeInstance) {
+		t.Fatal(cmp.Diff(ffInstance, kubeInstance))
+	}
+
+	serve := func(instance ec2.Instance, path string) (int, string) {
+		router := gin.New()
+		ec2.New(staticClient{instance}).Configure(router)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	paths := []string{
+		"/2009-04-04/meta-data/public-keys/0/openssh-key",
+		"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/local-ipv4s",
+		"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/public-ipv4s",
+	}
+	for _, p := range ec2.Paths() {
+		if !strings.Contains(p, ":") {
+			paths = append(paths, p)
+		}
+	}
+
+	for _, path := range paths {
+		ffStatus, ffBody := serve(ffInstance, path)
+		kubeStatus, kubeBody := serve(kubeInstance, path)
+		if ffStatus != kubeStatus || ffBody != kubeBody {
+			t.Fatalf("%v\nflatfile: %d %q\nkubernetes: %d %q", path, ffStatus, ffBody, kubeStatus, kubeBody)
+		}
+	}
+}
+
+// staticClient serves the same instance for every lookup.
+type staticClient struct {
+	instance ec2.Instance
+}
+
+func (c staticClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	return c.instance, nil
+}
+
+func TestIsTransient(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Err       error
+		Transient bool
+	}{
+		{Name: "Nil"},
+		{Name: "NotFound", Err: ec2.ErrInstanceNotFound},
+		{Name: "NotReady", Err: ec2.ErrBackendNotReady},
+		{Name: "Permanent", Err: errors.New("unsupported")},
+		{Name: "Canceled", Err: context.Canceled},
+		{Name: "DeadlineExceeded", Err: fmt.Errorf("list: %w", context.DeadlineExceeded)},
+		{Name: "ConnectionReset", Err: fmt.Errorf("list: %w", syscall.ECONNRESET), Transient: true},
+		{Name: "ConnectionRefused", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, Transient: true},
+		{Name: "UnexpectedEOF", Err: io.ErrUnexpectedEOF, Transient: true},
+		{Name: "TooManyRequests", Err: apierrors.NewTooManyRequests("slow down", 1), Transient: true},
+		{Name: "ServiceUnavailable", Err: apierrors.NewServiceUnavailable("unavailable"), Transient: true},
+		{Name: "InternalError", Err: apierrors.NewInternalError(errors.New("boom")), Transient: true},
+		{Name: "Forbidden", Err: apierrors.NewForbidden(schema.GroupResource{}, "hw", errors.New("denied"))},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if transient := IsTransient(tc.Err); transient != tc.Transient {
+				t.Fatalf("Expected: %v; Received: %v", tc.Transient, transient)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Package breaker provides a backend wrapper that stops instance lookups while the wrapped backend is
+failing.
+
+When the backend is down every request still attempts a lookup that is doomed to fail, adding
+latency for clients and load on a backend that may be trying to recover. A circuit breaker opens
+after consecutive failures, failing lookups immediately for a cooldown, then lets a single trial
+lookup through to test whether the backend has recovered.
+*/
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// DefaultCooldown is the default time lookups fail immediately once the breaker opens.
+const DefaultCooldown = 10 * time.Second
+
+// ErrOpen indicates a lookup was rejected because the breaker is open. It wraps
+// ec2.ErrBackendNotReady so frontends ask clients to retry.
+var ErrOpen = fmt.Errorf("backend circuit breaker open: %w", ec2.ErrBackendNotReady)
+
+// State is the state of a breaker. Its value is the value of the state metric.
+type State int
+
+const (
+	// Closed breakers perform every lookup.
+	Closed State = iota
+
+	// Open breakers fail every lookup with ErrOpen.
+	Open
+
+	// HalfOpen breakers perform a single trial lookup, failing others with ErrOpen, to test
+	// whether the backend has recovered.
+	HalfOpen
+)
+
+// String satisfies fmt.Stringer.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Backend wraps a backend.Client with a circuit breaker. Only lookups that fail with transient
+// errors, as determined by backend.IsTransient, or that time out count as failures. Lookups that
+// fail with other errors, such as the instance not existing or an unsupported lookup, succeed as
+// far as the breaker is concerned as the backend answered them. Lookups that fail because the
+// backend isn't ready or the client abandoned them are ignored.
+type Backend struct {
+	backend.Client
+
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+	logger    logr.Logger
+
+	state    prometheus.Gauge
+	rejected prometheus.Counter
+
+	mu       sync.Mutex
+	current  State
+	failures int
+	retryAt  time.Time
+	trial    bool
+}
+
+// New creates a Backend that opens after threshold consecutive failed lookups and stays open for
+// cooldown before performing a trial lookup. If the trial succeeds the breaker closes, else it
+// opens for another cooldown. It registers breaker metrics with registrar.
+func New(
+	client backend.Client,
+	threshold int,
+	cooldown time.Duration,
+	logger logr.Logger,
+	registrar prometheus.Registerer,
+) *Backend {
+	state := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "backend_circuit_breaker_state",
+		Help: "State of the backend circuit breaker: 0 closed, 1 open, 2 half-open",
+	})
+
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_circuit_breaker_rejected_total",
+		Help: "Count of instance lookups rejected because the backend circuit breaker was open",
+	})
+
+	registrar.MustRegister(state, rejected)
+
+	return &Backend{
+		Client:    client,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		logger:    logger,
+		state:     state,
+		rejected:  rejected,
+	}
+}
+
+// State returns the current state of b.
+func (b *Backend) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return do(b, func() (ec2.Instance, error) {
+		return b.Client.GetEC2Instance(ctx, ip)
+	})
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	return do(b, func() (ec2.Instance, error) {
+		return b.Client.GetEC2InstanceByMAC(ctx, mac)
+	})
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return do(b, func() (ec2.Instance, error) {
+		return ec2.GetInstanceByID(ctx, b.Client, id)
+	})
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return do(b, func() (ec2.Instance, error) {
+		return ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+	})
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return do(b, func() (hack.Instance, error) {
+		return b.Client.GetHackInstance(ctx, ip)
+	})
+}
+
+// GetNativeMetadata satisfies native.Client.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return do(b, func() ([]byte, error) {
+		return native.GetMetadata(ctx, b.Client, ip)
+	})
+}
+
+// do performs lookup if the breaker allows it and records the outcome.
+func do[T any](b *Backend, lookup func() (T, error)) (T, error) {
+	trial, err := b.allow()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	v, err := lookup()
+	b.record(trial, err)
+
+	return v, err
+}
+
+// allow returns ErrOpen if a lookup may not be performed. If the lookup is the trial lookup of a
+// half-open breaker it returns true.
+func (b *Backend) allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.current == Open {
+		if b.now().Before(b.retryAt) {
+			b.rejected.Inc()
+			return false, ErrOpen
+		}
+		b.transition(HalfOpen, nil)
+	}
+
+	if b.current == HalfOpen {
+		if b.trial {
+			b.rejected.Inc()
+			return false, ErrOpen
+		}
+		b.trial = true
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record updates the breaker with the outcome of a lookup. Lookups that began before the breaker
+// opened may complete while it's open or half-open; only the trial lookup changes the state of a
+// breaker that isn't closed.
+func (b *Backend) record(trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	failed := failure(err)
+
+	switch {
+	case ignored(err):
+		// The trial didn't tell us anything so the next lookup becomes the trial.
+	case failed && trial:
+		b.open(err)
+	case failed && b.current == Closed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open(err)
+		}
+	case trial:
+		b.transition(Closed, nil)
+	case b.current == Closed:
+		b.failures = 0
+	}
+}
+
+func (b *Backend) open(err error) {
+	b.retryAt = b.now().Add(b.cooldown)
+	b.transition(Open, err)
+}
+
+func (b *Backend) transition(to State, err error) {
+	b.current = to
+	b.failures = 0
+	b.state.Set(float64(to))
+
+	switch to {
+	case Open:
+		b.logger.Error(err, "Backend circuit breaker opened", "cooldown", b.cooldown)
+	case Closed:
+		b.logger.Info("Backend circuit breaker closed")
+	}
+}
+
+// failure returns true if err indicates the backend failed to answer a lookup. Lookups that time
+// out are failures as a hung backend is the main case the breaker guards against.
+func failure(err error) bool {
+	return backend.IsTransient(err) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// ignored returns true if err doesn't indicate whether the backend is healthy. Backends that
+// aren't ready gate readiness separately and canceled lookups were abandoned by the client.
+func ignored(err error) bool {
+	return errors.Is(err, ec2.ErrBackendNotReady) || errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,258 @@
+package breaker_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/breaker"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+var errConnection = fmt.Errorf("list hardware: %w", syscall.ECONNREFUSED)
+
+func TestBreakerOpensCoolsDownAndRecovers(t *testing.T) {
+	client := &fakeClient{errs: []error{errConnection, errConnection, errConnection}}
+	registry := prometheus.NewRegistry()
+
+	now := time.Now()
+	b := New(client, 2, time.Minute, logr.Discard(), registry)
+	b.SetClock(func() time.Time { return now })
+
+	lookup := func(expect error) {
+		t.Helper()
+		if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, expect) {
+			t.Fatalf("Expected: %v; Received: %v", expect, err)
+		}
+	}
+
+	// Failures below the threshold leave the breaker closed.
+	lookup(errConnection)
+	expectState(t, b, Closed)
+
+	// Reaching the threshold opens the breaker.
+	lookup(errConnection)
+	expectState(t, b, Open)
+	expectGauge(t, registry, Open, 0)
+
+	// Open breakers fail fast without querying the backend.
+	lookup(ErrOpen)
+	if client.calls != 2 {
+		t.Fatalf("Expected 2 backend calls; Received: %v", client.calls)
+	}
+	expectGauge(t, registry, Open, 1)
+
+	// Once the cooldown elapses a failed trial reopens the breaker for another cooldown.
+	now = now.Add(time.Minute)
+	lookup(errConnection)
+	expectState(t, b, Open)
+
+	now = now.Add(time.Minute - time.Second)
+	lookup(ErrOpen)
+
+	// A successful trial closes the breaker.
+	now = now.Add(time.Second)
+	lookup(nil)
+	expectState(t, b, Closed)
+	expectGauge(t, registry, Closed, 2)
+
+	lookup(nil)
+	if client.calls != 5 {
+		t.Fatalf("Expected 5 backend calls; Received: %v", client.calls)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	client := &fakeClient{errs: []error{errConnection, nil, errConnection}}
+
+	b := New(client, 2, time.Minute, logr.Discard(), prometheus.NewRegistry())
+
+	for i := 0; i < 3; i++ {
+		_, _ = b.GetEC2Instance(context.Background(), "10.10.10.10")
+	}
+
+	// The failures weren't consecutive so the breaker remains closed.
+	expectState(t, b, Closed)
+}
+
+func TestBreakerIgnoresHealthyErrors(t *testing.T) {
+	// Permanent errors, such as an unsupported lookup or hardware that can't be converted, are
+	// answers from a healthy backend.
+	errs := []error{
+		ec2.ErrInstanceNotFound,
+		ec2.ErrBackendNotReady,
+		context.Canceled,
+		errors.New("unsupported"),
+		errors.New("multiple hardware found"),
+	}
+	client := &fakeClient{errs: errs}
+
+	b := New(client, 1, time.Minute, logr.Discard(), prometheus.NewRegistry())
+
+	for _, expect := range errs {
+		if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, expect) {
+			t.Fatalf("Expected: %v; Received: %v", expect, err)
+		}
+		expectState(t, b, Closed)
+	}
+}
+
+func TestBreakerOpensOnTimeouts(t *testing.T) {
+	// A hung backend times lookups out, the main case the breaker guards against.
+	client := &fakeClient{errs: []error{context.DeadlineExceeded, context.DeadlineExceeded}}
+
+	b := New(client, 2, time.Minute, logr.Discard(), prometheus.NewRegistry())
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected: %v; Received: %v", context.DeadlineExceeded, err)
+		}
+	}
+	expectState(t, b, Open)
+}
+
+func TestBreakerNotFoundTrialCloses(t *testing.T) {
+	client := &fakeClient{errs: []error{errConnection, ec2.ErrInstanceNotFound}}
+
+	now := time.Now()
+	b := New(client, 1, time.Minute, logr.Discard(), prometheus.NewRegistry())
+	b.SetClock(func() time.Time { return now })
+
+	_, _ = b.GetEC2Instance(context.Background(), "10.10.10.10")
+	expectState(t, b, Open)
+
+	// The backend answering, even that the instance doesn't exist, shows it has recovered.
+	now = now.Add(time.Minute)
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected: %v; Received: %v", ec2.ErrInstanceNotFound, err)
+	}
+	expectState(t, b, Closed)
+}
+
+func TestBreakerHalfOpenAllowsSingleTrial(t *testing.T) {
+	release := make(chan struct{})
+	client := &fakeClient{errs: []error{errConnection}}
+
+	now := time.Now()
+	b := New(client, 1, time.Minute, logr.Discard(), prometheus.NewRegistry())
+	b.SetClock(func() time.Time { return now })
+
+	_, _ = b.GetEC2Instance(context.Background(), "10.10.10.10")
+	expectState(t, b, Open)
+
+	now = now.Add(time.Minute)
+	client.block(release)
+
+	trial := make(chan error)
+	go func() {
+		_, err := b.GetEC2Instance(context.Background(), "10.10.10.10")
+		trial <- err
+	}()
+
+	// Wait for the trial to reach the backend.
+	<-client.blocked
+
+	expectState(t, b, HalfOpen)
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.11"); !errors.Is(err, ErrOpen) {
+		t.Fatalf("Expected: %v; Received: %v", ErrOpen, err)
+	}
+
+	close(release)
+	if err := <-trial; err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, b, Closed)
+}
+
+func TestErrOpenIsBackendNotReady(t *testing.T) {
+	if !errors.Is(ErrOpen, ec2.ErrBackendNotReady) {
+		t.Fatal("Expected ErrOpen to wrap ec2.ErrBackendNotReady")
+	}
+}
+
+func expectState(t *testing.T, b *Backend, expect State) {
+	t.Helper()
+	if s := b.State(); s != expect {
+		t.Fatalf("Expected state: %v; Received: %v", expect, s)
+	}
+}
+
+func expectGauge(t *testing.T, registry *prometheus.Registry, state State, rejected int) {
+	t.Helper()
+
+	expect := `
+# HELP backend_circuit_breaker_rejected_total Count of instance lookups rejected because the backend circuit breaker was open
+# TYPE backend_circuit_breaker_rejected_total counter
+backend_circuit_breaker_rejected_total ` + strconv.Itoa(rejected) + `
+# HELP backend_circuit_breaker_state State of the backend circuit breaker: 0 closed, 1 open, 2 half-open
+# TYPE backend_circuit_breaker_state gauge
+backend_circuit_breaker_state ` + strconv.Itoa(int(state)) + `
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeClient returns errs in order for each lookup followed by successful lookups.
+type fakeClient struct {
+	errs  []error
+	calls int
+
+	// When release isn't nil, lookups signal blocked then wait for release to be closed.
+	release <-chan struct{}
+	blocked chan struct{}
+}
+
+// block makes subsequent lookups wait for release to be closed.
+func (f *fakeClient) block(release <-chan struct{}) {
+	f.release = release
+	f.blocked = make(chan struct{}, 1)
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) next() (ec2.Instance, error) {
+	if f.release != nil {
+		f.blocked <- struct{}{}
+		<-f.release
+	}
+
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return ec2.Instance{}, err
+	}
+	return ec2.Instance{}, nil
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return true
+}
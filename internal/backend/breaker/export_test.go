@@ -0,0 +1,8 @@
+package breaker
+
+import "time"
+
+// SetClock replaces the clock used by b to determine when the cooldown ends.
+func (b *Backend) SetClock(now func() time.Time) {
+	b.now = now
+}
@@ -0,0 +1,123 @@
+/*
+Package chain provides a backend that falls through a sequence of backends so operators can
+migrate between backends without losing instances that only exist in one of them.
+
+Lookups query each backend in order until one finds the instance. Only ec2.ErrInstanceNotFound,
+and ec2.ErrLookupUnsupported from backends that can't perform the lookup, fall through; any other
+error is returned immediately so a failing backend can't cause an instance to be served from a
+backend with stale data.
+*/
+package chain
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// Backend is a backend.Client that looks instances up in each of a sequence of backends in turn.
+type Backend struct {
+	clients []backend.Client
+	hits    *prometheus.CounterVec
+}
+
+// New creates a Backend that falls through clients in order. clients must contain at least 1
+// client. It registers a counter of lookups answered by each client with registrar.
+func New(clients []backend.Client, registrar prometheus.Registerer) *Backend {
+	hits := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backend_chain_lookups_total",
+			Help: "Count of instance lookups answered by each backend in the chain by position, starting at 0",
+		},
+		[]string{"position"},
+	)
+
+	registrar.MustRegister(hits)
+
+	return &Backend{
+		clients: clients,
+		hits:    hits,
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return lookup(b, func(c backend.Client) (ec2.Instance, error) {
+		return c.GetEC2Instance(ctx, ip)
+	})
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	return lookup(b, func(c backend.Client) (ec2.Instance, error) {
+		return c.GetEC2InstanceByMAC(ctx, mac)
+	})
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return lookup(b, func(c backend.Client) (ec2.Instance, error) {
+		return ec2.GetInstanceByID(ctx, c, id)
+	})
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return lookup(b, func(c backend.Client) (ec2.Instance, error) {
+		return ec2.GetInstanceForTenant(ctx, c, tenant, ip)
+	})
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return lookup(b, func(c backend.Client) (hack.Instance, error) {
+		return c.GetHackInstance(ctx, ip)
+	})
+}
+
+// GetNativeMetadata satisfies native.Client.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return lookup(b, func(c backend.Client) ([]byte, error) {
+		return native.GetMetadata(ctx, c, ip)
+	})
+}
+
+// IsHealthy satisfies healthcheck.Client. The chain is healthy only if every backend is healthy
+// as an unhealthy backend may hide instances.
+func (b *Backend) IsHealthy(ctx context.Context) bool {
+	for _, c := range b.clients {
+		if !c.IsHealthy(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup calls fn with each client in b until it returns something other than
+// ec2.ErrInstanceNotFound or ec2.ErrLookupUnsupported. If every client returns one of them, lookup
+// returns the error of the last client.
+func lookup[T any](b *Backend, fn func(backend.Client) (T, error)) (T, error) {
+	var (
+		instance T
+		err      error
+	)
+
+	for i, c := range b.clients {
+		instance, err = fn(c)
+		if errors.Is(err, ec2.ErrInstanceNotFound) || errors.Is(err, ec2.ErrLookupUnsupported) {
+			continue
+		}
+		if err == nil {
+			b.hits.WithLabelValues(strconv.Itoa(i)).Inc()
+		}
+		return instance, err
+	}
+
+	return instance, err
+}
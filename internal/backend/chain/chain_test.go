@@ -0,0 +1,210 @@
+package chain_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/backend"
+	. "github.com/tinkerbell/hegel/internal/backend/chain"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func TestLookupFallsThrough(t *testing.T) {
+	errBackend := errors.New("backend error")
+
+	cases := []struct {
+		Name            string
+		Primary         *fakeClient
+		Secondary       *fakeClient
+		ExpectedID      string
+		ExpectedError   error
+		ExpectSecondary bool
+	}{
+		{
+			Name:       "PrimaryHit",
+			Primary:    &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "primary"}}},
+			Secondary:  &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}},
+			ExpectedID: "primary",
+		},
+		{
+			Name:            "PrimaryMissSecondaryHit",
+			Primary:         &fakeClient{err: ec2.ErrInstanceNotFound},
+			Secondary:       &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}},
+			ExpectedID:      "secondary",
+			ExpectSecondary: true,
+		},
+		{
+			Name:            "BothMiss",
+			Primary:         &fakeClient{err: ec2.ErrInstanceNotFound},
+			Secondary:       &fakeClient{err: ec2.ErrInstanceNotFound},
+			ExpectedError:   ec2.ErrInstanceNotFound,
+			ExpectSecondary: true,
+		},
+		{
+			Name:            "PrimaryUnsupportedSecondaryHit",
+			Primary:         &fakeClient{err: ec2.ErrLookupUnsupported},
+			Secondary:       &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}},
+			ExpectedID:      "secondary",
+			ExpectSecondary: true,
+		},
+		{
+			// Only not found falls through so a failing primary can't serve secondary data.
+			Name:          "PrimaryError",
+			Primary:       &fakeClient{err: errBackend},
+			Secondary:     &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}},
+			ExpectedError: errBackend,
+		},
+		{
+			Name:          "PrimaryNotReady",
+			Primary:       &fakeClient{err: ec2.ErrBackendNotReady},
+			Secondary:     &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}},
+			ExpectedError: ec2.ErrBackendNotReady,
+		},
+		{
+			Name:            "SecondaryError",
+			Primary:         &fakeClient{err: ec2.ErrInstanceNotFound},
+			Secondary:       &fakeClient{err: errBackend},
+			ExpectedError:   errBackend,
+			ExpectSecondary: true,
+		},
+	}
+
+	lookups := map[string]func(*Backend) (ec2.Instance, error){
+		"IP": func(b *Backend) (ec2.Instance, error) {
+			return b.GetEC2Instance(context.Background(), "10.10.10.10")
+		},
+		"MAC": func(b *Backend) (ec2.Instance, error) {
+			return b.GetEC2InstanceByMAC(context.Background(), "3c:ec:ef:4c:4f:54")
+		},
+		"ID": func(b *Backend) (ec2.Instance, error) {
+			return b.GetEC2InstanceByID(context.Background(), "instance-id")
+		},
+		"Tenant": func(b *Backend) (ec2.Instance, error) {
+			return b.GetEC2InstanceForTenant(context.Background(), "tenant", "10.10.10.10")
+		},
+	}
+
+	for _, tc := range cases {
+		for name, lookup := range lookups {
+			t.Run(tc.Name+"/"+name, func(t *testing.T) {
+				primary, secondary := *tc.Primary, *tc.Secondary
+				b := New([]backend.Client{&primary, &secondary}, prometheus.NewRegistry())
+
+				instance, err := lookup(b)
+
+				if primary.calls != 1 {
+					t.Fatalf("Expected 1 primary call; Received: %v", primary.calls)
+				}
+				if called := secondary.calls == 1; called != tc.ExpectSecondary {
+					t.Fatalf("Expected secondary called: %v; Received: %v", tc.ExpectSecondary, called)
+				}
+
+				if tc.ExpectedError != nil {
+					if !errors.Is(err, tc.ExpectedError) {
+						t.Fatalf("Expected: %v; Received: %v", tc.ExpectedError, err)
+					}
+					return
+				}
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if instance.Metadata.InstanceID != tc.ExpectedID {
+					t.Fatalf("Expected: %v; Received: %v", tc.ExpectedID, instance.Metadata.InstanceID)
+				}
+			})
+		}
+	}
+}
+
+func TestLookupMetrics(t *testing.T) {
+	primary := &fakeClient{err: ec2.ErrInstanceNotFound}
+	secondary := &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "secondary"}}}
+	registry := prometheus.NewRegistry()
+
+	b := New([]backend.Client{primary, secondary}, registry)
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := `
+# HELP backend_chain_lookups_total Count of instance lookups answered by each backend in the chain by position, starting at 0
+# TYPE backend_chain_lookups_total counter
+backend_chain_lookups_total{position="1"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsHealthy(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Primary   bool
+		Secondary bool
+		Expect    bool
+	}{
+		{Name: "BothHealthy", Primary: true, Secondary: true, Expect: true},
+		{Name: "PrimaryUnhealthy", Secondary: true},
+		{Name: "SecondaryUnhealthy", Primary: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			b := New([]backend.Client{
+				&fakeClient{unhealthy: !tc.Primary},
+				&fakeClient{unhealthy: !tc.Secondary},
+			}, prometheus.NewRegistry())
+
+			if healthy := b.IsHealthy(context.Background()); healthy != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, healthy)
+			}
+		})
+	}
+}
+
+type fakeClient struct {
+	instance  ec2.Instance
+	err       error
+	unhealthy bool
+	calls     int
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) next() (ec2.Instance, error) {
+	f.calls++
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	f.calls++
+	return hack.Instance{}, f.err
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return !f.unhealthy
+}
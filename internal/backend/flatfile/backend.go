@@ -2,24 +2,67 @@ package flatfile
 
 import (
 	"context"
+	"net"
+	"strings"
+	"time"
 
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 )
 
 // Backend is a file-based implementation of a backend. It's primary use-case is testing.
 type Backend struct {
-	// Map of IPv4 addresses to instances.
-	instances map[string]Instance
+	// Map of IP addresses to the instances, in file order, using them.
+	instances map[string][]Instance
+
+	// Map of MAC addresses to instances.
+	macs map[string]Instance
+
+	// Map of instance IDs to instances.
+	ids map[string]Instance
+
+	// Map of lower case hostnames to the instances, in file order, using them.
+	hostnames map[string][]Instance
+
+	// Map of tenant scoped IP addresses to the instances, in file order, using them. Only instances
+	// with a tenant are included.
+	tenantIPs map[tenantIP][]Instance
+
+	// Ambiguous, if set, reports lookups by IP that match more than one instance. The instance
+	// with the lowest ID is returned for such lookups regardless.
+	Ambiguous *ambiguous.Reporter
+}
+
+// tenantIP is an IP address scoped to a tenant.
+type tenantIP struct {
+	tenant string
+	ip     string
 }
 
 // New returns a new instance of Backend.
 func NewBackend(instances []Instance) *Backend {
-	return &Backend{instances: toIPInstanceMap(instances)}
+	return &Backend{
+		instances: toIPInstanceMap(instances),
+		macs:      toMACInstanceMap(instances),
+		ids:       toIDInstanceMap(instances),
+		hostnames: toHostnameInstanceMap(instances),
+		tenantIPs: toTenantIPInstanceMap(instances),
+	}
 }
 
 // RetrieveEC2InstanceByIP satisfies ec2.Client.
 func (b *Backend) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
-	hw, ok := b.instances[ip]
+	hw, ok := b.choose(ip, b.instances[ip])
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	return toEC2Instance(hw), nil
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(_ context.Context, mac string) (ec2.Instance, error) {
+	hw, ok := b.macs[mac]
 	if !ok {
 		return ec2.Instance{}, ec2.ErrInstanceNotFound
 	}
@@ -27,14 +70,70 @@ func (b *Backend) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, er
 	return toEC2Instance(hw), nil
 }
 
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(_ context.Context, tenant, ip string) (ec2.Instance, error) {
+	hw, ok := b.choose(ip, b.tenantIPs[tenantIP{tenant: tenant, ip: ip}])
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	return toEC2Instance(hw), nil
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(_ context.Context, id string) (ec2.Instance, error) {
+	hw, ok := b.ids[id]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	return toEC2Instance(hw), nil
+}
+
+// GetEC2InstanceByHostname satisfies ptr.HostnameClient. Hostnames are matched case
+// insensitively.
+func (b *Backend) GetEC2InstanceByHostname(_ context.Context, hostname string) (ec2.Instance, error) {
+	hostname = strings.ToLower(hostname)
+	hw, ok := b.choose(hostname, b.hostnames[hostname])
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	return toEC2Instance(hw), nil
+}
+
+// choose returns the instance chosen by b.Ambiguous from the instances matching a lookup of ip. If
+// there are no instances, it returns false.
+func (b *Backend) choose(ip string, instances []Instance) (Instance, bool) {
+	if len(instances) == 0 {
+		return Instance{}, false
+	}
+
+	ids := make([]string, len(instances))
+	for i, instance := range instances {
+		ids[i] = instance.Metadata.ID
+	}
+
+	return instances[b.Ambiguous.Choose(ip, ids)], true
+}
+
 // IsHealthy satisfies healthcheck.Client.
 func (b *Backend) IsHealthy(context.Context) bool {
 	return true
 }
 
 func toEC2Instance(i Instance) ec2.Instance {
-	return ec2.Instance{
-		Userdata: i.Userdata,
+	userdata := i.Userdata
+	if v, ok := i.UserdataVariants[i.State]; ok {
+		userdata = v
+	}
+
+	return ec2.Normalize(ec2.Instance{
+		Userdata:          userdata,
+		UserdataFragments: i.UserdataFragments,
+		UserdataPhases:    i.UserdataPhases,
+		Vendordata:        i.Vendordata,
+		Tenant:            i.Tenant,
 		Metadata: ec2.Metadata{
 			InstanceID:    i.Metadata.ID,
 			Hostname:      i.Metadata.Hostname,
@@ -43,6 +142,7 @@ func toEC2Instance(i Instance) ec2.Instance {
 			Plan:          i.Metadata.Plan,
 			Facility:      i.Metadata.Facility,
 			Tags:          i.Metadata.Tags,
+			PublicKeys:    i.Metadata.PublicKeys,
 			OperatingSystem: ec2.OperatingSystem{
 				Slug:     i.Metadata.OS.Slug,
 				Distro:   i.Metadata.OS.Distro,
@@ -52,17 +152,70 @@ func toEC2Instance(i Instance) ec2.Instance {
 					State: i.Metadata.OS.LicenseActivationState,
 				},
 			},
-			PublicIPv4: i.Metadata.IPv4.Public,
-			PublicIPv6: i.Metadata.IPv6.Public,
-			LocalIPv4:  i.Metadata.IPv4.Local,
+			PublicIPv4:     i.Metadata.IPv4.Public,
+			PublicIPv6:     i.Metadata.IPv6.Public,
+			LocalIPv4:      i.Metadata.IPv4.Local,
+			Interfaces:     toNetworkInterfaces(i),
+			Spot:           toSpot(i),
+			InstanceAction: i.Metadata.InstanceAction,
+			Disks:          i.Metadata.Disks,
 		},
+	})
+}
+
+// toSpot creates the spot data for i if it is a spot instance.
+func toSpot(i Instance) *ec2.Spot {
+	if i.Metadata.Spot == nil {
+		return nil
 	}
+	return &ec2.Spot{TerminationTime: i.Metadata.Spot.TerminationTime}
+}
+
+// toNetworkInterfaces creates a network interface for each of i's valid MACs. The first MAC is
+// the primary interface and is assigned the instance's IPv4 addresses.
+func toNetworkInterfaces(i Instance) []ec2.NetworkInterface {
+	var ifaces []ec2.NetworkInterface
+	for _, mac := range i.MACs {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			continue
+		}
+
+		iface := ec2.NetworkInterface{MAC: hw.String()}
+		if len(ifaces) == 0 {
+			if i.Metadata.IPv4.Local != "" {
+				iface.LocalIPv4s = []string{i.Metadata.IPv4.Local}
+			}
+			if i.Metadata.IPv4.Public != "" {
+				iface.PublicIPv4s = []string{i.Metadata.IPv4.Public}
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces
 }
 
 // Instance is a representation of a machine instance.
 type Instance struct {
-	Userdata string `yaml:"userdata"`
-	Metadata struct {
+	Userdata          string   `yaml:"userdata"`
+	UserdataFragments []string `yaml:"userdataFragments"` // Composed, in order, before Userdata.
+
+	// UserdataVariants are served in place of Userdata while the instance is in the State they're
+	// keyed by, such as provisioned.
+	UserdataVariants map[string]string `yaml:"userdataVariants"`
+
+	// UserdataPhases are served in place of Userdata to requests made in the boot phase they're
+	// keyed by, such as ipxe, as selected by the request's User-Agent.
+	UserdataPhases map[string]string `yaml:"userdataPhases"`
+
+	// State is the provisioning state of the instance, such as provisioning or provisioned.
+	State string `yaml:"state"`
+
+	Vendordata string   `yaml:"vendordata"`
+	MACs       []string `yaml:"macs"`
+	IPs        []string `yaml:"ips"`    // Additional addresses the instance may request from.
+	Tenant     string   `yaml:"tenant"` // Tenant the instance belongs to in multi-tenant clusters.
+	Metadata   struct {
 		ID            string   `yaml:"id"`
 		Hostname      string   `yaml:"hostname"`
 		LocalHostname string   `yaml:"localHostname"`
@@ -70,6 +223,7 @@ type Instance struct {
 		Plan          string   `yaml:"plan"`
 		Facility      string   `yaml:"facility"`
 		Tags          []string `yaml:"tags"`
+		PublicKeys    []string `yaml:"publicKeys"`
 		IPv4          struct {
 			Local  string `yaml:"local"`
 			Public string `yaml:"public"`
@@ -84,13 +238,86 @@ type Instance struct {
 			ImageTag               string `yaml:"imageTag"`
 			LicenseActivationState string `yaml:"licenseActivationState"`
 		} `yaml:"os"`
+		Spot *struct {
+			TerminationTime time.Time `yaml:"terminationTime"` // RFC 3339. Optional.
+		} `yaml:"spot"` // Only set for spot instances.
+		InstanceAction string   `yaml:"instanceAction"` // Pending action such as reboot. Optional.
+		Disks          []string `yaml:"disks"`          // Device names, such as /dev/sda, root first.
 	} `yaml:"metadata"`
 }
 
-func toIPInstanceMap(instances []Instance) map[string]Instance {
-	m := make(map[string]Instance, len(instances))
+// toIPInstanceMap maps every address of each instance to the instance so instances with multiple
+// interfaces can request from any of them.
+func toIPInstanceMap(instances []Instance) map[string][]Instance {
+	m := make(map[string][]Instance, len(instances))
+	for _, i := range instances {
+		for _, ip := range instanceIPs(i) {
+			m[ip] = append(m[ip], i)
+		}
+	}
+	return m
+}
+
+// toTenantIPInstanceMap maps every address of each instance belonging to a tenant to the instance
+// scoped by the tenant so tenants may reuse addresses.
+func toTenantIPInstanceMap(instances []Instance) map[tenantIP][]Instance {
+	m := make(map[tenantIP][]Instance)
+	for _, i := range instances {
+		if i.Tenant == "" {
+			continue
+		}
+		for _, ip := range instanceIPs(i) {
+			key := tenantIP{tenant: i.Tenant, ip: ip}
+			m[key] = append(m[key], i)
+		}
+	}
+	return m
+}
+
+// instanceIPs returns every distinct address of i.
+func instanceIPs(i Instance) []string {
+	var ips []string
+	seen := map[string]bool{}
+	for _, ip := range append([]string{i.Metadata.IPv4.Public, i.Metadata.IPv4.Local, i.Metadata.IPv6.Public}, i.IPs...) {
+		if ip != "" && !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func toMACInstanceMap(instances []Instance) map[string]Instance {
+	m := make(map[string]Instance)
+	for _, i := range instances {
+		for _, mac := range i.MACs {
+			// Normalize MACs so they match the lower case, colon separated form used for lookups.
+			// Invalid MACs can never be looked up so they're ignored.
+			if hw, err := net.ParseMAC(mac); err == nil {
+				m[hw.String()] = i
+			}
+		}
+	}
+	return m
+}
+
+func toIDInstanceMap(instances []Instance) map[string]Instance {
+	m := make(map[string]Instance)
+	for _, i := range instances {
+		if i.Metadata.ID != "" {
+			m[i.Metadata.ID] = i
+		}
+	}
+	return m
+}
+
+func toHostnameInstanceMap(instances []Instance) map[string][]Instance {
+	m := make(map[string][]Instance)
 	for _, i := range instances {
-		m[i.Metadata.IPv4.Public] = i
+		if i.Metadata.Hostname != "" {
+			hostname := strings.ToLower(i.Metadata.Hostname)
+			m[hostname] = append(m[hostname], i)
+		}
 	}
 	return m
 }
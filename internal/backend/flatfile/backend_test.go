@@ -3,9 +3,15 @@ package flatfile_test
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	. "github.com/tinkerbell/hegel/internal/backend/flatfile"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 )
@@ -26,7 +32,8 @@ func TestGetEC2Instance(t *testing.T) {
 			Name:     "IPFound",
 			LookupIP: "10.10.10.10",
 			ExpectedInstance: &ec2.Instance{
-				Userdata: "test",
+				Userdata:   "test",
+				Vendordata: "vendor",
 				Metadata: ec2.Metadata{
 					InstanceID:    "instanceid",
 					Hostname:      "hostname",
@@ -35,6 +42,7 @@ func TestGetEC2Instance(t *testing.T) {
 					Plan:          "plan",
 					Facility:      "facility",
 					Tags:          []string{"foo", "bar"},
+					PublicKeys:    []string{"key1", "key2"},
 					OperatingSystem: ec2.OperatingSystem{
 						Slug:     "slug",
 						Distro:   "distro",
@@ -47,6 +55,13 @@ func TestGetEC2Instance(t *testing.T) {
 					PublicIPv4: "10.10.10.10",
 					PublicIPv6: "2001:db8:0:1:1:1:1:1",
 					LocalIPv4:  "10.10.10.11",
+					Interfaces: []ec2.NetworkInterface{
+						{
+							MAC:         "3c:ec:ef:4c:4f:54",
+							LocalIPv4s:  []string{"10.10.10.11"},
+							PublicIPv4s: []string{"10.10.10.10"},
+						},
+					},
 				},
 			},
 		},
@@ -79,3 +94,433 @@ func TestGetEC2Instance(t *testing.T) {
 		})
 	}
 }
+
+func TestGetEC2InstanceMultipleIPs(t *testing.T) {
+	backend, err := FromYAMLFile("testdata/TestGetEC2Instance.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"10.10.10.10", "10.10.10.11", "2001:db8:0:1:1:1:1:1", "10.10.20.10"} {
+		t.Run(ip, func(t *testing.T) {
+			instance, err := backend.GetEC2Instance(context.Background(), ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Metadata.InstanceID != "instanceid" {
+				t.Fatalf("Expected: instanceid; Received: %v", instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceAmbiguous(t *testing.T) {
+	// Both instances claim 10.10.10.10.
+	backend, err := FromYAML(strings.NewReader(`
+- metadata:
+    id: "sm02"
+    ipv4:
+      local: "10.10.10.10"
+- metadata:
+    id: "sm01"
+    ipv4:
+      public: "10.10.10.10"
+      local: "10.10.10.11"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	backend.Ambiguous = ambiguous.NewReporter(logr.Discard(), registry)
+
+	// The instance with the lowest ID is returned every time.
+	for i := 0; i < 2; i++ {
+		instance, err := backend.GetEC2Instance(context.Background(), "10.10.10.10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Metadata.InstanceID != "sm01" {
+			t.Fatalf("Expected: sm01; Received: %v", instance.Metadata.InstanceID)
+		}
+	}
+
+	// Addresses claimed by a single instance aren't ambiguous.
+	if _, err := backend.GetEC2Instance(context.Background(), "10.10.10.11"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `
+# HELP backend_ambiguous_lookups_total Count of instance lookups that matched more than one hardware record
+# TYPE backend_ambiguous_lookups_total counter
+backend_ambiguous_lookups_total 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEC2InstanceSpot(t *testing.T) {
+	backend, err := FromYAML(strings.NewReader(`
+- metadata:
+    id: "spot"
+    ipv4:
+      local: "10.10.10.10"
+    spot:
+      terminationTime: "2015-01-05T18:02:00Z"
+- metadata:
+    id: "ondemand"
+    ipv4:
+      local: "10.10.10.11"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name         string
+		LookupIP     string
+		ExpectedSpot *ec2.Spot
+	}{
+		{
+			Name:         "Spot",
+			LookupIP:     "10.10.10.10",
+			ExpectedSpot: &ec2.Spot{TerminationTime: time.Date(2015, time.January, 5, 18, 2, 0, 0, time.UTC)},
+		},
+		{
+			Name:     "NotSpot",
+			LookupIP: "10.10.10.11",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			instance, err := backend.GetEC2Instance(context.Background(), tc.LookupIP)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(instance.Metadata.Spot, tc.ExpectedSpot) {
+				t.Fatal(cmp.Diff(tc.ExpectedSpot, instance.Metadata.Spot))
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceInstanceAction(t *testing.T) {
+	backend, err := FromYAML(strings.NewReader(`
+- metadata:
+    id: "reboot"
+    ipv4:
+      local: "10.10.10.10"
+    instanceAction: "reboot"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := backend.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.InstanceAction != "reboot" {
+		t.Fatalf("Expected: reboot; Received: %v", instance.Metadata.InstanceAction)
+	}
+}
+
+func TestGetEC2InstanceDisks(t *testing.T) {
+	backend, err := FromYAML(strings.NewReader(`
+- metadata:
+    id: "disks"
+    ipv4:
+      local: "10.10.10.10"
+    disks:
+      - /dev/nvme0n1
+      - /dev/sda
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := backend.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expect := []string{"/dev/nvme0n1", "/dev/sda"}; !cmp.Equal(instance.Metadata.Disks, expect) {
+		t.Fatal(cmp.Diff(expect, instance.Metadata.Disks))
+	}
+}
+
+func TestGetEC2InstanceUserdataVariants(t *testing.T) {
+	backend, err := FromYAML(strings.NewReader(`
+- userdata: "userdata"
+  userdataVariants:
+    provisioning: "install"
+    provisioned: "post-install"
+  state: "provisioning"
+  metadata:
+    id: "provisioning"
+    ipv4:
+      local: "10.10.10.10"
+- userdata: "userdata"
+  userdataVariants:
+    provisioning: "install"
+    provisioned: "post-install"
+  state: "provisioned"
+  metadata:
+    id: "provisioned"
+    ipv4:
+      local: "10.10.10.11"
+- userdata: "userdata"
+  userdataVariants:
+    provisioned: "post-install"
+  state: "in_use"
+  metadata:
+    id: "unmapped"
+    ipv4:
+      local: "10.10.10.12"
+- userdata: "userdata"
+  metadata:
+    id: "novariants"
+    ipv4:
+      local: "10.10.10.13"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name             string
+		LookupIP         string
+		ExpectedUserdata string
+	}{
+		{Name: "Provisioning", LookupIP: "10.10.10.10", ExpectedUserdata: "install"},
+		{Name: "Provisioned", LookupIP: "10.10.10.11", ExpectedUserdata: "post-install"},
+		{Name: "UnmappedState", LookupIP: "10.10.10.12", ExpectedUserdata: "userdata"},
+		{Name: "NoVariants", LookupIP: "10.10.10.13", ExpectedUserdata: "userdata"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			instance, err := backend.GetEC2Instance(context.Background(), tc.LookupIP)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Userdata != tc.ExpectedUserdata {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedUserdata, instance.Userdata)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceByMAC(t *testing.T) {
+	backend, err := FromYAMLFile("testdata/TestGetEC2Instance.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name          string
+		LookupMAC     string
+		ExpectedID    string
+		ExpectedError error
+	}{
+		{
+			Name:       "MACFound",
+			LookupMAC:  "3c:ec:ef:4c:4f:54",
+			ExpectedID: "instanceid",
+		},
+		{
+			Name:          "MACNotFound",
+			LookupMAC:     "00:00:00:00:00:00",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ec2Instance, err := backend.GetEC2InstanceByMAC(context.Background(), tc.LookupMAC)
+
+			if tc.ExpectedError != nil {
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v;\nReceived: %v", tc.ExpectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ec2Instance.Metadata.InstanceID != tc.ExpectedID {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedID, ec2Instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceByID(t *testing.T) {
+	backend, err := FromYAMLFile("testdata/TestGetEC2Instance.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name          string
+		LookupID      string
+		ExpectedError error
+	}{
+		{
+			Name:     "IDFound",
+			LookupID: "instanceid",
+		},
+		{
+			Name:          "IDNotFound",
+			LookupID:      "unknown",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ec2Instance, err := backend.GetEC2InstanceByID(context.Background(), tc.LookupID)
+
+			if tc.ExpectedError != nil {
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v;\nReceived: %v", tc.ExpectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ec2Instance.Metadata.InstanceID != tc.LookupID {
+				t.Fatalf("Expected: %v; Received: %v", tc.LookupID, ec2Instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceByHostname(t *testing.T) {
+	backend, err := FromYAMLFile("testdata/TestGetEC2Instance.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name          string
+		Hostname      string
+		ExpectedError error
+	}{
+		{
+			Name:     "HostnameFound",
+			Hostname: "hostname",
+		},
+		{
+			Name:     "CaseInsensitive",
+			Hostname: "HostName",
+		},
+		{
+			Name:          "HostnameNotFound",
+			Hostname:      "unknown",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ec2Instance, err := backend.GetEC2InstanceByHostname(context.Background(), tc.Hostname)
+
+			if tc.ExpectedError != nil {
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v;\nReceived: %v", tc.ExpectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ec2Instance.Metadata.InstanceID != "instanceid" {
+				t.Fatalf("Expected: instanceid; Received: %v", ec2Instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceForTenant(t *testing.T) {
+	// Tenants may reuse addresses.
+	backend, err := FromYAML(strings.NewReader(`
+- tenant: "tenant-a"
+  metadata:
+    id: "a"
+    ipv4:
+      local: "10.10.10.10"
+- tenant: "tenant-b"
+  metadata:
+    id: "b"
+    ipv4:
+      local: "10.10.10.10"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name          string
+		Tenant        string
+		ExpectedID    string
+		ExpectedError error
+	}{
+		{
+			Name:       "TenantA",
+			Tenant:     "tenant-a",
+			ExpectedID: "a",
+		},
+		{
+			Name:       "TenantB",
+			Tenant:     "tenant-b",
+			ExpectedID: "b",
+		},
+		{
+			Name:          "UnknownTenant",
+			Tenant:        "tenant-c",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:          "NoTenant",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			instance, err := backend.GetEC2InstanceForTenant(context.Background(), tc.Tenant, "10.10.10.10")
+
+			if tc.ExpectedError != nil {
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v;\nReceived: %v", tc.ExpectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Metadata.InstanceID != tc.ExpectedID {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedID, instance.Metadata.InstanceID)
+			}
+			if instance.Tenant != tc.Tenant {
+				t.Fatalf("Expected: %v; Received: %v", tc.Tenant, instance.Tenant)
+			}
+		})
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
 
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -18,6 +22,15 @@ import (
 
 var errNotFound = errors.New("no hardware found")
 
+// TenantLabel is the Hardware label identifying the tenant the Hardware belongs to in
+// multi-tenant clusters.
+const TenantLabel = "hegel.tinkerbell.org/tenant"
+
+// InstanceActionAnnotation is the Hardware annotation operators set to an action pending on the
+// instance, such as reboot or reinstall, served by /meta-data/instance-action. The action can be
+// sourced from a different field with a field mapping.
+const InstanceActionAnnotation = "hegel.tinkerbell.org/instance-action"
+
 // Build the scheme as a package variable so we don't need to perform error checks.
 var scheme = kubescheme.Scheme
 
@@ -30,6 +43,30 @@ type Backend struct {
 	client listerClient
 	closer <-chan struct{}
 
+	// sizes, if set, tracks the size of the Hardware held by the informer cache. See CacheSize.
+	sizes *hardwareSizes
+
+	// notifier, if set, notifies waiters of Hardware changes. See Changes.
+	notifier *changeNotifier
+
+	userDataFragmentAnnotations []string
+
+	// fieldMappings source EC2 endpoint data from alternate Hardware fields.
+	fieldMappings []fieldMapping
+
+	// userDataStateMappings source user-data from alternate Hardware fields by provisioning state.
+	userDataStateMappings map[string]string
+
+	// userDataPhaseMappings source the user-data of boot phases from alternate Hardware fields.
+	userDataPhaseMappings map[string]string
+
+	// ambiguous, if set, reports lookups by IP that match more than one Hardware.
+	ambiguous *ambiguous.Reporter
+
+	// cacheSynced, if set, reports whether the cluster cache has synced. Lookups against an
+	// unsynced cache would spuriously find nothing so they fail with ec2.ErrBackendNotReady.
+	cacheSynced func() bool
+
 	// WaitForCacheSync waits for the initial sync to be completed. Returns false if the cache
 	// fails to sync.
 	WaitForCacheSync func(context.Context) bool
@@ -37,12 +74,28 @@ type Backend struct {
 
 // NewBackend creates a new Backend instance. It launches a goroutine to perform synchronization
 // between the cluster and internal caches. Consumers can wait for the initial sync using WaitForCachesync().
+// Until the initial sync completes the Backend isn't ready, see IsReady. If cfg.CacheSyncTimeout
+// is set, NewBackend waits for the initial sync and fails if it doesn't complete in time.
 // See k8s.io/Backend-go/tools/Backendcmd for constructing *rest.Config objects.
 func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
+	mappings, err := parseFieldMappings(cfg.FieldMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	stateMappings, err := parseUserDataMappings("user-data state mapping", cfg.UserDataStateMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	phaseMappings, err := parseUserDataMappings("user-data phase mapping", cfg.UserDataPhaseMappings)
+	if err != nil {
+		return nil, err
+	}
+
 	// If no client was specified, build one and configure the backend with it including waiting
 	// for the caches to sync.
 	if cfg.ClientConfig == nil {
-		var err error
 		cfg, err = loadConfig(cfg)
 		if err != nil {
 			return nil, err
@@ -69,21 +122,135 @@ func NewBackend(ctx context.Context, cfg Config) (*Backend, error) {
 		return nil, fmt.Errorf("register index: %v", err)
 	}
 
+	err = clstr.GetFieldIndexer().IndexField(
+		ctx,
+		&tinkv1.Hardware{},
+		hardwareMACAddrIndex,
+		hardwareMACIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("register index: %v", err)
+	}
+
+	err = clstr.GetFieldIndexer().IndexField(
+		ctx,
+		&tinkv1.Hardware{},
+		hardwareInstanceIDIndex,
+		hardwareInstanceIDIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("register index: %v", err)
+	}
+
+	err = clstr.GetFieldIndexer().IndexField(
+		ctx,
+		&tinkv1.Hardware{},
+		hardwareHostnameIndex,
+		hardwareHostnameIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("register index: %v", err)
+	}
+
+	err = clstr.GetFieldIndexer().IndexField(
+		ctx,
+		&tinkv1.Hardware{},
+		hardwareTenantIPAddrIndex,
+		hardwareTenantIPIndexFunc,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("register index: %v", err)
+	}
+
+	// Long polls and the cache size metrics are driven by informer events rather than reading
+	// the cache.
+	inf, err := clstr.GetCache().GetInformer(ctx, &tinkv1.Hardware{})
+	if err != nil {
+		return nil, fmt.Errorf("get hardware informer: %v", err)
+	}
+
+	sizes, err := newHardwareSizes(inf)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier, err := newChangeNotifier(inf)
+	if err != nil {
+		return nil, err
+	}
+
+	// The cluster is stopped if NewBackend fails after starting it so it doesn't outlive the
+	// failed Backend.
+	clusterCtx, stopCluster := context.WithCancel(ctx)
+	created := false
+	defer func() {
+		if !created {
+			stopCluster()
+		}
+	}()
+
 	// TODO(chrisdoherty4) Stop panicing on error. This will likely require exposing Start in
 	// some capacity and allowing the caller to handle the error.
 	go func() {
-		if err := clstr.Start(ctx); err != nil {
+		if err := clstr.Start(clusterCtx); err != nil {
 			panic(err)
 		}
 	}()
 
+	// By default the cache syncs in the background and the Backend isn't ready until it has. If
+	// a timeout is configured, the initial sync is waited for here instead.
+	var synced atomic.Bool
+	if cfg.CacheSyncTimeout > 0 {
+		syncCtx, cancel := context.WithTimeout(clusterCtx, cfg.CacheSyncTimeout)
+		defer cancel()
+
+		if !clstr.GetCache().WaitForCacheSync(syncCtx) {
+			return nil, fmt.Errorf("hardware cache didn't sync within %v", cfg.CacheSyncTimeout)
+		}
+		synced.Store(true)
+	} else {
+		go func() {
+			if clstr.GetCache().WaitForCacheSync(clusterCtx) {
+				synced.Store(true)
+			}
+		}()
+	}
+	created = true
+
 	return &Backend{
-		closer:           ctx.Done(),
-		client:           clstr.GetClient(),
-		WaitForCacheSync: clstr.GetCache().WaitForCacheSync,
+		closer:                      ctx.Done(),
+		client:                      clstr.GetClient(),
+		sizes:                       sizes,
+		notifier:                    notifier,
+		userDataFragmentAnnotations: cfg.UserDataFragmentAnnotations,
+		fieldMappings:               mappings,
+		userDataStateMappings:       stateMappings,
+		userDataPhaseMappings:       phaseMappings,
+		ambiguous:                   cfg.Ambiguous,
+		cacheSynced:                 synced.Load,
+		WaitForCacheSync:            clstr.GetCache().WaitForCacheSync,
 	}, nil
 }
 
+// CacheSize satisfies metrics.CacheSizer reporting the size of the Hardware held by the informer
+// cache lookups are served from.
+func (b *Backend) CacheSize() (entries, bytes int) {
+	if b.sizes == nil {
+		return 0, 0
+	}
+	return b.sizes.size()
+}
+
+// Changes satisfies native.Notifier. The returned channel is closed on the next add, delete or
+// change of resource version of Hardware associated with ip. It's nil, and never closed, for
+// Backends without an informer.
+func (b *Backend) Changes(ip string) <-chan struct{} {
+	if b.notifier == nil {
+		return nil
+	}
+	return b.notifier.changes(ip)
+}
+
 func loadConfig(cfg Config) (Config, error) {
 	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 	loadingRules.ExplicitPath = cfg.Kubeconfig
@@ -125,6 +292,12 @@ func (b *Backend) IsHealthy(context.Context) bool {
 	}
 }
 
+// IsReady satisfies healthcheck.ReadinessChecker. It returns true once the initial cache sync
+// has completed.
+func (b *Backend) IsReady(context.Context) bool {
+	return b.cacheSynced == nil || b.cacheSynced()
+}
+
 // GetEC2InstanceByIP satisfies ec2.Client.
 func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
 	hw, err := b.retrieveByIP(ctx, ip)
@@ -136,27 +309,136 @@ func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance,
 		return ec2.Instance{}, err
 	}
 
-	return toEC2Instance(hw), nil
+	return b.toEC2Instance(hw)
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	hw, err := b.choose(ctx, hardwareMACAddrIndex, strings.ToLower(mac))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return b.toEC2Instance(hw)
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient. Only Hardware labeled with TenantLabel set
+// to tenant is considered.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	hw, err := b.choose(ctx, hardwareTenantIPAddrIndex, tenantIPIndexValue(tenant, ip))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return b.toEC2Instance(hw)
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	hw, err := b.choose(ctx, hardwareInstanceIDIndex, id)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return b.toEC2Instance(hw)
+}
+
+// GetEC2InstanceByHostname satisfies ptr.HostnameClient. Hostnames are matched case
+// insensitively.
+func (b *Backend) GetEC2InstanceByHostname(ctx context.Context, hostname string) (ec2.Instance, error) {
+	hw, err := b.choose(ctx, hardwareHostnameIndex, strings.ToLower(hostname))
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return ec2.Instance{}, ec2.ErrInstanceNotFound
+		}
+
+		return ec2.Instance{}, err
+	}
+
+	return b.toEC2Instance(hw)
+}
+
+// toEC2Instance converts hw to an ec2.Instance including the user-data fragments from the
+// configured annotations, the data sourced from the configured field mappings, the user-data
+// sourced from the mapping for the Hardware provisioning state and the user-data of boot phases
+// sourced from the phase mappings.
+func (b *Backend) toEC2Instance(hw tinkv1.Hardware) (ec2.Instance, error) {
+	i := ToEC2Instance(hw)
+	for _, key := range b.userDataFragmentAnnotations {
+		if v, ok := hw.Annotations[key]; ok {
+			i.UserdataFragments = append(i.UserdataFragments, v)
+		}
+	}
+
+	if err := applyFieldMappings(&i, hw, b.fieldMappings); err != nil {
+		return ec2.Instance{}, err
+	}
+
+	if err := applyUserDataStateMapping(&i, hw, b.userDataStateMappings); err != nil {
+		return ec2.Instance{}, err
+	}
+
+	if err := applyUserDataPhaseMappings(&i, hw, b.userDataPhaseMappings); err != nil {
+		return ec2.Instance{}, err
+	}
+
+	// Mapped fields may not be in canonical form.
+	return ec2.Normalize(i), nil
 }
 
 func (b *Backend) retrieveByIP(ctx context.Context, ip string) (tinkv1.Hardware, error) {
+	return b.choose(ctx, hardwareIPAddrIndex, ip)
+}
+
+// choose retrieves the Hardware whose index field matches value. If more than one Hardware
+// matches, the one with the lowest namespace/name key is chosen and the lookup is reported to
+// b.ambiguous.
+func (b *Backend) choose(ctx context.Context, index, value string) (tinkv1.Hardware, error) {
+	hw, err := b.list(ctx, index, value)
+	if err != nil {
+		return tinkv1.Hardware{}, err
+	}
+
+	keys := make([]string, len(hw))
+	for i := range hw {
+		keys[i] = hardwareKey(&hw[i])
+	}
+
+	return hw[b.ambiguous.Choose(value, keys)], nil
+}
+
+// list retrieves the Hardware whose index field matches value. If none match, it returns
+// errNotFound.
+func (b *Backend) list(ctx context.Context, index, value string) ([]tinkv1.Hardware, error) {
+	if !b.IsReady(ctx) {
+		return nil, ec2.ErrBackendNotReady
+	}
+
 	var hw tinkv1.HardwareList
 	err := b.client.List(ctx, &hw, crclient.MatchingFields{
-		hardwareIPAddrIndex: ip,
+		index: value,
 	})
 	if err != nil {
-		return tinkv1.Hardware{}, err
+		return nil, err
 	}
 
 	if len(hw.Items) == 0 {
-		return tinkv1.Hardware{}, errNotFound
+		return nil, errNotFound
 	}
 
-	if len(hw.Items) > 1 {
-		return tinkv1.Hardware{}, fmt.Errorf("multiple hardware found")
-	}
-
-	return hw.Items[0], nil
+	return hw.Items, nil
 }
 
 // listerClient lists Kubernetes resources using a sigs.k8s.io/controller-runtime Backend.
@@ -164,8 +446,10 @@ type listerClient interface {
 	List(ctx context.Context, list crclient.ObjectList, opts ...crclient.ListOption) error
 }
 
+// ToEC2Instance converts a Tinkerbell Hardware resource to a normalized ec2.Instance.
+//
 //nolint:cyclop // This function is just mapping data with a bunch of nil checks, it's not complex.
-func toEC2Instance(hw tinkv1.Hardware) ec2.Instance {
+func ToEC2Instance(hw tinkv1.Hardware) ec2.Instance {
 	var i ec2.Instance
 
 	if hw.Spec.Metadata.Instance != nil {
@@ -173,6 +457,8 @@ func toEC2Instance(hw tinkv1.Hardware) ec2.Instance {
 		i.Metadata.Hostname = hw.Spec.Metadata.Instance.Hostname
 		i.Metadata.LocalHostname = hw.Spec.Metadata.Instance.Hostname
 		i.Metadata.Tags = hw.Spec.Metadata.Instance.Tags
+		i.Metadata.PublicKeys = hw.Spec.Metadata.Instance.SSHKeys
+		i.Metadata.Disks = toDisks(hw.Spec.Metadata.Instance.Storage)
 
 		if hw.Spec.Metadata.Instance.OperatingSystem != nil {
 			i.Metadata.OperatingSystem.Slug = hw.Spec.Metadata.Instance.OperatingSystem.Slug
@@ -210,11 +496,54 @@ func toEC2Instance(hw tinkv1.Hardware) ec2.Instance {
 		i.Userdata = *hw.Spec.UserData
 	}
 
-	// TODO(chrisdoherty4) Support public keys. The frontend doesn't handle public keys correctly
-	// as it expects a single string and just outputs that key. Until we can support multiple keys
-	// its not worth adding it to the metadata.
-	//
-	// https://github.com/tinkerbell/hegel/issues/165
+	if hw.Spec.VendorData != nil {
+		i.Vendordata = *hw.Spec.VendorData
+	}
+
+	i.Tenant = hw.Labels[TenantLabel]
+	i.Metadata.InstanceAction = hw.Annotations[InstanceActionAnnotation]
+
+	i.Metadata.Interfaces = toNetworkInterfaces(hw, i.Metadata.PublicIPv4)
+
+	return ec2.Normalize(i)
+}
+
+// toDisks returns the device names of the disks in storage, if any, in the order they're defined.
+func toDisks(storage *tinkv1.MetadataInstanceStorage) []string {
+	if storage == nil {
+		return nil
+	}
+
+	var disks []string
+	for _, disk := range storage.Disks {
+		if disk != nil && disk.Device != "" {
+			disks = append(disks, disk.Device)
+		}
+	}
+	return disks
+}
+
+// toNetworkInterfaces converts the DHCP configured interfaces of hw to network interfaces. The
+// DHCP address is the interface's local address. Public addresses aren't associated with a
+// particular interface so publicIPv4, if any, is associated with the primary interface.
+func toNetworkInterfaces(hw tinkv1.Hardware, publicIPv4 string) []ec2.NetworkInterface {
+	var ifaces []ec2.NetworkInterface
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.DHCP == nil || iface.DHCP.MAC == "" {
+			continue
+		}
 
-	return i
+		ni := ec2.NetworkInterface{MAC: strings.ToLower(iface.DHCP.MAC)}
+		if iface.DHCP.IP != nil {
+			if ip := net.ParseIP(iface.DHCP.IP.Address); ip != nil && ip.To4() != nil {
+				ni.LocalIPv4s = []string{iface.DHCP.IP.Address}
+			}
+		}
+		if len(ifaces) == 0 && publicIPv4 != "" {
+			ni.PublicIPv4s = []string{publicIPv4}
+		}
+
+		ifaces = append(ifaces, ni)
+	}
+	return ifaces
 }
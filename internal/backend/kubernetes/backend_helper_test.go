@@ -1,5 +1,7 @@
 package kubernetes
 
+import "github.com/tinkerbell/hegel/internal/backend/ambiguous"
+
 // NewTestBackend isn't representative of how Backends are constructed but is useful
 // when wanting to validate the business logic around data retrieval and conversion.
 func NewTestBackend(c listerClient, closer <-chan struct{}) *Backend {
@@ -8,3 +10,63 @@ func NewTestBackend(c listerClient, closer <-chan struct{}) *Backend {
 		closer: closer,
 	}
 }
+
+// NewTestBackendWithInformer creates a Backend whose cache size and change notifications are
+// driven by inf events.
+func NewTestBackendWithInformer(c listerClient, inf informer) (*Backend, error) {
+	sizes, err := newHardwareSizes(inf)
+	if err != nil {
+		return nil, err
+	}
+	notifier, err := newChangeNotifier(inf)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{client: c, sizes: sizes, notifier: notifier}, nil
+}
+
+// SetCacheSynced configures the func b uses to determine if its cache has synced.
+func SetCacheSynced(b *Backend, synced func() bool) {
+	b.cacheSynced = synced
+}
+
+// SetAmbiguous configures the Reporter b reports ambiguous lookups to.
+func SetAmbiguous(b *Backend, r *ambiguous.Reporter) {
+	b.ambiguous = r
+}
+
+// SetUserDataFragmentAnnotations configures the annotations b sources user-data fragments from.
+func SetUserDataFragmentAnnotations(b *Backend, annotations []string) {
+	b.userDataFragmentAnnotations = annotations
+}
+
+// SetFieldMappings configures the field mappings b sources EC2 endpoint data from.
+func SetFieldMappings(b *Backend, mappings map[string]string) error {
+	parsed, err := parseFieldMappings(mappings)
+	if err != nil {
+		return err
+	}
+	b.fieldMappings = parsed
+	return nil
+}
+
+// SetUserDataStateMappings configures the user-data state mappings b sources user-data from.
+func SetUserDataStateMappings(b *Backend, mappings map[string]string) error {
+	parsed, err := parseUserDataMappings("user-data state mapping", mappings)
+	if err != nil {
+		return err
+	}
+	b.userDataStateMappings = parsed
+	return nil
+}
+
+// SetUserDataPhaseMappings configures the user-data phase mappings b sources the user-data of boot
+// phases from.
+func SetUserDataPhaseMappings(b *Backend, mappings map[string]string) error {
+	parsed, err := parseUserDataMappings("user-data phase mapping", mappings)
+	if err != nil {
+		return err
+	}
+	b.userDataPhaseMappings = parsed
+	return nil
+}
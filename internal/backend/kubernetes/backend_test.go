@@ -4,14 +4,26 @@ package kubernetes_test
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	. "github.com/tinkerbell/hegel/internal/backend/kubernetes"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -35,6 +47,7 @@ func TestGetEC2Instance(t *testing.T) {
 							ID:       "instance-id",
 							Hostname: "instance-hostname",
 							Tags:     []string{"tag"},
+							SSHKeys:  []string{"key1", "key2"},
 							OperatingSystem: &tinkv1.MetadataInstanceOperatingSystem{
 								Slug:     "slug",
 								Distro:   "distro",
@@ -60,6 +73,7 @@ func TestGetEC2Instance(t *testing.T) {
 					Plan:          "plan-slug",
 					Facility:      "facility-code",
 					Tags:          []string{"tag"},
+					PublicKeys:    []string{"key1", "key2"},
 					PublicIPv4:    "10.10.10.10",
 					OperatingSystem: ec2.OperatingSystem{
 						Slug:     "slug",
@@ -169,6 +183,30 @@ func TestGetEC2Instance(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "StorageDisks",
+			Hardware: tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{
+							Storage: &tinkv1.MetadataInstanceStorage{
+								Disks: []*tinkv1.MetadataInstanceStorageDisk{
+									{Device: "/dev/nvme0n1"},
+									nil,
+									{Device: ""},
+									{Device: "/dev/sda"},
+								},
+							},
+						},
+					},
+				},
+			},
+			ExpectedInstance: ec2.Instance{
+				Metadata: ec2.Metadata{
+					Disks: []string{"/dev/nvme0n1", "/dev/sda"},
+				},
+			},
+		},
 		{
 			Name: "MultiplePublicIPv4s",
 			Hardware: tinkv1.Hardware{
@@ -197,6 +235,20 @@ func TestGetEC2Instance(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "UserAndVendorData",
+			Hardware: tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata:   &tinkv1.HardwareMetadata{},
+					UserData:   ptr("userdata"),
+					VendorData: ptr("vendordata"),
+				},
+			},
+			ExpectedInstance: ec2.Instance{
+				Userdata:   "userdata",
+				Vendordata: "vendordata",
+			},
+		},
 		{
 			Name: "LocalIPv4s",
 			Hardware: tinkv1.Hardware{
@@ -362,6 +414,75 @@ func TestGetEC2Instance(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name: "OneInterface",
+			Hardware: tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{
+							Ips: []*tinkv1.MetadataInstanceIP{
+								{
+									Address: "139.178.0.10",
+									Family:  4,
+									Public:  true,
+								},
+							},
+						},
+					},
+					Interfaces: []tinkv1.Interface{
+						{DHCP: &tinkv1.DHCP{MAC: "3C:EC:EF:4C:4F:54", IP: &tinkv1.IP{Address: "10.10.10.10"}}},
+					},
+				},
+			},
+			ExpectedInstance: ec2.Instance{
+				Metadata: ec2.Metadata{
+					PublicIPv4: "139.178.0.10",
+					Interfaces: []ec2.NetworkInterface{
+						{
+							MAC:         "3c:ec:ef:4c:4f:54",
+							LocalIPv4s:  []string{"10.10.10.10"},
+							PublicIPv4s: []string{"139.178.0.10"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name: "TwoInterfaces",
+			Hardware: tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{},
+					Interfaces: []tinkv1.Interface{
+						{DHCP: &tinkv1.DHCP{MAC: "3c:ec:ef:4c:4f:54", IP: &tinkv1.IP{Address: "10.10.10.10"}}},
+						{DHCP: &tinkv1.DHCP{MAC: "3c:ec:ef:4c:4f:55", IP: &tinkv1.IP{Address: "10.10.20.10"}}},
+						{DHCP: &tinkv1.DHCP{MAC: "3c:ec:ef:4c:4f:56"}},
+					},
+				},
+			},
+			ExpectedInstance: ec2.Instance{
+				Metadata: ec2.Metadata{
+					Interfaces: []ec2.NetworkInterface{
+						{MAC: "3c:ec:ef:4c:4f:54", LocalIPv4s: []string{"10.10.10.10"}},
+						{MAC: "3c:ec:ef:4c:4f:55", LocalIPv4s: []string{"10.10.20.10"}},
+						{MAC: "3c:ec:ef:4c:4f:56"},
+					},
+				},
+			},
+		},
+		{
+			Name: "InstanceAction",
+			Hardware: tinkv1.Hardware{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{InstanceActionAnnotation: "reinstall"},
+				},
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{},
+				},
+			},
+			ExpectedInstance: ec2.Instance{
+				Metadata: ec2.Metadata{InstanceAction: "reinstall"},
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -405,25 +526,719 @@ func TestGetEC2InstanceWithClientError(t *testing.T) {
 	}
 }
 
-func TestGetEC2InstanceWithGt1Result(t *testing.T) {
+func TestGetEC2InstanceAmbiguous(t *testing.T) {
+	hardware := func(namespace, name string) tinkv1.Hardware {
+		return tinkv1.Hardware{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec: tinkv1.HardwareSpec{
+				Metadata: &tinkv1.HardwareMetadata{
+					Instance: &tinkv1.MetadataInstance{ID: name},
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		Name   string
+		Lookup func(*Backend) (ec2.Instance, error)
+	}{
+		{
+			Name: "IP",
+			Lookup: func(b *Backend) (ec2.Instance, error) {
+				return b.GetEC2Instance(context.Background(), "10.10.10.10")
+			},
+		},
+		{
+			Name: "TenantIP",
+			Lookup: func(b *Backend) (ec2.Instance, error) {
+				return b.GetEC2InstanceForTenant(context.Background(), "tenant", "10.10.10.10")
+			},
+		},
+		{
+			Name: "MAC",
+			Lookup: func(b *Backend) (ec2.Instance, error) {
+				return b.GetEC2InstanceByMAC(context.Background(), "3c:ec:ef:4c:4f:54")
+			},
+		},
+		{
+			Name: "InstanceID",
+			Lookup: func(b *Backend) (ec2.Instance, error) {
+				return b.GetEC2InstanceByID(context.Background(), "sm01")
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			lister := NewMocklisterClient(ctrl)
+			lister.EXPECT().
+				List(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+					l.Items = []tinkv1.Hardware{hardware("default", "sm02"), hardware("default", "sm01")}
+					return nil
+				})
+
+			registry := prometheus.NewRegistry()
+			client := NewTestBackend(lister, nil)
+			SetAmbiguous(client, ambiguous.NewReporter(logr.Discard(), registry))
+
+			instance, err := tc.Lookup(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The Hardware with the lowest namespace/name is chosen regardless of list order.
+			if instance.Metadata.InstanceID != "sm01" {
+				t.Fatalf("Expected: sm01; Received: %v", instance.Metadata.InstanceID)
+			}
+
+			expect := `
+# HELP backend_ambiguous_lookups_total Count of instance lookups that matched more than one hardware record
+# TYPE backend_ambiguous_lookups_total counter
+backend_ambiguous_lookups_total 1
+`
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceWithNoResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	client := NewTestBackend(lister, nil)
+
+	_, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+	if !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected: ec2.ErrInstanceNotFound; Received: %v", err)
+	}
+}
+
+func TestGetEC2InstanceByMAC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, opts ...crclient.ListOption) error {
+			// Validate the MAC is matched against the MAC index in lower case form.
+			var lo crclient.ListOptions
+			for _, opt := range opts {
+				opt.ApplyToList(&lo)
+			}
+			if v, ok := lo.FieldSelector.RequiresExactMatch(".Spec.Interfaces.DHCP.MAC"); !ok || v != "3c:ec:ef:4c:4f:54" {
+				t.Fatalf("Unexpected field selector: %v", lo.FieldSelector)
+			}
+
+			l.Items = append(l.Items, tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id"},
+					},
+				},
+			})
+			return nil
+		})
+
+	client := NewTestBackend(lister, nil)
+
+	instance, err := client.GetEC2InstanceByMAC(context.Background(), "3C:EC:EF:4C:4F:54")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.InstanceID != "instance-id" {
+		t.Fatalf("Expected: instance-id; Received: %v", instance.Metadata.InstanceID)
+	}
+}
+
+func TestGetEC2InstanceByID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, opts ...crclient.ListOption) error {
+			// Validate the ID is matched against the instance ID index.
+			var lo crclient.ListOptions
+			for _, opt := range opts {
+				opt.ApplyToList(&lo)
+			}
+			if v, ok := lo.FieldSelector.RequiresExactMatch(".Spec.Metadata.Instance.ID"); !ok || v != "instance-id" {
+				t.Fatalf("Unexpected field selector: %v", lo.FieldSelector)
+			}
+
+			l.Items = append(l.Items, tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id", Hostname: "sm01"},
+					},
+				},
+			})
+			return nil
+		})
+
+	client := NewTestBackend(lister, nil)
+
+	instance, err := client.GetEC2InstanceByID(context.Background(), "instance-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.Hostname != "sm01" {
+		t.Fatalf("Expected: sm01; Received: %v", instance.Metadata.Hostname)
+	}
+}
+
+func TestGetEC2InstanceByHostname(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, opts ...crclient.ListOption) error {
+			// Validate the lower cased hostname is matched against the hostname index.
+			var lo crclient.ListOptions
+			for _, opt := range opts {
+				opt.ApplyToList(&lo)
+			}
+			if v, ok := lo.FieldSelector.RequiresExactMatch(".Spec.Metadata.Instance.Hostname"); !ok || v != "sm01.example.com" {
+				t.Fatalf("Unexpected field selector: %v", lo.FieldSelector)
+			}
+
+			l.Items = append(l.Items, tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id", Hostname: "SM01.example.com"},
+					},
+				},
+			})
+			return nil
+		})
+
+	client := NewTestBackend(lister, nil)
+
+	instance, err := client.GetEC2InstanceByHostname(context.Background(), "SM01.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.InstanceID != "instance-id" {
+		t.Fatalf("Expected: instance-id; Received: %v", instance.Metadata.InstanceID)
+	}
+}
+
+func TestGetEC2InstanceForTenant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, opts ...crclient.ListOption) error {
+			// Validate the IP is matched against the tenant scoped IP index.
+			var lo crclient.ListOptions
+			for _, opt := range opts {
+				opt.ApplyToList(&lo)
+			}
+			v, ok := lo.FieldSelector.RequiresExactMatch(".Metadata.Labels.Tenant.Spec.Interfaces.DHCP.IP")
+			if !ok || v != "tenant-a/10.10.10.10" {
+				t.Fatalf("Unexpected field selector: %v", lo.FieldSelector)
+			}
+
+			l.Items = append(l.Items, tinkv1.Hardware{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{TenantLabel: "tenant-a"},
+				},
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id"},
+					},
+				},
+			})
+			return nil
+		})
+
+	client := NewTestBackend(lister, nil)
+
+	instance, err := client.GetEC2InstanceForTenant(context.Background(), "tenant-a", "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Tenant != "tenant-a" {
+		t.Fatalf("Expected: tenant-a; Received: %v", instance.Tenant)
+	}
+}
+
+func TestGetEC2InstanceForTenantWithNoResults(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	client := NewTestBackend(lister, nil)
+
+	_, err := client.GetEC2InstanceForTenant(context.Background(), "tenant-a", "10.10.10.10")
+	if !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected: %v; Received: %v", ec2.ErrInstanceNotFound, err)
+	}
+}
+
+func TestGetNativeMetadata(t *testing.T) {
+	hw := tinkv1.Hardware{
+		Spec: tinkv1.HardwareSpec{
+			Metadata: &tinkv1.HardwareMetadata{
+				Instance: &tinkv1.MetadataInstance{
+					ID:       "instance-id",
+					Hostname: "sm01",
+				},
+			},
+			UserData: ptr("userdata"),
+		},
+	}
+
 	ctrl := gomock.NewController(t)
 	lister := NewMocklisterClient(ctrl)
 	lister.EXPECT().
 		List(gomock.Any(), gomock.Any(), gomock.Any()).
 		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
-			l.Items = make([]tinkv1.Hardware, 2)
+			l.Items = append(l.Items, hw)
 			return nil
 		})
 
 	client := NewTestBackend(lister, nil)
 
-	_, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+	doc, err := client.GetNativeMetadata(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The native document is the Hardware spec as exported.
+	expect, err := json.Marshal(hw.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(doc) != string(expect) {
+		t.Fatalf("Expected: %s; Received: %s", expect, doc)
+	}
+}
+
+func TestGetNativeMetadataNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil)
+
+	client := NewTestBackend(lister, nil)
+
+	_, err := client.GetNativeMetadata(context.Background(), "10.10.10.10")
+	if !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected: %v; Received: %v", ec2.ErrInstanceNotFound, err)
+	}
+}
+
+func TestGetEC2InstanceUserDataFragments(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+			hw := tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{},
+					UserData: ptr("userdata"),
+				},
+			}
+			hw.Annotations = map[string]string{
+				"example.com/base":  "base",
+				"example.com/node":  "node",
+				"example.com/other": "other",
+			}
+			l.Items = append(l.Items, hw)
+			return nil
+		})
+
+	client := NewTestBackend(lister, nil)
+	SetUserDataFragmentAnnotations(client, []string{"example.com/node", "example.com/missing", "example.com/base"})
+
+	instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := ec2.Instance{
+		Userdata:          "userdata",
+		UserdataFragments: []string{"node", "base"},
+	}
+	if !cmp.Equal(instance, expect) {
+		t.Fatal(cmp.Diff(expect, instance))
+	}
+}
+
+func TestGetEC2InstanceFieldMappings(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Mappings         map[string]string
+		Annotations      map[string]string
+		ExpectedHostname string
+	}{
+		{
+			Name:             "RemappedHostname",
+			Mappings:         map[string]string{"/meta-data/hostname": "{.metadata.annotations.example\\.com/hostname}"},
+			Annotations:      map[string]string{"example.com/hostname": "remapped"},
+			ExpectedHostname: "remapped",
+		},
+		{
+			Name:             "MissingField",
+			Mappings:         map[string]string{"/meta-data/hostname": "{.metadata.annotations.example\\.com/hostname}"},
+			ExpectedHostname: "hostname",
+		},
+		{
+			Name:             "NoMappings",
+			Annotations:      map[string]string{"example.com/hostname": "remapped"},
+			ExpectedHostname: "hostname",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			lister := NewMocklisterClient(ctrl)
+			lister.EXPECT().
+				List(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+					hw := tinkv1.Hardware{
+						Spec: tinkv1.HardwareSpec{
+							Metadata: &tinkv1.HardwareMetadata{
+								Instance: &tinkv1.MetadataInstance{
+									ID:       "instance-id",
+									Hostname: "hostname",
+								},
+							},
+						},
+					}
+					hw.Annotations = tc.Annotations
+					l.Items = append(l.Items, hw)
+					return nil
+				})
+
+			client := NewTestBackend(lister, nil)
+			if err := SetFieldMappings(client, tc.Mappings); err != nil {
+				t.Fatal(err)
+			}
+
+			instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Metadata.Hostname != tc.ExpectedHostname {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedHostname, instance.Metadata.Hostname)
+			}
+			if instance.Metadata.InstanceID != "instance-id" {
+				t.Fatalf("Expected: instance-id; Received: %v", instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+// TestGetEC2InstanceFieldMappingsConcurrent validates mappings can be applied by concurrent
+// lookups. Run with -race.
+func TestGetEC2InstanceFieldMappingsConcurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+			hw := tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id"},
+					},
+				},
+			}
+			hw.Annotations = map[string]string{"example.com/hostname": "remapped"}
+			l.Items = append(l.Items, hw)
+			return nil
+		}).
+		AnyTimes()
+
+	client := NewTestBackend(lister, nil)
+	err := SetFieldMappings(client, map[string]string{"/meta-data/hostname": "{.metadata.annotations.example\\.com/hostname}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+			if err == nil && instance.Metadata.Hostname != "remapped" {
+				err = errors.Errorf("expected: remapped; received: %v", instance.Metadata.Hostname)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFieldMappingsInvalid(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Mappings map[string]string
+	}{
+		{
+			Name:     "UnsupportedEndpoint",
+			Mappings: map[string]string{"/meta-data/public-keys": "{.metadata.name}"},
+		},
+		{
+			Name:     "InvalidJSONPath",
+			Mappings: map[string]string{"/meta-data/hostname": "{.metadata.name"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			client := NewTestBackend(nil, nil)
+			if err := SetFieldMappings(client, tc.Mappings); err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceUserDataStateMappings(t *testing.T) {
+	mappings := map[string]string{
+		"provisioning": "{.metadata.annotations.example\\.com/install-user-data}",
+		"provisioned":  "{.metadata.annotations.example\\.com/post-install-user-data}",
+	}
+	annotations := map[string]string{
+		"example.com/install-user-data":      "install",
+		"example.com/post-install-user-data": "post-install",
+	}
+
+	cases := []struct {
+		Name             string
+		Mappings         map[string]string
+		State            string
+		Annotations      map[string]string
+		ExpectedUserdata string
+	}{
+		{
+			Name:             "Provisioning",
+			Mappings:         mappings,
+			State:            "provisioning",
+			Annotations:      annotations,
+			ExpectedUserdata: "install",
+		},
+		{
+			Name:             "Provisioned",
+			Mappings:         mappings,
+			State:            "provisioned",
+			Annotations:      annotations,
+			ExpectedUserdata: "post-install",
+		},
+		{
+			Name:             "UnmappedState",
+			Mappings:         mappings,
+			State:            "in_use",
+			Annotations:      annotations,
+			ExpectedUserdata: "userdata",
+		},
+		{
+			Name:             "MissingField",
+			Mappings:         mappings,
+			State:            "provisioned",
+			ExpectedUserdata: "userdata",
+		},
+		{
+			Name:             "NoMappings",
+			State:            "provisioned",
+			Annotations:      annotations,
+			ExpectedUserdata: "userdata",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			lister := NewMocklisterClient(ctrl)
+			lister.EXPECT().
+				List(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+					hw := tinkv1.Hardware{
+						Spec: tinkv1.HardwareSpec{
+							Metadata: &tinkv1.HardwareMetadata{State: tc.State},
+							UserData: ptr("userdata"),
+						},
+					}
+					hw.Annotations = tc.Annotations
+					l.Items = append(l.Items, hw)
+					return nil
+				})
+
+			client := NewTestBackend(lister, nil)
+			if err := SetUserDataStateMappings(client, tc.Mappings); err != nil {
+				t.Fatal(err)
+			}
+
+			instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Userdata != tc.ExpectedUserdata {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedUserdata, instance.Userdata)
+			}
+		})
+	}
+}
+
+// TestGetEC2InstanceUserDataStateMappingsConcurrent validates state mappings can be applied by
+// concurrent lookups. Run with -race.
+func TestGetEC2InstanceUserDataStateMappingsConcurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+			hw := tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{State: "provisioned"},
+					UserData: ptr("userdata"),
+				},
+			}
+			hw.Annotations = map[string]string{"example.com/post-install-user-data": "post-install"}
+			l.Items = append(l.Items, hw)
+			return nil
+		}).
+		AnyTimes()
+
+	client := NewTestBackend(lister, nil)
+	err := SetUserDataStateMappings(client, map[string]string{
+		"provisioned": "{.metadata.annotations.example\\.com/post-install-user-data}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+			if err == nil && instance.Userdata != "post-install" {
+				err = errors.Errorf("expected: post-install; received: %v", instance.Userdata)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUserDataStateMappingsInvalid(t *testing.T) {
+	client := NewTestBackend(nil, nil)
+	err := SetUserDataStateMappings(client, map[string]string{"provisioned": "{.metadata.name"})
 	if err == nil {
-		t.Fatal("Expected error for > 2 results")
+		t.Fatal("Expected error; Received: nil")
 	}
 }
 
-func TestGetEC2InstanceWithNoResults(t *testing.T) {
+func TestGetEC2InstanceUserDataPhaseMappings(t *testing.T) {
+	mappings := map[string]string{
+		"ipxe":      "{.metadata.annotations.example\\.com/ipxe-script}",
+		"installer": "{.metadata.annotations.example\\.com/installer-user-data}",
+	}
+
+	cases := []struct {
+		Name           string
+		Mappings       map[string]string
+		Annotations    map[string]string
+		ExpectedPhases map[string]string
+	}{
+		{
+			Name:     "Mapped",
+			Mappings: mappings,
+			Annotations: map[string]string{
+				"example.com/ipxe-script":         "#!ipxe",
+				"example.com/installer-user-data": "installer",
+			},
+			ExpectedPhases: map[string]string{"ipxe": "#!ipxe", "installer": "installer"},
+		},
+		{
+			Name:           "MissingField",
+			Mappings:       mappings,
+			Annotations:    map[string]string{"example.com/ipxe-script": "#!ipxe"},
+			ExpectedPhases: map[string]string{"ipxe": "#!ipxe"},
+		},
+		{
+			Name:        "NoMappings",
+			Annotations: map[string]string{"example.com/ipxe-script": "#!ipxe"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			lister := NewMocklisterClient(ctrl)
+			lister.EXPECT().
+				List(gomock.Any(), gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+					hw := tinkv1.Hardware{
+						Spec: tinkv1.HardwareSpec{
+							Metadata: &tinkv1.HardwareMetadata{},
+							UserData: ptr("userdata"),
+						},
+					}
+					hw.Annotations = tc.Annotations
+					l.Items = append(l.Items, hw)
+					return nil
+				})
+
+			client := NewTestBackend(lister, nil)
+			if err := SetUserDataPhaseMappings(client, tc.Mappings); err != nil {
+				t.Fatal(err)
+			}
+
+			instance, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Userdata != "userdata" {
+				t.Fatalf("Expected: userdata; Received: %v", instance.Userdata)
+			}
+			if !cmp.Equal(instance.UserdataPhases, tc.ExpectedPhases) {
+				t.Fatal(cmp.Diff(tc.ExpectedPhases, instance.UserdataPhases))
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceByMACWithNoResults(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	lister := NewMocklisterClient(ctrl)
 	lister.EXPECT().
@@ -432,8 +1247,71 @@ func TestGetEC2InstanceWithNoResults(t *testing.T) {
 
 	client := NewTestBackend(lister, nil)
 
-	_, err := client.GetEC2Instance(context.Background(), "10.10.10.10")
+	_, err := client.GetEC2InstanceByMAC(context.Background(), "3c:ec:ef:4c:4f:54")
 	if !errors.Is(err, ec2.ErrInstanceNotFound) {
 		t.Fatalf("Expected: ec2.ErrInstanceNotFound; Received: %v", err)
 	}
 }
+
+func TestGetEC2InstanceCacheNotSynced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+
+	var synced atomic.Bool
+	client := NewTestBackend(lister, nil)
+	SetCacheSynced(client, synced.Load)
+
+	router := gin.New()
+	ec2.New(client).Configure(router)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/2009-04-04/meta-data/instance-id", nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	// A cold cache can't answer lookups so clients are told to retry rather than that the
+	// instance doesn't exist. The lister must not be called.
+	if client.IsReady(context.Background()) {
+		t.Fatal("Expected backend to not be ready")
+	}
+	w := get()
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected: %d; Received: %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("Expected Retry-After header")
+	}
+
+	synced.Store(true)
+
+	lister.EXPECT().
+		List(gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, l *tinkv1.HardwareList, _ ...crclient.ListOption) error {
+			l.Items = append(l.Items, tinkv1.Hardware{
+				Spec: tinkv1.HardwareSpec{
+					Metadata: &tinkv1.HardwareMetadata{
+						Instance: &tinkv1.MetadataInstance{ID: "instance-id"},
+					},
+				},
+			})
+			return nil
+		})
+
+	if !client.IsReady(context.Background()) {
+		t.Fatal("Expected backend to be ready")
+	}
+	w = get()
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: %d; Received: %d", http.StatusOK, w.Code)
+	}
+	if body := w.Body.String(); body != "instance-id" {
+		t.Fatalf("Expected: instance-id; Received: %v", body)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
@@ -1,6 +1,9 @@
 package kubernetes
 
 import (
+	"time"
+
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
 	"k8s.io/client-go/rest"
 )
 
@@ -17,6 +20,41 @@ type Config struct {
 	// this namespace only. Optional.
 	Namespace string
 
+	// UserDataFragmentAnnotations are Hardware annotation keys whose values are user-data
+	// fragments, such as a base template, composed in order before the Hardware user-data.
+	// Optional.
+	UserDataFragmentAnnotations []string
+
+	// FieldMappings map EC2 endpoints, such as /meta-data/hostname, to Kubernetes JSONPath
+	// expressions, such as {.metadata.annotations.hostname}, selecting alternate Hardware fields
+	// to source the endpoint data from. Endpoints without a mapping, or whose mapping selects
+	// nothing, use the default Hardware fields. Optional.
+	FieldMappings map[string]string
+
+	// UserDataStateMappings map Hardware provisioning states, the .spec.metadata.state, such as
+	// provisioned, to Kubernetes JSONPath expressions, such as
+	// {.metadata.annotations.post-install-user-data}, selecting the Hardware field user-data is
+	// sourced from while the Hardware is in the state. States without a mapping, or whose mapping
+	// selects nothing, use the Hardware user-data. Optional.
+	UserDataStateMappings map[string]string
+
+	// UserDataPhaseMappings map boot phases, such as ipxe, to Kubernetes JSONPath expressions,
+	// such as {.metadata.annotations.ipxe-script}, selecting the Hardware field served as the
+	// user-data of requests made in the phase. Phases are selected by the EC2 frontend, see
+	// ec2.WithUserAgentPhases. Phases without a mapping, or whose mapping selects nothing, are
+	// served the Hardware user-data. Optional.
+	UserDataPhaseMappings map[string]string
+
+	// Ambiguous reports lookups by IP that match more than one Hardware. Regardless, the Hardware
+	// with the lowest namespace/name is returned for such lookups. Optional.
+	Ambiguous *ambiguous.Reporter
+
+	// CacheSyncTimeout, if positive, is how long NewBackend waits for the initial cache sync. If
+	// the cache hasn't synced in time NewBackend fails rather than leaving the Backend unready
+	// indefinitely, such as when Hardware can't be listed. If zero, NewBackend returns immediately
+	// and the cache syncs in the background. Optional.
+	CacheSyncTimeout time.Duration
+
 	// ClientConfig is a Kubernetes client config. If specified, it will be used instead of
 	// constructing a client using the other configuration in this object. Optional.
 	ClientConfig *rest.Config
@@ -0,0 +1,222 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// mappableFields are the EC2 endpoints whose data can be sourced from alternate Hardware fields
+// with field mappings.
+var mappableFields = map[string]func(*ec2.Instance) *string{
+	"/meta-data/instance-id":                               func(i *ec2.Instance) *string { return &i.Metadata.InstanceID },
+	"/meta-data/hostname":                                  func(i *ec2.Instance) *string { return &i.Metadata.Hostname },
+	"/meta-data/local-hostname":                            func(i *ec2.Instance) *string { return &i.Metadata.LocalHostname },
+	"/meta-data/iqn":                                       func(i *ec2.Instance) *string { return &i.Metadata.IQN },
+	"/meta-data/plan":                                      func(i *ec2.Instance) *string { return &i.Metadata.Plan },
+	"/meta-data/facility":                                  func(i *ec2.Instance) *string { return &i.Metadata.Facility },
+	"/meta-data/placement/region":                          func(i *ec2.Instance) *string { return &i.Metadata.Region },
+	"/meta-data/public-ipv4":                               func(i *ec2.Instance) *string { return &i.Metadata.PublicIPv4 },
+	"/meta-data/public-ipv6":                               func(i *ec2.Instance) *string { return &i.Metadata.PublicIPv6 },
+	"/meta-data/instance-action":                           func(i *ec2.Instance) *string { return &i.Metadata.InstanceAction },
+	"/meta-data/local-ipv4":                                func(i *ec2.Instance) *string { return &i.Metadata.LocalIPv4 },
+	"/meta-data/operating-system/slug":                     func(i *ec2.Instance) *string { return &i.Metadata.OperatingSystem.Slug },
+	"/meta-data/operating-system/distro":                   func(i *ec2.Instance) *string { return &i.Metadata.OperatingSystem.Distro },
+	"/meta-data/operating-system/version":                  func(i *ec2.Instance) *string { return &i.Metadata.OperatingSystem.Version },
+	"/meta-data/operating-system/image_tag":                func(i *ec2.Instance) *string { return &i.Metadata.OperatingSystem.ImageTag },
+	"/meta-data/operating-system/license_activation/state": func(i *ec2.Instance) *string { return &i.Metadata.OperatingSystem.LicenseActivation.State },
+}
+
+// MappableFields returns the sorted EC2 endpoints that support field mappings.
+func MappableFields() []string {
+	var fields []string
+	for f := range mappableFields {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// fieldMapping sources the data for an EC2 endpoint from a Hardware field selected with a
+// Kubernetes JSONPath expression.
+type fieldMapping struct {
+	endpoint string
+	field    func(*ec2.Instance) *string
+
+	// expr is the validated JSONPath expression. A jsonpath.JSONPath isn't safe for concurrent
+	// use so expr is parsed for each evaluation.
+	expr string
+}
+
+// parseFieldMappings parses mappings of EC2 endpoints, such as /meta-data/hostname, to Kubernetes
+// JSONPath expressions, such as {.metadata.labels.hostname}, selecting the Hardware field to
+// source the endpoint data from. Mappings are sorted by endpoint so they're applied consistently.
+func parseFieldMappings(mappings map[string]string) ([]fieldMapping, error) {
+	var parsed []fieldMapping
+	for endpoint, expr := range mappings {
+		field, ok := mappableFields[endpoint]
+		if !ok {
+			return nil, fmt.Errorf("field mapping: unsupported endpoint %v; options: %v",
+				endpoint, strings.Join(MappableFields(), ", "))
+		}
+
+		if _, err := parseJSONPath(endpoint, expr); err != nil {
+			return nil, fmt.Errorf("field mapping: %v: %w", endpoint, err)
+		}
+
+		parsed = append(parsed, fieldMapping{endpoint: endpoint, field: field, expr: expr})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		return parsed[i].endpoint < parsed[j].endpoint
+	})
+
+	return parsed, nil
+}
+
+// applyFieldMappings overrides the data of i with the Hardware fields selected by mappings. If a
+// mapping selects nothing, the canonical data is retained.
+func applyFieldMappings(i *ec2.Instance, hw tinkv1.Hardware, mappings []fieldMapping) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&hw)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range mappings {
+		path, err := parseJSONPath(m.endpoint, m.expr)
+		if err != nil {
+			return fmt.Errorf("field mapping: %v: %w", m.endpoint, err)
+		}
+
+		v, ok, err := findField(path, obj)
+		if err != nil {
+			return fmt.Errorf("field mapping: %v: %w", m.endpoint, err)
+		}
+		if ok {
+			*m.field(i) = v
+		}
+	}
+
+	return nil
+}
+
+// parseUserDataMappings parses mappings of keys, such as the Hardware provisioning state
+// provisioned or the boot phase ipxe, to Kubernetes JSONPath expressions, such as
+// {.metadata.annotations.user-data}, selecting the Hardware field to source user-data from for the
+// key. kind describes the mappings in errors. The returned mappings retain the validated
+// expressions as a jsonpath.JSONPath isn't safe for concurrent use.
+func parseUserDataMappings(kind string, mappings map[string]string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for key, expr := range mappings {
+		if _, err := parseJSONPath(key, expr); err != nil {
+			return nil, fmt.Errorf("%v: %v: %w", kind, key, err)
+		}
+		parsed[key] = expr
+	}
+	return parsed, nil
+}
+
+// applyUserDataStateMapping overrides the user-data of i with the Hardware field selected by the
+// mapping for the Hardware's provisioning state, .spec.metadata.state. If the state has no
+// mapping, or its mapping selects nothing, the canonical user-data is retained.
+func applyUserDataStateMapping(i *ec2.Instance, hw tinkv1.Hardware, mappings map[string]string) error {
+	if hw.Spec.Metadata == nil {
+		return nil
+	}
+
+	state := hw.Spec.Metadata.State
+	expr, ok := mappings[state]
+	if !ok {
+		return nil
+	}
+
+	path, err := parseJSONPath(state, expr)
+	if err != nil {
+		return fmt.Errorf("user-data state mapping: %v: %w", state, err)
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&hw)
+	if err != nil {
+		return err
+	}
+
+	v, ok, err := findField(path, obj)
+	if err != nil {
+		return fmt.Errorf("user-data state mapping: %v: %w", state, err)
+	}
+	if ok {
+		i.Userdata = v
+	}
+
+	return nil
+}
+
+// applyUserDataPhaseMappings sets the user-data of i for each boot phase, Instance.UserdataPhases,
+// to the Hardware field selected by the phase's mapping. Phases whose mapping selects nothing have
+// no user-data so requests made in them are served the user-data of i.
+func applyUserDataPhaseMappings(i *ec2.Instance, hw tinkv1.Hardware, mappings map[string]string) error {
+	if len(mappings) == 0 {
+		return nil
+	}
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&hw)
+	if err != nil {
+		return err
+	}
+
+	for phase, expr := range mappings {
+		path, err := parseJSONPath(phase, expr)
+		if err != nil {
+			return fmt.Errorf("user-data phase mapping: %v: %w", phase, err)
+		}
+
+		v, ok, err := findField(path, obj)
+		if err != nil {
+			return fmt.Errorf("user-data phase mapping: %v: %w", phase, err)
+		}
+		if !ok {
+			continue
+		}
+
+		if i.UserdataPhases == nil {
+			i.UserdataPhases = map[string]string{}
+		}
+		i.UserdataPhases[phase] = v
+	}
+
+	return nil
+}
+
+// parseJSONPath parses expr, a Kubernetes JSONPath expression named name, that tolerates missing
+// keys.
+func parseJSONPath(name, expr string) (*jsonpath.JSONPath, error) {
+	path := jsonpath.New(name).AllowMissingKeys(true)
+	if err := path.Parse(expr); err != nil {
+		return nil, err
+	}
+	return path, nil
+}
+
+// findField returns the first value selected by path from obj, an unstructured Hardware. If path
+// selects nothing it returns false.
+func findField(path *jsonpath.JSONPath, obj map[string]any) (string, bool, error) {
+	results, err := path.FindResults(obj)
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(results) == 0 || len(results[0]) == 0 {
+		return "", false, nil
+	}
+
+	return fmt.Sprint(results[0][0].Interface()), true, nil
+}
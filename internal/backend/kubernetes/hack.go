@@ -17,12 +17,12 @@ func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance
 	return toHackInstance(hw)
 }
 
-// toHackInstance converts a Tinkerbell Hardware resource to a hack.Instance by marshalling and
-// unmarshalling. This works because the Hardware resource has historical roots that align with
-// the hack.Instance struct that is derived from the rootio action. See the hack frontend for more
-// details.
+// toHackInstance converts a Tinkerbell Hardware resource to a hack.Instance by unmarshalling its
+// native metadata document. This works because the Hardware resource has historical roots that
+// align with the hack.Instance struct that is derived from the rootio action. See the hack frontend
+// for more details.
 func toHackInstance(hw tinkv1.Hardware) (hack.Instance, error) {
-	marshalled, err := json.Marshal(hw.Spec)
+	marshalled, err := toNativeMetadata(hw)
 	if err != nil {
 		return hack.Instance{}, err
 	}
@@ -1,6 +1,8 @@
 package kubernetes
 
 import (
+	"strings"
+
 	"github.com/tinkerbell/tink/api/v1alpha1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -9,17 +11,117 @@ import (
 // the controller-runtimes MatchingFields selector.
 const hardwareIPAddrIndex = ".Spec.Interfaces.DHCP.IP"
 
-// hardwareIPIndexFunc satisfies the controller runtimes index.
+// hardwareIPIndexFunc satisfies the controller runtimes index. Every address bound to the
+// Hardware is indexed: the DHCP address of each interface and any addresses listed in the
+// instance metadata.
 func hardwareIPIndexFunc(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	resp := []string{}
+	seen := map[string]struct{}{}
+	add := func(ip string) {
+		if _, ok := seen[ip]; ip == "" || ok {
+			return
+		}
+		seen[ip] = struct{}{}
+		resp = append(resp, ip)
+	}
+	for _, iface := range hw.Spec.Interfaces {
+		if iface.DHCP != nil && iface.DHCP.IP != nil {
+			add(iface.DHCP.IP.Address)
+		}
+	}
+	if hw.Spec.Metadata != nil && hw.Spec.Metadata.Instance != nil {
+		for _, ip := range hw.Spec.Metadata.Instance.Ips {
+			if ip != nil {
+				add(ip.Address)
+			}
+		}
+	}
+	return resp
+}
+
+// hardwareTenantIPAddrIndex is the index used to retrieve hardware by IP address scoped to the
+// tenant identified by the TenantLabel. It is used with the controller-runtimes MatchingFields
+// selector. Values are produced with tenantIPIndexValue.
+const hardwareTenantIPAddrIndex = ".Metadata.Labels.Tenant.Spec.Interfaces.DHCP.IP"
+
+// hardwareTenantIPIndexFunc satisfies the controller runtimes index. Every address indexed by
+// hardwareIPIndexFunc is indexed with the Hardware's tenant. Hardware without a tenant isn't
+// indexed.
+func hardwareTenantIPIndexFunc(obj client.Object) []string {
+	tenant := obj.GetLabels()[TenantLabel]
+	if tenant == "" {
+		return []string{}
+	}
+	resp := []string{}
+	for _, ip := range hardwareIPIndexFunc(obj) {
+		resp = append(resp, tenantIPIndexValue(tenant, ip))
+	}
+	return resp
+}
+
+// tenantIPIndexValue returns the hardwareTenantIPAddrIndex value for ip belonging to tenant.
+// Label values can't contain a slash so values are unambiguous.
+func tenantIPIndexValue(tenant, ip string) string {
+	return tenant + "/" + ip
+}
+
+// hardwareInstanceIDIndex is the index used to retrieve hardware by instance ID. It is used with
+// the controller-runtimes MatchingFields selector.
+const hardwareInstanceIDIndex = ".Spec.Metadata.Instance.ID"
+
+// hardwareInstanceIDIndexFunc satisfies the controller runtimes index.
+func hardwareInstanceIDIndexFunc(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	if hw.Spec.Metadata == nil || hw.Spec.Metadata.Instance == nil || hw.Spec.Metadata.Instance.ID == "" {
+		return []string{}
+	}
+	return []string{hw.Spec.Metadata.Instance.ID}
+}
+
+// hardwareHostnameIndex is the index used to retrieve hardware by instance hostname. It is used
+// with the controller-runtimes MatchingFields selector.
+const hardwareHostnameIndex = ".Spec.Metadata.Instance.Hostname"
+
+// hardwareHostnameIndexFunc satisfies the controller runtimes index. Hostnames are indexed in
+// lower case as DNS names are case insensitive.
+func hardwareHostnameIndexFunc(obj client.Object) []string {
+	hw, ok := obj.(*v1alpha1.Hardware)
+	if !ok {
+		return nil
+	}
+	if hw.Spec.Metadata == nil || hw.Spec.Metadata.Instance == nil || hw.Spec.Metadata.Instance.Hostname == "" {
+		return []string{}
+	}
+	return []string{strings.ToLower(hw.Spec.Metadata.Instance.Hostname)}
+}
+
+// hardwareMACAddrIndex is the index used to retrieve hardware by MAC address. It is used with
+// the controller-runtimes MatchingFields selector.
+const hardwareMACAddrIndex = ".Spec.Interfaces.DHCP.MAC"
+
+// hardwareMACIndexFunc satisfies the controller runtimes index. MACs are indexed in lower case.
+func hardwareMACIndexFunc(obj client.Object) []string {
 	hw, ok := obj.(*v1alpha1.Hardware)
 	if !ok {
 		return nil
 	}
 	resp := []string{}
 	for _, iface := range hw.Spec.Interfaces {
-		if iface.DHCP != nil && iface.DHCP.IP != nil && iface.DHCP.IP.Address != "" {
-			resp = append(resp, iface.DHCP.IP.Address)
+		if iface.DHCP != nil && iface.DHCP.MAC != "" {
+			resp = append(resp, strings.ToLower(iface.DHCP.MAC))
 		}
 	}
 	return resp
 }
+
+// hardwareKey uniquely identifies hw.
+func hardwareKey(hw *v1alpha1.Hardware) string {
+	return hw.Namespace + "/" + hw.Name
+}
@@ -0,0 +1,29 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+)
+
+// GetNativeMetadata satisfies native.Client. The native metadata document is the Hardware spec.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	hw, err := b.retrieveByIP(ctx, ip)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return nil, ec2.ErrInstanceNotFound
+		}
+
+		return nil, err
+	}
+
+	return toNativeMetadata(hw)
+}
+
+// toNativeMetadata converts a Tinkerbell Hardware resource to the native metadata document.
+func toNativeMetadata(hw tinkv1.Hardware) ([]byte, error) {
+	return json.Marshal(hw.Spec)
+}
@@ -0,0 +1,106 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sync"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// informer notifies event handlers of Hardware changes. It is satisfied by controller-runtime
+// informers.
+type informer interface {
+	AddEventHandler(handler toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error)
+}
+
+// changeNotifier notifies waiters of changes to the Hardware associated with an IP address from
+// informer events.
+type changeNotifier struct {
+	mu sync.Mutex
+
+	// waiters maps IP addresses to the channel closed on the next change to Hardware using the IP.
+	// Channels are created when first waited on and removed when closed so only watched IPs are
+	// tracked.
+	waiters map[string]chan struct{}
+}
+
+// newChangeNotifier creates a changeNotifier notified of changes by inf events.
+func newChangeNotifier(inf informer) (*changeNotifier, error) {
+	n := &changeNotifier{waiters: map[string]chan struct{}{}}
+
+	if _, err := inf.AddEventHandler(n); err != nil {
+		return nil, fmt.Errorf("add hardware event handler: %v", err)
+	}
+
+	return n, nil
+}
+
+// changes returns a channel closed on the next change to Hardware associated with ip.
+func (n *changeNotifier) changes(ip string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ch, ok := n.waiters[ip]
+	if !ok {
+		ch = make(chan struct{})
+		n.waiters[ip] = ch
+	}
+	return ch
+}
+
+// OnAdd satisfies toolscache.ResourceEventHandler.
+func (n *changeNotifier) OnAdd(obj any, _ bool) {
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		n.notify(hw)
+	}
+}
+
+// OnUpdate satisfies toolscache.ResourceEventHandler. Periodic resyncs redeliver Hardware with an
+// unchanged resource version so they don't wake waiters.
+func (n *changeNotifier) OnUpdate(oldObj, newObj any) {
+	hw, ok := newObj.(*tinkv1.Hardware)
+	if !ok {
+		return
+	}
+
+	old, ok := oldObj.(*tinkv1.Hardware)
+	if !ok {
+		n.notify(hw)
+		return
+	}
+
+	if old.ResourceVersion == hw.ResourceVersion {
+		return
+	}
+
+	// Waiters on IPs the Hardware no longer uses are notified too as they're no longer
+	// associated with it.
+	n.notify(old, hw)
+}
+
+// OnDelete satisfies toolscache.ResourceEventHandler.
+func (n *changeNotifier) OnDelete(obj any) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	if hw, ok := obj.(*tinkv1.Hardware); ok {
+		n.notify(hw)
+	}
+}
+
+// notify wakes waiters on the IPs of hw.
+func (n *changeNotifier) notify(hw ...*tinkv1.Hardware) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, h := range hw {
+		for _, ip := range hardwareIPIndexFunc(h) {
+			if ch, ok := n.waiters[ip]; ok {
+				close(ch)
+				delete(n.waiters, ip)
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//go:build !integration
+
+package kubernetes_test
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// fakeInformer records the registered event handlers so tests can fire events.
+type fakeInformer struct {
+	handler handlers
+}
+
+func (f *fakeInformer) AddEventHandler(h toolscache.ResourceEventHandler) (toolscache.ResourceEventHandlerRegistration, error) {
+	f.handler = append(f.handler, h)
+	return nil, nil
+}
+
+// handlers fires events at each handler in order.
+type handlers []toolscache.ResourceEventHandler
+
+func (h handlers) OnAdd(obj any, isInInitialList bool) {
+	for _, handler := range h {
+		handler.OnAdd(obj, isInInitialList)
+	}
+}
+
+func (h handlers) OnUpdate(oldObj, newObj any) {
+	for _, handler := range h {
+		handler.OnUpdate(oldObj, newObj)
+	}
+}
+
+func (h handlers) OnDelete(obj any) {
+	for _, handler := range h {
+		handler.OnDelete(obj)
+	}
+}
+
+func newHardware(name, id, ip string) *tinkv1.Hardware {
+	return &tinkv1.Hardware{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: tinkv1.HardwareSpec{
+			Metadata: &tinkv1.HardwareMetadata{
+				Instance: &tinkv1.MetadataInstance{ID: id},
+			},
+			Interfaces: []tinkv1.Interface{
+				{DHCP: &tinkv1.DHCP{IP: &tinkv1.IP{Address: ip}}},
+			},
+		},
+	}
+}
+
+func TestChanges(t *testing.T) {
+	inf := &fakeInformer{}
+	client, err := NewTestBackendWithInformer(NewMocklisterClient(gomock.NewController(t)), inf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hw := newHardware("hw1", "instance-1", "10.10.10.10")
+	hw.ResourceVersion = "1"
+
+	updated := hw.DeepCopy()
+	updated.ResourceVersion = "2"
+
+	other := newHardware("hw2", "instance-2", "10.10.10.11")
+
+	events := []struct {
+		Name         string
+		Fire         func()
+		ExpectClosed bool
+	}{
+		{Name: "Add", Fire: func() { inf.handler.OnAdd(hw, false) }, ExpectClosed: true},
+		{Name: "Resync", Fire: func() { inf.handler.OnUpdate(hw, hw) }},
+		{Name: "Update", Fire: func() { inf.handler.OnUpdate(hw, updated) }, ExpectClosed: true},
+		{Name: "OtherInstance", Fire: func() { inf.handler.OnAdd(other, false) }},
+		{Name: "Delete", Fire: func() { inf.handler.OnDelete(updated) }, ExpectClosed: true},
+	}
+
+	for _, event := range events {
+		changed := client.Changes("10.10.10.10")
+
+		select {
+		case <-changed:
+			t.Fatalf("%v: Changes closed before the event", event.Name)
+		default:
+		}
+
+		event.Fire()
+
+		var closed bool
+		select {
+		case <-changed:
+			closed = true
+		default:
+		}
+		if closed != event.ExpectClosed {
+			t.Fatalf("%v: Expected closed: %v; Received: %v", event.Name, event.ExpectClosed, closed)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// hardwareSizes tracks the approximate size of the Hardware held by the informer cache from
+// informer events so reporting the cache size doesn't need to list and encode every Hardware.
+type hardwareSizes struct {
+	mu sync.Mutex
+
+	// sizes maps Hardware namespace/name keys to the approximate size of the Hardware in bytes,
+	// its JSON encoded length.
+	sizes map[string]int
+}
+
+// newHardwareSizes creates a hardwareSizes updated by inf events.
+func newHardwareSizes(inf informer) (*hardwareSizes, error) {
+	s := &hardwareSizes{sizes: map[string]int{}}
+
+	if _, err := inf.AddEventHandler(s); err != nil {
+		return nil, fmt.Errorf("add hardware event handler: %v", err)
+	}
+
+	return s, nil
+}
+
+// OnAdd satisfies toolscache.ResourceEventHandler.
+func (s *hardwareSizes) OnAdd(obj any, _ bool) {
+	hw, ok := obj.(*tinkv1.Hardware)
+	if !ok {
+		return
+	}
+
+	n := hardwareSize(hw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sizes[hardwareKey(hw)] = n
+}
+
+// OnUpdate satisfies toolscache.ResourceEventHandler.
+func (s *hardwareSizes) OnUpdate(_, obj any) {
+	s.OnAdd(obj, false)
+}
+
+// OnDelete satisfies toolscache.ResourceEventHandler.
+func (s *hardwareSizes) OnDelete(obj any) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	hw, ok := obj.(*tinkv1.Hardware)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sizes, hardwareKey(hw))
+}
+
+// size returns the number of Hardware tracked and their approximate size in bytes.
+func (s *hardwareSizes) size() (entries, bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, n := range s.sizes {
+		bytes += len(key) + n
+	}
+	return len(s.sizes), bytes
+}
+
+// hardwareSize approximates the size of hw in bytes as the length of its JSON encoding.
+func hardwareSize(hw *tinkv1.Hardware) int {
+	b, err := json.Marshal(hw)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
@@ -0,0 +1,50 @@
+//go:build !integration
+
+package kubernetes_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/backend/kubernetes"
+)
+
+func TestCacheSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	lister := NewMocklisterClient(ctrl)
+
+	inf := &fakeInformer{}
+	client, err := NewTestBackendWithInformer(lister, inf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, bytes := client.CacheSize(); entries != 0 || bytes != 0 {
+		t.Fatalf("Expected empty cache; Received: %v entries, %v bytes", entries, bytes)
+	}
+
+	hw := newHardware("hw1", "instance-1", "10.10.10.10")
+	inf.handler.OnAdd(hw, true)
+
+	entries, small := client.CacheSize()
+	if entries != 1 || small == 0 {
+		t.Fatalf("Expected 1 non-empty entry; Received: %v entries, %v bytes", entries, small)
+	}
+
+	// Updates replace the size of the Hardware they update.
+	hw = hw.DeepCopy()
+	hw.Spec.UserData = ptr(strings.Repeat("a", 1024))
+	inf.handler.OnUpdate(nil, hw)
+
+	entries, large := client.CacheSize()
+	if entries != 1 || large < small+1024 {
+		t.Fatalf("Expected 1 entry of at least %v bytes; Received: %v entries, %v bytes", small+1024, entries, large)
+	}
+
+	inf.handler.OnDelete(hw)
+
+	if entries, bytes := client.CacheSize(); entries != 0 || bytes != 0 {
+		t.Fatalf("Expected empty cache; Received: %v entries, %v bytes", entries, bytes)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Package limit provides a backend wrapper that limits the number of concurrent instance lookups.
+
+During a boot storm many instances request metadata at once. Unbounded, the lookups they cause
+can overwhelm the Kubernetes API server. Limiting concurrent lookups protects the backend
+regardless of how many instances, or IPs, are making requests.
+*/
+package limit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// ErrSaturated indicates a lookup was rejected because the maximum number of concurrent lookups
+// were in progress. It wraps ec2.ErrBackendNotReady so frontends ask clients to retry.
+var ErrSaturated = fmt.Errorf("backend saturated: %w", ec2.ErrBackendNotReady)
+
+// Backend wraps a backend.Client limiting the number of concurrent lookups. Lookups beyond the
+// limit either wait for an in progress lookup to complete or fail with ErrSaturated.
+type Backend struct {
+	backend.Client
+
+	slots chan struct{}
+	queue bool
+
+	inFlight prometheus.Gauge
+	queued   prometheus.Gauge
+	rejected prometheus.Counter
+}
+
+// New creates a Backend that allows up to maxConcurrent concurrent lookups. If queue is true,
+// lookups beyond the limit wait until a lookup completes or their context is done, else they fail
+// immediately with ErrSaturated. It registers concurrency metrics with registrar.
+func New(client backend.Client, maxConcurrent int, queue bool, registrar prometheus.Registerer) *Backend {
+	inFlight := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "backend_lookups_in_flight",
+		Help: "Number of instance lookups currently in progress",
+	})
+
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "backend_lookups_queued",
+		Help: "Number of instance lookups waiting for an in progress lookup to complete",
+	})
+
+	rejected := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_lookups_rejected_total",
+		Help: "Count of instance lookups rejected because the concurrency limit was reached",
+	})
+
+	registrar.MustRegister(inFlight, queued, rejected)
+
+	return &Backend{
+		Client:   client,
+		slots:    make(chan struct{}, maxConcurrent),
+		queue:    queue,
+		inFlight: inFlight,
+		queued:   queued,
+		rejected: rejected,
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return do(ctx, b, func() (ec2.Instance, error) {
+		return b.Client.GetEC2Instance(ctx, ip)
+	})
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	return do(ctx, b, func() (ec2.Instance, error) {
+		return b.Client.GetEC2InstanceByMAC(ctx, mac)
+	})
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return do(ctx, b, func() (ec2.Instance, error) {
+		return ec2.GetInstanceByID(ctx, b.Client, id)
+	})
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return do(ctx, b, func() (ec2.Instance, error) {
+		return ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+	})
+}
+
+// GetHackInstance satisfies hack.Client.
+func (b *Backend) GetHackInstance(ctx context.Context, ip string) (hack.Instance, error) {
+	return do(ctx, b, func() (hack.Instance, error) {
+		return b.Client.GetHackInstance(ctx, ip)
+	})
+}
+
+// GetNativeMetadata satisfies native.Client.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return do(ctx, b, func() ([]byte, error) {
+		return native.GetMetadata(ctx, b.Client, ip)
+	})
+}
+
+// do performs lookup once a slot is acquired.
+func do[T any](ctx context.Context, b *Backend, lookup func() (T, error)) (T, error) {
+	if err := b.acquire(ctx); err != nil {
+		var zero T
+		return zero, err
+	}
+	defer b.release()
+
+	return lookup()
+}
+
+func (b *Backend) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Inc()
+		return nil
+	default:
+	}
+
+	if !b.queue {
+		b.rejected.Inc()
+		return ErrSaturated
+	}
+
+	b.queued.Inc()
+	defer b.queued.Dec()
+
+	select {
+	case b.slots <- struct{}{}:
+		b.inFlight.Inc()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Backend) release() {
+	b.inFlight.Dec()
+	<-b.slots
+}
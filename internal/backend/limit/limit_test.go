@@ -0,0 +1,198 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/limit"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func TestGetEC2InstanceConcurrencyCap(t *testing.T) {
+	const limit, requests = 3, 20
+
+	client := newBlockingClient()
+	registry := prometheus.NewRegistry()
+	b := New(client, limit, true, registry)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := b.GetEC2Instance(context.Background(), "10.10.10.10")
+			errs <- err
+		}()
+	}
+
+	// Wait until the limit is reached and every other lookup is queued.
+	waitFor(t, func() bool {
+		return client.current.Load() == limit &&
+			gaugeValue(t, registry, "backend_lookups_queued") == requests-limit
+	})
+
+	expect := `
+# HELP backend_lookups_in_flight Number of instance lookups currently in progress
+# TYPE backend_lookups_in_flight gauge
+backend_lookups_in_flight 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "backend_lookups_in_flight"); err != nil {
+		t.Fatal(err)
+	}
+
+	close(client.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if max := client.max.Load(); max != limit {
+		t.Fatalf("Expected max concurrent lookups: %d; Received: %d", limit, max)
+	}
+	if calls := client.calls.Load(); calls != requests {
+		t.Fatalf("Expected %d backend calls; Received: %d", requests, calls)
+	}
+}
+
+func TestGetEC2InstanceFailFast(t *testing.T) {
+	client := newBlockingClient()
+	registry := prometheus.NewRegistry()
+	b := New(client, 1, false, registry)
+
+	done := make(chan error)
+	go func() {
+		_, err := b.GetEC2Instance(context.Background(), "10.10.10.10")
+		done <- err
+	}()
+	waitFor(t, func() bool { return client.current.Load() == 1 })
+
+	_, err := b.GetEC2Instance(context.Background(), "10.10.10.11")
+	if !errors.Is(err, ErrSaturated) || !errors.Is(err, ec2.ErrBackendNotReady) {
+		t.Fatalf("Expected: %v; Received: %v", ErrSaturated, err)
+	}
+
+	close(client.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	expect := `
+# HELP backend_lookups_rejected_total Count of instance lookups rejected because the concurrency limit was reached
+# TYPE backend_lookups_rejected_total counter
+backend_lookups_rejected_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "backend_lookups_rejected_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEC2InstanceQueuedContextDone(t *testing.T) {
+	client := newBlockingClient()
+	defer close(client.release)
+
+	b := New(client, 1, true, prometheus.NewRegistry())
+
+	go func() { _, _ = b.GetEC2Instance(context.Background(), "10.10.10.10") }()
+	waitFor(t, func() bool { return client.current.Load() == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := b.GetEC2Instance(ctx, "10.10.10.11")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected: %v; Received: %v", context.DeadlineExceeded, err)
+	}
+}
+
+// waitFor polls cond until it's true failing the test if it isn't true within a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// gaugeValue retrieves the value of the gauge called name from registry.
+func gaugeValue(t *testing.T, registry *prometheus.Registry, name string) float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("Gauge not found: %v", name)
+	return 0
+}
+
+// blockingClient blocks lookups until release is closed tracking the number of concurrent
+// lookups.
+type blockingClient struct {
+	release chan struct{}
+
+	calls   atomic.Int64
+	current atomic.Int64
+	max     atomic.Int64
+}
+
+func newBlockingClient() *blockingClient {
+	return &blockingClient{release: make(chan struct{})}
+}
+
+func (c *blockingClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	c.calls.Add(1)
+	n := c.current.Add(1)
+	defer c.current.Add(-1)
+
+	for {
+		max := c.max.Load()
+		if n <= max || c.max.CompareAndSwap(max, n) {
+			break
+		}
+	}
+
+	<-c.release
+	return ec2.Instance{}, nil
+}
+
+func (c *blockingClient) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	return c.GetEC2Instance(ctx, mac)
+}
+
+func (c *blockingClient) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return c.GetEC2Instance(ctx, id)
+}
+
+func (c *blockingClient) GetEC2InstanceForTenant(ctx context.Context, _, ip string) (ec2.Instance, error) {
+	return c.GetEC2Instance(ctx, ip)
+}
+
+func (c *blockingClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (c *blockingClient) IsHealthy(context.Context) bool {
+	return true
+}
@@ -0,0 +1,8 @@
+package negativecache
+
+import "time"
+
+// SetClock replaces the clock used by b to determine entry expiry.
+func (b *Backend) SetClock(now func() time.Time) {
+	b.now = now
+}
@@ -0,0 +1,184 @@
+/*
+Package negativecache provides a backend wrapper that briefly remembers IPs for which no instance
+could be found.
+
+Instances commonly request metadata during boot before their hardware record exists, often in
+tight retry loops. Caching not-found results for a short period absorbs those loops without
+hiding a newly created record for long.
+*/
+package negativecache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// DefaultTTL is the default duration a not-found IP is cached for. Caching is disabled by default.
+const DefaultTTL = 0
+
+// entryOverhead approximates the bytes, beyond the key, of an entry: the key's string header and
+// expiry.
+const entryOverhead = 40
+
+// Backend wraps a backend.Client caching ec2.ErrInstanceNotFound results by IP, and by tenant and
+// IP for tenant scoped lookups.
+type Backend struct {
+	backend.Client
+
+	ttl  time.Duration
+	now  func() time.Time
+	hits prometheus.Counter
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+
+	// nextSweep is the earliest time store sweeps expired entries.
+	nextSweep time.Time
+}
+
+// New creates a Backend that caches not-found IPs for ttl. It registers a hit counter with
+// registrar.
+func New(client backend.Client, ttl time.Duration, registrar prometheus.Registerer) *Backend {
+	hits := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_negative_cache_hits_total",
+		Help: "Count of instance lookups answered as not found by the negative cache",
+	})
+
+	registrar.MustRegister(hits)
+
+	return &Backend{
+		Client:  client,
+		ttl:     ttl,
+		now:     time.Now,
+		hits:    hits,
+		expires: make(map[string]time.Time),
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client. If ip was recently not found it returns
+// ec2.ErrInstanceNotFound without querying the wrapped backend.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return b.lookup(ip, func() (ec2.Instance, error) {
+		return b.Client.GetEC2Instance(ctx, ip)
+	})
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient. If ip was recently not found for tenant it
+// returns ec2.ErrInstanceNotFound without querying the wrapped backend. Results are cached per
+// tenant as tenants may reuse IPs.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return b.lookup(tenantKey(tenant, ip), func() (ec2.Instance, error) {
+		return ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+	})
+}
+
+// lookup answers the lookup identified by key as not found if key was recently not found.
+// Otherwise, it calls fn caching its result if it's ec2.ErrInstanceNotFound.
+func (b *Backend) lookup(key string, fn func() (ec2.Instance, error)) (ec2.Instance, error) {
+	if b.isCached(key) {
+		b.hits.Inc()
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	instance, err := fn()
+	if errors.Is(err, ec2.ErrInstanceNotFound) {
+		b.store(key)
+	}
+
+	return instance, err
+}
+
+// tenantKey is the cache key of tenant scoped lookups of ip.
+func tenantKey(tenant, ip string) string {
+	return tenant + "/" + ip
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient. Lookups by instance ID aren't cached.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return ec2.GetInstanceByID(ctx, b.Client, id)
+}
+
+// GetNativeMetadata satisfies native.Client. Native metadata lookups aren't cached.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return native.GetMetadata(ctx, b.Client, ip)
+}
+
+// Flush evicts ip, including its tenant scoped lookups, from the cache so its next lookup queries
+// the wrapped backend. If ip is empty every entry is evicted. It returns the number of entries
+// evicted.
+func (b *Backend) Flush(ip string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ip != "" {
+		var n int
+		for key := range b.expires {
+			if key == ip || strings.HasSuffix(key, "/"+ip) {
+				delete(b.expires, key)
+				n++
+			}
+		}
+		return n
+	}
+
+	n := len(b.expires)
+	b.expires = make(map[string]time.Time)
+	return n
+}
+
+// CacheSize satisfies metrics.CacheSizer. Expired entries that haven't been swept are included as
+// they still consume memory.
+func (b *Backend) CacheSize() (entries, bytes int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for key := range b.expires {
+		bytes += len(key) + entryOverhead
+	}
+	return len(b.expires), bytes
+}
+
+func (b *Backend) isCached(key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	expires, ok := b.expires[key]
+	if !ok {
+		return false
+	}
+
+	if !b.now().Before(expires) {
+		delete(b.expires, key)
+		return false
+	}
+
+	return true
+}
+
+func (b *Backend) store(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	// Expired entries are evicted when looked up. Sweep the rest, at most once per TTL so the cost
+	// is amortized across misses, to stop IPs that are never requested again accumulating.
+	if !now.Before(b.nextSweep) {
+		for k, expires := range b.expires {
+			if !now.Before(expires) {
+				delete(b.expires, k)
+			}
+		}
+		b.nextSweep = now.Add(b.ttl)
+	}
+
+	b.expires[key] = now.Add(b.ttl)
+}
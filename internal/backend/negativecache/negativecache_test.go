@@ -0,0 +1,235 @@
+package negativecache_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/negativecache"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+func TestGetEC2InstanceQueriesOncePerTTL(t *testing.T) {
+	client := &fakeClient{err: ec2.ErrInstanceNotFound}
+	registry := prometheus.NewRegistry()
+
+	now := time.Unix(0, 0)
+	cache := New(client, 250*time.Millisecond, registry)
+	cache.SetClock(func() time.Time { return now })
+
+	lookup := func() {
+		t.Helper()
+		if _, err := cache.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected ErrInstanceNotFound; Received: %v", err)
+		}
+	}
+
+	// Repeated lookups within a TTL window should query the backend once.
+	for i := 0; i < 5; i++ {
+		lookup()
+		now = now.Add(40 * time.Millisecond)
+	}
+
+	if client.calls != 1 {
+		t.Fatalf("Expected 1 backend call; Received: %v", client.calls)
+	}
+
+	// Lookups after the TTL expires should query the backend again.
+	now = now.Add(250 * time.Millisecond)
+	lookup()
+	lookup()
+
+	if client.calls != 2 {
+		t.Fatalf("Expected 2 backend calls; Received: %v", client.calls)
+	}
+
+	expect := `
+# HELP backend_negative_cache_hits_total Count of instance lookups answered as not found by the negative cache
+# TYPE backend_negative_cache_hits_total counter
+backend_negative_cache_hits_total 5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpiredEntriesSwept(t *testing.T) {
+	client := &fakeClient{err: ec2.ErrInstanceNotFound}
+
+	now := time.Unix(0, 0)
+	cache := New(client, time.Second, prometheus.NewRegistry())
+	cache.SetClock(func() time.Time { return now })
+
+	lookup := func(ip string) {
+		t.Helper()
+		if _, err := cache.GetEC2Instance(context.Background(), ip); !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected ErrInstanceNotFound; Received: %v", err)
+		}
+	}
+
+	lookup("10.10.10.10")
+	now = now.Add(200 * time.Millisecond)
+	lookup("10.10.10.11")
+
+	// The first sweep was when 10.10.10.10 was cached so a TTL has passed and it's swept.
+	now = now.Add(900 * time.Millisecond)
+	lookup("10.10.10.12")
+
+	// 10.10.10.11 has expired but isn't swept until a TTL has passed since the last sweep.
+	now = now.Add(400 * time.Millisecond)
+	lookup("10.10.10.13")
+	if entries, _ := cache.CacheSize(); entries != 3 {
+		t.Fatalf("Expected 3 entries; Received: %v", entries)
+	}
+
+	now = now.Add(700 * time.Millisecond)
+	lookup("10.10.10.14")
+	if entries, _ := cache.CacheSize(); entries != 2 {
+		t.Fatalf("Expected 2 entries; Received: %v", entries)
+	}
+}
+
+func TestGetEC2InstanceDoesNotCacheFound(t *testing.T) {
+	client := &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "instance-id"}}}
+	cache := New(client, time.Minute, prometheus.NewRegistry())
+
+	for i := 0; i < 3; i++ {
+		instance, err := cache.GetEC2Instance(context.Background(), "10.10.10.10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if instance.Metadata.InstanceID != "instance-id" {
+			t.Fatalf("Unexpected instance: %v", instance)
+		}
+	}
+
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+}
+
+func TestGetEC2InstanceDoesNotCacheErrors(t *testing.T) {
+	client := &fakeClient{err: errors.New("backend unavailable")}
+	cache := New(client, time.Minute, prometheus.NewRegistry())
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetEC2Instance(context.Background(), "10.10.10.10"); err == nil {
+			t.Fatal("Expected error, received nil")
+		}
+	}
+
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+}
+
+func TestGetEC2InstanceForTenantCachedPerTenant(t *testing.T) {
+	client := &fakeClient{err: ec2.ErrInstanceNotFound}
+	cache := New(client, time.Minute, prometheus.NewRegistry())
+
+	lookup := func(tenant string) {
+		t.Helper()
+		_, err := cache.GetEC2InstanceForTenant(context.Background(), tenant, "10.10.10.10")
+		if !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected ErrInstanceNotFound; Received: %v", err)
+		}
+	}
+
+	lookup("tenant-a")
+	lookup("tenant-a")
+	if client.calls != 1 {
+		t.Fatalf("Expected 1 backend call; Received: %v", client.calls)
+	}
+
+	// Tenants may reuse IPs so a miss for one tenant isn't a miss for another.
+	lookup("tenant-b")
+	if client.calls != 2 {
+		t.Fatalf("Expected 2 backend calls; Received: %v", client.calls)
+	}
+
+	// Flushing the IP evicts its lookups for every tenant.
+	if n := cache.Flush("10.10.10.10"); n != 2 {
+		t.Fatalf("Expected 2 entries flushed; Received: %v", n)
+	}
+	lookup("tenant-a")
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+}
+
+type fakeClient struct {
+	instance ec2.Instance
+	err      error
+	calls    int
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	f.calls++
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	f.calls++
+	return f.instance, f.err
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return true
+}
+
+func TestCacheSizeMetrics(t *testing.T) {
+	client := &fakeClient{err: ec2.ErrInstanceNotFound}
+	registry := prometheus.NewRegistry()
+
+	cache := New(client, time.Minute, registry)
+	metrics.RegisterCacheSize(registry, map[string]metrics.CacheSizer{"negative": cache})
+
+	expectSize := func(entries, bytes int) {
+		t.Helper()
+
+		expect := fmt.Sprintf(`
+# HELP cache_entries Number of entries in a cache
+# TYPE cache_entries gauge
+cache_entries{cache="negative"} %v
+# HELP cache_size_bytes Approximate size of the entries in a cache in bytes
+# TYPE cache_size_bytes gauge
+cache_size_bytes{cache="negative"} %v
+`, entries, bytes)
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "cache_entries", "cache_size_bytes"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expectSize(0, 0)
+
+	for _, ip := range []string{"10.10.10.10", "10.10.10.11"} {
+		if _, err := cache.GetEC2Instance(context.Background(), ip); !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected ErrInstanceNotFound; Received: %v", err)
+		}
+	}
+
+	// Each entry is its 11 byte IP and 40 bytes of overhead.
+	expectSize(2, 102)
+
+	cache.Flush("")
+	expectSize(0, 0)
+}
@@ -0,0 +1,182 @@
+/*
+Package ptr provides a backend wrapper that falls back to finding an instance by the hostname its
+IP reverse resolves to.
+
+Some environments address machines by DNS rather than recording every IP against the hardware.
+When a lookup by IP finds nothing the IP's PTR records are resolved and the lookup is retried by
+each resolved hostname. Resolution is bounded by a timeout so a slow resolver can't stall requests
+and is only attempted after the direct lookup misses.
+
+Whoever controls the reverse zone of an IP controls its PTR records so a PTR name is only trusted
+once it's forward confirmed: the name must resolve back to the requester's IP. Otherwise a
+requester could claim another machine's hostname and receive its metadata.
+*/
+package ptr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// DefaultTimeout is the default time allowed for reverse resolving an IP.
+const DefaultTimeout = 500 * time.Millisecond
+
+// Resolver resolves IP addresses to names and names to IP addresses. *net.Resolver satisfies
+// Resolver.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// HostnameClient retrieves instances by hostname.
+type HostnameClient interface {
+	// GetEC2InstanceByHostname retrieves the instance with hostname. If no instance has hostname
+	// it returns ec2.ErrInstanceNotFound.
+	GetEC2InstanceByHostname(ctx context.Context, hostname string) (ec2.Instance, error)
+}
+
+// Client is a backend.Client that can retrieve instances by hostname.
+type Client interface {
+	backend.Client
+	HostnameClient
+}
+
+// Backend wraps a Client retrying lookups by IP that find no instance using the hostnames the IP
+// reverse resolves to.
+type Backend struct {
+	Client
+
+	resolver Resolver
+	timeout  time.Duration
+	domain   string
+	logger   logr.Logger
+	hits     prometheus.Counter
+}
+
+// New creates a Backend that resolves IPs with resolver, allowing timeout for resolving each IP.
+// If domain isn't empty, confirmed names within domain are also tried without it so they match
+// hardware recording short hostnames. It registers a hit counter with registrar.
+func New(
+	client Client,
+	resolver Resolver,
+	timeout time.Duration,
+	domain string,
+	logger logr.Logger,
+	registrar prometheus.Registerer,
+) *Backend {
+	hits := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_ptr_fallback_hits_total",
+		Help: "Count of instance lookups by IP answered using the hostname the IP reverse resolves to",
+	})
+
+	registrar.MustRegister(hits)
+
+	return &Backend{
+		Client:   client,
+		resolver: resolver,
+		timeout:  timeout,
+		domain:   strings.ToLower(strings.Trim(domain, ".")),
+		logger:   logger,
+		hits:     hits,
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client. If no instance has ip, ip is reverse resolved and the
+// lookup retried by each forward confirmed hostname in turn. Names within the configured domain
+// are then tried without the domain.
+//
+// Resolution failures are logged and the original ec2.ErrInstanceNotFound returned.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	instance, err := b.Client.GetEC2Instance(ctx, ip)
+	if !errors.Is(err, ec2.ErrInstanceNotFound) {
+		return instance, err
+	}
+
+	for _, hostname := range b.resolve(ctx, ip) {
+		resolved, herr := b.GetEC2InstanceByHostname(ctx, hostname)
+		switch {
+		case herr == nil:
+			b.hits.Inc()
+			return resolved, nil
+		case !errors.Is(herr, ec2.ErrInstanceNotFound):
+			return ec2.Instance{}, herr
+		}
+	}
+
+	return instance, err
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return ec2.GetInstanceByID(ctx, b.Client, id)
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient. Tenant scoped lookups aren't reverse
+// resolved.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+}
+
+// GetNativeMetadata satisfies native.Client. Native metadata lookups aren't reverse resolved.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return native.GetMetadata(ctx, b.Client, ip)
+}
+
+// resolve returns the forward confirmed hostnames to try for ip in order of preference.
+func (b *Backend) resolve(ctx context.Context, ip string) []string {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	names, err := b.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		b.logger.V(1).Info("Reverse resolve failed", "ip", ip, "error", err)
+		return nil
+	}
+
+	var confirmed []string
+	for _, name := range names {
+		// DNS names are case insensitive.
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name != "" && b.confirm(ctx, ip, name) {
+			confirmed = append(confirmed, name)
+		}
+	}
+
+	hostnames := confirmed
+	if b.domain != "" {
+		for _, name := range confirmed {
+			if short, ok := strings.CutSuffix(name, "."+b.domain); ok && short != "" {
+				hostnames = append(hostnames, short)
+			}
+		}
+	}
+	return hostnames
+}
+
+// confirm reports whether name resolves to ip.
+func (b *Backend) confirm(ctx context.Context, ip, name string) bool {
+	addrs, err := b.resolver.LookupIPAddr(ctx, name)
+	if err != nil {
+		b.logger.V(1).Info("Forward resolve failed", "ip", ip, "name", name, "error", err)
+		return false
+	}
+
+	want := net.ParseIP(ip)
+	for _, addr := range addrs {
+		if addr.IP.Equal(want) {
+			return true
+		}
+	}
+
+	b.logger.V(1).Info("Ignoring PTR name that doesn't resolve to the IP", "ip", ip, "name", name)
+	return false
+}
@@ -0,0 +1,255 @@
+package ptr_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/backend"
+	. "github.com/tinkerbell/hegel/internal/backend/ptr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestGetEC2Instance(t *testing.T) {
+	cases := []struct {
+		Name          string
+		IP            string
+		Domain        string
+		ExpectedID    string
+		ExpectedError error
+	}{
+		{
+			Name:       "DirectHit",
+			IP:         "10.10.10.10",
+			ExpectedID: "direct",
+		},
+		{
+			Name:       "FullyQualifiedHostname",
+			IP:         "10.10.10.11",
+			ExpectedID: "fqdn",
+		},
+		{
+			// The PTR name doesn't resolve back to the IP so it could be claimed by anyone
+			// controlling the IP's reverse zone.
+			Name:          "Unconfirmed",
+			IP:            "10.10.10.16",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:       "ShortHostnameInDomain",
+			IP:         "10.10.10.12",
+			Domain:     "example.com.",
+			ExpectedID: "short",
+		},
+		{
+			Name:          "ShortHostnameOutsideDomain",
+			IP:            "10.10.10.17",
+			Domain:        "example.com",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:          "ShortHostnameWithoutDomain",
+			IP:            "10.10.10.12",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:       "LaterName",
+			IP:         "10.10.10.13",
+			ExpectedID: "fqdn",
+		},
+		{
+			Name:          "UnknownHostname",
+			IP:            "10.10.10.14",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{
+			Name:          "NoPTR",
+			IP:            "10.10.10.15",
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			b := New(newFakeClient(), newStubResolver(), time.Second, tc.Domain, logr.Discard(), prometheus.NewRegistry())
+
+			instance, err := b.GetEC2Instance(context.Background(), tc.IP)
+			if !errors.Is(err, tc.ExpectedError) {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedError, err)
+			}
+
+			if instance.Metadata.InstanceID != tc.ExpectedID {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedID, instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetEC2InstanceResolverTimeout(t *testing.T) {
+	resolver := newStubResolver()
+	resolver.block = true
+	b := New(newFakeClient(), resolver, 10*time.Millisecond, "", logr.Discard(), prometheus.NewRegistry())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.GetEC2Instance(context.Background(), "10.10.10.11")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ec2.ErrInstanceNotFound) {
+			t.Fatalf("Expected: %v; Received: %v", ec2.ErrInstanceNotFound, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the resolver to be abandoned")
+	}
+}
+
+func TestGetEC2InstanceDirectHitSkipsResolver(t *testing.T) {
+	resolver := newStubResolver()
+	b := New(newFakeClient(), resolver, time.Second, "", logr.Discard(), prometheus.NewRegistry())
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); err != nil {
+		t.Fatal(err)
+	}
+
+	if resolver.calls != 0 {
+		t.Fatalf("Expected 0 resolver calls; Received: %v", resolver.calls)
+	}
+}
+
+func TestGetEC2InstanceBackendError(t *testing.T) {
+	errConnection := errors.New("connection refused")
+	client := newFakeClient()
+	client.hostnameErr = errConnection
+
+	b := New(client, newStubResolver(), time.Second, "", logr.Discard(), prometheus.NewRegistry())
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.11"); !errors.Is(err, errConnection) {
+		t.Fatalf("Expected: %v; Received: %v", errConnection, err)
+	}
+}
+
+func TestHitsMetric(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	b := New(newFakeClient(), newStubResolver(), time.Second, "", logr.Discard(), registry)
+
+	for _, ip := range []string{"10.10.10.10", "10.10.10.11", "10.10.10.15"} {
+		_, _ = b.GetEC2Instance(context.Background(), ip)
+	}
+
+	expect := `
+# HELP backend_ptr_fallback_hits_total Count of instance lookups by IP answered using the hostname the IP reverse resolves to
+# TYPE backend_ptr_fallback_hits_total counter
+backend_ptr_fallback_hits_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// stubResolver resolves IPs to the names in names and names to the IPs in addrs. If block is
+// true, lookups wait for the context to be done.
+type stubResolver struct {
+	names map[string][]string
+	addrs map[string][]string
+	block bool
+	calls int
+}
+
+func newStubResolver() *stubResolver {
+	return &stubResolver{
+		names: map[string][]string{
+			"10.10.10.11": {"SM01.example.com."},
+			"10.10.10.12": {"sm02.example.com."},
+			"10.10.10.13": {"unknown.example.com.", "sm01.example.com."},
+			"10.10.10.14": {"unknown.example.com."},
+			"10.10.10.16": {"sm01.example.com."},
+			"10.10.10.17": {"sm02.attacker.example."},
+		},
+		addrs: map[string][]string{
+			"sm01.example.com":      {"10.10.10.11", "10.10.10.13"},
+			"sm02.example.com":      {"10.10.10.12"},
+			"unknown.example.com":   {"10.10.10.13", "10.10.10.14"},
+			"sm02.attacker.example": {"10.10.10.17"},
+		},
+	}
+}
+
+func (s *stubResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	s.calls++
+	if s.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	names, ok := s.names[addr]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return names, nil
+}
+
+func (s *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := s.addrs[strings.ToLower(host)]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+// fakeClient serves instances by IP and hostname. Other backend.Client methods aren't used.
+type fakeClient struct {
+	backend.Client
+
+	ips         map[string]ec2.Instance
+	hostnames   map[string]ec2.Instance
+	hostnameErr error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		ips: map[string]ec2.Instance{
+			"10.10.10.10": instance("direct"),
+		},
+		hostnames: map[string]ec2.Instance{
+			"sm01.example.com": instance("fqdn"),
+			"sm02":             instance("short"),
+		},
+	}
+}
+
+func instance(id string) ec2.Instance {
+	var i ec2.Instance
+	i.Metadata.InstanceID = id
+	return i
+}
+
+func (f *fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	i, ok := f.ips[ip]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return i, nil
+}
+
+func (f *fakeClient) GetEC2InstanceByHostname(_ context.Context, hostname string) (ec2.Instance, error) {
+	if f.hostnameErr != nil {
+		return ec2.Instance{}, f.hostnameErr
+	}
+	i, ok := f.hostnames[hostname]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return i, nil
+}
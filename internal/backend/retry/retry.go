@@ -0,0 +1,109 @@
+/*
+Package retry provides a backend wrapper that retries instance lookups that fail with transient
+errors, such as a connection reset by the Kubernetes API server.
+*/
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+const (
+	// DefaultMaxRetries is the default number of times a failed lookup is retried. Retries are
+	// disabled by default.
+	DefaultMaxRetries = 0
+
+	// DefaultBackoff is the default time waited before the first retry. The wait doubles for each
+	// subsequent retry.
+	DefaultBackoff = 100 * time.Millisecond
+)
+
+// Backend wraps a backend.Client retrying ec2.Client lookups that fail with transient errors as
+// determined by backend.IsTransient. Retries stop early if the lookup context is done before the
+// next attempt.
+type Backend struct {
+	backend.Client
+
+	maxRetries int
+	backoff    time.Duration
+	retries    prometheus.Counter
+}
+
+// New creates a Backend that retries failed lookups up to maxRetries times waiting backoff,
+// doubling for each retry, between attempts. It registers a retry counter with registrar.
+func New(client backend.Client, maxRetries int, backoff time.Duration, registrar prometheus.Registerer) *Backend {
+	retries := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_lookup_retries_total",
+		Help: "Count of instance lookups retried after a transient backend error",
+	})
+
+	registrar.MustRegister(retries)
+
+	return &Backend{
+		Client:     client,
+		maxRetries: maxRetries,
+		backoff:    backoff,
+		retries:    retries,
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	return b.do(ctx, func() (ec2.Instance, error) {
+		return b.Client.GetEC2Instance(ctx, ip)
+	})
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	return b.do(ctx, func() (ec2.Instance, error) {
+		return b.Client.GetEC2InstanceByMAC(ctx, mac)
+	})
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	return b.do(ctx, func() (ec2.Instance, error) {
+		return ec2.GetInstanceByID(ctx, b.Client, id)
+	})
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	return b.do(ctx, func() (ec2.Instance, error) {
+		return ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+	})
+}
+
+// GetNativeMetadata satisfies native.Client. Native metadata lookups aren't retried.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return native.GetMetadata(ctx, b.Client, ip)
+}
+
+func (b *Backend) do(ctx context.Context, lookup func() (ec2.Instance, error)) (ec2.Instance, error) {
+	backoff := b.backoff
+
+	for attempt := 0; ; attempt++ {
+		instance, err := lookup()
+		if !backend.IsTransient(err) || attempt >= b.maxRetries {
+			return instance, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ec2.Instance{}, err
+		case <-timer.C:
+		}
+
+		b.retries.Inc()
+		backoff *= 2
+	}
+}
@@ -0,0 +1,155 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/retry"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+var errTransient = fmt.Errorf("list hardware: %w", syscall.ECONNRESET)
+
+func TestGetEC2InstanceRetriesTransientErrors(t *testing.T) {
+	client := &fakeClient{
+		errs:     []error{errTransient, errTransient},
+		instance: ec2.Instance{Metadata: ec2.Metadata{InstanceID: "instance-id"}},
+	}
+	registry := prometheus.NewRegistry()
+
+	b := New(client, 3, time.Millisecond, registry)
+
+	instance, err := b.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if instance.Metadata.InstanceID != "instance-id" {
+		t.Fatalf("Unexpected instance: %v", instance)
+	}
+
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+
+	expect := `
+# HELP backend_lookup_retries_total Count of instance lookups retried after a transient backend error
+# TYPE backend_lookup_retries_total counter
+backend_lookup_retries_total 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetEC2InstanceRetriesExhausted(t *testing.T) {
+	client := &fakeClient{errs: []error{errTransient, errTransient, errTransient}}
+
+	b := New(client, 2, time.Millisecond, prometheus.NewRegistry())
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, errTransient) {
+		t.Fatalf("Expected: %v; Received: %v", errTransient, err)
+	}
+
+	if client.calls != 3 {
+		t.Fatalf("Expected 3 backend calls; Received: %v", client.calls)
+	}
+}
+
+func TestGetEC2InstanceNotFoundFailsFast(t *testing.T) {
+	client := &fakeClient{errs: []error{ec2.ErrInstanceNotFound}}
+
+	b := New(client, 2, time.Millisecond, prometheus.NewRegistry())
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, ec2.ErrInstanceNotFound) {
+		t.Fatalf("Expected: %v; Received: %v", ec2.ErrInstanceNotFound, err)
+	}
+
+	if client.calls != 1 {
+		t.Fatalf("Expected 1 backend call; Received: %v", client.calls)
+	}
+}
+
+func TestGetEC2InstancePermanentErrorFailsFast(t *testing.T) {
+	// Errors not positively identified as transient, such as hardware that can't be converted,
+	// would fail again so aren't retried.
+	errPermanent := errors.New("multiple hardware found")
+	client := &fakeClient{errs: []error{errPermanent}}
+
+	b := New(client, 2, time.Millisecond, prometheus.NewRegistry())
+
+	if _, err := b.GetEC2Instance(context.Background(), "10.10.10.10"); !errors.Is(err, errPermanent) {
+		t.Fatalf("Expected: %v; Received: %v", errPermanent, err)
+	}
+
+	if client.calls != 1 {
+		t.Fatalf("Expected 1 backend call; Received: %v", client.calls)
+	}
+}
+
+func TestGetEC2InstanceStopsWhenContextDone(t *testing.T) {
+	client := &fakeClient{errs: []error{errTransient, errTransient}}
+
+	// The backoff exceeds the context deadline so the lookup should give up without retrying.
+	b := New(client, 2, time.Minute, prometheus.NewRegistry())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := b.GetEC2Instance(ctx, "10.10.10.10"); !errors.Is(err, errTransient) {
+		t.Fatalf("Expected: %v; Received: %v", errTransient, err)
+	}
+
+	if client.calls != 1 {
+		t.Fatalf("Expected 1 backend call; Received: %v", client.calls)
+	}
+}
+
+// fakeClient returns errs in order for each lookup followed by instance.
+type fakeClient struct {
+	errs     []error
+	instance ec2.Instance
+	calls    int
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	return f.next()
+}
+
+func (f *fakeClient) next() (ec2.Instance, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return ec2.Instance{}, err
+	}
+	return f.instance, nil
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return true
+}
@@ -0,0 +1,79 @@
+/*
+Package tenantscope provides a backend wrapper that scopes instance lookups to the tenant of the
+request.
+
+Tenants may reuse IP ranges so an IP alone doesn't identify an instance. The wrapper combines the
+tenant stored in the lookup context by tenant.Middleware with the lookup key so an instance is
+only ever found by requests from its own tenant. Lookups from another tenant, or without a tenant,
+fail with ec2.ErrInstanceNotFound so the existence of other tenants' instances isn't disclosed.
+*/
+package tenantscope
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/tenant"
+)
+
+// ErrHackUnsupported indicates a hack instance was requested with tenant scoping enabled. Hack
+// instances carry no tenant so can't be scoped.
+var ErrHackUnsupported = errors.New("hack instances can't be scoped to a tenant")
+
+// Backend wraps a backend.Client scoping lookups to the tenant in the lookup context.
+type Backend struct {
+	backend.Client
+}
+
+// New creates a Backend wrapping client. client should satisfy ec2.TenantClient else lookups by IP
+// fail with ec2.ErrLookupUnsupported.
+func New(client backend.Client) *Backend {
+	return &Backend{Client: client}
+}
+
+// GetEC2Instance satisfies ec2.Client. It retrieves the instance associated with ip belonging to
+// the tenant in ctx.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	t, ok := tenant.FromContext(ctx)
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return ec2.GetInstanceForTenant(ctx, b.Client, t, ip)
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client. Instances that don't belong to the tenant in ctx
+// aren't found.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	instance, err := b.Client.GetEC2InstanceByMAC(ctx, mac)
+	return scope(ctx, instance, err)
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient. Instances that don't belong to the tenant in ctx
+// aren't found.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	instance, err := ec2.GetInstanceByID(ctx, b.Client, id)
+	return scope(ctx, instance, err)
+}
+
+// GetHackInstance satisfies hack.Client. Hack instances can't be scoped so it always fails with
+// ErrHackUnsupported.
+func (b *Backend) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, ErrHackUnsupported
+}
+
+// scope returns ec2.ErrInstanceNotFound in place of instance if it doesn't belong to the tenant
+// in ctx.
+func scope(ctx context.Context, instance ec2.Instance, err error) (ec2.Instance, error) {
+	if err != nil {
+		return instance, err
+	}
+
+	if t, ok := tenant.FromContext(ctx); !ok || instance.Tenant != t {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+
+	return instance, nil
+}
@@ -0,0 +1,116 @@
+package tenantscope_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
+	. "github.com/tinkerbell/hegel/internal/backend/tenantscope"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/tenant"
+)
+
+// Both tenants have an instance at 10.10.10.10.
+const instances = `
+- tenant: "tenant-a"
+  macs: ["00:00:00:00:00:0a"]
+  metadata:
+    id: "a"
+    ipv4:
+      local: "10.10.10.10"
+- tenant: "tenant-b"
+  macs: ["00:00:00:00:00:0b"]
+  metadata:
+    id: "b"
+    ipv4:
+      local: "10.10.10.10"
+`
+
+func TestIsolation(t *testing.T) {
+	client, err := flatfile.FromYAML(strings.NewReader(instances))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	be := New(client)
+
+	type lookup func(context.Context) (ec2.Instance, error)
+
+	byIP := func(ctx context.Context) (ec2.Instance, error) {
+		return be.GetEC2Instance(ctx, "10.10.10.10")
+	}
+	byMAC := func(mac string) lookup {
+		return func(ctx context.Context) (ec2.Instance, error) {
+			return be.GetEC2InstanceByMAC(ctx, mac)
+		}
+	}
+	byID := func(id string) lookup {
+		return func(ctx context.Context) (ec2.Instance, error) {
+			return be.GetEC2InstanceByID(ctx, id)
+		}
+	}
+
+	cases := []struct {
+		Name          string
+		Tenant        string
+		Lookup        lookup
+		ExpectedID    string
+		ExpectedError error
+	}{
+		{Name: "IPTenantA", Tenant: "tenant-a", Lookup: byIP, ExpectedID: "a"},
+		{Name: "IPTenantB", Tenant: "tenant-b", Lookup: byIP, ExpectedID: "b"},
+		{Name: "IPOtherTenant", Tenant: "tenant-c", Lookup: byIP, ExpectedError: ec2.ErrInstanceNotFound},
+		{Name: "IPNoTenant", Lookup: byIP, ExpectedError: ec2.ErrInstanceNotFound},
+		{Name: "MACOwnTenant", Tenant: "tenant-a", Lookup: byMAC("00:00:00:00:00:0a"), ExpectedID: "a"},
+		{
+			Name:          "MACOtherTenant",
+			Tenant:        "tenant-a",
+			Lookup:        byMAC("00:00:00:00:00:0b"),
+			ExpectedError: ec2.ErrInstanceNotFound,
+		},
+		{Name: "MACNoTenant", Lookup: byMAC("00:00:00:00:00:0a"), ExpectedError: ec2.ErrInstanceNotFound},
+		{Name: "IDOwnTenant", Tenant: "tenant-b", Lookup: byID("b"), ExpectedID: "b"},
+		{Name: "IDOtherTenant", Tenant: "tenant-b", Lookup: byID("a"), ExpectedError: ec2.ErrInstanceNotFound},
+		{Name: "IDNotFound", Tenant: "tenant-b", Lookup: byID("unknown"), ExpectedError: ec2.ErrInstanceNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.Tenant != "" {
+				ctx = tenant.NewContext(ctx, tc.Tenant)
+			}
+
+			instance, err := tc.Lookup(ctx)
+
+			if tc.ExpectedError != nil {
+				if !errors.Is(err, tc.ExpectedError) {
+					t.Fatalf("Expected: %v; Received: %v", tc.ExpectedError, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if instance.Metadata.InstanceID != tc.ExpectedID {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectedID, instance.Metadata.InstanceID)
+			}
+		})
+	}
+}
+
+func TestGetHackInstanceUnsupported(t *testing.T) {
+	client, err := flatfile.FromYAML(strings.NewReader(instances))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := tenant.NewContext(context.Background(), "tenant-a")
+	if _, err := New(client).GetHackInstance(ctx, "10.10.10.10"); !errors.Is(err, ErrHackUnsupported) {
+		t.Fatalf("Expected: %v; Received: %v", ErrHackUnsupported, err)
+	}
+}
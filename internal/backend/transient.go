@@ -0,0 +1,37 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// IsTransient returns true if err indicates the backend couldn't answer a lookup rather than
+// answering it: network errors and API server responses indicating it's overloaded or failing.
+// Such lookups may succeed if retried and reflect the health of the backend.
+//
+// Errors are only transient if positively identified so permanent errors, such as an unsupported
+// lookup or hardware that can't be converted, aren't retried. Lookups whose context is done aren't
+// transient as the caller abandoned them.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
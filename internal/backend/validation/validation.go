@@ -0,0 +1,175 @@
+/*
+Package validation provides a backend wrapper that validates the structure of instances retrieved
+from the wrapped backend.
+
+Backends map hardware data onto instances. When the hardware data has an unexpected shape, such as
+an IPv6 address in an IPv4 field, endpoints silently serve bad or empty data. Validating instances
+at lookup time surfaces those problems to operators through logs and metrics. Invalid instances are
+still served so validation never causes an outage.
+*/
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+)
+
+// FieldError describes an instance field with an unexpected structure.
+type FieldError struct {
+	// Field is the instance field, such as Metadata.LocalIPv4.
+	Field string
+
+	// Reason describes why the field is invalid.
+	Reason string
+}
+
+// Error satisfies the error interface.
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Field, e.Reason)
+}
+
+// Validate validates the structure of i. Each invalid field is reported as a *FieldError joined in
+// the returned error.
+func Validate(i ec2.Instance) error {
+	var errs []error
+	invalid := func(field, format string, args ...any) {
+		errs = append(errs, &FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if i.Metadata.InstanceID == "" {
+		invalid("Metadata.InstanceID", "empty")
+	}
+
+	if strings.ContainsAny(i.Metadata.Hostname, " \t\r\n") {
+		invalid("Metadata.Hostname", "contains whitespace: %q", i.Metadata.Hostname)
+	}
+
+	for _, f := range []struct{ Name, IP string }{
+		{"Metadata.LocalIPv4", i.Metadata.LocalIPv4},
+		{"Metadata.PublicIPv4", i.Metadata.PublicIPv4},
+	} {
+		if parsed := net.ParseIP(f.IP); f.IP != "" && (parsed == nil || parsed.To4() == nil) {
+			invalid(f.Name, "not an IPv4 address: %q", f.IP)
+		}
+	}
+
+	if ip := i.Metadata.PublicIPv6; ip != "" {
+		if parsed := net.ParseIP(ip); parsed == nil || parsed.To4() != nil {
+			invalid("Metadata.PublicIPv6", "not an IPv6 address: %q", ip)
+		}
+	}
+
+	for idx, key := range i.Metadata.PublicKeys {
+		if strings.TrimSpace(key) == "" {
+			invalid(fmt.Sprintf("Metadata.PublicKeys[%d]", idx), "empty")
+		}
+	}
+
+	for idx, tag := range i.Metadata.Tags {
+		if tag == "" {
+			invalid(fmt.Sprintf("Metadata.Tags[%d]", idx), "empty")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Backend wraps a backend.Client validating the instances it retrieves.
+type Backend struct {
+	backend.Client
+
+	logger  logr.Logger
+	invalid *prometheus.CounterVec
+}
+
+// New creates a Backend that validates instances retrieved from client. Validation errors are
+// logged with logger and counted by field with a counter registered with registrar.
+func New(client backend.Client, logger logr.Logger, registrar prometheus.Registerer) *Backend {
+	m := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "backend_instance_validation_errors_total",
+			Help: "Count of invalid instance fields encountered when retrieving instances",
+		},
+		[]string{"field"},
+	)
+
+	registrar.MustRegister(m)
+
+	return &Backend{
+		Client:  client,
+		logger:  logger,
+		invalid: m,
+	}
+}
+
+// GetEC2Instance satisfies ec2.Client.
+func (b *Backend) GetEC2Instance(ctx context.Context, ip string) (ec2.Instance, error) {
+	instance, err := b.Client.GetEC2Instance(ctx, ip)
+	if err == nil {
+		b.validate(instance, "ip", ip)
+	}
+	return instance, err
+}
+
+// GetEC2InstanceByMAC satisfies ec2.Client.
+func (b *Backend) GetEC2InstanceByMAC(ctx context.Context, mac string) (ec2.Instance, error) {
+	instance, err := b.Client.GetEC2InstanceByMAC(ctx, mac)
+	if err == nil {
+		b.validate(instance, "mac", mac)
+	}
+	return instance, err
+}
+
+// GetEC2InstanceByID satisfies ec2.IDClient.
+func (b *Backend) GetEC2InstanceByID(ctx context.Context, id string) (ec2.Instance, error) {
+	instance, err := ec2.GetInstanceByID(ctx, b.Client, id)
+	if err == nil {
+		b.validate(instance, "id", id)
+	}
+	return instance, err
+}
+
+// GetEC2InstanceForTenant satisfies ec2.TenantClient.
+func (b *Backend) GetEC2InstanceForTenant(ctx context.Context, tenant, ip string) (ec2.Instance, error) {
+	instance, err := ec2.GetInstanceForTenant(ctx, b.Client, tenant, ip)
+	if err == nil {
+		b.validate(instance, "tenant", tenant, "ip", ip)
+	}
+	return instance, err
+}
+
+// GetNativeMetadata satisfies native.Client. Native metadata documents aren't validated.
+func (b *Backend) GetNativeMetadata(ctx context.Context, ip string) ([]byte, error) {
+	return native.GetMetadata(ctx, b.Client, ip)
+}
+
+func (b *Backend) validate(instance ec2.Instance, keysAndValues ...any) {
+	err := Validate(instance)
+	if err == nil {
+		return
+	}
+
+	b.logger.Error(err, "Invalid instance", keysAndValues...)
+
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		return
+	}
+	for _, e := range joined.Unwrap() {
+		var fieldErr *FieldError
+		if errors.As(e, &fieldErr) {
+			// Collapse indexes so the label cardinality is bounded.
+			field, _, _ := strings.Cut(fieldErr.Field, "[")
+			b.invalid.WithLabelValues(field).Inc()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package validation_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/backend/validation"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/frontend/hack"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Instance ec2.Instance
+		Expect   []string
+	}{
+		{
+			Name: "Valid",
+			Instance: ec2.Instance{Metadata: ec2.Metadata{
+				InstanceID: "instance-id",
+				Hostname:   "hostname",
+				LocalIPv4:  "10.10.10.10",
+				PublicIPv4: "1.1.1.1",
+				PublicIPv6: "2001:db8::1",
+				PublicKeys: []string{"ssh-ed25519 AAAA"},
+				Tags:       []string{"tag"},
+			}},
+		},
+		{
+			Name: "Malformed",
+			Instance: ec2.Instance{Metadata: ec2.Metadata{
+				Hostname:   "host name",
+				LocalIPv4:  "2001:db8::1",
+				PublicIPv4: "not-an-ip",
+				PublicIPv6: "10.10.10.10",
+				PublicKeys: []string{"ssh-ed25519 AAAA", " "},
+				Tags:       []string{""},
+			}},
+			Expect: []string{
+				"Metadata.InstanceID",
+				"Metadata.Hostname",
+				"Metadata.LocalIPv4",
+				"Metadata.PublicIPv4",
+				"Metadata.PublicIPv6",
+				"Metadata.PublicKeys[1]",
+				"Metadata.Tags[0]",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := Validate(tc.Instance)
+
+			var fields []string
+			if err != nil {
+				for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+					var fieldErr *FieldError
+					if !errors.As(e, &fieldErr) {
+						t.Fatalf("Unexpected error type: %T", e)
+					}
+					fields = append(fields, fieldErr.Field)
+				}
+			}
+
+			if !cmp.Equal(fields, tc.Expect) {
+				t.Fatal(cmp.Diff(tc.Expect, fields))
+			}
+		})
+	}
+}
+
+func TestBackendSurfacesValidationErrors(t *testing.T) {
+	client := &fakeClient{instance: ec2.Instance{Metadata: ec2.Metadata{
+		InstanceID: "instance-id",
+		LocalIPv4:  "not-an-ip",
+		PublicKeys: []string{"", ""},
+	}}}
+	registry := prometheus.NewRegistry()
+
+	var logged error
+	logger := logr.New(&errorSink{err: &logged})
+
+	b := New(client, logger, registry)
+
+	// Invalid instances are still served.
+	instance, err := b.GetEC2Instance(context.Background(), "10.10.10.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(instance, client.instance) {
+		t.Fatal(cmp.Diff(client.instance, instance))
+	}
+
+	var fieldErr *FieldError
+	if !errors.As(logged, &fieldErr) || fieldErr.Field != "Metadata.LocalIPv4" {
+		t.Fatalf("Expected logged Metadata.LocalIPv4 error; Received: %v", logged)
+	}
+
+	expect := `
+# HELP backend_instance_validation_errors_total Count of invalid instance fields encountered when retrieving instances
+# TYPE backend_instance_validation_errors_total counter
+backend_instance_validation_errors_total{field="Metadata.LocalIPv4"} 1
+backend_instance_validation_errors_total{field="Metadata.PublicKeys"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeClient struct {
+	instance ec2.Instance
+}
+
+func (f *fakeClient) GetEC2Instance(context.Context, string) (ec2.Instance, error) {
+	return f.instance, nil
+}
+
+func (f *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return f.instance, nil
+}
+
+func (f *fakeClient) GetEC2InstanceByID(context.Context, string) (ec2.Instance, error) {
+	return f.instance, nil
+}
+
+func (f *fakeClient) GetEC2InstanceForTenant(context.Context, string, string) (ec2.Instance, error) {
+	return f.instance, nil
+}
+
+func (f *fakeClient) GetHackInstance(context.Context, string) (hack.Instance, error) {
+	return hack.Instance{}, nil
+}
+
+func (f *fakeClient) IsHealthy(context.Context) bool {
+	return true
+}
+
+// errorSink is a logr.LogSink that records the last logged error.
+type errorSink struct {
+	logr.LogSink
+	err *error
+}
+
+func (s *errorSink) Init(logr.RuntimeInfo) {}
+
+func (s *errorSink) Error(err error, _ string, _ ...any) {
+	*s.err = err
+}
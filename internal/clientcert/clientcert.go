@@ -0,0 +1,34 @@
+// Package clientcert provides instance identification for requests authenticated with a client
+// certificate.
+package clientcert
+
+import (
+	"crypto/x509"
+	"net"
+	"net/http"
+)
+
+// RequestIdentity returns the instance IP identified by the verified client certificate r was
+// made with. It returns false if r has no verified client certificate or the certificate has no
+// identity so it's only meaningful when serving with client certificate verification enabled.
+func RequestIdentity(r *http.Request) (net.IP, bool) {
+	state := r.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return Identity(state.VerifiedChains[0][0])
+}
+
+// Identity returns the instance IP identified by cert. The identity is the first IP subject
+// alternative name or, if there are none, the common name if it is an IP address.
+func Identity(cert *x509.Certificate) (net.IP, bool) {
+	if len(cert.IPAddresses) > 0 {
+		return cert.IPAddresses[0], true
+	}
+
+	if ip := net.ParseIP(cert.Subject.CommonName); ip != nil {
+		return ip, true
+	}
+
+	return nil, false
+}
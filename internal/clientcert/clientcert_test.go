@@ -0,0 +1,103 @@
+package clientcert_test
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/clientcert"
+	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/http/tlstest"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestRequestIdentity(t *testing.T) {
+	ca := tlstest.NewCA(t)
+
+	cases := []struct {
+		Name         string
+		Certificates []tls.Certificate
+		Expect       string
+	}{
+		{
+			Name:         "IPSubjectAlternativeName",
+			Certificates: []tls.Certificate{ca.Issue(t, "node", net.ParseIP("10.10.10.10"))},
+			Expect:       "10.10.10.10",
+		},
+		{
+			Name:         "IPCommonName",
+			Certificates: []tls.Certificate{ca.Issue(t, "10.10.10.11")},
+			Expect:       "10.10.10.11",
+		},
+		{
+			Name:         "NoIdentity",
+			Certificates: []tls.Certificate{ca.Issue(t, "node")},
+			Expect:       "127.0.0.1",
+		},
+		{
+			Name:   "NoClientCertificate",
+			Expect: "127.0.0.1",
+		},
+	}
+
+	router := gin.New()
+	router.GET("/", func(ctx *gin.Context) {
+		if ip, ok := RequestIdentity(ctx.Request); ok {
+			ctx.String(http.StatusOK, ip.String())
+			return
+		}
+
+		ip, err := request.RemoteAddrIP(ctx.Request)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		ctx.String(http.StatusOK, ip)
+	})
+
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.Issue(t, "hegel", net.ParseIP("127.0.0.1"))},
+		ClientCAs:    ca.Pool(),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						RootCAs:      ca.Pool(),
+						Certificates: tc.Certificates,
+						MinVersion:   tls.VersionTLS12,
+					},
+				},
+			}
+
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, string(body))
+			}
+		})
+	}
+}
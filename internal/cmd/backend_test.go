@@ -0,0 +1,161 @@
+package cmd_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/tinkerbell/hegel/internal/cmd"
+)
+
+func TestValidateFallbackBackend(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Opts        RootCommandOptions
+		ExpectError bool
+	}{
+		{Name: "Disabled", Opts: RootCommandOptions{Backend: "kubernetes"}},
+		{Name: "Flatfile", Opts: RootCommandOptions{Backend: "kubernetes", FallbackBackend: "flatfile"}},
+		{Name: "Kubernetes", Opts: RootCommandOptions{Backend: "flatfile", FallbackBackend: "kubernetes"}},
+		{Name: "SameAsBackend", Opts: RootCommandOptions{Backend: "flatfile", FallbackBackend: "flatfile"}, ExpectError: true},
+		{Name: "Unknown", Opts: RootCommandOptions{Backend: "flatfile", FallbackBackend: "unknown"}, ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateFallbackBackend(tc.Opts)
+			if (err != nil) != tc.ExpectError {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+		})
+	}
+}
+
+func TestKubernetesFieldMappingsFlag(t *testing.T) {
+	cmd, err := NewRootCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JSONPath expressions may contain commas so each mapping is a separate flag.
+	mappings := []string{
+		"/meta-data/hostname={.metadata.annotations.hostname}",
+		"/meta-data/plan={.metadata.labels['plan','class']}",
+	}
+	for _, m := range mappings {
+		if err := cmd.Flags().Set("kubernetes-field-mappings", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmd.PreRun(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cmd.Opts.KubernetesFieldMappings, mappings) {
+		t.Fatalf("Expected: %v; Received: %v", mappings, cmd.Opts.KubernetesFieldMappings)
+	}
+}
+
+func TestKubernetesUserDataStateMappingsFlag(t *testing.T) {
+	cmd, err := NewRootCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappings := []string{
+		"provisioning={.metadata.annotations['install-user-data','user-data']}",
+		"provisioned={.metadata.annotations.post-install-user-data}",
+	}
+	for _, m := range mappings {
+		if err := cmd.Flags().Set("kubernetes-user-data-state-mappings", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cmd.PreRun(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(cmd.Opts.KubernetesUserDataStateMappings, mappings) {
+		t.Fatalf("Expected: %v; Received: %v", mappings, cmd.Opts.KubernetesUserDataStateMappings)
+	}
+}
+
+func TestKubernetesCacheSyncTimeoutFlag(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Value       string
+		Expect      time.Duration
+		ExpectError bool
+	}{
+		{Name: "Default", Expect: 0},
+		{Name: "Custom", Value: "30s", Expect: 30 * time.Second},
+		{Name: "Negative", Value: "-1s", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd, err := NewRootCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.Value != "" {
+				if err := cmd.Flags().Set("kubernetes-cache-sync-timeout", tc.Value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = cmd.PreRun(nil, nil)
+			if (err != nil) != tc.ExpectError {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+			if err == nil && cmd.Opts.KubernetesCacheSyncTimeout != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, cmd.Opts.KubernetesCacheSyncTimeout)
+			}
+		})
+	}
+}
+
+func TestUserAgentPhasesRequirePhaseMappings(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Flags       map[string]string
+		ExpectError bool
+	}{
+		{
+			Name:  "Flatfile",
+			Flags: map[string]string{"backend": "flatfile", "user-agent-phases": "^iPXE=ipxe"},
+		},
+		{
+			Name: "KubernetesWithMappings",
+			Flags: map[string]string{
+				"backend":                             "kubernetes",
+				"user-agent-phases":                   "^iPXE=ipxe",
+				"kubernetes-user-data-phase-mappings": "ipxe={.metadata.annotations.ipxe-script}",
+			},
+		},
+		{
+			Name:        "KubernetesWithoutMappings",
+			Flags:       map[string]string{"backend": "kubernetes", "user-agent-phases": "^iPXE=ipxe"},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cmd, err := NewRootCommand()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tc.Flags {
+				if err := cmd.Flags().Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = cmd.PreRun(nil, nil)
+			if (err != nil) != tc.ExpectError {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+		})
+	}
+}
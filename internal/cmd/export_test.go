@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+// NewRouter exposes newRouter for testing.
+var NewRouter = newRouter
+
+// NewAdminRouter exposes newAdminRouter for testing.
+var NewAdminRouter = newAdminRouter
+
+// IdentityMiddleware exposes identityMiddleware for testing.
+func IdentityMiddleware(opts RootCommandOptions) ([]gin.HandlerFunc, error) {
+	return (&RootCommand{Opts: opts}).identityMiddleware(context.Background(), logr.Discard())
+}
+
+// TenantSource exposes tenantSource for testing.
+var TenantSource = tenantSource
+
+// LoadUserDataSigningKey exposes loadUserDataSigningKey for testing.
+var LoadUserDataSigningKey = loadUserDataSigningKey
+
+// ValidateFallbackBackend exposes validateFallbackBackend for testing.
+var ValidateFallbackBackend = validateFallbackBackend
+
+// FrontendSettings exposes frontendSettings for testing.
+var FrontendSettings = frontendSettings
+
+// Reloader returns the func Run uses to reload c's config file and apply it to fe.
+func Reloader(c *RootCommand, fe ec2.Frontend) func() error {
+	return (&reloader{vpr: c.vpr, opts: c.Opts, fe: fe}).Reload
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+// reloadableFlags are the flags whose options can be changed in the config file while Hegel is
+// serving requests. Changes to other flags, such as path-aliases and backend-max-concurrency whose
+// routes and limiters are built on startup, require a restart.
+var reloadableFlags = map[string]bool{
+	"default-values":     true,
+	"facility-regions":   true,
+	"max-user-data-size": true,
+	"services-domain":    true,
+	"services-partition": true,
+	"user-data-merge":    true,
+}
+
+// frontendSettings parses the options of reloadableFlags into EC2 frontend Options. The Options
+// are validated by ec2.ValidateSettings, or by ec2.Frontend.Reload when they're reloaded.
+func frontendSettings(opts RootCommandOptions) ([]ec2.Option, error) {
+	defaults, err := parseDefaultValues(opts.DefaultValues)
+	if err != nil {
+		return nil, err
+	}
+
+	regions, err := parseFacilityRegions(opts.FacilityRegions)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ec2.Option{
+		ec2.WithUserDataMerge(ec2.UserDataMerge(opts.UserDataMerge)),
+		ec2.WithMaxUserDataSize(opts.MaxUserDataSize),
+		ec2.WithDefaults(defaults),
+		ec2.WithFacilityRegions(regions),
+		ec2.WithServices(opts.ServicesDomain, opts.ServicesPartition),
+	}, nil
+}
+
+// reloader reloads options from the config file read by vpr and applies them to fe.
+type reloader struct {
+	mu   sync.Mutex
+	vpr  *viper.Viper
+	opts RootCommandOptions
+	fe   ec2.Frontend
+}
+
+// Reload re-reads the config file and applies the options of reloadableFlags. Flags and
+// environment variables take precedence over the config file as they do on startup. If the options
+// are invalid, or options that can't be reloaded have changed, it returns an error and the
+// previous options are retained.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.vpr.ReadInConfig(); err != nil {
+		return errors.Errorf("--config-file: %v", err)
+	}
+
+	var opts RootCommandOptions
+	if err := r.vpr.Unmarshal(&opts); err != nil {
+		return errors.Errorf("--config-file: %v", err)
+	}
+
+	var restart []string
+	for _, flag := range changedFlags(r.opts, opts) {
+		if !reloadableFlags[flag] {
+			restart = append(restart, "--"+flag)
+		}
+	}
+	if len(restart) > 0 {
+		return errors.Errorf("%v: requires restart; can't be reloaded", strings.Join(restart, ", "))
+	}
+
+	settings, err := frontendSettings(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := r.fe.Reload(settings...); err != nil {
+		return err
+	}
+
+	r.opts = opts
+
+	return nil
+}
+
+// changedFlags returns the sorted flags whose options differ between a and b.
+func changedFlags(a, b RootCommandOptions) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, va.Type().Field(i).Tag.Get("mapstructure"))
+		}
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+// reloadOnSignal calls reload for each signal received on signals until ctx is done.
+func reloadOnSignal(ctx context.Context, logger logr.Logger, signals <-chan os.Signal, reload func() error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			if err := reload(); err != nil {
+				logger.Error(err, "Rejected configuration reload")
+				continue
+			}
+			logger.Info("Reloaded configuration")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package cmd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
+	. "github.com/tinkerbell/hegel/internal/cmd"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestReload(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	client, err := flatfile.FromYAML(strings.NewReader(`
+- metadata:
+    id: "a"
+    ipv4:
+      local: "10.10.10.10"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "hegel.yaml")
+	write := func(config string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("default-values: /meta-data/hostname=before\n")
+
+	cmd, err := NewRootCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Flags().Set("config-file", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.PreRun(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := FrontendSettings(cmd.Opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fe := ec2.New(client, settings...)
+	router := gin.New()
+	fe.Configure(router)
+
+	reload := Reloader(cmd, fe)
+
+	expectHostname := func(expect string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/hostname", nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+
+		if body := w.Body.String(); w.Code != http.StatusOK || body != expect {
+			t.Fatalf("Expected: 200 %q; Received: %d %q", expect, w.Code, body)
+		}
+	}
+
+	expectHostname("before")
+
+	write("default-values: /meta-data/hostname=after\n")
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	expectHostname("after")
+
+	// Rejected configs retain the live options.
+	rejected := []struct {
+		Name   string
+		Config string
+		Expect string
+	}{
+		{
+			Name:   "Invalid",
+			Config: "default-values: /meta-data/hostname=rejected\nmax-user-data-size: -1\n",
+			Expect: "max user-data size must not be negative",
+		},
+		{
+			Name:   "Malformed",
+			Config: "default-values: [\n",
+			Expect: "--config-file",
+		},
+		{
+			Name:   "NotReloadable",
+			Config: "default-values: /meta-data/hostname=rejected\ntenant-source: static\ntenant: a\n",
+			Expect: "--tenant, --tenant-source: requires restart",
+		},
+		{
+			Name:   "PathAliases",
+			Config: "default-values: /meta-data/hostname=rejected\npath-aliases: /meta-data/host-name=/meta-data/hostname\n",
+			Expect: "--path-aliases: requires restart",
+		},
+		{
+			Name:   "BackendConcurrency",
+			Config: "default-values: /meta-data/hostname=rejected\nbackend-max-concurrency: 10\n",
+			Expect: "--backend-max-concurrency: requires restart",
+		},
+	}
+	for _, tc := range rejected {
+		t.Run(tc.Name, func(t *testing.T) {
+			write(tc.Config)
+
+			err := reload()
+			if err == nil || !strings.Contains(err.Error(), tc.Expect) {
+				t.Fatalf("Expected error containing %q; Received: %v", tc.Expect, err)
+			}
+
+			expectHostname("after")
+		})
+	}
+}
+
+func TestFrontendSettingsDefaultValues(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Value       string
+		ExpectError bool
+	}{
+		{Name: "Single", Value: "/meta-data/hostname=default"},
+		{Name: "TrailingComma", Value: "/meta-data/hostname=default,"},
+		{Name: "DoubledComma", Value: "/meta-data/hostname=default,,/meta-data/plan=plan"},
+		{Name: "Spaces", Value: " /meta-data/hostname=default , /meta-data/plan=plan "},
+		{Name: "MissingValue", Value: "/meta-data/hostname", ExpectError: true},
+		{Name: "UnknownEndpoint", Value: "/meta-data/unknown=default", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := FrontendSettings(RootCommandOptions{DefaultValues: tc.Value})
+			if (err != nil) != tc.ExpectError {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+		})
+	}
+}
@@ -1,14 +1,26 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/equinix-labs/otel-init-go/otelinit"
 	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
 	"github.com/go-logr/zerologr"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,14 +28,33 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"github.com/tinkerbell/hegel/internal/admin"
+	"github.com/tinkerbell/hegel/internal/audit"
 	"github.com/tinkerbell/hegel/internal/backend"
+	"github.com/tinkerbell/hegel/internal/backend/ambiguous"
+	"github.com/tinkerbell/hegel/internal/backend/breaker"
+	"github.com/tinkerbell/hegel/internal/backend/chain"
 	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	"github.com/tinkerbell/hegel/internal/backend/limit"
+	"github.com/tinkerbell/hegel/internal/backend/negativecache"
+	"github.com/tinkerbell/hegel/internal/backend/ptr"
+	"github.com/tinkerbell/hegel/internal/backend/retry"
+	"github.com/tinkerbell/hegel/internal/backend/tenantscope"
+	"github.com/tinkerbell/hegel/internal/backend/validation"
+	"github.com/tinkerbell/hegel/internal/build"
+	"github.com/tinkerbell/hegel/internal/dhcplease"
+	"github.com/tinkerbell/hegel/internal/frontend/azure"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2"
 	"github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/frontend/native"
+	"github.com/tinkerbell/hegel/internal/frontend/nocloud"
+	"github.com/tinkerbell/hegel/internal/frontend/phonehome"
 	"github.com/tinkerbell/hegel/internal/healthcheck"
 	hegelhttp "github.com/tinkerbell/hegel/internal/http"
-	hegellogger "github.com/tinkerbell/hegel/internal/logger"
+	"github.com/tinkerbell/hegel/internal/identity"
 	"github.com/tinkerbell/hegel/internal/metrics"
+	"github.com/tinkerbell/hegel/internal/tenant"
+	"github.com/tinkerbell/hegel/internal/unixsocket"
 	"github.com/tinkerbell/hegel/internal/xff"
 )
 
@@ -32,7 +63,8 @@ Run a Hegel server.
 
 Each CLI argument has a corresponding environment variable in the form of the CLI argument prefixed
 with HEGEL. If both the flag and environment variable form are specified, the flag form takes
-precedence.
+precedence. Options may also be specified in a --config-file, keyed by flag name, which has the
+lowest precedence.
 
 Examples
   --http-port          HEGEL_HTTP_PORT
@@ -42,16 +74,102 @@ Examples
 // EnvNamePrefix defines the environment variable prefix required for all environment configuration.
 const EnvNamePrefix = "HEGEL"
 
+// dhcpLeaseRefreshInterval is how often --dhcp-lease-file is checked for changes.
+const dhcpLeaseRefreshInterval = 5 * time.Second
+
 // RootCommandOptions encompasses all the configurability of the RootCommand.
 type RootCommandOptions struct {
+	ConfigFile           string `mapstructure:"config-file"`
 	TrustedProxies       string `mapstructure:"trusted-proxies"`
 	HTTPAddr             string `mapstructure:"http-addr"`
+	LinkLocalAlias       string `mapstructure:"link-local-alias-interface"`
+	AdminAddr            string `mapstructure:"admin-addr"`
+	AdminToken           string `mapstructure:"admin-token"`
+	AdminPprof           bool   `mapstructure:"admin-pprof"`
+	TLSCertFile          string `mapstructure:"tls-cert-file"`
+	TLSKeyFile           string `mapstructure:"tls-key-file"`
+	TLSClientCAFile      string `mapstructure:"tls-client-ca-file"`
+	TLSClientIdentity    bool   `mapstructure:"tls-client-identity"`
 	Backend              string `mapstructure:"backend"`
+	FallbackBackend      string `mapstructure:"fallback-backend"`
 	KubernetesAPIServer  string `mapstructure:"kubernetes-apiserver"`
 	KubernetesKubeconfig string `mapstructure:"kubernetes-kubeconfig"`
 	KubernetesNamespace  string `mapstructure:"kubernetes-namespace"`
 	FlatfilePath         string `mapstructure:"flatfile-path"`
 	Debug                bool   `mapstructure:"debug"`
+	MetricsNamespace     string `mapstructure:"metrics-namespace"`
+	MetricsSubsystem     string `mapstructure:"metrics-subsystem"`
+	AuditLog             string `mapstructure:"audit-log"`
+
+	AuditLogSampleRate float64 `mapstructure:"audit-log-sample-rate"`
+
+	KubernetesUserDataFragmentAnnotations string   `mapstructure:"kubernetes-user-data-fragment-annotations"`
+	KubernetesFieldMappings               []string `mapstructure:"kubernetes-field-mappings"`
+	KubernetesUserDataStateMappings       []string `mapstructure:"kubernetes-user-data-state-mappings"`
+	KubernetesUserDataPhaseMappings       []string `mapstructure:"kubernetes-user-data-phase-mappings"`
+
+	KubernetesCacheSyncTimeout time.Duration `mapstructure:"kubernetes-cache-sync-timeout"`
+
+	ShutdownGracePeriod time.Duration `mapstructure:"shutdown-grace-period"`
+	ReadHeaderTimeout   time.Duration `mapstructure:"http-read-header-timeout"`
+	ReadTimeout         time.Duration `mapstructure:"http-read-timeout"`
+	WriteTimeout        time.Duration `mapstructure:"http-write-timeout"`
+	IdleTimeout         time.Duration `mapstructure:"http-idle-timeout"`
+	MaxHeaderBytes      int           `mapstructure:"http-max-header-bytes"`
+	HTTP2               bool          `mapstructure:"http2"`
+	NegativeCacheTTL    time.Duration `mapstructure:"negative-cache-ttl"`
+	HotPathTTL          time.Duration `mapstructure:"hot-path-ttl"`
+	BackendRetries      int           `mapstructure:"backend-retries"`
+	BackendRetryBackoff time.Duration `mapstructure:"backend-retry-backoff"`
+	BackendConcurrency  int           `mapstructure:"backend-max-concurrency"`
+	BackendFailFast     bool          `mapstructure:"backend-concurrency-fail-fast"`
+	BreakerThreshold    int           `mapstructure:"backend-breaker-threshold"`
+	BreakerCooldown     time.Duration `mapstructure:"backend-breaker-cooldown"`
+	ValidateInstances   bool          `mapstructure:"validate-instances"`
+	ReverseDNSFallback  bool          `mapstructure:"reverse-dns-fallback"`
+	ReverseDNSTimeout   time.Duration `mapstructure:"reverse-dns-timeout"`
+	ReverseDNSDomain    string        `mapstructure:"reverse-dns-domain"`
+
+	SniffUserDataContentType bool   `mapstructure:"sniff-user-data-content-type"`
+	UserDataMerge            string `mapstructure:"user-data-merge"`
+	UserDataSigningKey       string `mapstructure:"user-data-signing-key"`
+	MaxUserDataSize          int    `mapstructure:"max-user-data-size"`
+	MACHeader                string `mapstructure:"mac-header"`
+	DHCPLeaseFile            string `mapstructure:"dhcp-lease-file"`
+	DHCPLeaseFormat          string `mapstructure:"dhcp-lease-format"`
+	NodeHintHeader           string `mapstructure:"node-hint-header"`
+	NodeHintKeyFile          string `mapstructure:"node-hint-key-file"`
+	IdentityStrategies       string `mapstructure:"identity-strategies"`
+
+	IdentityAllowUnidentified bool `mapstructure:"identity-allow-unidentified"`
+
+	RecursiveSkipFailed      bool   `mapstructure:"recursive-skip-failed"`
+	UnknownPathParentListing bool   `mapstructure:"unknown-path-parent-listing"`
+	UserDataTemplates        bool   `mapstructure:"user-data-templates"`
+	UserAgentPhases          string `mapstructure:"user-agent-phases"`
+	TrailingNewlineEndpoints string `mapstructure:"trailing-newline-endpoints"`
+	UpstreamURL              string `mapstructure:"upstream-url"`
+	DefaultValues            string `mapstructure:"default-values"`
+	PathAliases              string `mapstructure:"path-aliases"`
+	FacilityRegions          string `mapstructure:"facility-regions"`
+	ServicesDomain           string `mapstructure:"services-domain"`
+	ServicesPartition        string `mapstructure:"services-partition"`
+	NoCloudBasePath          string `mapstructure:"nocloud-base-path"`
+	AzureIMDS                bool   `mapstructure:"azure-imds"`
+	NativeMetadata           bool   `mapstructure:"native-metadata"`
+
+	NativeLongPollTimeout time.Duration `mapstructure:"native-long-poll-timeout"`
+	NativeMaxWatchers     int           `mapstructure:"native-long-poll-max-watchers"`
+	NodeHintMaxAge        time.Duration `mapstructure:"node-hint-max-age"`
+
+	UnixSocketIdentityHeader string `mapstructure:"unix-socket-identity-header"`
+	UnixSocketIdentityIP     string `mapstructure:"unix-socket-identity-ip"`
+
+	UnsafeDebugIdentityOverride bool `mapstructure:"unsafe-debug-identity-override"`
+
+	TenantSource string `mapstructure:"tenant-source"`
+	TenantHeader string `mapstructure:"tenant-header"`
+	Tenant       string `mapstructure:"tenant"`
 
 	// Hidden CLI flags.
 	HegelAPI bool `mapstructure:"hegel-api"`
@@ -74,6 +192,8 @@ func NewRootCommand() (*RootCommand, error) {
 		},
 	}
 
+	rootCmd.AddCommand(NewValidateCommand())
+
 	rootCmd.PreRunE = rootCmd.PreRun
 	rootCmd.RunE = rootCmd.Run
 	rootCmd.Flags().SortFlags = false // Print flag help in the order they're specified.
@@ -91,7 +211,166 @@ func NewRootCommand() (*RootCommand, error) {
 
 // PreRun satisfies cobra.Command.PreRunE and unmarshalls. Its responsible for populating c.Opts.
 func (c *RootCommand) PreRun(*cobra.Command, []string) error {
-	return c.vpr.Unmarshal(&c.Opts)
+	if path := c.vpr.GetString("config-file"); path != "" {
+		c.vpr.SetConfigFile(path)
+		if err := c.vpr.ReadInConfig(); err != nil {
+			return errors.Errorf("--config-file: %v", err)
+		}
+	}
+
+	if err := c.vpr.Unmarshal(&c.Opts); err != nil {
+		return err
+	}
+
+	if err := hegelhttp.ValidateAddr(c.Opts.HTTPAddr); err != nil {
+		return err
+	}
+
+	if c.Opts.AdminAddr != "" {
+		if err := hegelhttp.ValidateAddr(c.Opts.AdminAddr); err != nil {
+			return err
+		}
+	}
+
+	if c.Opts.AdminToken != "" && c.Opts.AdminAddr == "" {
+		return errors.New("--admin-token requires --admin-addr")
+	}
+
+	if c.Opts.AdminPprof && c.Opts.AdminAddr == "" {
+		return errors.New("--admin-pprof requires --admin-addr")
+	}
+
+	if err := c.validateTLSOpts(); err != nil {
+		return err
+	}
+
+	if err := validateFallbackBackend(c.Opts); err != nil {
+		return err
+	}
+
+	if c.Opts.BackendConcurrency < 0 {
+		return errors.Errorf("--backend-max-concurrency: must not be negative, got %v", c.Opts.BackendConcurrency)
+	}
+
+	if c.Opts.BreakerThreshold < 0 {
+		return errors.Errorf("--backend-breaker-threshold: must not be negative, got %v", c.Opts.BreakerThreshold)
+	}
+
+	if c.Opts.BreakerThreshold > 0 && c.Opts.BreakerCooldown <= 0 {
+		return errors.Errorf("--backend-breaker-cooldown: must be positive, got %v", c.Opts.BreakerCooldown)
+	}
+
+	if c.Opts.KubernetesCacheSyncTimeout < 0 {
+		return errors.Errorf("--kubernetes-cache-sync-timeout: must not be negative, got %v", c.Opts.KubernetesCacheSyncTimeout)
+	}
+
+	if c.Opts.NativeLongPollTimeout < 0 {
+		return errors.Errorf("--native-long-poll-timeout: must not be negative, got %v", c.Opts.NativeLongPollTimeout)
+	}
+
+	if c.Opts.NativeLongPollTimeout > 0 && !c.Opts.NativeMetadata {
+		return errors.New("--native-long-poll-timeout requires --native-metadata")
+	}
+
+	if c.Opts.NativeMaxWatchers < 0 {
+		return errors.Errorf("--native-long-poll-max-watchers: must not be negative, got %v", c.Opts.NativeMaxWatchers)
+	}
+
+	// Native metadata documents carry no tenant so can't be scoped to one.
+	if c.Opts.NativeMetadata && c.Opts.TenantSource != "" {
+		return errors.New("--native-metadata can't be used with --tenant-source")
+	}
+
+	// Long-poll responses must be written before the server gives up on them.
+	if c.Opts.NativeLongPollTimeout > 0 && c.Opts.WriteTimeout > 0 && c.Opts.NativeLongPollTimeout >= c.Opts.WriteTimeout {
+		return errors.Errorf(
+			"--native-long-poll-timeout: must be less than --http-write-timeout (%v), got %v",
+			c.Opts.WriteTimeout,
+			c.Opts.NativeLongPollTimeout,
+		)
+	}
+
+	if c.Opts.ReverseDNSFallback && c.Opts.ReverseDNSTimeout <= 0 {
+		return errors.Errorf("--reverse-dns-timeout: must be positive, got %v", c.Opts.ReverseDNSTimeout)
+	}
+
+	if c.Opts.AuditLogSampleRate < 0 || c.Opts.AuditLogSampleRate > 1 {
+		return errors.Errorf("--audit-log-sample-rate: must be between 0 and 1, got %v", c.Opts.AuditLogSampleRate)
+	}
+
+	settings, err := frontendSettings(c.Opts)
+	if err != nil {
+		return err
+	}
+	if err := ec2.ValidateSettings(settings...); err != nil {
+		return err
+	}
+
+	if _, err := parsePathAliases(c.Opts.PathAliases); err != nil {
+		return err
+	}
+
+	if _, err := parseUpstreamURL(c.Opts.UpstreamURL); err != nil {
+		return err
+	}
+
+	if _, err := parseUserAgentPhases(c.Opts.UserAgentPhases); err != nil {
+		return err
+	}
+
+	if _, err := parseTrailingNewlineEndpoints(c.Opts.TrailingNewlineEndpoints); err != nil {
+		return err
+	}
+
+	if _, err := parseFieldMappings(c.Opts.KubernetesFieldMappings); err != nil {
+		return err
+	}
+
+	if _, err := parseUserDataStateMappings(c.Opts.KubernetesUserDataStateMappings); err != nil {
+		return err
+	}
+
+	if _, err := parseUserDataPhaseMappings(c.Opts.KubernetesUserDataPhaseMappings); err != nil {
+		return err
+	}
+
+	// Kubernetes Hardware only has user-data for boot phases that are mapped to Hardware fields.
+	if c.Opts.UserAgentPhases != "" && c.Opts.Backend == "kubernetes" && len(c.Opts.KubernetesUserDataPhaseMappings) == 0 {
+		return errors.New("--user-agent-phases requires --kubernetes-user-data-phase-mappings with the kubernetes backend")
+	}
+
+	// Strategies are only created to validate them so their background work is stopped on return.
+	validateCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := identityStrategies(validateCtx, logr.Discard(), c.Opts); err != nil {
+		return err
+	}
+
+	if _, err := tenantSource(c.Opts); err != nil {
+		return err
+	}
+
+	if _, err := loadUserDataSigningKey(c.Opts.UserDataSigningKey); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *RootCommand) validateTLSOpts() error {
+	if (c.Opts.TLSCertFile == "") != (c.Opts.TLSKeyFile == "") {
+		return errors.New("--tls-cert-file and --tls-key-file must be specified together")
+	}
+
+	if c.Opts.TLSClientCAFile != "" && c.Opts.TLSCertFile == "" {
+		return errors.New("--tls-client-ca-file requires --tls-cert-file and --tls-key-file")
+	}
+
+	if c.Opts.TLSClientIdentity && c.Opts.TLSClientCAFile == "" {
+		return errors.New("--tls-client-identity requires --tls-client-ca-file")
+	}
+
+	return nil
 }
 
 // Run executes Hegel.
@@ -103,69 +382,797 @@ func (c *RootCommand) Run(cmd *cobra.Command, _ []string) error {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	logger.Info("Root command options", "opts", fmt.Sprintf("%#v", c.Opts))
+	// Don't log secrets.
+	opts := c.Opts
+	if opts.AdminToken != "" {
+		opts.AdminToken = "<redacted>"
+	}
+	logger.Info("Root command options", "opts", fmt.Sprintf("%#v", opts))
 
 	ctx, otelShutdown := otelinit.InitOpenTelemetry(cmd.Context(), "hegel")
 	defer otelShutdown(ctx)
 
-	be, err := backend.New(ctx, toBackendOptions(c.Opts))
+	registry := prometheus.NewRegistry()
+
+	// Metrics are registered under the configured prefix; the /metrics endpoint gathers from the
+	// underlying registry.
+	registrar := metrics.NewRegisterer(registry, c.Opts.MetricsNamespace, c.Opts.MetricsSubsystem)
+	metrics.RegisterInfo(registrar, c.Opts.Backend, build.GetGitRevision())
+
+	// Shared by the backends so ambiguous lookups are counted once whichever serves them.
+	ambiguity := ambiguous.NewReporter(logger, registrar)
+
+	backendOpts := toBackendOptions(c.Opts.Backend, c.Opts)
+	backendOpts.Ambiguous = ambiguity
+	be, err := backend.New(ctx, backendOpts)
 	if err != nil {
 		return errors.Errorf("initialize backend: %v", err)
 	}
 
-	xffmw, err := xff.MiddlewareFromUnparsed(c.Opts.TrustedProxies)
+	// Backends that can't serve requests immediately gate readiness. Checked before wrapping
+	// as wrappers don't expose it.
+	var readiness []healthcheck.ReadinessChecker
+	if r, ok := be.(healthcheck.ReadinessChecker); ok {
+		readiness = append(readiness, r)
+	}
+
+	// Caches report their size as metrics, labelled by name, once the frontend is created.
+	// Checked before wrapping for the same reason.
+	caches := map[string]metrics.CacheSizer{}
+	if s, ok := be.(metrics.CacheSizer); ok {
+		caches["hardware"] = s
+	}
+
+	// Native metadata lookups are forwarded by the wrappers to the backend. Checked before
+	// wrapping for the same reason.
+	if _, ok := be.(native.Client); c.Opts.NativeMetadata && !ok {
+		return errors.Errorf("--native-metadata: %v backend doesn't serve native metadata", c.Opts.Backend)
+	}
+
+	// Long-polling native metadata requests wait on backend change notifications. Checked before
+	// wrapping for the same reason.
+	notifier, _ := be.(native.Notifier)
+	if c.Opts.NativeLongPollTimeout > 0 && notifier == nil {
+		return errors.Errorf("--native-long-poll-timeout: %v backend doesn't notify changes", c.Opts.Backend)
+	}
+
+	// Tenant scoped lookups by IP are delegated to the backend. Checked before wrapping for the
+	// same reason.
+	if _, ok := be.(ec2.TenantClient); c.Opts.TenantSource != "" && !ok {
+		return errors.Errorf("--tenant-source: %v backend can't scope lookups to tenants", c.Opts.Backend)
+	}
+
+	// Only the primary backend is reverse resolved against so a PTR match is preferred to a
+	// fallback backend record for the IP.
+	if c.Opts.ReverseDNSFallback {
+		hc, ok := be.(ptr.Client)
+		if !ok {
+			return errors.Errorf("--reverse-dns-fallback: %v backend can't look up instances by hostname", c.Opts.Backend)
+		}
+		be = ptr.New(hc, net.DefaultResolver, c.Opts.ReverseDNSTimeout, c.Opts.ReverseDNSDomain, logger, registrar)
+	}
+
+	if c.Opts.FallbackBackend != "" {
+		fallbackOpts := toBackendOptions(c.Opts.FallbackBackend, c.Opts)
+		fallbackOpts.Ambiguous = ambiguity
+		fallback, err := backend.New(ctx, fallbackOpts)
+		if err != nil {
+			return errors.Errorf("initialize fallback backend: %v", err)
+		}
+		if r, ok := fallback.(healthcheck.ReadinessChecker); ok {
+			readiness = append(readiness, r)
+		}
+
+		be = chain.New([]backend.Client{be, fallback}, registrar)
+	}
+
+	// Limit concurrency closest to the backend so retry backoff and cached results don't hold a
+	// slot.
+	if c.Opts.BackendConcurrency > 0 {
+		be = limit.New(be, c.Opts.BackendConcurrency, !c.Opts.BackendFailFast, registrar)
+	}
+
+	if c.Opts.BackendRetries > 0 {
+		be = retry.New(be, c.Opts.BackendRetries, c.Opts.BackendRetryBackoff, registrar)
+	}
+
+	// Break outside retries so a lookup that exhausts its retries counts as a single failure and
+	// an open breaker doesn't wait out retry backoff.
+	if c.Opts.BreakerThreshold > 0 {
+		be = breaker.New(be, c.Opts.BreakerThreshold, c.Opts.BreakerCooldown, logger, registrar)
+	}
+
+	if c.Opts.ValidateInstances {
+		be = validation.New(be, logger, registrar)
+	}
+
+	// Caches are flushable from the admin listener.
+	var flushers []admin.Flusher
+
+	if c.Opts.NegativeCacheTTL > 0 {
+		nc := negativecache.New(be, c.Opts.NegativeCacheTTL, registrar)
+		flushers = append(flushers, nc)
+		caches["negative"] = nc
+		be = nc
+	}
+
+	identitymw, err := c.identityMiddleware(ctx, logger)
 	if err != nil {
 		return err
 	}
 
-	registry := prometheus.NewRegistry()
+	// Validated in PreRun.
+	tenants, _ := tenantSource(c.Opts)
+	if tenants != nil {
+		// Scope outside the caches so cached results can't be served across tenants.
+		be = tenantscope.New(be)
+		identitymw = append(identitymw, tenant.Middleware(tenants))
+	}
 
-	router := gin.New()
-	router.Use(
-		metrics.InstrumentRequestCount(registry),
-		metrics.InstrumentRequestDuration(registry),
-		gin.Recovery(),
-		hegellogger.Middleware(logger),
-		xffmw,
-	)
+	if c.Opts.UnsafeDebugIdentityOverride {
+		logger.Info("WARNING: unsafe debug identity override enabled; any client can retrieve any instance's data")
+	}
+
+	var auditLog *audit.Log
+	if c.Opts.AuditLog != "" {
+		w, closeAuditLog, err := openAuditLog(c.Opts.AuditLog)
+		if err != nil {
+			return errors.Errorf("open audit log: %v", err)
+		}
+		defer closeAuditLog()
+
+		auditLog = audit.New(w, logger, audit.WithSampleRate(c.Opts.AuditLogSampleRate))
+	}
+
+	router := newRouter(registrar, logger, auditLog, identitymw...)
+
+	// Listen for signals to gracefully shutdown. When options are read from a config file, SIGHUP
+	// reloads them instead.
+	shutdownSignals := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if c.Opts.ConfigFile == "" {
+		shutdownSignals = append(shutdownSignals, syscall.SIGHUP)
+	}
+	ctx, cancel := signal.NotifyContext(ctx, shutdownSignals...)
+	defer cancel()
 
 	metrics.Configure(router, registry)
 	healthcheck.Configure(router, be)
+	healthcheck.ConfigureReadiness(router, ctx, readiness...)
+
+	userDataKey, err := loadUserDataSigningKey(c.Opts.UserDataSigningKey)
+	if err != nil {
+		return err
+	}
+	if userDataKey != nil {
+		publicKey := userDataKey.Public().(ed25519.PublicKey)
+		logger.Info("Signing user-data", "publicKey", base64.StdEncoding.EncodeToString(publicKey))
+	}
+
+	// Validated in PreRun.
+	aliases, _ := parsePathAliases(c.Opts.PathAliases)
+	upstream, _ := parseUpstreamURL(c.Opts.UpstreamURL)
+	phases, _ := parseUserAgentPhases(c.Opts.UserAgentPhases)
+	trailingNewlines, _ := parseTrailingNewlineEndpoints(c.Opts.TrailingNewlineEndpoints)
+	settings, _ := frontendSettings(c.Opts)
 
 	// TODO(chrisdoherty4) Handle multiple frontends.
-	fe := ec2.New(be)
+	fe := ec2.New(
+		be,
+		append(
+			settings,
+			ec2.WithUserDataContentTypeSniffing(c.Opts.SniffUserDataContentType),
+			ec2.WithUserDataSigningKey(userDataKey),
+			ec2.WithMACHeader(c.Opts.MACHeader),
+			ec2.WithPathAliases(aliases),
+			ec2.WithSkipFailedTreeValues(c.Opts.RecursiveSkipFailed),
+			ec2.WithParentListing(c.Opts.UnknownPathParentListing),
+			ec2.WithUserDataTemplates(c.Opts.UserDataTemplates),
+			ec2.WithUserAgentPhases(phases),
+			ec2.WithTrailingNewlines(trailingNewlines),
+			ec2.WithUpstream(upstream),
+			ec2.WithHotPathTTL(c.Opts.HotPathTTL),
+		)...,
+	)
 	fe.Configure(router)
+	router.NoRoute(fe.NotFound)
 
-	hack.Configure(router, be)
+	if c.Opts.HotPathTTL > 0 {
+		caches["hot_path"] = fe
+		flushers = append(flushers, fe)
+	}
+	metrics.RegisterCacheSize(registrar, caches)
+
+	var reload func() error
+	if c.Opts.ConfigFile != "" {
+		reload = (&reloader{vpr: c.vpr, opts: c.Opts, fe: fe}).Reload
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		go reloadOnSignal(ctx, logger, hup, reload)
+	}
+
+	// The native document is a superset of the hack document so it can be served in its place.
+	// Hack documents carry no tenant so can't be served when lookups are scoped to tenants.
+	switch {
+	case c.Opts.NativeMetadata:
+		// Validated before wrapping.
+		nc, _ := be.(native.Client)
+		native.Configure(
+			router,
+			nc,
+			native.WithLongPoll(c.Opts.NativeLongPollTimeout, notifier),
+			native.WithMaxWatchers(c.Opts.NativeMaxWatchers),
+		)
+	case tenants == nil:
+		hack.Configure(router, be)
+	}
+
+	phonehome.Configure(router, fe, logger, registrar)
+
+	if c.Opts.NoCloudBasePath != "" {
+		nocloud.Configure(router, fe, c.Opts.NoCloudBasePath)
+	}
+
+	if c.Opts.AzureIMDS {
+		azure.Configure(router, fe)
+	}
+
+	serveOpts := []hegelhttp.Option{
+		hegelhttp.WithShutdownGracePeriod(c.Opts.ShutdownGracePeriod),
+		hegelhttp.WithReadHeaderTimeout(c.Opts.ReadHeaderTimeout),
+		hegelhttp.WithReadTimeout(c.Opts.ReadTimeout),
+		hegelhttp.WithWriteTimeout(c.Opts.WriteTimeout),
+		hegelhttp.WithIdleTimeout(c.Opts.IdleTimeout),
+		hegelhttp.WithMaxHeaderBytes(c.Opts.MaxHeaderBytes),
+	}
+
+	// TLS and HTTP/2 only apply to the metadata listener.
+	metadataServeOpts := append([]hegelhttp.Option{hegelhttp.WithHTTP2(c.Opts.HTTP2)}, serveOpts...)
+	if c.Opts.TLSCertFile != "" {
+		tlsConfig, err := hegelhttp.LoadTLSConfig(c.Opts.TLSCertFile, c.Opts.TLSKeyFile, c.Opts.TLSClientCAFile)
+		if err != nil {
+			return err
+		}
+		metadataServeOpts = append([]hegelhttp.Option{hegelhttp.WithTLSConfig(tlsConfig)}, metadataServeOpts...)
+	}
+
+	// Assigning the address is best effort so Hegel still starts where it isn't permitted. If
+	// the address is required to listen, listening fails instead.
+	if c.Opts.LinkLocalAlias != "" {
+		remove, err := hegelhttp.AddLinkLocalAlias(c.Opts.LinkLocalAlias)
+		if err != nil {
+			logger.Error(err, "Unable to assign the link-local metadata address", "interface", c.Opts.LinkLocalAlias)
+		} else {
+			defer func() {
+				if err := remove(); err != nil {
+					logger.Error(err, "Unable to remove the link-local metadata address")
+				}
+			}()
+		}
+	}
+
+	if c.Opts.AdminAddr == "" {
+		return hegelhttp.Serve(ctx, logger, c.Opts.HTTPAddr, router, metadataServeOpts...)
+	}
 
-	// Listen for signals to gracefully shutdown.
-	ctx, cancel := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	adminRouter := newAdminRouter(registrar, logger, c.Opts, fe, reload, flushers...)
+
+	return serveAll(
+		ctx,
+		func(ctx context.Context) error {
+			return hegelhttp.Serve(ctx, logger, c.Opts.HTTPAddr, router, metadataServeOpts...)
+		},
+		func(ctx context.Context) error {
+			return hegelhttp.Serve(ctx, logger, c.Opts.AdminAddr, adminRouter, serveOpts...)
+		},
+	)
+}
+
+// serveAll runs each serve func concurrently until they all return. If any serve func returns,
+// the context passed to the others is cancelled so they shut down. It returns the first error
+// returned.
+func serveAll(ctx context.Context, serve ...func(context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	return hegelhttp.Serve(ctx, logger, c.Opts.HTTPAddr, router)
+	errs := make(chan error, len(serve))
+	for _, fn := range serve {
+		go func(fn func(context.Context) error) {
+			errs <- fn(ctx)
+		}(fn)
+	}
+
+	var first error
+	for range serve {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+		cancel()
+	}
+
+	return first
 }
 
 func (c *RootCommand) configureFlags() error {
+	c.Flags().String(
+		"config-file",
+		"",
+		"Path to a YAML or JSON file of options keyed by flag name, such as default-values. Flags and environment variables "+
+			"take precedence. Changes to default-values, facility-regions, max-user-data-size, services-domain, "+
+			"services-partition and user-data-merge are applied on SIGHUP, or POST /admin/reload with --admin-token. Changes to "+
+			"other options, such as path-aliases and backend-max-concurrency, require a restart and are rejected",
+	)
+
 	c.Flags().String(
 		"trusted-proxies",
 		"",
 		"A commma separated list of allowed peer IPs and/or CIDR blocks to replace with X-Forwarded-For",
 	)
 
-	c.Flags().String("http-addr", ":50061", "Port to listen on for HTTP requests")
+	c.Flags().String(
+		"http-addr",
+		":50061",
+		fmt.Sprintf(
+			"Address to listen on for HTTP requests. Use %v to serve at the EC2 link-local metadata address "+
+				"or %v<path> to serve on a Unix domain socket",
+			hegelhttp.LinkLocalMetadataAddr,
+			hegelhttp.UnixAddrPrefix,
+		),
+	)
+
+	c.Flags().String(
+		"link-local-alias-interface",
+		"",
+		"Network interface to assign the link-local metadata address, 169.254.169.254/32, to at startup so it can be "+
+			"listened on. Requires CAP_NET_ADMIN. The address is removed at shutdown if it was assigned",
+	)
+
+	c.Flags().String(
+		"unix-socket-identity-header",
+		unixsocket.DefaultIdentityHeader,
+		"Header identifying the instance IP for requests received on a Unix domain socket. Empty disables the header",
+	)
+
+	c.Flags().String(
+		"unix-socket-identity-ip",
+		"",
+		"Instance IP for requests received on a Unix domain socket that don't specify the identity header",
+	)
+
+	c.Flags().String("tls-cert-file", "", "Path to a PEM encoded certificate for serving metadata over HTTPS")
+	c.Flags().String("tls-key-file", "", "Path to the PEM encoded private key for --tls-cert-file")
+	c.Flags().String(
+		"tls-client-ca-file",
+		"",
+		"Path to PEM encoded CA certificates used to verify client certificates. Clients must present a certificate when specified",
+	)
+	c.Flags().Bool(
+		"tls-client-identity",
+		false,
+		"Identify instances by the IP subject alternative name of their verified client certificate instead of the source IP",
+	)
+
+	c.Flags().String(
+		"admin-addr",
+		"",
+		"Address to listen on for admin HTTP requests such as listing served paths. Empty disables the admin listener",
+	)
+
+	c.Flags().String(
+		"admin-token",
+		"",
+		"Bearer token required by admin endpoints that change state, such as /admin/flush-cache. Empty disables them",
+	)
+
+	c.Flags().Bool(
+		"admin-pprof",
+		false,
+		"Serve Go pprof profiles beneath /debug/pprof/ on the admin listener",
+	)
 
 	c.Flags().String("backend", "kubernetes", "Backend to use for metadata. Options: flatfile, kubernetes")
+	c.Flags().String(
+		"fallback-backend",
+		"",
+		"Backend queried for instances the --backend has no record of, such as during a migration. "+
+			"Options: flatfile, kubernetes. Must differ from --backend. Empty disables fallback",
+	)
 
 	// Kubernetes backend specific flags.
 	c.Flags().String("kubernetes-kubeconfig", "", "Path to a kubeconfig file")
 	c.Flags().String("kubernetes-apiserver", "", "URL of the Kubernetes API Server")
 	c.Flags().String("kubernetes-namespace", "", "The Kubernetes namespace to target; defaults to the service account")
+	c.Flags().String(
+		"kubernetes-user-data-fragment-annotations",
+		"",
+		"Comma separated Hardware annotation keys whose values are user-data fragments composed, in order, before the Hardware user-data",
+	)
+	c.Flags().StringArray(
+		"kubernetes-field-mappings",
+		nil,
+		"An endpoint=jsonpath pair sourcing EC2 endpoint data from an alternate Hardware field, such as /meta-data/hostname={.metadata.annotations.hostname}; repeat the flag for each endpoint",
+	)
+	c.Flags().StringArray(
+		"kubernetes-user-data-state-mappings",
+		nil,
+		"A state=jsonpath pair sourcing user-data from an alternate Hardware field while the Hardware is in the provisioning state, such as provisioned={.metadata.annotations.post-install-user-data}; repeat the flag for each state",
+	)
+	c.Flags().StringArray(
+		"kubernetes-user-data-phase-mappings",
+		nil,
+		"A phase=jsonpath pair sourcing the user-data of a boot phase selected by --user-agent-phases from a Hardware field, such as ipxe={.metadata.annotations.ipxe-script}; repeat the flag for each phase",
+	)
+	c.Flags().Duration(
+		"kubernetes-cache-sync-timeout",
+		0,
+		"Maximum time to wait at startup for the Hardware cache to sync before exiting with an error. 0 doesn't wait; "+
+			"/readyz and lookups fail with 503 Service Unavailable until the cache syncs",
+	)
 
 	// Flatfile backend specific flags.
 	c.Flags().String("flatfile-path", "", "Path to the flatfile metadata")
 
+	c.Flags().Bool(
+		"sniff-user-data-content-type",
+		false,
+		"Set the user-data Content-Type based on its content, such as a shell script or cloud-config",
+	)
+
+	c.Flags().Bool(
+		"recursive-skip-failed",
+		false,
+		"Omit data that can't be produced from recursive directory responses rather than failing the request",
+	)
+
+	c.Flags().String(
+		"upstream-url",
+		"",
+		"Base URL, such as a cloud instance metadata service or another Hegel, unknown metadata paths are proxied to "+
+			"rather than rejected. Takes precedence over --unknown-path-parent-listing. Empty disables proxying",
+	)
+
+	c.Flags().Bool(
+		"unknown-path-parent-listing",
+		false,
+		"Serve the listing of the nearest parent directory for unknown metadata paths, as AWS does for some partial paths, "+
+			"rather than a 404",
+	)
+
+	c.Flags().Bool(
+		"user-data-templates",
+		false,
+		"Render user-data as a Go template with the instance metadata, such as {{ .Hostname }} and {{ .InstanceID }}, "+
+			"when served. Templates that fail to render are served as a 500",
+	)
+
+	c.Flags().String(
+		"trailing-newline-endpoints",
+		"",
+		"Comma separated endpoints, such as /meta-data/hostname or /meta-data for every endpoint beneath it, whose plain "+
+			"text responses end with a newline. Use / for every endpoint. Defaults to none, matching AWS. User-data is "+
+			"always served as is",
+	)
+
+	c.Flags().String(
+		"user-agent-phases",
+		"",
+		"Comma separated pattern=phase pairs, such as ^iPXE=ipxe, selecting the boot phase of requests whose User-Agent "+
+			"matches the regular expression pattern. Instances with user-data for the phase are served it in place of "+
+			"their user-data; see --kubernetes-user-data-phase-mappings for the kubernetes backend. The first matching "+
+			"pattern is used",
+	)
+
+	c.Flags().String(
+		"user-data-merge",
+		string(ec2.UserDataMergeMultipart),
+		"Strategy for composing user-data fragments with user-data. Options: multipart, concat",
+	)
+
+	c.Flags().String(
+		"user-data-signing-key",
+		"",
+		"Path to a PEM encoded PKCS #8 Ed25519 private key used to sign user-data. Signatures are served at "+
+			"/user-data.sig and in the X-Hegel-User-Data-Signature header and cover the instance ID, /user-data and the "+
+			"user-data joined by newlines. Empty disables signing",
+	)
+
+	c.Flags().Int(
+		"max-user-data-size",
+		0,
+		"Size in bytes of the largest user-data or vendor-data served. Requests for larger data fail. Use 0 for unlimited",
+	)
+
+	c.Flags().String(
+		"mac-header",
+		"",
+		"Header, such as X-Hegel-MAC, clients can use to identify by MAC address when their IP is unknown. Empty disables MAC lookups",
+	)
+
+	c.Flags().String(
+		"identity-strategies",
+		"",
+		"Comma separated strategies, in priority order, used to identify the instance a request is made on behalf of. "+
+			"Options: source-ip, xff, client-cert, mac-header, unix-socket, dhcp-lease, node-hint. Requests none of them identify are "+
+			"rejected. Empty uses the identity flags such as --trusted-proxies",
+	)
+
+	c.Flags().Bool(
+		"identity-allow-unidentified",
+		false,
+		"Identify requests --identity-strategies don't identify by their source IP rather than rejecting them",
+	)
+
+	c.Flags().String(
+		"dhcp-lease-file",
+		"",
+		"Path to a DHCP server lease file the dhcp-lease identity strategy uses to identify instances by the MAC address "+
+			"their IP is leased to. It is read again when it changes, checked every 5s",
+	)
+
+	c.Flags().String(
+		"dhcp-lease-format",
+		string(dhcplease.FormatDnsmasq),
+		"Format of --dhcp-lease-file. Options: dnsmasq, isc",
+	)
+
+	c.Flags().String(
+		"node-hint-header",
+		"X-Hegel-Node-Hint",
+		"Header the node-hint identity strategy reads signed hints from. Hints identify instances sharing an IP, such as "+
+			"those behind NAT, and are of the form <kind>:<value>.<issued>.<signature> where kind is ip, mac or instance-id, "+
+			"issued is the Unix time in seconds the hint was issued at and signature is the unpadded base64url encoded "+
+			"HMAC-SHA256 of <kind>:<value>.<issued>",
+	)
+
+	c.Flags().String(
+		"node-hint-key-file",
+		"",
+		"Path to a file containing the key, of at least 32 bytes, node hints are signed with. Surrounding whitespace is ignored",
+	)
+
+	c.Flags().Duration(
+		"node-hint-max-age",
+		5*time.Minute,
+		"Maximum time since, or until, a node hint was issued for it to identify an instance so intercepted hints can't be replayed indefinitely",
+	)
+
+	c.Flags().Bool(
+		"unsafe-debug-identity-override",
+		false,
+		"UNSAFE: identify instances by the instance_id or ip query parameter, overriding all other identities. "+
+			"Any client can retrieve any instance's data so only use for debugging",
+	)
+
+	c.Flags().String(
+		"tenant-source",
+		"",
+		"Source of the tenant requests are scoped to. Instances are only served to requests from their own tenant. "+
+			"Options: header, client-cert, static. Empty disables tenant scoping",
+	)
+
+	c.Flags().String(
+		"tenant-header",
+		"X-Hegel-Tenant",
+		"Header carrying the tenant when --tenant-source is header. It must be set by a trusted proxy",
+	)
+
+	c.Flags().String(
+		"tenant",
+		"",
+		"Tenant every request is scoped to when --tenant-source is static",
+	)
+
+	c.Flags().String(
+		"default-values",
+		"",
+		"Comma separated endpoint=value pairs, such as /meta-data/hostname=unknown, served when an instance has no data for the endpoint",
+	)
+
+	c.Flags().String(
+		"path-aliases",
+		"",
+		"Comma separated alias=endpoint pairs, such as /meta-data/host-name=/meta-data/hostname, serving an endpoint's data at an alternate path",
+	)
+
+	c.Flags().String(
+		"facility-regions",
+		"",
+		"Comma separated facility=region pairs, such as sv15=us-west, defining the region served for instances in a facility",
+	)
+
+	c.Flags().String(
+		"services-domain",
+		ec2.DefaultServicesDomain,
+		"Domain served at /meta-data/services/domain that AWS SDKs use to construct service endpoints",
+	)
+
+	c.Flags().String(
+		"services-partition",
+		ec2.DefaultServicesPartition,
+		"Partition served at /meta-data/services/partition that AWS SDKs use to construct service endpoints",
+	)
+
+	c.Flags().String(
+		"nocloud-base-path",
+		"",
+		"Path, such as /nocloud, to serve cloud-init NoCloud datasource seed files beneath. Empty disables NoCloud",
+	)
+
+	c.Flags().Bool(
+		"azure-imds",
+		false,
+		"Serve an Azure Instance Metadata Service compatible /metadata/instance endpoint",
+	)
+
+	c.Flags().Bool(
+		"native-metadata",
+		false,
+		"Serve the native Tinkerbell metadata document at /metadata in place of the rootio action document; requires the kubernetes backend and can't be used with --tenant-source",
+	)
+
+	c.Flags().Duration(
+		"native-long-poll-timeout",
+		0,
+		"Serve a /metadata/watch endpoint, with --native-metadata, that holds requests whose If-None-Match header "+
+			"matches the document open until it changes for up to this long. Must be less than --http-write-timeout. "+
+			"Use 0 to disable",
+	)
+
+	c.Flags().Int(
+		"native-long-poll-max-watchers",
+		1000,
+		"Maximum concurrent /metadata/watch requests, with --native-long-poll-timeout. Requests beyond it are rejected "+
+			"with a 429. Use 0 for no limit",
+	)
+
 	c.Flags().Bool("debug", false, "Enable debug logging")
 
+	c.Flags().String(
+		"metrics-namespace",
+		metrics.DefaultNamespace,
+		"Namespace prefixed to the name of every exported metric. Empty disables the prefix",
+	)
+
+	c.Flags().String(
+		"metrics-subsystem",
+		"",
+		"Subsystem prefixed to the name of every exported metric after the namespace",
+	)
+
+	c.Flags().String(
+		"audit-log",
+		"",
+		"Path to a file to append a JSON audit log entry to for each request, or - for stdout. Empty disables the audit log",
+	)
+
+	c.Flags().Float64(
+		"audit-log-sample-rate",
+		audit.DefaultSampleRate,
+		"Fraction of requests, between 0 and 1, recorded in the audit log",
+	)
+
+	c.Flags().Duration(
+		"shutdown-grace-period",
+		hegelhttp.DefaultShutdownGracePeriod,
+		"Time to wait for in-flight requests to complete when shutting down",
+	)
+
+	c.Flags().Duration(
+		"http-read-header-timeout",
+		hegelhttp.DefaultReadHeaderTimeout,
+		"Time allowed to read request headers",
+	)
+
+	c.Flags().Duration(
+		"http-read-timeout",
+		hegelhttp.DefaultReadTimeout,
+		"Time allowed to read an entire request",
+	)
+
+	c.Flags().Duration(
+		"http-write-timeout",
+		hegelhttp.DefaultWriteTimeout,
+		"Time allowed to write a response",
+	)
+
+	c.Flags().Duration(
+		"http-idle-timeout",
+		hegelhttp.DefaultIdleTimeout,
+		"Time a keep-alive connection may wait for the next request",
+	)
+
+	c.Flags().Int(
+		"http-max-header-bytes",
+		hegelhttp.DefaultMaxHeaderBytes,
+		"Maximum size of request headers in bytes",
+	)
+
+	c.Flags().Bool(
+		"http2",
+		false,
+		"Support HTTP/2 on the metadata listener in addition to HTTP/1.1. Negotiated with ALPN when serving TLS, "+
+			"otherwise clients must use HTTP/2 over cleartext (h2c)",
+	)
+
+	c.Flags().Duration(
+		"negative-cache-ttl",
+		negativecache.DefaultTTL,
+		"Time to cache IPs for which no instance was found. Use 0 to disable",
+	)
+
+	c.Flags().Duration(
+		"hot-path-ttl",
+		0,
+		"Time to cache the instance ID, hostname, public keys and user-data of an instance once retrieved, serving "+
+			"the requests cloud-init makes on every boot without further lookups. Use 0 to disable",
+	)
+
+	c.Flags().Int(
+		"backend-retries",
+		retry.DefaultMaxRetries,
+		"Number of times to retry instance lookups that fail with a transient backend error. Use 0 to disable",
+	)
+
+	c.Flags().Duration(
+		"backend-retry-backoff",
+		retry.DefaultBackoff,
+		"Time to wait before the first retry of a failed instance lookup. Doubles for each subsequent retry",
+	)
+
+	c.Flags().Int(
+		"backend-max-concurrency",
+		0,
+		"Maximum number of concurrent instance lookups. Lookups beyond the limit wait for a lookup to complete. Use 0 to disable",
+	)
+
+	c.Flags().Bool(
+		"backend-concurrency-fail-fast",
+		false,
+		"Fail lookups beyond --backend-max-concurrency immediately, serving a 503, rather than waiting",
+	)
+
+	c.Flags().Int(
+		"backend-breaker-threshold",
+		0,
+		"Number of consecutive failed instance lookups after which lookups fail immediately, serving a 503, "+
+			"until --backend-breaker-cooldown elapses. Use 0 to disable",
+	)
+
+	c.Flags().Duration(
+		"backend-breaker-cooldown",
+		breaker.DefaultCooldown,
+		"Time instance lookups fail immediately once --backend-breaker-threshold is reached before a trial lookup "+
+			"tests whether the backend has recovered",
+	)
+
+	c.Flags().Bool(
+		"validate-instances",
+		false,
+		"Validate the structure of instances retrieved from the backend, logging and counting invalid fields",
+	)
+
+	c.Flags().Bool(
+		"reverse-dns-fallback",
+		false,
+		"When no instance has a requester's IP, reverse resolve the IP and retry the lookup by each resolved hostname "+
+			"that resolves back to the IP",
+	)
+
+	c.Flags().Duration(
+		"reverse-dns-timeout",
+		ptr.DefaultTimeout,
+		"Time allowed for reverse resolving a requester's IP when --reverse-dns-fallback is enabled",
+	)
+
+	c.Flags().String(
+		"reverse-dns-domain",
+		"",
+		"Trusted domain stripped from forward confirmed names resolved by --reverse-dns-fallback so they match "+
+			"short hostnames, such as example.com matching sm01.example.com to sm01. Empty matches fully qualified names only",
+	)
+
 	c.Flags().Bool("hegel-api", false, "Toggle to true to enable Hegel's new experimental API. Default is false.")
 	if err := c.Flags().MarkHidden("hegel-api"); err != nil {
 		return err
@@ -186,9 +1193,387 @@ func (c *RootCommand) configureFlags() error {
 	return err
 }
 
-func toBackendOptions(opts RootCommandOptions) backend.Options {
+// loadUserDataSigningKey loads the PEM encoded PKCS #8 Ed25519 private key at path. If path is
+// empty it returns nil.
+func loadUserDataSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Errorf("--user-data-signing-key: %v", err)
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("--user-data-signing-key: no PEM data found")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Errorf("--user-data-signing-key: %v", err)
+	}
+
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.Errorf("--user-data-signing-key: expected an Ed25519 key, got %T", key)
+	}
+
+	return edKey, nil
+}
+
+// parseDefaultValues parses comma separated endpoint=value pairs into a map of EC2 data endpoint
+// to default value. Endpoints exclude the API version prefix.
+func parseDefaultValues(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	known := map[string]bool{}
+	for _, p := range ec2.Paths() {
+		known[strings.TrimPrefix(p, ec2.APIVersionPrefix)] = true
+	}
+
+	defaults := map[string]string{}
+	for _, pair := range splitList(s) {
+		endpoint, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errors.Errorf("--default-values: expected endpoint=value, got %q", pair)
+		}
+		endpoint = strings.TrimSpace(endpoint)
+		if !known[endpoint] {
+			return nil, errors.Errorf("--default-values: unknown endpoint %q", endpoint)
+		}
+		defaults[endpoint] = value
+	}
+
+	return defaults, nil
+}
+
+// parsePathAliases parses comma separated alias=endpoint pairs into a map of alternate path to the
+// EC2 data endpoint it serves. Paths exclude the API version prefix.
+func parsePathAliases(s string) (map[string]string, error) {
+	aliases := map[string]string{}
+	for _, pair := range splitList(s) {
+		alias, endpoint, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, errors.Errorf("--path-aliases: expected alias=endpoint, got %q", pair)
+		}
+		aliases[strings.TrimSpace(alias)] = strings.TrimSpace(endpoint)
+	}
+
+	if err := ec2.ValidatePathAliases(aliases); err != nil {
+		return nil, errors.Errorf("--path-aliases: %v", err)
+	}
+
+	return aliases, nil
+}
+
+// parseUpstreamURL parses the --upstream-url option. If s is empty it returns nil.
+func parseUpstreamURL(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errors.Errorf("--upstream-url: %v", err)
+	}
+
+	if err := ec2.ValidateUpstream(u); err != nil {
+		return nil, errors.Errorf("--upstream-url: %v", err)
+	}
+
+	return u, nil
+}
+
+// parseUserAgentPhases parses comma separated pattern=phase pairs, in order of precedence, into
+// the phases selected by the User-Agent of requests. Patterns are regular expressions and may
+// themselves contain =; the phase follows the last.
+func parseUserAgentPhases(s string) ([]ec2.UserAgentPhase, error) {
+	var phases []ec2.UserAgentPhase
+	for _, pair := range splitList(s) {
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, errors.Errorf("--user-agent-phases: expected pattern=phase, got %q", pair)
+		}
+
+		pattern, err := regexp.Compile(strings.TrimSpace(pair[:i]))
+		if err != nil {
+			return nil, errors.Errorf("--user-agent-phases: %v", err)
+		}
+
+		phases = append(phases, ec2.UserAgentPhase{
+			Pattern: pattern,
+			Phase:   strings.TrimSpace(pair[i+1:]),
+		})
+	}
+
+	if err := ec2.ValidateUserAgentPhases(phases); err != nil {
+		return nil, errors.Errorf("--user-agent-phases: %v", err)
+	}
+
+	return phases, nil
+}
+
+// parseTrailingNewlineEndpoints parses comma separated endpoints, excluding the API version prefix,
+// whose text responses end with a newline.
+func parseTrailingNewlineEndpoints(s string) ([]string, error) {
+	endpoints := splitList(s)
+	for _, e := range endpoints {
+		if !strings.HasPrefix(e, "/") {
+			return nil, errors.Errorf("--trailing-newline-endpoints: endpoint must begin with /, got %q", e)
+		}
+	}
+	return endpoints, nil
+}
+
+// parseFacilityRegions parses comma separated facility=region pairs into a map of facility to
+// region.
+func parseFacilityRegions(s string) (map[string]string, error) {
+	regions := map[string]string{}
+	for _, pair := range splitList(s) {
+		facility, region, ok := strings.Cut(pair, "=")
+		if !ok || facility == "" || region == "" {
+			return nil, errors.Errorf("--facility-regions: expected facility=region, got %q", pair)
+		}
+		regions[strings.TrimSpace(facility)] = strings.TrimSpace(region)
+	}
+	return regions, nil
+}
+
+// parseFieldMappings parses endpoint=jsonpath pairs into a map of EC2 endpoint to the Kubernetes
+// JSONPath expression selecting the Hardware field its data is sourced from. Pairs aren't comma
+// separated as JSONPath expressions may contain commas.
+func parseFieldMappings(pairs []string) (map[string]string, error) {
+	mappings := map[string]string{}
+	for _, pair := range pairs {
+		endpoint, path, ok := strings.Cut(pair, "=")
+		if !ok || endpoint == "" || path == "" {
+			return nil, errors.Errorf("--kubernetes-field-mappings: expected endpoint=jsonpath, got %q", pair)
+		}
+		mappings[strings.TrimSpace(endpoint)] = strings.TrimSpace(path)
+	}
+	return mappings, nil
+}
+
+// parseUserDataStateMappings parses state=jsonpath pairs into a map of Hardware provisioning state
+// to the Kubernetes JSONPath expression selecting the user-data served in it.
+func parseUserDataStateMappings(pairs []string) (map[string]string, error) {
+	mappings := map[string]string{}
+	for _, pair := range pairs {
+		state, path, ok := strings.Cut(pair, "=")
+		if !ok || state == "" || path == "" {
+			return nil, errors.Errorf("--kubernetes-user-data-state-mappings: expected state=jsonpath, got %q", pair)
+		}
+		mappings[strings.TrimSpace(state)] = strings.TrimSpace(path)
+	}
+	return mappings, nil
+}
+
+// parseUserDataPhaseMappings parses phase=jsonpath pairs into a map of boot phase to the
+// Kubernetes JSONPath expression selecting the user-data served in it.
+func parseUserDataPhaseMappings(pairs []string) (map[string]string, error) {
+	mappings := map[string]string{}
+	for _, pair := range pairs {
+		phase, path, ok := strings.Cut(pair, "=")
+		if !ok || phase == "" || path == "" {
+			return nil, errors.Errorf("--kubernetes-user-data-phase-mappings: expected phase=jsonpath, got %q", pair)
+		}
+		mappings[strings.TrimSpace(phase)] = strings.TrimSpace(path)
+	}
+	return mappings, nil
+}
+
+// identityMiddleware creates the middleware that identify the instance a request is made on behalf
+// of using the strategies returned by identityStrategies. When the unsafe debug identity override
+// is enabled, the debug query parameters take precedence over all of them.
+//
+// When strategies are configured, requests they don't identify are rejected unless unidentified
+// requests are allowed, so requests are never served the instance at their source IP unless the
+// source-ip strategy is configured.
+func (c *RootCommand) identityMiddleware(ctx context.Context, logger logr.Logger) ([]gin.HandlerFunc, error) {
+	strategies, err := identityStrategies(ctx, logger, c.Opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Opts.UnsafeDebugIdentityOverride {
+		strategies = append([]identity.Strategy{identity.DebugQuery()}, strategies...)
+	}
+
+	if c.Opts.IdentityStrategies != "" && !c.Opts.IdentityAllowUnidentified {
+		return []gin.HandlerFunc{identity.StrictMiddleware(strategies...)}, nil
+	}
+	return []gin.HandlerFunc{identity.Middleware(strategies...)}, nil
+}
+
+// defaultIdentityStrategies returns the names of the strategies used when none are configured. In
+// priority order, they're the client certificate when configured, the Unix socket identity and
+// X-Forwarded-For when there are trusted proxies.
+func defaultIdentityStrategies(opts RootCommandOptions) []string {
+	var names []string
+	if opts.TLSClientIdentity {
+		names = append(names, identity.ClientCertStrategy)
+	}
+	names = append(names, identity.UnixSocketStrategy)
+	if opts.TrustedProxies != "" {
+		names = append(names, identity.ForwardedForStrategy)
+	}
+	return names
+}
+
+// identityStrategies creates the identity strategies named by opts.IdentityStrategies, in order,
+// configured from the corresponding options. If none are named, it creates those named by
+// defaultIdentityStrategies. Strategies that refresh their data in the background, such as
+// dhcp-lease, do so until ctx is done and log failures to logger.
+func identityStrategies(ctx context.Context, logger logr.Logger, opts RootCommandOptions) ([]identity.Strategy, error) {
+	names, flag := splitList(opts.IdentityStrategies), "--identity-strategies: "
+	if len(names) == 0 {
+		names, flag = defaultIdentityStrategies(opts), ""
+	}
+
+	var strategies []identity.Strategy
+	for _, name := range names {
+		var s identity.Strategy
+		var err error
+
+		switch name {
+		case identity.SourceIPStrategy:
+			s = identity.SourceIP()
+		case identity.ForwardedForStrategy:
+			var proxies []string
+			if proxies, err = xff.Parse(opts.TrustedProxies); err == nil {
+				s, err = identity.ForwardedFor(proxies)
+			}
+		case identity.ClientCertStrategy:
+			if opts.TLSClientCAFile == "" {
+				err = errors.New("requires --tls-client-ca-file")
+			}
+			s = identity.ClientCert()
+		case identity.MACHeaderStrategy:
+			s, err = identity.MACHeader(opts.MACHeader)
+		case identity.UnixSocketStrategy:
+			s, err = identity.UnixSocket(unixsocket.Options{
+				IdentityHeader: opts.UnixSocketIdentityHeader,
+				IdentityIP:     opts.UnixSocketIdentityIP,
+			})
+		case identity.DHCPLeaseStrategy:
+			if opts.DHCPLeaseFile == "" {
+				err = errors.New("requires --dhcp-lease-file")
+				break
+			}
+			var leases *dhcplease.File
+			leases, err = dhcplease.NewFile(
+				ctx,
+				logger,
+				opts.DHCPLeaseFile,
+				dhcplease.Format(opts.DHCPLeaseFormat),
+				dhcpLeaseRefreshInterval,
+			)
+			if err == nil {
+				s = identity.DHCPLease(leases)
+			}
+		case identity.NodeHintStrategy:
+			if opts.NodeHintKeyFile == "" {
+				err = errors.New("requires --node-hint-key-file")
+				break
+			}
+			var key []byte
+			if key, err = os.ReadFile(opts.NodeHintKeyFile); err == nil {
+				s, err = identity.NodeHint(opts.NodeHintHeader, bytes.TrimSpace(key), opts.NodeHintMaxAge)
+			}
+		default:
+			err = errors.Errorf("unknown strategy; options: %v", strings.Join(identity.StrategyNames(), ", "))
+		}
+
+		if err != nil {
+			return nil, errors.Errorf("%v%v: %v", flag, name, err)
+		}
+		strategies = append(strategies, s)
+	}
+	return strategies, nil
+}
+
+// tenantSource creates the tenant source named by opts.TenantSource configured from the
+// corresponding options. If no source is named, tenant scoping is disabled and it returns nil.
+func tenantSource(opts RootCommandOptions) (tenant.Source, error) {
+	var (
+		s   tenant.Source
+		err error
+	)
+
+	switch opts.TenantSource {
+	case "":
+		return nil, nil
+	case tenant.HeaderSource:
+		s, err = tenant.Header(opts.TenantHeader)
+	case tenant.ClientCertSource:
+		if opts.TLSClientCAFile == "" {
+			err = errors.New("requires --tls-client-ca-file")
+		}
+		s = tenant.ClientCert()
+	case tenant.StaticSource:
+		s, err = tenant.Static(opts.Tenant)
+	default:
+		err = errors.Errorf("unknown source; options: %v", strings.Join(tenant.SourceNames(), ", "))
+	}
+
+	if err != nil {
+		return nil, errors.Errorf("--tenant-source: %v: %v", opts.TenantSource, err)
+	}
+	return s, nil
+}
+
+// openAuditLog opens the audit log at path for appending. The path - is stdout. The returned func
+// closes the audit log.
+func openAuditLog(path string) (io.Writer, func(), error) {
+	if path == "-" {
+		return os.Stdout, func() {}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { _ = f.Close() }, nil
+}
+
+// splitList splits a comma separated list ignoring empty elements.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// validateFallbackBackend validates the --fallback-backend, if any, names a backend other than the
+// --backend.
+func validateFallbackBackend(opts RootCommandOptions) error {
+	switch opts.FallbackBackend {
+	case "":
+		return nil
+	case "flatfile", "kubernetes":
+	default:
+		return errors.Errorf("--fallback-backend: unknown backend %q; options: flatfile, kubernetes", opts.FallbackBackend)
+	}
+
+	if opts.FallbackBackend == opts.Backend {
+		return errors.Errorf("--fallback-backend: must differ from --backend, got %q", opts.FallbackBackend)
+	}
+
+	return nil
+}
+
+// toBackendOptions creates the backend.Options for the backend named name configured from the
+// corresponding options.
+func toBackendOptions(name string, opts RootCommandOptions) backend.Options {
 	var backndOpts backend.Options
-	switch opts.Backend {
+	switch name {
 	case "flatfile":
 		backndOpts = backend.Options{
 			Flatfile: &backend.Flatfile{
@@ -196,11 +1581,21 @@ func toBackendOptions(opts RootCommandOptions) backend.Options {
 			},
 		}
 	case "kubernetes":
+		// Validated in PreRun.
+		fieldMappings, _ := parseFieldMappings(opts.KubernetesFieldMappings)
+		stateMappings, _ := parseUserDataStateMappings(opts.KubernetesUserDataStateMappings)
+		phaseMappings, _ := parseUserDataPhaseMappings(opts.KubernetesUserDataPhaseMappings)
 		backndOpts = backend.Options{
 			Kubernetes: &kubernetes.Config{
 				APIServerAddress: opts.KubernetesAPIServer,
 				Kubeconfig:       opts.KubernetesKubeconfig,
 				Namespace:        opts.KubernetesNamespace,
+
+				UserDataFragmentAnnotations: splitList(opts.KubernetesUserDataFragmentAnnotations),
+				FieldMappings:               fieldMappings,
+				UserDataStateMappings:       stateMappings,
+				UserDataPhaseMappings:       phaseMappings,
+				CacheSyncTimeout:            opts.KubernetesCacheSyncTimeout,
 			},
 		}
 	}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/admin"
+	"github.com/tinkerbell/hegel/internal/audit"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/ginutil"
+	hegellogger "github.com/tinkerbell/hegel/internal/logger"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+// newRouter creates the router frontends are configured on. Cross-cutting concerns are
+// implemented as middleware that run, for every request, in the following order.
+//
+//  1. Instrumentation so every request is observed, including those that panic.
+//  2. Recovery so panics are served as internal server errors.
+//  3. Rejecting requests with oversized paths so they're neither logged nor parsed.
+//  4. Logging.
+//  5. Draining bodies sent with GET and HEAD requests so connections remain reusable.
+//  6. Audit logging, if auditLog isn't nil, so requests rejected by identity middleware are
+//     recorded.
+//  7. The identity middleware, in the order given. Identity middleware rewrite the request remote
+//     address to the address identifying the instance so handlers identify it by remote address.
+func newRouter(
+	registry prometheus.Registerer,
+	logger logr.Logger,
+	auditLog *audit.Log,
+	identity ...gin.HandlerFunc,
+) *gin.Engine {
+	router := gin.New()
+
+	router.Use(
+		metrics.InstrumentRequestCount(registry),
+		metrics.InstrumentInFlightRequests(registry),
+		metrics.InstrumentClientDisconnects(registry),
+		metrics.InstrumentRequestDuration(registry),
+		metrics.InstrumentResponseSize(registry),
+		metrics.InstrumentErrors(registry),
+		metrics.InstrumentPathRequests(registry, ec2.APIVersionPrefix, ec2.LatestAPIVersionPrefix),
+		gin.Recovery(),
+		ginutil.LimitPathLength(registry, "metadata", ginutil.DefaultMaxPathLength),
+		hegellogger.Middleware(logger),
+		ginutil.DrainRequestBody(),
+	)
+	if auditLog != nil {
+		router.Use(auditLog.Middleware())
+	}
+	router.Use(identity...)
+
+	return router
+}
+
+// newAdminRouter creates the router served on the admin listener. It serves endpoints for
+// operators that shouldn't be exposed to instances, such as the paths fe serves and, when enabled
+// by opts, profiles. Endpoints that change state, such as flushing caches and calling reload, are
+// only served when opts has an admin token. reload may be nil if configuration can't be reloaded.
+func newAdminRouter(
+	registry prometheus.Registerer,
+	logger logr.Logger,
+	opts RootCommandOptions,
+	fe ec2.Frontend,
+	reload func() error,
+	caches ...admin.Flusher,
+) *gin.Engine {
+	router := gin.New()
+	router.Use(
+		gin.Recovery(),
+		ginutil.LimitPathLength(registry, "admin", ginutil.DefaultMaxPathLength),
+		hegellogger.Middleware(logger),
+		ginutil.DrainRequestBody(),
+	)
+	fe.ConfigurePaths(router)
+	fe.ConfigureRouteManifest(router)
+
+	if opts.AdminPprof {
+		admin.ConfigurePprof(router)
+	}
+
+	if opts.AdminToken != "" {
+		admin.ConfigureFlushCache(router, opts.AdminToken, logger, caches...)
+		if reload != nil {
+			admin.ConfigureReload(router, opts.AdminToken, logger, reload)
+		}
+	}
+
+	return router
+}
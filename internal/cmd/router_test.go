@@ -0,0 +1,438 @@
+package cmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/audit"
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
+	. "github.com/tinkerbell/hegel/internal/cmd"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/identity"
+)
+
+func TestRouterIdentityOrder(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	var order []string
+	identity := func(name, addr string) gin.HandlerFunc {
+		return func(ctx *gin.Context) {
+			order = append(order, name)
+			ctx.Request.RemoteAddr = addr
+		}
+	}
+
+	router := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil,
+		identity("first", "10.10.10.10:0"),
+		identity("second", "10.10.10.11:0"),
+	)
+
+	var remoteAddr string
+	router.GET("/", func(ctx *gin.Context) {
+		order = append(order, "handler")
+		remoteAddr = ctx.Request.RemoteAddr
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if expect := []string{"first", "second", "handler"}; !cmp.Equal(order, expect) {
+		t.Fatal(cmp.Diff(expect, order))
+	}
+
+	// The last identity middleware takes precedence.
+	if remoteAddr != "10.10.10.11:0" {
+		t.Fatalf("Expected remote address: 10.10.10.11:0; Received: %v", remoteAddr)
+	}
+}
+
+func TestRouterInstrumentsRecoveredPanics(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	registry := prometheus.NewRegistry()
+
+	var identityCalled bool
+	router := NewRouter(registry, logr.Discard(), nil, func(*gin.Context) { identityCalled = true })
+	router.GET("/panic", func(*gin.Context) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected: 500; Received: %d", w.Code)
+	}
+
+	if !identityCalled {
+		t.Fatal("Expected identity middleware to run before the handler")
+	}
+
+	// Instrumentation runs before recovery so it observes the recovered request.
+	expect := `
+# HELP http_server_requests_in_flight Number of HTTP requests currently being served
+# TYPE http_server_requests_in_flight gauge
+http_server_requests_in_flight 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_requests_in_flight"); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := testutil.CollectAndCount(registry, "http_server_requests_total"); n != 1 {
+		t.Fatalf("Expected 1 request count series; Received: %d", n)
+	}
+}
+
+func TestRouterAuditsIdentityRejections(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	var buf bytes.Buffer
+	router := NewRouter(prometheus.NewRegistry(), logr.Discard(), audit.New(&buf, logr.Discard()),
+		func(ctx *gin.Context) {
+			ctx.AbortWithStatus(http.StatusBadRequest)
+		},
+	)
+	router.GET("/", func(*gin.Context) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Audit logging precedes identity middleware so rejected requests are recorded.
+	var entry audit.Entry
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Status != http.StatusBadRequest {
+		t.Fatalf("Expected: 400; Received: %d", entry.Status)
+	}
+}
+
+func TestDebugIdentityOverride(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	cases := []struct {
+		Name         string
+		Opts         RootCommandOptions
+		ExpectRemote string
+	}{
+		{
+			Name:         "Disabled",
+			ExpectRemote: "10.10.10.10:0",
+		},
+		{
+			Name:         "Enabled",
+			Opts:         RootCommandOptions{UnsafeDebugIdentityOverride: true},
+			ExpectRemote: "10.10.10.11:0",
+		},
+		{
+			Name: "EnabledOverridesStrategies",
+			Opts: RootCommandOptions{
+				IdentityStrategies:          "source-ip",
+				UnsafeDebugIdentityOverride: true,
+			},
+			ExpectRemote: "10.10.10.11:0",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mw, err := IdentityMiddleware(tc.Opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil, mw...)
+
+			var remoteAddr string
+			router.GET("/", func(ctx *gin.Context) {
+				remoteAddr = ctx.Request.RemoteAddr
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/?ip=10.10.10.11", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(httptest.NewRecorder(), r)
+
+			if remoteAddr != tc.ExpectRemote {
+				t.Fatalf("Expected remote address: %v; Received: %v", tc.ExpectRemote, remoteAddr)
+			}
+		})
+	}
+}
+
+func TestDHCPLeaseIdentity(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	// The instance's hardware record isn't keyed by the IP it's leased.
+	client, err := flatfile.FromYAML(strings.NewReader(`
+- metadata:
+    id: "sm01"
+  macs: ["3c:ec:ef:4c:4f:54"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leases := filepath.Join(t.TempDir(), "dnsmasq.leases")
+	if err := os.WriteFile(leases, []byte("0 3c:ec:ef:4c:4f:54 10.10.10.10 sm01 *\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := IdentityMiddleware(RootCommandOptions{
+		IdentityStrategies: "dhcp-lease",
+		DHCPLeaseFile:      leases,
+		DHCPLeaseFormat:    "dnsmasq",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil, mw...)
+	ec2.New(client).Configure(router)
+
+	cases := []struct {
+		Name         string
+		RemoteAddr   string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		{
+			Name:         "Leased",
+			RemoteAddr:   "10.10.10.10:0",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "sm01",
+		},
+		{
+			// Unidentified requests aren't served the instance at their source IP.
+			Name:         "NotLeased",
+			RemoteAddr:   "10.10.10.11:0",
+			ExpectStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/instance-id", nil)
+			r.RemoteAddr = tc.RemoteAddr
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+			if tc.ExpectBody != "" && w.Body.String() != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDHCPLeaseIdentityRequiresFile(t *testing.T) {
+	if _, err := IdentityMiddleware(RootCommandOptions{IdentityStrategies: "dhcp-lease"}); err == nil {
+		t.Fatal("Expected error; Received: nil")
+	}
+}
+
+func TestNodeHintIdentity(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	// Both instances are behind a NAT gateway so share a source IP.
+	client, err := flatfile.FromYAML(strings.NewReader(`
+- metadata:
+    id: "sm01"
+  macs: ["3c:ec:ef:4c:4f:54"]
+- metadata:
+    id: "sm02"
+  macs: ["3c:ec:ef:4c:4f:55"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	keyFile := filepath.Join(t.TempDir(), "node-hint.key")
+	if err := os.WriteFile(keyFile, append(key, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mw, err := IdentityMiddleware(RootCommandOptions{
+		IdentityStrategies: "node-hint,source-ip",
+		NodeHintHeader:     "X-Hegel-Node-Hint",
+		NodeHintKeyFile:    keyFile,
+		NodeHintMaxAge:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil, mw...)
+	ec2.New(client).Configure(router)
+
+	cases := []struct {
+		Name         string
+		Hint         string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		{
+			Name:         "Valid",
+			Hint:         identity.SignNodeHint(identity.Key{Kind: identity.KindMAC, Value: "3c:ec:ef:4c:4f:55"}, time.Now(), key),
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "sm02",
+		},
+		{
+			Name:         "Forged",
+			Hint:         identity.SignNodeHint(identity.Key{Kind: identity.KindMAC, Value: "3c:ec:ef:4c:4f:55"}, time.Now(), []byte("fedcba9876543210fedcba9876543210")),
+			ExpectStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/instance-id", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			r.Header.Set("X-Hegel-Node-Hint", tc.Hint)
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+			if tc.ExpectBody != "" && w.Body.String() != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNodeHintIdentityErrors(t *testing.T) {
+	short := filepath.Join(t.TempDir(), "short.key")
+	if err := os.WriteFile(short, []byte("short"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name    string
+		KeyFile string
+	}{
+		{Name: "NoKeyFile"},
+		{Name: "MissingKeyFile", KeyFile: filepath.Join(t.TempDir(), "missing.key")},
+		{Name: "ShortKey", KeyFile: short},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := IdentityMiddleware(RootCommandOptions{
+				IdentityStrategies: "node-hint",
+				NodeHintHeader:     "X-Hegel-Node-Hint",
+				NodeHintKeyFile:    tc.KeyFile,
+				NodeHintMaxAge:     time.Minute,
+			})
+			if err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+		})
+	}
+}
+
+func TestPprofServedOnAdminRouterOnly(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	client, err := flatfile.FromYAML(strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fe := ec2.New(client)
+
+	metadata := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil)
+	fe.Configure(metadata)
+	metadata.NoRoute(fe.NotFound)
+
+	cases := []struct {
+		Name       string
+		Router     *gin.Engine
+		ExpectCode int
+	}{
+		{
+			Name:       "Admin",
+			Router:     NewAdminRouter(prometheus.NewRegistry(), logr.Discard(), RootCommandOptions{AdminPprof: true}, fe, nil),
+			ExpectCode: http.StatusOK,
+		},
+		{
+			Name:       "AdminDisabled",
+			Router:     NewAdminRouter(prometheus.NewRegistry(), logr.Discard(), RootCommandOptions{}, fe, nil),
+			ExpectCode: http.StatusNotFound,
+		},
+		{
+			Name:       "Metadata",
+			Router:     metadata,
+			ExpectCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/pprof/goroutine"} {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, path, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				tc.Router.ServeHTTP(w, r)
+
+				if w.Code != tc.ExpectCode {
+					t.Fatalf("%v: Expected: %d; Received: %d", path, tc.ExpectCode, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestRoutersRejectOversizedPaths(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	client, err := flatfile.FromYAML(strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fe := ec2.New(client)
+
+	registry := prometheus.NewRegistry()
+
+	metadata := NewRouter(registry, logr.Discard(), nil)
+	fe.Configure(metadata)
+	metadata.NoRoute(fe.NotFound)
+
+	admin := NewAdminRouter(registry, logr.Discard(), RootCommandOptions{}, fe, nil)
+
+	path := ec2.APIVersionPrefix + "/meta-data/" + strings.Repeat("a", 1<<20)
+
+	for _, router := range []*gin.Engine{metadata, admin} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusRequestURITooLong {
+			t.Fatalf("Expected: 414; Received: %d", w.Code)
+		}
+	}
+
+	expect := `
+# HELP http_server_oversized_paths_total Count of HTTP requests rejected because their path was too long
+# TYPE http_server_oversized_paths_total counter
+http_server_oversized_paths_total{listener="admin"} 1
+http_server_oversized_paths_total{listener="metadata"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_oversized_paths_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,128 @@
+package cmd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
+	"github.com/tinkerbell/hegel/internal/backend/tenantscope"
+	. "github.com/tinkerbell/hegel/internal/cmd"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/tenant"
+)
+
+func TestTenantSource(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Opts        RootCommandOptions
+		ExpectNil   bool
+		ExpectError bool
+	}{
+		{Name: "Disabled", ExpectNil: true},
+		{Name: "Header", Opts: RootCommandOptions{TenantSource: "header", TenantHeader: "X-Hegel-Tenant"}},
+		{Name: "HeaderMissing", Opts: RootCommandOptions{TenantSource: "header"}, ExpectError: true},
+		{Name: "ClientCert", Opts: RootCommandOptions{TenantSource: "client-cert", TLSClientCAFile: "ca.pem"}},
+		{Name: "ClientCertWithoutCA", Opts: RootCommandOptions{TenantSource: "client-cert"}, ExpectError: true},
+		{Name: "Static", Opts: RootCommandOptions{TenantSource: "static", Tenant: "tenant-a"}},
+		{Name: "StaticMissing", Opts: RootCommandOptions{TenantSource: "static"}, ExpectError: true},
+		{Name: "Unknown", Opts: RootCommandOptions{TenantSource: "unknown"}, ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s, err := TenantSource(tc.Opts)
+			if (err != nil) != tc.ExpectError {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+			if !tc.ExpectError && (s == nil) != tc.ExpectNil {
+				t.Fatalf("Expected nil source: %v; Received: %v", tc.ExpectNil, s)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	// Both tenants have an instance at 10.10.10.10.
+	client, err := flatfile.FromYAML(strings.NewReader(`
+- tenant: "tenant-a"
+  metadata:
+    id: "a"
+    ipv4:
+      local: "10.10.10.10"
+- tenant: "tenant-b"
+  metadata:
+    id: "b"
+    ipv4:
+      local: "10.10.10.10"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := TenantSource(RootCommandOptions{TenantSource: "header", TenantHeader: "X-Hegel-Tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := NewRouter(prometheus.NewRegistry(), logr.Discard(), nil, tenant.Middleware(source))
+	ec2.New(tenantscope.New(client)).Configure(router)
+
+	cases := []struct {
+		Name         string
+		Tenant       string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		{Name: "TenantA", Tenant: "tenant-a", ExpectStatus: http.StatusOK, ExpectBody: "a"},
+		{Name: "TenantB", Tenant: "tenant-b", ExpectStatus: http.StatusOK, ExpectBody: "b"},
+		{Name: "OtherTenant", Tenant: "tenant-c", ExpectStatus: http.StatusNotFound},
+		{Name: "NoTenant", ExpectStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/instance-id", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			if tc.Tenant != "" {
+				r.Header.Set("X-Hegel-Tenant", tc.Tenant)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+			if tc.ExpectBody != "" && w.Body.String() != tc.ExpectBody {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNativeMetadataRejectedWithTenantSource(t *testing.T) {
+	cmd, err := NewRootCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"native-metadata": "true",
+		"tenant-source":   "static",
+		"tenant":          "tenant-a",
+	} {
+		if err := cmd.Flags().Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cmd.PreRun(nil, nil); err == nil {
+		t.Fatal("Expected error; Received: nil")
+	}
+}
@@ -0,0 +1,82 @@
+package cmd_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/cmd"
+)
+
+func TestLoadUserDataSigningKey(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey := func(t *testing.T, key any) string {
+		t.Helper()
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("Ed25519", func(t *testing.T) {
+		key, err := LoadUserDataSigningKey(writeKey(t, edKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !key.Equal(edKey) {
+			t.Fatal("Loaded key doesn't match")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		key, err := LoadUserDataSigningKey("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != nil {
+			t.Fatalf("Expected nil key; Received: %v", key)
+		}
+	})
+
+	t.Run("NotEd25519", func(t *testing.T) {
+		if _, err := LoadUserDataSigningKey(writeKey(t, ecKey)); err == nil {
+			t.Fatal("Expected error for ECDSA key")
+		}
+	})
+
+	t.Run("NotPEM", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(path, []byte("invalid"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadUserDataSigningKey(path); err == nil {
+			t.Fatal("Expected error for non-PEM file")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		if _, err := LoadUserDataSigningKey(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+			t.Fatal("Expected error for missing file")
+		}
+	})
+}
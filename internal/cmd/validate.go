@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/tinkerbell/hegel/internal/backend/kubernetes"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	tinkv1 "github.com/tinkerbell/tink/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const validateLongHelp = `
+Validate the data served for a sample Hardware resource.
+
+Every EC2 metadata endpoint is evaluated against the Hardware resource, a YAML or JSON document,
+and the result for each endpoint is printed. Endpoints that return no data are reported as empty.
+The command fails if any endpoint errors.
+`
+
+// NewValidateCommand creates a command that evaluates every EC2 metadata endpoint against a
+// sample Hardware resource.
+func NewValidateCommand() *cobra.Command {
+	var hardwarePath string
+
+	cmd := &cobra.Command{
+		Use:          "validate",
+		Short:        "Validate the data served for a sample Hardware resource",
+		Long:         validateLongHelp,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			hw, err := readHardware(hardwarePath)
+			if err != nil {
+				return err
+			}
+
+			return validate(cmd.OutOrStdout(), kubernetes.ToEC2Instance(hw))
+		},
+	}
+
+	cmd.Flags().StringVar(&hardwarePath, "hardware", "", "Path to a Hardware resource YAML or JSON document")
+	if err := cmd.MarkFlagRequired("hardware"); err != nil {
+		// The flag is defined above so this can only happen if the flag name is mistyped.
+		panic(err)
+	}
+
+	return cmd
+}
+
+func readHardware(path string) (tinkv1.Hardware, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return tinkv1.Hardware{}, err
+	}
+	defer f.Close()
+
+	var hw tinkv1.Hardware
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&hw); err != nil {
+		return tinkv1.Hardware{}, fmt.Errorf("decode hardware %v: %w", path, err)
+	}
+
+	return hw, nil
+}
+
+// validate writes a table of endpoint results for instance to w. It returns an error if any
+// endpoint errored.
+func validate(w io.Writer, instance ec2.Instance) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENDPOINT\tRESULT")
+
+	var failed int
+	for _, r := range ec2.Check(instance) {
+		var result string
+		switch {
+		case r.Err != nil:
+			failed++
+			result = fmt.Sprintf("error: %v", r.Err)
+		case r.Empty():
+			result = "<empty>"
+		default:
+			result = fmt.Sprintf("%q", r.Value)
+		}
+		fmt.Fprintf(tw, "%v\t%v\n", r.Endpoint, result)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d endpoint(s) failed", failed)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package cmd_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/cmd"
+)
+
+func TestValidateCommand(t *testing.T) {
+	var out bytes.Buffer
+
+	cmd := NewValidateCommand()
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"--hardware", "testdata/hardware.yaml"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Unexpected error: %v\n%s", err, out.String())
+	}
+
+	rows := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		endpoint := strings.Fields(line)[0]
+		rows[endpoint] = strings.TrimSpace(strings.TrimPrefix(line, endpoint))
+	}
+
+	expect := map[string]string{
+		"/meta-data/instance-id":               `"3c:ec:ef:4c:4f:54"`,
+		"/meta-data/hostname":                  `"sm01"`,
+		"/meta-data/local-ipv4":                `"172.16.10.100"`,
+		"/meta-data/plan":                      `"c2.medium.x86"`,
+		"/meta-data/tags":                      `"rack-1"`,
+		"/meta-data/public-keys/0/openssh-key": `"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIDa2XwCAb9uy7mQO6UrJi3t3TG8Qs3rMCpRZ4lDIfO7H"`,
+		"/meta-data/public-ipv4":               "<empty>",
+		"/user-data":                           `"#cloud-config\npackages:\n  - curl\n"`,
+	}
+
+	for endpoint, result := range expect {
+		if rows[endpoint] != result {
+			t.Errorf("%v: Expected: %v; Received: %v", endpoint, result, rows[endpoint])
+		}
+	}
+}
+
+func TestValidateCommandMissingHardware(t *testing.T) {
+	var out bytes.Buffer
+
+	cmd := NewValidateCommand()
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs([]string{"--hardware", "testdata/missing.yaml"})
+
+	if err := cmd.Execute(); err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
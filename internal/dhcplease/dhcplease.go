@@ -0,0 +1,328 @@
+/*
+Package dhcplease resolves the MAC address of the network interface an IP is leased to from a DHCP
+server's lease database. It supports deployments where the authoritative mapping of IPs to
+hardware lives in the DHCP server rather than in hardware records.
+*/
+package dhcplease
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Source is a DHCP lease database.
+type Source interface {
+	// Lookup returns the MAC address of the network interface ip is leased to. If ip has no
+	// active lease it returns false. Zones, such as the eth0 of fe80::1%eth0, are ignored.
+	Lookup(ip netip.Addr) (net.HardwareAddr, bool)
+}
+
+// Format is the format of a lease file.
+type Format string
+
+const (
+	// FormatDnsmasq is the lease file written by dnsmasq, one lease per line.
+	FormatDnsmasq Format = "dnsmasq"
+
+	// FormatISC is the dhcpd.leases file written by the ISC DHCP server.
+	FormatISC Format = "isc"
+)
+
+// FormatNames returns the name of every Format.
+func FormatNames() []string {
+	return []string{string(FormatDnsmasq), string(FormatISC)}
+}
+
+// lease is an IP leased to the interface identified by mac. A zero expires never expires.
+type lease struct {
+	mac     net.HardwareAddr
+	expires time.Time
+}
+
+// parseFunc parses a lease file into leases keyed by IP. Malformed leases are reported to skip,
+// with the line they're on, and omitted rather than failing the file.
+type parseFunc func(r io.Reader, skip func(line int, err error)) (map[netip.Addr]lease, error)
+
+// File is a Source backed by a lease file. The file is read again each refresh interval if its
+// modification time has changed so leases granted while serving are observed. If the file can't be
+// read, for example because it's being rewritten, the leases last read are used until it can.
+type File struct {
+	path   string
+	parse  parseFunc
+	logger logr.Logger
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	modTime time.Time
+	leases  map[netip.Addr]lease
+}
+
+// NewFile creates a File reading the lease file at path written in format. The file is read to
+// ensure it can be, then read again every interval until ctx is done. Malformed leases, and
+// failures to read the file again, are logged to logger.
+func NewFile(ctx context.Context, logger logr.Logger, path string, format Format, interval time.Duration) (*File, error) {
+	var parse parseFunc
+	switch format {
+	case FormatDnsmasq:
+		parse = parseDnsmasq
+	case FormatISC:
+		parse = parseISC
+	default:
+		return nil, fmt.Errorf("unknown lease file format: %q; options: %v", format, strings.Join(FormatNames(), ", "))
+	}
+
+	if interval <= 0 {
+		return nil, fmt.Errorf("refresh interval must be positive, got %v", interval)
+	}
+
+	f := &File{path: path, parse: parse, logger: logger, now: time.Now}
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+
+	go f.watch(ctx, interval)
+
+	return f, nil
+}
+
+// Lookup satisfies Source.
+func (f *File) Lookup(ip netip.Addr) (net.HardwareAddr, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	l, ok := f.leases[ip.WithZone("").Unmap()]
+	if !ok || (!l.expires.IsZero() && !f.now().Before(l.expires)) {
+		return nil, false
+	}
+
+	return l.mac, true
+}
+
+// watch refreshes f every interval until ctx is done.
+func (f *File) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// The leases last read are used until the file can be read again.
+			if err := f.refresh(); err != nil {
+				f.logger.Error(err, "Unable to read lease file", "path", f.path)
+			}
+		}
+	}
+}
+
+// refresh reads the lease file if it has changed since it was last read. Only one refresh may run
+// at a time; lookups may run concurrently with it.
+func (f *File) refresh() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if f.leases != nil && info.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	leases, err := f.parse(file, func(line int, err error) {
+		f.logger.Error(err, "Skipped malformed lease", "path", f.path, "line", line)
+	})
+	if err != nil {
+		return fmt.Errorf("%v: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.leases = leases
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+// parseIP parses a leased IP. Zones are discarded as lookups ignore them.
+func parseIP(s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid ip: %q", s)
+	}
+	return ip.WithZone("").Unmap(), nil
+}
+
+// parseDnsmasq parses a dnsmasq lease file. Each line is a lease of the form
+// "<expiry> <mac> <ip> <hostname> <client-id>" where expiry is a Unix time, or 0 for leases that
+// never expire. DHCPv6 leases, whose second field is an IAID rather than a MAC, are skipped.
+func parseDnsmasq(r io.Reader, skip func(int, error)) (map[netip.Addr]lease, error) {
+	leases := map[netip.Addr]lease{}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) < 3 {
+			skip(n, errors.New("expected <expiry> <mac> <ip>"))
+			continue
+		}
+
+		mac, err := net.ParseMAC(fields[1])
+		if err != nil {
+			continue
+		}
+
+		ip, err := parseIP(fields[2])
+		if err != nil {
+			skip(n, err)
+			continue
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			skip(n, fmt.Errorf("invalid expiry: %q", fields[0]))
+			continue
+		}
+
+		l := lease{mac: mac}
+		if expiry != 0 {
+			l.expires = time.Unix(expiry, 0)
+		}
+		leases[ip] = l
+	}
+
+	return leases, scanner.Err()
+}
+
+// parseISC parses an ISC DHCP server dhcpd.leases file. The file is a log so later declarations of
+// a lease replace earlier ones. Leases whose binding state isn't active are released. Only the
+// statements of lease declarations needed to resolve leases are interpreted; others are skipped.
+// Malformed declarations are skipped so the lease they declare keeps its previous state.
+func parseISC(r io.Reader, skip func(int, error)) (map[netip.Addr]lease, error) {
+	leases := map[netip.Addr]lease{}
+
+	var (
+		ip     netip.Addr
+		l      lease
+		active bool
+		depth  int
+	)
+
+	// invalid discards the declaration being parsed reporting err on line n.
+	invalid := func(n int, err error) {
+		skip(n, err)
+		ip = netip.Addr{}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+
+		switch {
+		case strings.HasSuffix(line, "{"):
+			depth++
+			if depth == 1 && fields[0] == "lease" && len(fields) == 3 {
+				parsed, err := parseIP(fields[1])
+				if err != nil {
+					skip(n, err)
+					continue
+				}
+				ip, l, active = parsed, lease{}, true
+			}
+
+		case line == "}":
+			if depth == 0 {
+				return nil, fmt.Errorf("line %d: unexpected }", n)
+			}
+			depth--
+			if depth == 0 && ip.IsValid() {
+				if active && l.mac != nil {
+					leases[ip] = l
+				} else {
+					delete(leases, ip)
+				}
+				ip = netip.Addr{}
+			}
+
+		case !ip.IsValid() || depth != 1:
+			// Statements outside lease declarations aren't needed.
+
+		case fields[0] == "hardware" && len(fields) == 3:
+			mac, err := net.ParseMAC(fields[2])
+			if err != nil {
+				invalid(n, fmt.Errorf("invalid mac: %q", fields[2]))
+				continue
+			}
+			l.mac = mac
+
+		case fields[0] == "binding" && len(fields) == 3 && fields[1] == "state":
+			active = fields[2] == "active"
+
+		case fields[0] == "ends":
+			expires, err := parseISCTime(fields[1:])
+			if err != nil {
+				invalid(n, err)
+				continue
+			}
+			l.expires = expires
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unterminated declaration")
+	}
+
+	return leases, scanner.Err()
+}
+
+// parseISCTime parses the fields of an ISC DHCP server date following a statement such as ends.
+// Dates are of the form "<weekday> <yyyy/mm/dd> <hh:mm:ss>" in UTC, "epoch <seconds>" or "never",
+// which is returned as the zero time.
+func parseISCTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+
+	case len(fields) == 2 && fields[0] == "epoch":
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch: %q", fields[1])
+		}
+		return time.Unix(seconds, 0), nil
+
+	case len(fields) == 3:
+		t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date: %q", strings.Join(fields, " "))
+		}
+		return t, nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %q", strings.Join(fields, " "))
+}
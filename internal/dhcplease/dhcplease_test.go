@@ -0,0 +1,288 @@
+package dhcplease_test
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	. "github.com/tinkerbell/hegel/internal/dhcplease"
+)
+
+const dnsmasqLeases = `duid 00:01:00:01:2c:4f:5a:3b:52:54:00:12:34:56
+0 3c:ec:ef:4c:4f:54 10.10.10.10 sm01 01:3c:ec:ef:4c:4f:54
+4102444800 3c:ec:ef:4c:4f:55 10.10.10.11 sm02 *
+946684800 3c:ec:ef:4c:4f:56 10.10.10.12 sm03 *
+4102444800 1234 fd00::10 sm04 00:01:00:01:2c:4f:5a:3b:52:54:00:12:34:56
+0 3c:ec:ef:4c:4f:58 fe80::1 sm05 *
+`
+
+const iscLeases = `# The format of this file is documented in the dhcpd.leases(5) manual page.
+authoring-byte-order little-endian;
+
+lease 10.10.10.10 {
+  starts 4 2026/10/15 10:00:00;
+  ends never;
+  binding state active;
+  hardware ethernet 3c:ec:ef:4c:4f:50;
+}
+lease 10.10.10.10 {
+  starts 4 2026/10/15 11:00:00;
+  ends never;
+  binding state active;
+  hardware ethernet 3c:ec:ef:4c:4f:54;
+  client-hostname "sm01";
+}
+lease 10.10.10.11 {
+  starts 4 2026/10/15 10:00:00;
+  ends 5 2100/01/01 00:00:00;
+  hardware ethernet 3c:ec:ef:4c:4f:55;
+}
+lease 10.10.10.12 {
+  starts 4 2000/01/01 00:00:00;
+  ends epoch 946684800; # Sat Jan 01 00:00:00 2000
+  binding state active;
+  hardware ethernet 3c:ec:ef:4c:4f:56;
+}
+lease 10.10.10.13 {
+  starts 4 2026/10/15 10:00:00;
+  ends never;
+  binding state active;
+  hardware ethernet 3c:ec:ef:4c:4f:57;
+}
+lease 10.10.10.13 {
+  starts 4 2026/10/15 11:00:00;
+  ends never;
+  binding state free;
+  hardware ethernet 3c:ec:ef:4c:4f:57;
+}
+failover peer "peer" state {
+  my state normal at 4 2026/10/15 10:00:00;
+}
+`
+
+func TestFile(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Format Format
+		Leases string
+		// Expect maps IPs to the MAC they're expected to resolve to. IPs mapped to an empty
+		// string shouldn't resolve.
+		Expect map[string]string
+	}{
+		{
+			Name:   "Dnsmasq",
+			Format: FormatDnsmasq,
+			Leases: dnsmasqLeases,
+			Expect: map[string]string{
+				"10.10.10.10":  "3c:ec:ef:4c:4f:54",
+				"10.10.10.11":  "3c:ec:ef:4c:4f:55",
+				"10.10.10.12":  "",                  // Expired.
+				"fd00::10":     "",                  // DHCPv6 leases aren't identified by MAC.
+				"fe80::1%eth0": "3c:ec:ef:4c:4f:58", // Zones are ignored.
+				"10.10.10.99":  "",
+			},
+		},
+		{
+			Name:   "ISC",
+			Format: FormatISC,
+			Leases: iscLeases,
+			Expect: map[string]string{
+				"10.10.10.10": "3c:ec:ef:4c:4f:54", // The latest declaration wins.
+				"10.10.10.11": "3c:ec:ef:4c:4f:55",
+				"10.10.10.12": "", // Expired.
+				"10.10.10.13": "", // Released.
+				"10.10.10.99": "",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := writeLeases(t, filepath.Join(t.TempDir(), "leases"), tc.Leases)
+
+			f, err := NewFile(context.Background(), logr.Discard(), path, tc.Format, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for ip, expect := range tc.Expect {
+				expectLease(t, f, ip, expect)
+			}
+		})
+	}
+}
+
+func TestFileReread(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := writeLeases(t, filepath.Join(t.TempDir(), "leases"), "0 3c:ec:ef:4c:4f:54 10.10.10.10 sm01 *\n")
+
+	f, err := NewFile(ctx, logr.Discard(), path, FormatDnsmasq, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLease(t, f, "10.10.10.10", "3c:ec:ef:4c:4f:54")
+
+	// The IP is leased to another interface.
+	writeLeases(t, path, "0 3c:ec:ef:4c:4f:55 10.10.10.10 sm02 *\n")
+	touch(t, path, time.Now().Add(time.Minute))
+	eventuallyLease(t, f, "10.10.10.10", "3c:ec:ef:4c:4f:55")
+
+	// Files that can't be read retain the leases last read.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	expectLease(t, f, "10.10.10.10", "3c:ec:ef:4c:4f:55")
+}
+
+func TestFileSkipsMalformedLeases(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Format Format
+		Leases string
+		// Expect maps IPs to the MAC they're expected to resolve to. IPs mapped to an empty
+		// string shouldn't resolve.
+		Expect        map[string]string
+		ExpectSkipped int
+	}{
+		{
+			Name:   "Dnsmasq",
+			Format: FormatDnsmasq,
+			Leases: "0 3c:ec:ef:4c:4f:54\n" +
+				"0 3c:ec:ef:4c:4f:55 invalid\n" +
+				"soon 3c:ec:ef:4c:4f:56 10.10.10.12\n" +
+				"0 3c:ec:ef:4c:4f:57 10.10.10.13 sm04 *\n",
+			Expect: map[string]string{
+				"10.10.10.12": "",
+				"10.10.10.13": "3c:ec:ef:4c:4f:57",
+			},
+			ExpectSkipped: 3,
+		},
+		{
+			Name:   "ISC",
+			Format: FormatISC,
+			Leases: "lease invalid {\n  hardware ethernet 3c:ec:ef:4c:4f:54;\n}\n" +
+				"lease 10.10.10.11 {\n  hardware ethernet invalid;\n}\n" +
+				"lease 10.10.10.12 {\n  ends never;\n  hardware ethernet 3c:ec:ef:4c:4f:56;\n}\n" +
+				"lease 10.10.10.12 {\n  ends tomorrow;\n  binding state free;\n}\n" +
+				"lease 10.10.10.13 {\n  hardware ethernet 3c:ec:ef:4c:4f:57;\n}\n",
+			Expect: map[string]string{
+				"10.10.10.11": "",
+				// Malformed declarations don't replace earlier ones.
+				"10.10.10.12": "3c:ec:ef:4c:4f:56",
+				"10.10.10.13": "3c:ec:ef:4c:4f:57",
+			},
+			ExpectSkipped: 3,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var logged []string
+			logger := funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})
+
+			path := writeLeases(t, filepath.Join(t.TempDir(), "leases"), tc.Leases)
+
+			f, err := NewFile(context.Background(), logger, path, tc.Format, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for ip, expect := range tc.Expect {
+				expectLease(t, f, ip, expect)
+			}
+
+			if len(logged) != tc.ExpectSkipped {
+				t.Fatalf("Expected %v skipped leases logged; Received: %v", tc.ExpectSkipped, strings.Join(logged, "\n"))
+			}
+		})
+	}
+}
+
+func TestNewFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	cases := []struct {
+		Name     string
+		Format   Format
+		Leases   string
+		Interval time.Duration
+	}{
+		{Name: "UnknownFormat", Format: "unknown", Leases: dnsmasqLeases, Interval: time.Hour},
+		{Name: "NonPositiveInterval", Format: FormatDnsmasq, Leases: dnsmasqLeases},
+		{Name: "ISCUnexpectedBrace", Format: FormatISC, Leases: "}\n", Interval: time.Hour},
+		{Name: "ISCUnterminated", Format: FormatISC, Leases: "lease 10.10.10.10 {\n", Interval: time.Hour},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := writeLeases(t, filepath.Join(dir, tc.Name), tc.Leases)
+
+			if _, err := NewFile(context.Background(), logr.Discard(), path, tc.Format, tc.Interval); err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+		})
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := NewFile(context.Background(), logr.Discard(), filepath.Join(dir, "missing"), FormatDnsmasq, time.Hour)
+		if err == nil {
+			t.Fatal("Expected error; Received: nil")
+		}
+	})
+}
+
+func expectLease(t *testing.T, s Source, ip, expect string) {
+	t.Helper()
+
+	mac, ok := s.Lookup(netip.MustParseAddr(ip))
+	if expect == "" {
+		if ok {
+			t.Fatalf("%v: Expected no lease; Received: %v", ip, mac)
+		}
+		return
+	}
+
+	if !ok || mac.String() != expect {
+		t.Fatalf("%v: Expected: %v; Received: %v", ip, expect, mac)
+	}
+}
+
+// eventuallyLease waits for ip to resolve to expect as the lease file is read again.
+func eventuallyLease(t *testing.T, s Source, ip, expect string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if mac, ok := s.Lookup(netip.MustParseAddr(ip)); ok && mac.String() == expect {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	expectLease(t, s, ip, expect)
+}
+
+func writeLeases(t *testing.T, path, leases string) string {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(leases), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// touch sets the modification time of path so rewrites within the file system's timestamp
+// granularity are observed.
+func touch(t *testing.T, path string, mtime time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
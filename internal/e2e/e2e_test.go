@@ -51,7 +51,8 @@ func TestHegel(t *testing.T) {
 				Name:     "StaticRoute",
 				Endpoint: "/2009-04-04",
 				Expect: `meta-data/
-user-data`,
+user-data
+vendor-data`,
 			},
 			{
 				Name:     "DynamicRoute",
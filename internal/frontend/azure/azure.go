@@ -0,0 +1,169 @@
+/*
+Package azure contains a frontend that provides an Azure Instance Metadata Service (IMDS)
+compatible /metadata/instance endpoint for images that expect to run on Azure.
+
+	https://learn.microsoft.com/en-us/azure/virtual-machines/instance-metadata-service
+
+Only the subset of the compute and network categories that can be derived from an instance is
+served.
+*/
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+// metricsHandler is the handler label used when recording errors.
+const metricsHandler = "azure"
+
+// APIVersions are the supported values of the api-version query parameter, newest first. They
+// share the same response shape.
+var APIVersions = []string{"2021-08-01", "2021-02-01", "2019-06-01"}
+
+// Response is the Azure IMDS instance document.
+type Response struct {
+	Compute Compute `json:"compute"`
+	Network Network `json:"network"`
+}
+
+// Compute is the compute category of the instance document.
+type Compute struct {
+	AzEnvironment string      `json:"azEnvironment"`
+	Location      string      `json:"location"`
+	Name          string      `json:"name"`
+	OSType        string      `json:"osType"`
+	VMID          string      `json:"vmId"`
+	VMSize        string      `json:"vmSize"`
+	Tags          string      `json:"tags"`
+	PublicKeys    []PublicKey `json:"publicKeys"`
+	OSProfile     OSProfile   `json:"osProfile"`
+}
+
+// PublicKey is an SSH public key.
+type PublicKey struct {
+	KeyData string `json:"keyData"`
+}
+
+// OSProfile describes the operating system configuration.
+type OSProfile struct {
+	ComputerName string `json:"computerName"`
+}
+
+// Network is the network category of the instance document.
+type Network struct {
+	Interface []Interface `json:"interface"`
+}
+
+// Interface is a network interface.
+type Interface struct {
+	IPv4 IPAddresses `json:"ipv4"`
+	IPv6 IPAddresses `json:"ipv6"`
+}
+
+// IPAddresses are the addresses of an interface for an IP family.
+type IPAddresses struct {
+	IPAddress []IPAddress `json:"ipAddress"`
+}
+
+// IPAddress is a private address and its associated public address.
+type IPAddress struct {
+	PrivateIPAddress string `json:"privateIpAddress"`
+	PublicIPAddress  string `json:"publicIpAddress"`
+}
+
+// Configure configures router with a /metadata/instance endpoint serving the instances retrieved by
+// fe so they're consistent with the EC2 endpoints. Like Azure, requests must include a
+// "Metadata: true" header and a supported api-version query parameter.
+func Configure(router gin.IRouter, fe ec2.Frontend) {
+	router.GET("/metadata/instance", func(ctx *gin.Context) {
+		if ctx.GetHeader("Metadata") != "true" {
+			abort(ctx, http.StatusBadRequest, "request", errors.New("missing metadata header"), "Bad request. Required metadata header not specified")
+			return
+		}
+
+		if !isSupportedVersion(ctx.Query("api-version")) {
+			_ = ctx.Error(errors.New("unsupported api-version")).SetMeta(metrics.ErrorLabels{
+				Handler: metricsHandler,
+				Kind:    "request",
+			})
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error":           "Bad request. api-version is invalid or was not specified in the request.",
+				"newest-versions": APIVersions,
+			})
+			return
+		}
+
+		instance, err := fe.Instance(ctx.Request.Context(), ctx.Request)
+		if err != nil {
+			status, kind := ec2.ErrorStatus(err)
+			abort(ctx, status, kind, err, http.StatusText(status))
+			return
+		}
+
+		ctx.JSON(http.StatusOK, toResponse(instance))
+	})
+}
+
+func isSupportedVersion(v string) bool {
+	for _, supported := range APIVersions {
+		if v == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// toResponse maps i to the Azure instance document.
+func toResponse(i ec2.Instance) Response {
+	keys := []PublicKey{}
+	for _, k := range i.Metadata.PublicKeys {
+		keys = append(keys, PublicKey{KeyData: k})
+	}
+
+	iface := Interface{
+		IPv4: IPAddresses{IPAddress: []IPAddress{}},
+		IPv6: IPAddresses{IPAddress: []IPAddress{}},
+	}
+	if i.Metadata.LocalIPv4 != "" || i.Metadata.PublicIPv4 != "" {
+		iface.IPv4.IPAddress = append(iface.IPv4.IPAddress, IPAddress{
+			PrivateIPAddress: i.Metadata.LocalIPv4,
+			PublicIPAddress:  i.Metadata.PublicIPv4,
+		})
+	}
+	if i.Metadata.PublicIPv6 != "" {
+		iface.IPv6.IPAddress = append(iface.IPv6.IPAddress, IPAddress{
+			PublicIPAddress: i.Metadata.PublicIPv6,
+		})
+	}
+
+	return Response{
+		Compute: Compute{
+			AzEnvironment: "AzurePublicCloud",
+			Location:      i.Metadata.Facility,
+			Name:          i.Metadata.Hostname,
+			OSType:        "Linux",
+			VMID:          i.Metadata.InstanceID,
+			VMSize:        i.Metadata.Plan,
+			Tags:          strings.Join(i.Metadata.Tags, ";"),
+			PublicKeys:    keys,
+			OSProfile:     OSProfile{ComputerName: i.Metadata.Hostname},
+		},
+		Network: Network{Interface: []Interface{iface}},
+	}
+}
+
+// abort aborts the request with status and an Azure style JSON error body containing msg. err is
+// recorded on ctx for logging and metrics.
+func abort(ctx *gin.Context, status int, kind string, err error, msg string) {
+	_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    kind,
+	})
+	ctx.AbortWithStatusJSON(status, gin.H{"error": msg})
+}
@@ -0,0 +1,147 @@
+package azure_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	. "github.com/tinkerbell/hegel/internal/frontend/azure"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+type fakeClient map[string]ec2.Instance
+
+func (c fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	instance, ok := c[ip]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func (c fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return ec2.Instance{}, ec2.ErrInstanceNotFound
+}
+
+func TestInstance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	instance := ec2.Instance{Metadata: ec2.Metadata{
+		InstanceID: "instance-id",
+		Hostname:   "worker-1",
+		Plan:       "c3.small.x86",
+		Facility:   "sv15",
+		Tags:       []string{"tag1", "tag2"},
+		PublicKeys: []string{"ssh-ed25519 AAAA"},
+		LocalIPv4:  "10.10.10.10",
+		PublicIPv4: "1.1.1.1",
+		PublicIPv6: "2001:db8::1",
+	}}
+
+	router := gin.New()
+	Configure(router, ec2.New(fakeClient{"10.10.10.10": instance}))
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metadata/instance?api-version=2021-02-01", nil)
+	r.Header.Set("Metadata", "true")
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+
+	// Decode generically so we validate the JSON field names rather than the Go types.
+	var received map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &received); err != nil {
+		t.Fatal(err)
+	}
+
+	var expect map[string]any
+	err := json.Unmarshal([]byte(`{
+		"compute": {
+			"azEnvironment": "AzurePublicCloud",
+			"location": "sv15",
+			"name": "worker-1",
+			"osType": "Linux",
+			"vmId": "instance-id",
+			"vmSize": "c3.small.x86",
+			"tags": "tag1;tag2",
+			"publicKeys": [{"keyData": "ssh-ed25519 AAAA"}],
+			"osProfile": {"computerName": "worker-1"}
+		},
+		"network": {
+			"interface": [{
+				"ipv4": {"ipAddress": [{"privateIpAddress": "10.10.10.10", "publicIpAddress": "1.1.1.1"}]},
+				"ipv6": {"ipAddress": [{"privateIpAddress": "", "publicIpAddress": "2001:db8::1"}]}
+			}]
+		}
+	}`), &expect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(received, expect) {
+		t.Fatal(cmp.Diff(expect, received))
+	}
+}
+
+func TestInstanceBadRequest(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Header string
+		Path   string
+	}{
+		{
+			Name: "MissingMetadataHeader",
+			Path: "/metadata/instance?api-version=2021-02-01",
+		},
+		{
+			Name:   "FalseMetadataHeader",
+			Header: "false",
+			Path:   "/metadata/instance?api-version=2021-02-01",
+		},
+		{
+			Name:   "MissingAPIVersion",
+			Header: "true",
+			Path:   "/metadata/instance",
+		},
+		{
+			Name:   "UnsupportedAPIVersion",
+			Header: "true",
+			Path:   "/metadata/instance?api-version=2000-01-01",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+
+			router := gin.New()
+			Configure(router, ec2.New(fakeClient{"10.10.10.10": {}}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.Path, nil)
+			if tc.Header != "" {
+				r.Header.Set("Metadata", tc.Header)
+			}
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status: 400; Received status: %d", w.Code)
+			}
+
+			var body struct{ Error string }
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error == "" {
+				t.Fatalf("Expected JSON error body; Received: %q", w.Body.String())
+			}
+		})
+	}
+}
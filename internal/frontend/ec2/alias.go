@@ -0,0 +1,65 @@
+package ec2
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidatePathAliases ensures aliases, as accepted by WithPathAliases, can be served. Every alias
+// must be an absolute path, excluding the API version prefix, that isn't already served, including
+// by endpoints with named parameters and directories. Every canonical endpoint must be a data
+// endpoint without named parameters.
+func ValidatePathAliases(aliases map[string]string) error {
+	canonical := map[string]bool{}
+	for _, r := range dataRoutes {
+		canonical[r.Endpoint] = true
+	}
+
+	for alias, endpoint := range aliases {
+		if !strings.HasPrefix(alias, "/") || strings.ContainsAny(alias, ":*") || strings.HasSuffix(alias, "/") {
+			return fmt.Errorf("invalid alias: %q", alias)
+		}
+		if served(alias) {
+			return fmt.Errorf("alias %q is already served", alias)
+		}
+		if !canonical[endpoint] {
+			return fmt.Errorf("alias %q: unknown endpoint %q", alias, endpoint)
+		}
+	}
+
+	return nil
+}
+
+// served returns true if path, excluding the API version prefix, is served by an endpoint or is a
+// directory of endpoints. Named parameters match any path segment.
+func served(path string) bool {
+	endpoints := []string{userDataSignatureEndpoint}
+	for _, r := range dataRoutes {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+	for _, r := range paramRoutes {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+
+	segments := strings.Split(path, "/")
+	for _, e := range endpoints {
+		candidate := strings.Split(e, "/")
+		if len(candidate) < len(segments) {
+			continue
+		}
+
+		// Compare against the endpoint or, if path is shorter, the directory containing it.
+		match := true
+		for i, s := range segments {
+			if candidate[i] != s && !strings.HasPrefix(candidate[i], ":") {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
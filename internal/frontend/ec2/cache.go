@@ -0,0 +1,54 @@
+package ec2
+
+import "strings"
+
+// Cache-Control header values served for data by how often it changes. Responses depend on the
+// instance making the request so they're only cacheable by the client, never shared caches.
+const (
+	// cacheStable is served for data that doesn't change for the lifetime of an instance.
+	cacheStable = "private, max-age=86400"
+
+	// cacheVolatile is served for data that changes at any time, such as spot termination
+	// notices, so stale data must not be served.
+	cacheVolatile = "no-store"
+)
+
+// cachePolicies maps endpoints, excluding the API version prefix, to the Cache-Control header
+// value they're served with. Policies of directories apply to the endpoints beneath them.
+// Endpoints without a policy are served without a Cache-Control header.
+var cachePolicies = map[string]string{
+	"/meta-data/instance-id": cacheStable,
+	"/meta-data/facility":    cacheStable,
+	"/meta-data/placement":   cacheStable,
+	"/meta-data/mac":         cacheStable,
+
+	"/meta-data/spot":            cacheVolatile,
+	"/meta-data/instance-action": cacheVolatile,
+	"/meta-data/events":          cacheVolatile,
+}
+
+// cacheControl returns the Cache-Control header value endpoint, excluding the API version prefix,
+// is served with. Directories containing volatile data are volatile as their listings and
+// recursive responses include it. If endpoint has no policy it returns an empty string.
+func cacheControl(endpoint string) string {
+	policy, depth := "", -1
+	for p, v := range cachePolicies {
+		if endpoint == p || strings.HasPrefix(endpoint, p+"/") {
+			// The policy of the nearest directory applies.
+			if d := strings.Count(p, "/"); d > depth {
+				policy, depth = v, d
+			}
+		}
+	}
+	if policy != "" {
+		return policy
+	}
+
+	for p, v := range cachePolicies {
+		if v == cacheVolatile && (endpoint == "" || strings.HasPrefix(p, endpoint+"/")) {
+			return cacheVolatile
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,99 @@
+package ec2
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CheckResult is the outcome of serving an endpoint for an instance.
+type CheckResult struct {
+	// Endpoint is the endpoint path relative to the API version prefix. For example,
+	// /meta-data/instance-id.
+	Endpoint string
+
+	// Value is the plain text rendering of the endpoint data. It is empty if Err is not nil.
+	Value string
+
+	// Err is the error produced by the endpoint filter, if any.
+	Err error
+}
+
+// Empty returns true if the endpoint produced no data and no error.
+func (r CheckResult) Empty() bool {
+	return r.Err == nil && r.Value == ""
+}
+
+// Check runs the filter for every data endpoint against i without serving HTTP requests. It is
+// intended for confirming a representative instance produces sensible data for each endpoint.
+// Endpoints with named parameters are checked for each of the instance's public keys, network
+// interfaces or block devices. Endpoints that don't exist for the instance, such as the spot data
+// of instances that aren't spot instances, are omitted. Results are sorted by endpoint.
+func Check(i Instance) []CheckResult {
+	var results []CheckResult
+
+	for _, r := range dataRoutes {
+		if !exists(r.Endpoint, i) {
+			continue
+		}
+		results = append(results, check(r.Endpoint, r.Filter.ignoreVars(), i, requestVars{}))
+	}
+
+	for _, r := range paramRoutes {
+		for _, p := range checkParamsFor(r.Endpoint, i) {
+			endpoint := r.Endpoint
+			for name, v := range p {
+				endpoint = strings.ReplaceAll(endpoint, ":"+name, v)
+			}
+			results = append(results, check(endpoint, r.Filter, i, requestVars{Path: endpoint, Params: p}))
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Endpoint < results[j].Endpoint
+	})
+
+	return results
+}
+
+// checkParamsFor returns the named parameters endpoint is checked with for i.
+func checkParamsFor(endpoint string, i Instance) []checkParams {
+	var params []checkParams
+	switch {
+	case strings.Contains(endpoint, ":index"):
+		for idx := range i.Metadata.PublicKeys {
+			params = append(params, checkParams{"index": strconv.Itoa(idx)})
+		}
+	case strings.Contains(endpoint, ":device"):
+		for _, name := range blockDeviceNames(i) {
+			params = append(params, checkParams{"device": name})
+		}
+	case strings.Contains(endpoint, ":mac"):
+		for _, iface := range i.Metadata.Interfaces {
+			params = append(params, checkParams{"mac": normalizeMAC(iface.MAC)})
+		}
+	}
+	return params
+}
+
+func check(endpoint string, filter requestFilterFunc, i Instance, v requestVars) CheckResult {
+	data, err := filter(i, v)
+	if err != nil {
+		return CheckResult{Endpoint: endpoint, Err: err}
+	}
+
+	var buf bytes.Buffer
+	if err := data.render(&buf, TextRenderer{}); err != nil {
+		return CheckResult{Endpoint: endpoint, Err: err}
+	}
+
+	return CheckResult{Endpoint: endpoint, Value: buf.String()}
+}
+
+// checkParams satisfies params for checking endpoints with named parameters.
+type checkParams map[string]string
+
+func (p checkParams) ByName(name string) string {
+	return p[name]
+}
@@ -0,0 +1,54 @@
+package ec2_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestCheck(t *testing.T) {
+	instance := Instance{
+		Userdata: "userdata",
+		Metadata: Metadata{
+			InstanceID: "instance-id",
+			Tags:       []string{"tag1", "tag2"},
+			PublicKeys: []string{"key1"},
+		},
+	}
+
+	restore := SetFilter("/meta-data/plan", func(Instance) (string, error) {
+		return "", errors.New("filter failed")
+	})
+	defer restore()
+
+	results := map[string]CheckResult{}
+	for _, r := range Check(instance) {
+		results[r.Endpoint] = r
+	}
+
+	expect := map[string]string{
+		"/user-data":                           "userdata",
+		"/meta-data/instance-id":               "instance-id",
+		"/meta-data/tags":                      "tag1\ntag2",
+		"/meta-data/public-keys/0":             "openssh-key",
+		"/meta-data/public-keys/0/openssh-key": "key1",
+	}
+	for endpoint, value := range expect {
+		r, ok := results[endpoint]
+		if !ok {
+			t.Fatalf("Missing result for %v", endpoint)
+		}
+		if r.Err != nil || r.Value != value {
+			t.Fatalf("%v: Expected: %q; Received: %q (%v)", endpoint, value, r.Value, r.Err)
+		}
+	}
+
+	if !results["/meta-data/hostname"].Empty() {
+		t.Fatalf("Expected /meta-data/hostname to be empty: %+v", results["/meta-data/hostname"])
+	}
+
+	if results["/meta-data/plan"].Err == nil {
+		t.Fatal("Expected /meta-data/plan to error")
+	}
+}
@@ -0,0 +1,56 @@
+package ec2
+
+import "time"
+
+// SetFilter replaces the filter for endpoint and returns a func that restores the original. It
+// exists so tests can exercise filter failures that the built-in filters never produce.
+func SetFilter(endpoint string, filter func(Instance) (string, error)) (restore func()) {
+	for i, r := range dataRoutes {
+		if r.Endpoint == endpoint {
+			original := r.Filter
+			dataRoutes[i].Filter = func(i Instance) (value, error) {
+				v, err := filter(i)
+				return scalar(v), err
+			}
+			return func() { dataRoutes[i].Filter = original }
+		}
+	}
+	panic("unknown endpoint: " + endpoint)
+}
+
+// AddRequestRoute adds an endpoint served by filter, which receives the requesting client IP and
+// path, and returns a func that removes it. The endpoint must be added before configuring a router.
+func AddRequestRoute(endpoint string, filter func(i Instance, clientIP, path string) (string, error)) (remove func()) {
+	original := paramRoutes
+	paramRoutes = append(paramRoutes[:len(paramRoutes):len(paramRoutes)], struct {
+		Endpoint string
+		Filter   requestFilterFunc
+	}{
+		Endpoint: endpoint,
+		Filter: func(i Instance, v requestVars) (value, error) {
+			s, err := filter(i, v.ClientIP, v.Path)
+			return scalar(s), err
+		},
+	})
+	return func() { paramRoutes = original }
+}
+
+// Endpoints returns the endpoint of every data and parameterized route.
+func Endpoints() []string {
+	var endpoints []string
+	for _, r := range dataRoutes {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+	for _, r := range paramRoutes {
+		endpoints = append(endpoints, r.Endpoint)
+	}
+	return endpoints
+}
+
+// SetUpstreamResponseHeaderTimeout configures the response header timeout of upstreams created
+// after it's called.
+func SetUpstreamResponseHeaderTimeout(timeout time.Duration) (restore func()) {
+	original := upstreamResponseHeaderTimeout
+	upstreamResponseHeaderTimeout = timeout
+	return func() { upstreamResponseHeaderTimeout = original }
+}
@@ -2,65 +2,585 @@ package ec2
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/tinkerbell/hegel/internal/frontend/ec2/internal/staticroute"
 	"github.com/tinkerbell/hegel/internal/ginutil"
 	"github.com/tinkerbell/hegel/internal/http/httperror"
 	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/identity"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// metricsHandler is the handler label used when recording errors for the data endpoints.
+const metricsHandler = "metadata"
+
+// APIVersionPrefix is the path prefix of the supported AWS EC2 instance metadata API version.
+const APIVersionPrefix = "/2009-04-04"
+
+// LatestAPIVersionPrefix is the path prefix AWS serves its latest instance metadata API version
+// at. Hegel serves the supported API version beneath it too so clients that always request the
+// latest version work unchanged.
+const LatestAPIVersionPrefix = "/latest"
+
+// Default services data served for instances when no services data is configured. They are the
+// values of the AWS commercial partition.
+const (
+	DefaultServicesDomain    = "amazonaws.com"
+	DefaultServicesPartition = "aws"
+)
+
+// statusClientClosedRequest is the non-standard status, popularised by nginx, recorded for
+// requests whose client disconnected before a response was written.
+const statusClientClosedRequest = 499
+
+// tracerName is the name of the OpenTelemetry tracer used to trace data endpoint requests.
+const tracerName = "github.com/tinkerbell/hegel/internal/frontend/ec2"
+
 // ErrInstanceNotFound indicates an instance could not be found for the given identifier.
 var ErrInstanceNotFound = errors.New("instance not found")
 
+// ErrBackendNotReady indicates the backend can't yet serve lookups, for example because its cache
+// hasn't synced. Unlike ErrInstanceNotFound, clients should retry the request.
+var ErrBackendNotReady = errors.New("backend not ready")
+
+// ErrLookupUnsupported indicates the backend can't look up instances by the requested identifier,
+// for example because it doesn't implement IDClient.
+var ErrLookupUnsupported = errors.New("lookup not supported by the backend")
+
+// ErrorStatus returns the HTTP status code, and the kind used to classify it in metrics, of err
+// returned when retrieving an instance so frontends sharing the EC2 backends respond to failed
+// lookups consistently. Errors carrying a status code use it, unknown instances are not found,
+// backends that aren't ready are unavailable so clients retry and lookups the backend doesn't
+// support aren't implemented. Other errors are internal errors.
+func ErrorStatus(err error) (status int, kind string) {
+	var httpErr *httperror.E
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.StatusCode
+	case errors.Is(err, ErrInstanceNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrBackendNotReady):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, ErrLookupUnsupported):
+		status = http.StatusNotImplemented
+	default:
+		status = http.StatusInternalServerError
+	}
+	return status, statusErrorKind(status)
+}
+
+// retryAfterSeconds is the Retry-After header value sent with responses for requests that failed
+// because the backend isn't ready.
+const retryAfterSeconds = "1"
+
 // Client is a backend for retrieving EC2 Instance data.
 type Client interface {
 	// GetEC2Instance retrieves an Instance associated with ip. If no Instance can be
 	// found, it should return ErrInstanceNotFound.
 	GetEC2Instance(_ context.Context, ip string) (Instance, error)
+
+	// GetEC2InstanceByMAC retrieves an Instance with a network interface identified by mac. mac
+	// is a lower case, colon separated MAC address. If no Instance can be found, it should return
+	// ErrInstanceNotFound.
+	GetEC2InstanceByMAC(_ context.Context, mac string) (Instance, error)
+}
+
+// IDClient is a Client that can retrieve instances by instance ID. Requests identified by instance
+// ID, such as those with a node hint naming the instance, can only be served by an IDClient.
+type IDClient interface {
+	// GetEC2InstanceByID retrieves the Instance with the instance ID id. If no Instance can be
+	// found, it should return ErrInstanceNotFound.
+	GetEC2InstanceByID(_ context.Context, id string) (Instance, error)
+}
+
+// GetInstanceByID retrieves the Instance with the instance ID id from client. If client isn't an
+// IDClient it returns ErrLookupUnsupported. Backend wrappers use it to forward lookups by instance
+// ID to the Client they wrap.
+func GetInstanceByID(ctx context.Context, client Client, id string) (Instance, error) {
+	c, ok := client.(IDClient)
+	if !ok {
+		return Instance{}, ErrLookupUnsupported
+	}
+	return c.GetEC2InstanceByID(ctx, id)
+}
+
+// TenantClient is a Client that can scope lookups by IP to a tenant. It is used in multi-tenant
+// clusters where tenants may reuse IPs.
+type TenantClient interface {
+	// GetEC2InstanceForTenant retrieves the Instance belonging to tenant associated with ip. If no
+	// Instance can be found, it should return ErrInstanceNotFound.
+	GetEC2InstanceForTenant(_ context.Context, tenant, ip string) (Instance, error)
+}
+
+// GetInstanceForTenant retrieves the Instance belonging to tenant associated with ip from client.
+// If client isn't a TenantClient it returns ErrLookupUnsupported. Backend wrappers use it to
+// forward tenant scoped lookups to the Client they wrap.
+func GetInstanceForTenant(ctx context.Context, client Client, tenant, ip string) (Instance, error) {
+	c, ok := client.(TenantClient)
+	if !ok {
+		return Instance{}, ErrLookupUnsupported
+	}
+	return c.GetEC2InstanceForTenant(ctx, tenant, ip)
 }
 
 // Frontend is an EC2 HTTP API frontend. It is responsible for configuring routers with handlers
 // for the AWS EC2 instance metadata API.
 type Frontend struct {
 	client Client
+	tracer trace.Tracer
+
+	sniffUserData bool
+	macHeader     string
+	aliases       map[string]string
+	userDataKey   ed25519.PrivateKey
+
+	skipFailedTreeValues bool
+	parentListing        bool
+	userDataTemplates    *userDataTemplates
+
+	// trailingNewlines are the endpoints whose text responses end with a newline. See
+	// WithTrailingNewlines.
+	trailingNewlines []string
+
+	// phases select the boot phase of requests by User-Agent. See WithUserAgentPhases.
+	phases []UserAgentPhase
+
+	// upstream, if set, serves unknown paths. See WithUpstream.
+	upstream *httputil.ReverseProxy
+
+	// listings are the handlers serving directory listings keyed by directory, excluding the API
+	// version prefix. They're registered by Configure for use by NotFound.
+	listings map[string]gin.HandlerFunc
+
+	hotPathTTL time.Duration
+	hotPath    *hotPath
+
+	// settings are the settings requests are served with. Handlers replace them with the live
+	// settings when they begin serving a request so the request is served with the same settings
+	// throughout, even if they're reloaded.
+	*settings
+	live *atomic.Pointer[settings]
+}
+
+// settings are the Options of a Frontend that can be changed with Reload while serving requests.
+type settings struct {
+	defaults        map[string]string
+	userDataMerge   UserDataMerge
+	maxUserDataSize int
+	regions         map[string]string
+	services        Services
+}
+
+// defaultSettings returns the settings used for Options that aren't specified.
+func defaultSettings() *settings {
+	return &settings{
+		userDataMerge: UserDataMergeMultipart,
+		services: Services{
+			Domain:    DefaultServicesDomain,
+			Partition: DefaultServicesPartition,
+		},
+	}
+}
+
+// Option configures a Frontend.
+type Option func(*Frontend)
+
+// WithUserDataContentTypeSniffing configures whether the Content-Type of plain text user-data
+// responses is determined from the user-data, such as a shell script or cloud-config, rather
+// than served as plain text.
+func WithUserDataContentTypeSniffing(enabled bool) Option {
+	return func(f *Frontend) {
+		f.sniffUserData = enabled
+	}
+}
+
+// WithMACHeader configures a header clients can use to present the MAC address of the instance.
+// When the header is present and the instance cannot be identified by the request remote address,
+// the instance is retrieved by MAC address instead. An empty header disables MAC lookups.
+func WithMACHeader(header string) Option {
+	return func(f *Frontend) {
+		f.macHeader = header
+	}
+}
+
+// WithDefaults configures values served by scalar data endpoints when the instance has no data
+// for them. defaults maps endpoints, excluding the API version prefix, such as
+// /meta-data/hostname, to their default value. Endpoints without a default serve an empty body.
+func WithDefaults(defaults map[string]string) Option {
+	return func(f *Frontend) {
+		f.defaults = defaults
+	}
+}
+
+// WithPathAliases configures alternate paths data endpoints are served at so images requesting
+// differing spellings of an endpoint can be served without duplicating it. aliases maps alternate
+// paths to the data endpoint they serve, both excluding the API version prefix, such as
+// /meta-data/host-name to /meta-data/hostname. Aliases serve the endpoint's data, including its
+// default, but aren't included in directory listings. aliases must satisfy ValidatePathAliases.
+func WithPathAliases(aliases map[string]string) Option {
+	return func(f *Frontend) {
+		f.aliases = aliases
+	}
+}
+
+// WithUserDataMerge configures the strategy used to compose an instance's user-data fragments with
+// its user-data. Defaults to UserDataMergeMultipart.
+func WithUserDataMerge(m UserDataMerge) Option {
+	return func(f *Frontend) {
+		f.userDataMerge = m
+	}
+}
+
+// WithUserDataSigningKey configures an Ed25519 key used to sign user-data so instances can verify
+// it wasn't tampered with in transit. The base64 encoded signature of the composed user-data is
+// served at /user-data.sig and in the UserDataSignatureHeader of user-data responses. Signatures
+// cover the raw user-data regardless of the requested encoding. A nil key disables signing.
+//
+// The signed message binds the user-data to the instance and endpoint it's served for so verifiers
+// must reconstruct it as the instance ID, a newline, the endpoint without the API version prefix,
+// "/user-data", a newline and the raw user-data.
+func WithUserDataSigningKey(key ed25519.PrivateKey) Option {
+	return func(f *Frontend) {
+		f.userDataKey = key
+	}
+}
+
+// WithMaxUserDataSize configures the size, in bytes, of the largest composed user-data or
+// vendor-data served. Requests for larger data fail so pathologically large records can't exhaust
+// memory, and are counted as oversized_user_data errors. Recursive listings treat oversized data
+// as data that can't be produced. A size of 0 is unlimited.
+func WithMaxUserDataSize(size int) Option {
+	return func(f *Frontend) {
+		f.maxUserDataSize = size
+	}
+}
+
+// WithFacilityRegions configures the region served for instances in a facility. regions maps
+// facilities, which are served as availability zones, to regions. Facilities without a region
+// follow the EC2 availability zone naming convention where the region is the zone without its
+// trailing letter, for example us-east-1a is in us-east-1, falling back to the facility itself.
+func WithFacilityRegions(regions map[string]string) Option {
+	return func(f *Frontend) {
+		f.regions = regions
+	}
+}
+
+// WithServices configures the services domain and partition served for instances without their
+// own. Empty values retain the defaults, DefaultServicesDomain and DefaultServicesPartition, so
+// AWS SDKs constructing service endpoints from them don't fail.
+func WithServices(domain, partition string) Option {
+	return func(f *Frontend) {
+		if domain != "" {
+			f.services.Domain = domain
+		}
+		if partition != "" {
+			f.services.Partition = partition
+		}
+	}
+}
+
+// WithSkipFailedTreeValues configures whether recursive directory requests omit data that can't
+// be produced rather than failing the request. Omitted data is recorded as an error for logging and
+// metrics.
+func WithSkipFailedTreeValues(enabled bool) Option {
+	return func(f *Frontend) {
+		f.skipFailedTreeValues = enabled
+	}
+}
+
+// WithUserDataTemplates configures whether user-data served from /user-data is rendered as a Go
+// text/template with the instance's Metadata as the data, so placeholders such as {{ .Hostname }}
+// and {{ .InstanceID }} are filled from the hardware record when served. User-data is rendered
+// after fragments are composed with it so fragments may be templates too. Templates that can't be
+// rendered, such as those referencing unknown fields, fail the request with a 500 rather than
+// serving partially rendered user-data.
+func WithUserDataTemplates(enabled bool) Option {
+	return func(f *Frontend) {
+		f.userDataTemplates = nil
+		if enabled {
+			f.userDataTemplates = newUserDataTemplates()
+		}
+	}
+}
+
+// WithUserAgentPhases configures the boot phase of requests to be selected by their User-Agent
+// so, for example, the iPXE firmware and the installed OS's cloud-init are served different
+// user-data. The phase of a request is that of the first of phases whose pattern matches its
+// User-Agent. Instances with user-data for the phase, Instance.UserdataPhases, are served it in
+// place of their user-data. Requests that match no phase, and instances without user-data for the
+// phase, are served as they would be without phases. phases must satisfy ValidateUserAgentPhases.
+func WithUserAgentPhases(phases []UserAgentPhase) Option {
+	return func(f *Frontend) {
+		f.phases = phases
+	}
+}
+
+// WithTrailingNewlines configures plain text responses for endpoints to end with a newline for
+// clients that expect one. Endpoints exclude the API version prefix, such as /meta-data/hostname,
+// and directories, such as /meta-data, apply to their listing and every endpoint beneath them; /
+// applies to every endpoint. Responses for other endpoints follow AWS: scalars are served as is
+// and listings are newline separated without a trailing newline. User-data and vendor-data are
+// always served as is, as are JSON responses.
+func WithTrailingNewlines(endpoints []string) Option {
+	return func(f *Frontend) {
+		f.trailingNewlines = endpoints
+	}
+}
+
+// WithParentListing configures whether requests for unknown paths under the API version prefix
+// are served the listing of the nearest parent directory, as AWS does for some partial paths,
+// rather than a 404. It applies to requests handled by NotFound only; known endpoints without data
+// for an instance are still a 404.
+func WithParentListing(enabled bool) Option {
+	return func(f *Frontend) {
+		f.parentListing = enabled
+	}
+}
+
+// WithUpstream configures requests for unknown paths, those NotFound would reject, to be proxied
+// to the same path beneath target, such as a cloud's instance metadata service or another Hegel,
+// rather than rejected. It supports incrementally adopting Hegel. The request method and body are
+// forwarded with a minimal set of headers and the client's IP in X-Forwarded-For. The upstream
+// response is streamed back with a minimal set of headers, and fails if the upstream doesn't
+// respond promptly. Paths Hegel serves are never proxied, even if the instance has no data for
+// them. target must satisfy ValidateUpstream. A nil target disables proxying.
+func WithUpstream(target *url.URL) Option {
+	return func(f *Frontend) {
+		f.upstream = nil
+		if target != nil {
+			f.upstream = newUpstream(target)
+		}
+	}
+}
+
+// WithHotPathTTL configures the duration the data of the endpoints cloud-init requests on every
+// boot, the instance ID, hostname, public keys and user-data, is cached for once an instance has
+// been retrieved. Requests for them within ttl are served without retrieving the instance again so
+// changes to the instance may not be observed until ttl elapses. A ttl of 0 disables caching.
+func WithHotPathTTL(ttl time.Duration) Option {
+	return func(f *Frontend) {
+		f.hotPathTTL = ttl
+	}
 }
 
 // New creates a new Frontend.
-func New(client Client) Frontend {
-	return Frontend{
-		client: client,
+func New(client Client, opts ...Option) Frontend {
+	f := Frontend{
+		client:   client,
+		tracer:   otel.Tracer(tracerName),
+		settings: defaultSettings(),
+		live:     &atomic.Pointer[settings]{},
+		listings: map[string]gin.HandlerFunc{},
+	}
+
+	for _, opt := range opts {
+		opt(&f)
+	}
+
+	if f.hotPathTTL > 0 {
+		f.hotPath = newHotPath(f.hotPathTTL, f.macHeader, f.userDataTemplates, f.phases)
+	}
+
+	f.live.Store(f.settings)
+
+	return f
+}
+
+// ValidateSettings validates the settings configured by opts as Reload does so they can be
+// validated before a Frontend is created with them.
+func ValidateSettings(opts ...Option) error {
+	_, err := newSettings(opts)
+	return err
+}
+
+// newSettings applies opts to the default settings and validates the result.
+func newSettings(opts []Option) (*settings, error) {
+	f := Frontend{settings: defaultSettings()}
+	for _, opt := range opts {
+		opt(&f)
+	}
+
+	if err := f.userDataMerge.Validate(); err != nil {
+		return nil, err
+	}
+	if f.maxUserDataSize < 0 {
+		return nil, fmt.Errorf("max user-data size must not be negative, got %v", f.maxUserDataSize)
 	}
+
+	return f.settings, nil
 }
 
-// Configure configures router with the supported AWS EC2 instance metadata API endpoints.
+// Reload replaces the settings configured by WithDefaults, WithUserDataMerge,
+// WithMaxUserDataSize, WithFacilityRegions and WithServices for f and every copy of it, including
+// those serving requests. Requests being served when f is reloaded complete with the previous
+// settings. opts are applied to the defaults, as they are by New, so settings that aren't specified
+// revert to their default. Other Options can't be reloaded and are ignored. If the settings are
+// invalid, Reload returns an error and the previous settings are retained.
+//
+// Data cached by the hot path was produced with the previous settings so is discarded.
+func (f Frontend) Reload(opts ...Option) error {
+	next, err := newSettings(opts)
+	if err != nil {
+		return err
+	}
+
+	f.live.Store(next)
+	f.hotPath.flush("")
+
+	return nil
+}
+
+// CacheSize satisfies metrics.CacheSizer reporting the size of the data cached by the hot path. See
+// WithHotPathTTL.
+func (f Frontend) CacheSize() (entries, bytes int) {
+	return f.hotPath.size()
+}
+
+// Flush satisfies admin.Flusher evicting the data cached by the hot path for requests from ip, or
+// all data if ip is empty. See WithHotPathTTL.
+func (f Frontend) Flush(ip string) int {
+	return f.hotPath.flush(ip)
+}
+
+// load returns a copy of f using the live settings.
+func (f Frontend) load() Frontend {
+	f.settings = f.live.Load()
+	return f
+}
+
+// Configure configures router with the supported AWS EC2 instance metadata API endpoints beneath
+// both APIVersionPrefix and LatestAPIVersionPrefix.
+// Directory listings, such as the API version root and /meta-data, are sorted lexically. Data
+// listings, such as tags and public keys, retain the order defined by the instance and are served
+// as empty listings, rather than errors, when the instance has no entries. Requesting a
+// directory with the recursive=true query parameter returns the data beneath it as a nested JSON
+// object instead of a listing. User-data and vendor-data are returned base64 encoded when
+// requested with the encoding=base64 query parameter. Responses carry a Cache-Control header
+// allowing clients to cache data that doesn't change for the lifetime of an instance, such as its
+// instance ID, and forbidding caching of volatile data such as spot termination notices.
 //
 // TODO(chrisdoherty4) Document unimplemented endpoints.
 func (f Frontend) Configure(router gin.IRouter) {
-	// Setup the 2009-04-04 API path prefix and use a trailing slash route helper to patch
-	// equivalent trailing slash routes.
-	v20090404 := ginutil.TrailingSlashRouteHelper{IRouter: router.Group("/2009-04-04")}
+	// Setup the 2009-04-04 API path prefix, and the latest prefix serving the same API, and use a
+	// trailing slash route helper to patch equivalent trailing slash routes.
+	versions := []gin.IRouter{
+		ginutil.TrailingSlashRouteHelper{IRouter: router.Group(APIVersionPrefix)},
+		ginutil.TrailingSlashRouteHelper{IRouter: router.Group(LatestAPIVersionPrefix)},
+	}
+
+	// path is the route endpoint is served at. It differs from endpoint for aliases.
+	dataEndpointBinder := func(router gin.IRouter, path, endpoint string, filter requestFilterFunc) {
+		cache := cacheControl(endpoint)
+
+		router.GET(path, func(ctx *gin.Context) {
+			f := f.load()
+
+			reqCtx, span := ginutil.StartServerSpan(ctx, f.tracer, "ec2.metadata",
+				attribute.String("http.route", ctx.FullPath()),
+			)
+			defer span.End()
+
+			// Hot endpoints may be served from data cached by a recent request without retrieving
+			// the instance.
+			data, instanceID, hit := f.hotPath.get(reqCtx, ctx.Request, endpoint)
+			span.SetAttributes(attribute.Bool("ec2.hot_path", hit))
 
-	dataEndpointBinder := func(router gin.IRouter, endpoint string, filter filterFunc) {
-		router.GET(endpoint, func(ctx *gin.Context) {
-			instance, err := f.getInstance(ctx, ctx.Request)
+			var instance Instance
+			if !hit {
+				var err error
+				instance, err = f.getInstance(reqCtx, ctx.Request)
+
+				// Don't produce data nobody will receive.
+				if clientDisconnected(ctx) {
+					return
+				}
+
+				if err != nil {
+					recordError(span, err)
+
+					f.abortInstanceError(ctx, err)
+					return
+				}
+
+				if !exists(endpoint, instance) {
+					abortNotFound(ctx)
+					return
+				}
+				instanceID = instance.Metadata.InstanceID
+			}
+
+			// The remote address has been validated by getInstance or, for hits, the request that
+			// cached the data.
+			clientIP, _ := request.RemoteAddrIP(ctx.Request)
+			span.SetAttributes(attribute.String("client.address", clientIP))
+
+			_, filterSpan := f.tracer.Start(reqCtx, "ec2.filter")
+			var err error
+			if !hit {
+				data, err = filter(instance, requestVars{
+					ClientIP: clientIP,
+					Path:     ctx.Request.URL.Path,
+					Params:   ctx.Params,
+				})
+				if err == nil {
+					data, err = f.userDataTemplates.render(endpoint, instance, data)
+				}
+				if err == nil {
+					f.hotPath.store(reqCtx, ctx.Request, instance, endpoint, data)
+				}
+			}
+			var signature string
+			if u, ok := data.(userData); ok && err == nil {
+				data, signature, err = f.prepareUserData(u, instanceID, endpoint, ctx.Query("encoding"))
+			}
 			if err != nil {
-				// If there's an error containing an http status code, use that status code else
-				// assume its an internal server error.
-				var httpErr *httperror.E
-				if errors.As(err, &httpErr) {
-					_ = ctx.AbortWithError(httpErr.StatusCode, err)
-				} else {
-					_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+				recordError(filterSpan, err)
+				filterSpan.End()
+
+				msg := "failed to produce data for " + ctx.Request.URL.Path
+				if errors.Is(err, errUserDataTemplate) {
+					// Operators need to know what's wrong with the template to fix it.
+					msg = err.Error()
 				}
 
+				status, kind := filterErrorStatus(err)
+				abort(ctx, status, kind, err, msg)
 				return
 			}
+			filterSpan.End()
+
+			if s, ok := data.(scalar); ok && s == "" {
+				if def, ok := f.defaults[endpoint]; ok {
+					data = scalar(def)
+				}
+			}
+
+			if signature != "" {
+				ctx.Header(UserDataSignatureHeader, signature)
+			}
+			if cache != "" {
+				ctx.Header("Cache-Control", cache)
+			}
 
-			ctx.String(http.StatusOK, filter(instance))
+			_, renderSpan := f.tracer.Start(reqCtx, "ec2.render")
+			f.render(ctx, data, f.trailingNewline(endpoint))
+			renderSpan.End()
 		})
 	}
 
@@ -71,35 +591,443 @@ func (f Frontend) Configure(router gin.IRouter) {
 	// Configure all dynamic routes. Dynamic routes are anything that requires retrieving a specific
 	// instance and returning data from it.
 	for _, r := range dataRoutes {
-		dataEndpointBinder(v20090404, r.Endpoint, r.Filter)
+		for _, v := range versions {
+			dataEndpointBinder(v, r.Endpoint, r.Endpoint, r.Filter.ignoreVars())
+		}
 		staticRoutes.FromEndpoint(r.Endpoint)
 	}
 
+	for _, r := range paramRoutes {
+		for _, v := range versions {
+			dataEndpointBinder(v, r.Endpoint, r.Endpoint, r.Filter)
+		}
+	}
+
+	// Aliases aren't added to the static routes so they're omitted from directory listings.
+	for _, r := range dataRoutes {
+		for alias, endpoint := range f.aliases {
+			if endpoint == r.Endpoint {
+				for _, v := range versions {
+					dataEndpointBinder(v, alias, r.Endpoint, r.Filter.ignoreVars())
+				}
+			}
+		}
+	}
+
+	if f.userDataKey != nil {
+		signature := func(i Instance, _ requestVars) (value, error) {
+			// The signature is of the user-data as served.
+			v, err := f.userDataTemplates.render(userDataEndpoint, i, userData(i.Userdata))
+			if err != nil {
+				return nil, err
+			}
+			if err := checkUserDataSize(v.(userData), f.live.Load().maxUserDataSize); err != nil {
+				return nil, err
+			}
+			return scalar(signUserData(f.userDataKey, i.Metadata.InstanceID, userDataEndpoint, v.(userData))), nil
+		}
+		for _, v := range versions {
+			dataEndpointBinder(v, userDataSignatureEndpoint, userDataSignatureEndpoint, signature)
+		}
+	}
+
 	staticEndpointBinder := func(router gin.IRouter, endpoint string, childEndpoints []string) {
-		router.GET(endpoint, func(ctx *gin.Context) {
-			ctx.String(http.StatusOK, join(childEndpoints))
-		})
+		conditional := isConditional(endpoint, childEndpoints)
+		cache := cacheControl(endpoint)
+
+		listing := func(ctx *gin.Context) {
+			f := f.load()
+			recursive := ctx.Query("recursive") == "true"
+
+			if cache != "" {
+				ctx.Header("Cache-Control", cache)
+			}
+
+			// Listings are the same for every instance unless they contain conditional
+			// directories so the instance is only retrieved when necessary.
+			if !recursive && !conditional {
+				f.render(ctx, list(childEndpoints), f.trailingNewline(endpoint))
+				return
+			}
+
+			instance, err := f.getInstance(ctx.Request.Context(), ctx.Request)
+			if clientDisconnected(ctx) {
+				return
+			}
+			if err != nil {
+				f.abortInstanceError(ctx, err)
+				return
+			}
+
+			if !exists(endpoint, instance) {
+				abortNotFound(ctx)
+				return
+			}
+
+			if recursive {
+				f.renderTree(ctx, instance, endpoint)
+				return
+			}
+
+			children := list{}
+			for _, child := range childEndpoints {
+				if exists(endpoint+"/"+strings.TrimSuffix(child, "/"), instance) {
+					children = append(children, child)
+				}
+			}
+			f.render(ctx, children, f.trailingNewline(endpoint))
+		}
+
+		router.GET(endpoint, listing)
+		f.listings[endpoint] = listing
 	}
 
 	for _, r := range staticRoutes.Build() {
-		staticEndpointBinder(v20090404, r.Endpoint, r.Children)
+		for _, v := range versions {
+			staticEndpointBinder(v, r.Endpoint, r.Children)
+		}
+	}
+}
+
+// renderTree writes the data of every data endpoint beneath directory for instance as a nested
+// JSON object mirroring the endpoint hierarchy.
+func (f Frontend) renderTree(ctx *gin.Context, instance Instance, directory string) {
+	tree, skipped, err := buildTree(instance, directory, f.maxUserDataSize, f.userDataTemplates, f.skipFailedTreeValues)
+	if err != nil {
+		status, kind := filterErrorStatus(err)
+		abort(ctx, status, kind, err, "failed to produce data for "+ctx.Request.URL.Path)
+		return
+	}
+	for _, err := range skipped {
+		_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+			Handler: metricsHandler,
+			Kind:    "skipped_value",
+		})
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := encodeJSON(buf, tree); err != nil {
+		abort(ctx, http.StatusInternalServerError, "render", err, "failed to render data for "+ctx.Request.URL.Path)
+		return
+	}
+
+	ctx.Data(http.StatusOK, JSONRenderer{}.ContentType(), buf.Bytes())
+}
+
+// NotFound is a gin.HandlerFunc for use with gin.Engine.NoRoute. Requests for unknown paths under
+// the API version prefixes, such as a bogus item beneath a known directory, are aborted with a 404
+// and a body echoing the requested path. Requests that appear to be for metadata but use a
+// malformed or unsupported API version, such as /2009-13-45/meta-data or /bogus/user-data, are
+// aborted with a 400 so clients can distinguish them from unknown items. Other requests are left
+// for gin's default handling.
+//
+// When configured with WithParentListing, requests for unknown paths under the API version prefix
+// are instead served the listing of the nearest parent directory configured by Configure. When
+// configured with WithUpstream, requests that would be aborted are instead proxied upstream; this
+// takes precedence over WithParentListing.
+func (f Frontend) NotFound(ctx *gin.Context) {
+	path := ctx.Request.URL.Path
+	if endpoint, ok := trimAPIVersionPrefix(path); ok {
+		if f.upstream != nil {
+			f.proxy(ctx)
+			return
+		}
+		if listing, ok := f.parentListingOf(endpoint); ok {
+			listing(ctx)
+			return
+		}
+		abortNotFound(ctx)
+		return
+	}
+
+	version, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	category, _, _ := strings.Cut(rest, "/")
+	if versionPattern.MatchString(version) || isCategory(category) {
+		if f.upstream != nil {
+			f.proxy(ctx)
+			return
+		}
+		err := httperror.Newf(http.StatusBadRequest, "unsupported metadata API version: %v", version)
+		abort(ctx, http.StatusBadRequest, statusErrorKind(http.StatusBadRequest), err, err.Error())
+	}
+}
+
+// parentListingOf returns the handler serving the listing of the nearest directory above endpoint,
+// excluding the API version prefix. If f isn't configured with WithParentListing, it returns
+// false.
+func (f Frontend) parentListingOf(endpoint string) (gin.HandlerFunc, bool) {
+	if !f.parentListing {
+		return nil, false
+	}
+
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	for i := strings.LastIndex(endpoint, "/"); i >= 0; i = strings.LastIndex(endpoint, "/") {
+		endpoint = endpoint[:i]
+		if listing, ok := f.listings[endpoint]; ok {
+			return listing, true
+		}
+	}
+
+	return nil, false
+}
+
+// trimAPIVersionPrefix returns path without the API version prefix it's beneath. If path isn't
+// beneath APIVersionPrefix or LatestAPIVersionPrefix, it returns false.
+func trimAPIVersionPrefix(path string) (string, bool) {
+	for _, prefix := range []string{APIVersionPrefix, LatestAPIVersionPrefix} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return strings.TrimPrefix(path, prefix), true
+		}
+	}
+	return "", false
+}
+
+// versionPattern matches the form of metadata API versions, such as 2009-04-04.
+var versionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+// isCategory returns true if name is a top level metadata category such as meta-data.
+func isCategory(name string) bool {
+	for _, r := range dataRoutes {
+		if c, _, _ := strings.Cut(strings.TrimPrefix(r.Endpoint, "/"), "/"); name != "" && c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// abortNotFound aborts the request with a 404 and a body echoing the requested path.
+func abortNotFound(ctx *gin.Context) {
+	err := httperror.Newf(http.StatusNotFound, "metadata item not found: %v", ctx.Request.URL.Path)
+	abort(ctx, http.StatusNotFound, statusErrorKind(http.StatusNotFound), err, err.Error())
+}
+
+// render writes v to the response using a Renderer selected from the request Accept header. Text
+// responses, other than user-data, end with a newline if trailingNewline is true.
+func (f Frontend) render(ctx *gin.Context, v value, trailingNewline bool) {
+	renderer := selectRenderer(ctx.GetHeader("Accept"))
+	t, text := renderer.(TextRenderer)
+	if text && trailingNewline && !isUserData(v) {
+		t.TrailingNewline = true
+		renderer = t
+	}
+
+	contentType := renderer.ContentType()
+	if u, ok := v.(userData); ok && f.sniffUserData && text {
+		contentType = sniffUserDataContentType(string(u))
+	}
+
+	// Text single values are rendered as is, or encoded as they're written, so they're written
+	// directly to the response rather than copied to a buffer first. This avoids copying large
+	// values such as user-data.
+	if isSingleValue(v) && text {
+		ctx.Header("Content-Type", contentType)
+		ctx.Status(http.StatusOK)
+
+		// Writes only fail if the client has gone away so there's nobody to report the error to.
+		_ = v.render(ctx.Writer, renderer)
+		return
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	buf.Grow(sizeHint(v))
+	if err := v.render(buf, renderer); err != nil {
+		abort(ctx, http.StatusInternalServerError, "render", err, "failed to render data for "+ctx.Request.URL.Path)
+		return
+	}
+
+	ctx.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// trailingNewline returns true if text responses for endpoint, excluding the API version prefix,
+// end with a newline as configured by WithTrailingNewlines.
+func (f Frontend) trailingNewline(endpoint string) bool {
+	for _, e := range f.trailingNewlines {
+		if e == "/" || endpoint == e || strings.HasPrefix(endpoint, e+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// prepareUserData prepares user-data u served by endpoint for the instance identified by
+// instanceID for rendering. It guards against oversized user-data, signs user-data when configured
+// to and encodes u using encoding.
+func (f Frontend) prepareUserData(u userData, instanceID, endpoint, encoding string) (v value, signature string, err error) {
+	if err := checkUserDataSize(u, f.maxUserDataSize); err != nil {
+		return nil, "", err
+	}
+
+	if endpoint == userDataEndpoint && f.userDataKey != nil {
+		signature = signUserData(f.userDataKey, instanceID, endpoint, u)
+	}
+
+	v, err = encodeUserData(u, encoding)
+	return v, signature, err
+}
+
+// abortInstanceError aborts the request for an error returned by getInstance. The body of client
+// errors, such as an unknown instance, is err's message.
+func (f Frontend) abortInstanceError(ctx *gin.Context, err error) {
+	status, kind := ErrorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		ctx.Header("Retry-After", retryAfterSeconds)
+	}
+
+	msg := "failed to retrieve instance"
+	if status < http.StatusInternalServerError {
+		msg = err.Error()
+	}
+	abort(ctx, status, kind, err, msg)
+}
+
+// abort aborts the request with status and a plain text body describing the failure. err is
+// recorded on ctx, classified by kind, for logging and metrics. The body is err's message for
+// client errors that carry an HTTP status code, such as an unknown instance, otherwise it is msg so
+// internal details aren't exposed to clients.
+func abort(ctx *gin.Context, status int, kind string, err error, msg string) {
+	_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    kind,
+	})
+
+	var httpErr *httperror.E
+	if errors.As(err, &httpErr) && httpErr.StatusCode < http.StatusInternalServerError {
+		msg = httpErr.Error()
+	}
+
+	// Failures mustn't be cached in place of the data.
+	ctx.Writer.Header().Del("Cache-Control")
+
+	ctx.Data(status, "text/plain; charset=utf-8", []byte(msg))
+	ctx.Abort()
+}
+
+// clientDisconnected reports whether the client disconnected, cancelling the request context. If
+// it has, the request is aborted without a response body as there is nobody to receive it.
+func clientDisconnected(ctx *gin.Context) bool {
+	err := ctx.Request.Context().Err()
+	if !errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    "client_disconnect",
+	})
+	ctx.Status(statusClientClosedRequest)
+	ctx.Abort()
+	return true
+}
+
+// Instance retrieves the instance r is made on behalf of as it's served by f's endpoints so
+// frontends sharing f's backend serve consistent data. The instance is identified, and its
+// user-data composed, as described by getInstance and, when configured, its user-data is rendered
+// as a template. Errors may be mapped to a response with ErrorStatus.
+func (f Frontend) Instance(ctx context.Context, r *http.Request) (Instance, error) {
+	f = f.load()
+
+	instance, err := f.getInstance(ctx, r)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	v, err := f.userDataTemplates.render(userDataEndpoint, instance, userData(instance.Userdata))
+	if err != nil {
+		return Instance{}, err
+	}
+	if err := checkUserDataSize(v.(userData), f.maxUserDataSize); err != nil {
+		return Instance{}, err
 	}
+	instance.Userdata = string(v.(userData))
+
+	return instance, nil
 }
 
 // getInstance is a framework agnostic method for retrieving Instance data based on a remote
-// address.
+// address. If the instance cannot be identified by the remote address and the request contains
+// the configured MAC header, the instance is retrieved by MAC address. Requests identified by MAC
+// address or instance ID by an identity strategy are retrieved accordingly. The instance's
+// user-data fragments are composed with its user-data unless it has user-data for the request's
+// phase which is served instead.
 func (f Frontend) getInstance(ctx context.Context, r *http.Request) (Instance, error) {
+	instance, err := f.lookupInstance(ctx, r)
+	if err != nil {
+		return Instance{}, err
+	}
+
+	instance = applyPhase(instance, requestPhase(f.phases, r))
+
+	if instance.Metadata.Region == "" {
+		instance.Metadata.Region = f.region(instance.Metadata.Facility)
+	}
+	if instance.Metadata.Services.Domain == "" {
+		instance.Metadata.Services.Domain = f.services.Domain
+	}
+	if instance.Metadata.Services.Partition == "" {
+		instance.Metadata.Services.Partition = f.services.Partition
+	}
+
+	// Compose user-data once so every endpoint serving it is consistent.
+	if len(instance.UserdataFragments) > 0 {
+		instance.Userdata, err = mergeUserData(f.userDataMerge, instance.UserdataFragments, instance.Userdata)
+		if err != nil {
+			return Instance{}, err
+		}
+	}
+
+	return instance, nil
+}
+
+// lookupInstance retrieves the instance identified by r as described by getInstance.
+func (f Frontend) lookupInstance(ctx context.Context, r *http.Request) (Instance, error) {
+	if key, ok := identity.FromContext(ctx); ok {
+		switch key.Kind {
+		case identity.KindMAC:
+			return f.getInstanceByMAC(ctx, key.Value)
+		case identity.KindInstanceID:
+			return f.getInstanceByID(ctx, key.Value)
+		}
+	}
+
+	instance, err := f.getInstanceByIP(ctx, r)
+	if err != nil && f.macHeader != "" && r.Header.Get(f.macHeader) != "" {
+		var httpErr *httperror.E
+		if !errors.As(err, &httpErr) || (httpErr.StatusCode != http.StatusBadRequest && httpErr.StatusCode != http.StatusNotFound) {
+			return Instance{}, err
+		}
+
+		return f.getInstanceByMAC(ctx, r.Header.Get(f.macHeader))
+	}
+	return instance, err
+}
+
+func (f Frontend) getInstanceByIP(ctx context.Context, r *http.Request) (Instance, error) {
 	ip, err := request.RemoteAddrIP(r)
 	if err != nil {
-		return Instance{}, httperror.New(http.StatusBadRequest, "invalid remote addr")
+		return Instance{}, httperror.New(http.StatusBadRequest, "unable to determine the source IP of the request")
 	}
 
+	ctx, span := f.tracer.Start(ctx, "ec2.GetEC2Instance",
+		trace.WithAttributes(attribute.String("client.address", ip)),
+	)
+	defer span.End()
+
 	instance, err := f.client.GetEC2Instance(ctx, ip)
 	if err != nil {
+		recordError(span, err)
+
 		if errors.Is(err, ErrInstanceNotFound) {
 			return Instance{}, httperror.New(http.StatusNotFound, "no hardware found for source ip")
 		}
 
+		if errors.Is(err, ErrBackendNotReady) {
+			return Instance{}, httperror.New(http.StatusServiceUnavailable, "backend not ready; retry later")
+		}
+
 		// TODO(chrisdoherty4) What happens when multiple Instance could be returned? What
 		// is the behavior of GetEC2Instance?
 		return Instance{}, httperror.Wrap(http.StatusInternalServerError, err)
@@ -108,6 +1036,81 @@ func (f Frontend) getInstance(ctx context.Context, r *http.Request) (Instance, e
 	return instance, nil
 }
 
-func join(v []string) string {
-	return strings.Join(v, "\n")
+func (f Frontend) getInstanceByMAC(ctx context.Context, mac string) (Instance, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return Instance{}, httperror.Newf(http.StatusBadRequest, "invalid %v header", f.macHeader)
+	}
+
+	ctx, span := f.tracer.Start(ctx, "ec2.GetEC2InstanceByMAC",
+		trace.WithAttributes(attribute.String("client.mac", hwAddr.String())),
+	)
+	defer span.End()
+
+	instance, err := f.client.GetEC2InstanceByMAC(ctx, hwAddr.String())
+	if err != nil {
+		recordError(span, err)
+
+		if errors.Is(err, ErrInstanceNotFound) {
+			return Instance{}, httperror.New(http.StatusNotFound, "no hardware found for source ip or mac")
+		}
+
+		if errors.Is(err, ErrBackendNotReady) {
+			return Instance{}, httperror.New(http.StatusServiceUnavailable, "backend not ready; retry later")
+		}
+		return Instance{}, httperror.Wrap(http.StatusInternalServerError, err)
+	}
+
+	return instance, nil
+}
+
+func (f Frontend) getInstanceByID(ctx context.Context, id string) (Instance, error) {
+	ctx, span := f.tracer.Start(ctx, "ec2.GetEC2InstanceByID",
+		trace.WithAttributes(attribute.String("instance.id", id)),
+	)
+	defer span.End()
+
+	// The response status is chosen from err by ErrorStatus.
+	instance, err := GetInstanceByID(ctx, f.client, id)
+	if err != nil {
+		recordError(span, err)
+		return Instance{}, fmt.Errorf("retrieve instance by instance id: %w", err)
+	}
+
+	return instance, nil
+}
+
+// region determines the region containing facility.
+func (f Frontend) region(facility string) string {
+	if r, ok := f.regions[facility]; ok {
+		return r
+	}
+
+	// Strip the zone letter from EC2 style availability zones such as us-east-1a.
+	if n := len(facility); n > 1 && facility[n-1] >= 'a' && facility[n-1] <= 'z' &&
+		facility[n-2] >= '0' && facility[n-2] <= '9' {
+		return facility[:n-1]
+	}
+
+	return facility
+}
+
+// statusErrorKind maps the status code of an error to an error kind for metrics.
+func statusErrorKind(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "request"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusServiceUnavailable:
+		return "not_ready"
+	default:
+		return "backend"
+	}
+}
+
+// recordError records err on span and marks the span as failed.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
 }
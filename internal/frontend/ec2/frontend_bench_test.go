@@ -0,0 +1,214 @@
+package ec2_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+// Benchmarks serve requests end to end through a router so they capture the cost of the filter,
+// rendering and writing the response.
+//
+// Results on an Intel Xeon Processor before rendering responses to pooled buffers and writing
+// text scalars directly to the response:
+//
+//	BenchmarkScalar             5381 ns/op      2528 B/op     34 allocs/op
+//	BenchmarkListing            8423 ns/op      3152 B/op     28 allocs/op
+//	BenchmarkRecursiveListing  33093 ns/op      9521 B/op    143 allocs/op
+//	BenchmarkLargeUserData    683111 ns/op   2017743 B/op     36 allocs/op
+//
+// After:
+//
+//	BenchmarkScalar             2931 ns/op      2368 B/op     31 allocs/op
+//	BenchmarkListing            5874 ns/op      2656 B/op     25 allocs/op
+//	BenchmarkRecursiveListing  23182 ns/op      8834 B/op    141 allocs/op
+//	BenchmarkLargeUserData    252717 ns/op   1010011 B/op     33 allocs/op
+//
+// The remaining bytes for large user-data were the response recorder's copy of the body. Responses
+// are now written to a discardResponseWriter so results exclude it.
+//
+// Results before encoding base64 user-data as it's written to the response:
+//
+//	BenchmarkLargeUserData          3047 ns/op      1856 B/op     28 allocs/op
+//	BenchmarkLargeUserDataBase64 1632552 ns/op   2672906 B/op     33 allocs/op
+//
+// After:
+//
+//	BenchmarkLargeUserData          3052 ns/op      1856 B/op     28 allocs/op
+//	BenchmarkLargeUserDataBase64  778970 ns/op      3376 B/op     31 allocs/op
+//
+// Results serving the requests cloud-init makes on every boot without and with the hot path:
+//
+//	BenchmarkHotPath/Disabled  15609 ns/op      8824 B/op    115 allocs/op
+//	BenchmarkHotPath/Enabled   12684 ns/op      7441 B/op     91 allocs/op
+
+func BenchmarkScalar(b *testing.B) {
+	benchmarkEndpoint(b, "/2009-04-04/meta-data/hostname")
+}
+
+func BenchmarkListing(b *testing.B) {
+	benchmarkEndpoint(b, "/2009-04-04/meta-data")
+}
+
+func BenchmarkRecursiveListing(b *testing.B) {
+	benchmarkEndpoint(b, "/2009-04-04/meta-data?recursive=true")
+}
+
+func BenchmarkLargeUserData(b *testing.B) {
+	benchmarkEndpoint(b, "/2009-04-04/user-data")
+}
+
+func BenchmarkLargeUserDataBase64(b *testing.B) {
+	benchmarkEndpoint(b, "/2009-04-04/user-data?encoding=base64")
+}
+
+// BenchmarkHotPath serves the requests cloud-init makes on every boot with and without the hot
+// path. The static client retrieves instances at no cost so results understate the savings for
+// backends whose lookups are not free.
+func BenchmarkHotPath(b *testing.B) {
+	instance := Instance{
+		Userdata: "#cloud-config\n",
+		Metadata: Metadata{
+			InstanceID: "instance-id",
+			Hostname:   "sm01",
+			PublicKeys: []string{"ssh-ed25519 AAAA"},
+		},
+	}
+
+	endpoints := []string{
+		"/2009-04-04/meta-data/instance-id",
+		"/2009-04-04/meta-data/hostname",
+		"/2009-04-04/meta-data/public-keys",
+		"/2009-04-04/user-data",
+	}
+
+	cases := []struct {
+		Name    string
+		Options []Option
+	}{
+		{Name: "Disabled"},
+		{Name: "Enabled", Options: []Option{WithHotPathTTL(time.Hour)}},
+	}
+
+	for _, tc := range cases {
+		b.Run(tc.Name, func(b *testing.B) {
+			router := gin.New()
+			New(staticClient{instance: instance}, tc.Options...).Configure(router)
+
+			var requests []*http.Request
+			for _, e := range endpoints {
+				r := httptest.NewRequest(http.MethodGet, e, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+				requests = append(requests, r)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for n := 0; n < b.N; n++ {
+				for _, r := range requests {
+					w := &discardResponseWriter{header: http.Header{}}
+					router.ServeHTTP(w, r)
+					if w.code != http.StatusOK {
+						b.Fatalf("Expected: 200; Received: %d", w.code)
+					}
+				}
+			}
+		})
+	}
+}
+
+func benchmarkEndpoint(b *testing.B, endpoint string) {
+	b.Helper()
+
+	instance := Instance{
+		Userdata: "#cloud-config\n" + strings.Repeat("# padding\n", 100_000),
+		Metadata: Metadata{
+			InstanceID: "instance-id",
+			Hostname:   "sm01",
+			Tags:       []string{"tag1", "tag2"},
+			PublicKeys: []string{"ssh-ed25519 AAAA"},
+			LocalIPv4:  "10.10.10.10",
+			Interfaces: []NetworkInterface{
+				{MAC: "00:00:00:00:00:01", LocalIPv4s: []string{"10.10.10.10"}},
+			},
+		},
+	}
+
+	router := gin.New()
+	New(staticClient{instance: instance}).Configure(router)
+
+	r := httptest.NewRequest(http.MethodGet, endpoint, nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for n := 0; n < b.N; n++ {
+		w := &discardResponseWriter{header: http.Header{}}
+		router.ServeHTTP(w, r)
+		if w.code != http.StatusOK {
+			b.Fatalf("Expected: 200; Received: %d", w.code)
+		}
+	}
+}
+
+// discardResponseWriter discards response bodies so benchmarks measure the cost of producing
+// responses rather than the cost of buffering them, as httptest.ResponseRecorder does.
+type discardResponseWriter struct {
+	header http.Header
+	code   int
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.ensureStatus()
+	return len(b), nil
+}
+
+// WriteString avoids copying strings to byte slices as the net/http response writer does.
+func (w *discardResponseWriter) WriteString(s string) (int, error) {
+	w.ensureStatus()
+	return len(s), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+// ensureStatus sets the implicit 200 status of responses written without calling WriteHeader.
+func (w *discardResponseWriter) ensureStatus() {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+}
+
+// staticClient returns the same instance for every lookup.
+type staticClient struct {
+	instance Instance
+}
+
+func (c staticClient) GetEC2Instance(context.Context, string) (Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceByMAC(context.Context, string) (Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceByID(context.Context, string) (Instance, error) {
+	return c.instance, nil
+}
+
+func (c staticClient) GetEC2InstanceForTenant(context.Context, string, string) (Instance, error) {
+	return c.instance, nil
+}
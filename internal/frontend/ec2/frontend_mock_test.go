@@ -48,3 +48,94 @@ func (mr *MockClientMockRecorder) GetEC2Instance(arg0, ip interface{}) *gomock.C
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEC2Instance", reflect.TypeOf((*MockClient)(nil).GetEC2Instance), arg0, ip)
 }
+
+// GetEC2InstanceByMAC mocks base method.
+func (m *MockClient) GetEC2InstanceByMAC(arg0 context.Context, mac string) (Instance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEC2InstanceByMAC", arg0, mac)
+	ret0, _ := ret[0].(Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEC2InstanceByMAC indicates an expected call of GetEC2InstanceByMAC.
+func (mr *MockClientMockRecorder) GetEC2InstanceByMAC(arg0, mac interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEC2InstanceByMAC", reflect.TypeOf((*MockClient)(nil).GetEC2InstanceByMAC), arg0, mac)
+}
+
+// MockIDClient is a mock of IDClient interface.
+type MockIDClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockIDClientMockRecorder
+}
+
+// MockIDClientMockRecorder is the mock recorder for MockIDClient.
+type MockIDClientMockRecorder struct {
+	mock *MockIDClient
+}
+
+// NewMockIDClient creates a new mock instance.
+func NewMockIDClient(ctrl *gomock.Controller) *MockIDClient {
+	mock := &MockIDClient{ctrl: ctrl}
+	mock.recorder = &MockIDClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIDClient) EXPECT() *MockIDClientMockRecorder {
+	return m.recorder
+}
+
+// GetEC2InstanceByID mocks base method.
+func (m *MockIDClient) GetEC2InstanceByID(arg0 context.Context, id string) (Instance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEC2InstanceByID", arg0, id)
+	ret0, _ := ret[0].(Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEC2InstanceByID indicates an expected call of GetEC2InstanceByID.
+func (mr *MockIDClientMockRecorder) GetEC2InstanceByID(arg0, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEC2InstanceByID", reflect.TypeOf((*MockIDClient)(nil).GetEC2InstanceByID), arg0, id)
+}
+
+// MockTenantClient is a mock of TenantClient interface.
+type MockTenantClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockTenantClientMockRecorder
+}
+
+// MockTenantClientMockRecorder is the mock recorder for MockTenantClient.
+type MockTenantClientMockRecorder struct {
+	mock *MockTenantClient
+}
+
+// NewMockTenantClient creates a new mock instance.
+func NewMockTenantClient(ctrl *gomock.Controller) *MockTenantClient {
+	mock := &MockTenantClient{ctrl: ctrl}
+	mock.recorder = &MockTenantClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTenantClient) EXPECT() *MockTenantClientMockRecorder {
+	return m.recorder
+}
+
+// GetEC2InstanceForTenant mocks base method.
+func (m *MockTenantClient) GetEC2InstanceForTenant(arg0 context.Context, tenant, ip string) (Instance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetEC2InstanceForTenant", arg0, tenant, ip)
+	ret0, _ := ret[0].(Instance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetEC2InstanceForTenant indicates an expected call of GetEC2InstanceForTenant.
+func (mr *MockTenantClientMockRecorder) GetEC2InstanceForTenant(arg0, tenant, ip interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetEC2InstanceForTenant", reflect.TypeOf((*MockTenantClient)(nil).GetEC2InstanceForTenant), arg0, tenant, ip)
+}
@@ -1,14 +1,33 @@
 package ec2_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/mail"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/identity"
+	"github.com/tinkerbell/hegel/internal/metrics"
 )
 
 func init() {
@@ -33,6 +52,23 @@ func TestFrontendDynamicEndpoints(t *testing.T) {
 			},
 			Expect: "userdata",
 		},
+		{
+			Name:     "Vendordata",
+			Endpoint: "/2009-04-04/vendor-data",
+			Instance: Instance{
+				Userdata:   "userdata",
+				Vendordata: "vendordata",
+			},
+			Expect: "vendordata",
+		},
+		{
+			Name:     "VendordataAbsent",
+			Endpoint: "/2009-04-04/vendor-data",
+			Instance: Instance{
+				Userdata: "userdata",
+			},
+			Expect: "",
+		},
 		{
 			Name:     "InstanceID",
 			Endpoint: "/2009-04-04/meta-data/instance-id",
@@ -83,6 +119,51 @@ func TestFrontendDynamicEndpoints(t *testing.T) {
 			},
 			Expect: "plan",
 		},
+		{
+			Name:     "InstanceType",
+			Endpoint: "/2009-04-04/meta-data/instance-type",
+			Instance: Instance{
+				Metadata: Metadata{
+					Plan: "c3.small.x86",
+				},
+			},
+			Expect: "c3.small.x86",
+		},
+		{
+			Name:     "AMIID",
+			Endpoint: "/2009-04-04/meta-data/ami-id",
+			Instance: Instance{
+				Metadata: Metadata{
+					OperatingSystem: OperatingSystem{
+						Slug:     "ubuntu_20_04",
+						ImageTag: "ubuntu-20.04-20230101",
+					},
+				},
+			},
+			Expect: "ubuntu-20.04-20230101",
+		},
+		{
+			Name:     "AMIIDFromSlug",
+			Endpoint: "/2009-04-04/meta-data/ami-id",
+			Instance: Instance{
+				Metadata: Metadata{
+					OperatingSystem: OperatingSystem{
+						Slug: "ubuntu_20_04",
+					},
+				},
+			},
+			Expect: "ubuntu_20_04",
+		},
+		{
+			Name:     "AMIIDAbsent",
+			Endpoint: "/2009-04-04/meta-data/ami-id",
+			Expect:   "",
+		},
+		{
+			Name:     "AMILaunchIndex",
+			Endpoint: "/2009-04-04/meta-data/ami-launch-index",
+			Expect:   "0",
+		},
 		{
 			Name:     "Facility",
 			Endpoint: "/2009-04-04/meta-data/facility",
@@ -113,6 +194,26 @@ func TestFrontendDynamicEndpoints(t *testing.T) {
 			},
 			Expect: "key1\nkey2",
 		},
+		{
+			Name:     "PublicKeysIndex",
+			Endpoint: "/2009-04-04/meta-data/public-keys/1",
+			Instance: Instance{
+				Metadata: Metadata{
+					PublicKeys: []string{"key1", "key2"},
+				},
+			},
+			Expect: "openssh-key",
+		},
+		{
+			Name:     "PublicKeysIndexOpenSSHKey",
+			Endpoint: "/2009-04-04/meta-data/public-keys/1/openssh-key",
+			Instance: Instance{
+				Metadata: Metadata{
+					PublicKeys: []string{"key1", "key2"},
+				},
+			},
+			Expect: "key2",
+		},
 		{
 			Name:     "PublicIPv4",
 			Endpoint: "/2009-04-04/meta-data/public-ipv4",
@@ -238,22 +339,32 @@ func TestFrontendStaticEndpoints(t *testing.T) {
 			Name:     "Root",
 			Endpoint: "/2009-04-04",
 			Expect: `meta-data/
-user-data`,
+user-data
+vendor-data`,
 		},
 		{
 			Name:     "Metadata",
 			Endpoint: "/2009-04-04/meta-data",
-			Expect: `facility
+			Expect: `ami-id
+ami-launch-index
+events/
+facility
 hostname
+instance-action
 instance-id
+instance-type
 iqn
 local-hostname
 local-ipv4
+mac
+network/
 operating-system/
+placement/
 plan
 public-ipv4
 public-ipv6
 public-keys
+services/
 tags`,
 		},
 		{
@@ -270,6 +381,22 @@ version`,
 			Endpoint: "/2009-04-04/meta-data/operating-system/license_activation",
 			Expect:   `state`,
 		},
+		{
+			Name:     "MetadataPlacement",
+			Endpoint: "/2009-04-04/meta-data/placement",
+			Expect: `availability-zone
+region`,
+		},
+		{
+			Name:     "MetadataNetwork",
+			Endpoint: "/2009-04-04/meta-data/network",
+			Expect:   `interfaces/`,
+		},
+		{
+			Name:     "MetadataNetworkInterfaces",
+			Endpoint: "/2009-04-04/meta-data/network/interfaces",
+			Expect:   `macs`,
+		},
 	}
 
 	for _, tc := range cases {
@@ -277,6 +404,12 @@ version`,
 			ctrl := gomock.NewController(t)
 			client := NewMockClient(ctrl)
 
+			// Listings containing conditional directories, such as spot, retrieve the instance.
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{}, nil).
+				AnyTimes()
+
 			router := gin.New()
 
 			fe := New(client)
@@ -289,6 +422,62 @@ version`,
 	}
 }
 
+func TestRootListing(t *testing.T) {
+	// Every form of the API version root, including the latest alias, serves the same listing.
+	endpoints := []string{
+		"/2009-04-04",
+		"/2009-04-04/",
+		"/latest",
+		"/latest/",
+	}
+
+	expect := `meta-data/
+user-data
+vendor-data`
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint, func(t *testing.T) {
+			// The root listing is the same for every instance so it doesn't retrieve one.
+			client := NewMockClient(gomock.NewController(t))
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			validate(t, router, endpoint, expect)
+		})
+	}
+}
+
+func TestLatestAPIVersion(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "userdata", Metadata: Metadata{Hostname: "hostname"}}, nil).
+		Times(2)
+
+	router := gin.New()
+	router.NoRoute(New(client).NotFound)
+
+	fe := New(client)
+	fe.Configure(router)
+
+	validate(t, router, "/latest/meta-data/hostname", "hostname")
+	validate(t, router, "/latest/user-data", "userdata")
+
+	// Unknown items beneath the latest prefix are unknown items rather than unsupported versions.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/latest/meta-data/bogus", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+}
+
 func validate(t *testing.T, router *gin.Engine, endpoint string, expect string) {
 	t.Helper()
 
@@ -310,39 +499,163 @@ func validate(t *testing.T, router *gin.Engine, endpoint string, expect string)
 	}
 }
 
-func Test404OnInstanceNotFound(t *testing.T) {
+func TestPublicKeysStableOrder(t *testing.T) {
+	instance := Instance{
+		Metadata: Metadata{
+			PublicKeys: []string{"key1", "key2", "key3"},
+		},
+	}
+
 	ctrl := gomock.NewController(t)
 	client := NewMockClient(ctrl)
 	client.EXPECT().
 		GetEC2Instance(gomock.Any(), gomock.Any()).
-		Return(Instance{}, ErrInstanceNotFound)
+		Return(instance, nil).
+		AnyTimes()
 
 	router := gin.New()
 
 	fe := New(client)
 	fe.Configure(router)
 
-	w := httptest.NewRecorder()
-	// Ensure we're using an dynamic endpoint else we won't trigger an instance lookup.
-	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+	// Repeated requests should consistently return the keys in the order they're defined on
+	// the instance for both the flat and indexed forms.
+	for i := 0; i < 5; i++ {
+		validate(t, router, "/2009-04-04/meta-data/public-keys", "key1\nkey2\nkey3")
+		for idx, key := range instance.Metadata.PublicKeys {
+			validate(t, router, fmt.Sprintf("/2009-04-04/meta-data/public-keys/%d/openssh-key", idx), key)
+		}
+	}
+}
 
-	// RemoteAddr must be valid for us to perform a lookup successfully. Because we're
-	// mocking the client the address value doesn't matter.
-	r.RemoteAddr = "10.10.10.10:0"
+func TestPublicKeysIndexNotFound(t *testing.T) {
+	cases := []string{"2", "-1", "foo"}
 
-	router.ServeHTTP(w, r)
+	for _, index := range cases {
+		t.Run(index, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{PublicKeys: []string{"key1", "key2"}}}, nil)
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("Expected: 404; Received: %d", w.Code)
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data/public-keys/"+index+"/openssh-key", nil)
+
+			// RemoteAddr must be valid for us to perform a lookup successfully. Because we're
+			// mocking the client the address value doesn't matter.
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("Expected: 404; Received: %d", w.Code)
+			}
+
+			if body := w.Body.String(); body != "public key not found: "+index {
+				t.Fatalf("Unexpected body: %q", body)
+			}
+		})
 	}
 }
 
-func Test500OnGenericError(t *testing.T) {
+func TestEmptyDirectories(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Endpoint     string
+		Accept       string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		{
+			Name:         "PublicKeys",
+			Endpoint:     "/2009-04-04/meta-data/public-keys",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "",
+		},
+		{
+			Name:         "PublicKeysTrailingSlash",
+			Endpoint:     "/2009-04-04/meta-data/public-keys/",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "",
+		},
+		{
+			Name:         "PublicKeysJSON",
+			Endpoint:     "/2009-04-04/meta-data/public-keys",
+			Accept:       "application/json",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "[]\n",
+		},
+		{
+			Name:         "PublicKeysRecursive",
+			Endpoint:     "/2009-04-04/meta-data/public-keys?recursive=true",
+			Accept:       "application/json",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "[]\n",
+		},
+		{
+			Name:         "PublicKeyIndex",
+			Endpoint:     "/2009-04-04/meta-data/public-keys/0",
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   "public key not found: 0",
+		},
+		{
+			Name:         "NetworkInterfaces",
+			Endpoint:     "/2009-04-04/meta-data/network/interfaces/macs",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "",
+		},
+		{
+			Name:         "UnknownPath",
+			Endpoint:     "/2009-04-04/meta-data/unknown",
+			ExpectStatus: http.StatusNotFound,
+			ExpectBody:   "404 page not found",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{InstanceID: "instance-id"}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.Header.Set("Accept", tc.Accept)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, body)
+			}
+		})
+	}
+}
+
+func Test404OnInstanceNotFound(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	client := NewMockClient(ctrl)
 	client.EXPECT().
 		GetEC2Instance(gomock.Any(), gomock.Any()).
-		Return(Instance{}, errors.New("generic error"))
+		Return(Instance{}, ErrInstanceNotFound)
 
 	router := gin.New()
 
@@ -359,37 +672,2893 @@ func Test500OnGenericError(t *testing.T) {
 
 	router.ServeHTTP(w, r)
 
-	if w.Code != http.StatusInternalServerError {
-		t.Fatalf("Expected: 500; Received: %d", w.Code)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+
+	if body := w.Body.String(); body != "no hardware found for source ip" {
+		t.Fatalf("Unexpected body: %q", body)
 	}
 }
 
-func Test400OnInvalidRemoteAddr(t *testing.T) {
-	cases := []string{
-		"invalid",
-		"",
+func TestNotFound(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Path       string
+		ExpectCode int
+		ExpectBody string
+	}{
+		{
+			Name:       "BogusOperatingSystemSubpath",
+			Path:       "/2009-04-04/meta-data/operating-system/bogus",
+			ExpectCode: http.StatusNotFound,
+			ExpectBody: "metadata item not found: /2009-04-04/meta-data/operating-system/bogus",
+		},
+		{
+			Name:       "BogusNestedOperatingSystemSubpath",
+			Path:       "/2009-04-04/meta-data/operating-system/license_activation/bogus",
+			ExpectCode: http.StatusNotFound,
+			ExpectBody: "metadata item not found: /2009-04-04/meta-data/operating-system/license_activation/bogus",
+		},
+		{
+			Name:       "KnownDirectoryWithoutTrailingSlash",
+			Path:       "/2009-04-04/meta-data/operating-system",
+			ExpectCode: http.StatusOK,
+			ExpectBody: "distro\nimage_tag\nlicense_activation/\nslug\nversion",
+		},
+		{
+			Name:       "OutsideAPIVersionPrefix",
+			Path:       "/bogus",
+			ExpectCode: http.StatusNotFound,
+			ExpectBody: "404 page not found",
+		},
+		{
+			Name:       "ValidVersionUnknownLeaf",
+			Path:       "/2009-04-04/meta-data/bogus",
+			ExpectCode: http.StatusNotFound,
+			ExpectBody: "metadata item not found: /2009-04-04/meta-data/bogus",
+		},
+		{
+			Name:       "UnsupportedVersion",
+			Path:       "/2021-01-03/meta-data/hostname",
+			ExpectCode: http.StatusBadRequest,
+			ExpectBody: "unsupported metadata API version: 2021-01-03",
+		},
+		{
+			Name:       "MalformedVersion",
+			Path:       "/2009-13-45",
+			ExpectCode: http.StatusBadRequest,
+			ExpectBody: "unsupported metadata API version: 2009-13-45",
+		},
+		{
+			Name:       "GarbageVersion",
+			Path:       "/garbage/meta-data/hostname",
+			ExpectCode: http.StatusBadRequest,
+			ExpectBody: "unsupported metadata API version: garbage",
+		},
+		{
+			Name:       "GarbageVersionUserData",
+			Path:       "/garbage/user-data",
+			ExpectCode: http.StatusBadRequest,
+			ExpectBody: "unsupported metadata API version: garbage",
+		},
 	}
 
-	for _, invalidIP := range cases {
-		ctrl := gomock.NewController(t)
-		client := NewMockClient(ctrl)
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
 
-		router := gin.New()
+			router := gin.New()
 
-		fe := New(client)
-		fe.Configure(router)
+			fe := New(client)
+			fe.Configure(router)
+			router.NoRoute(fe.NotFound)
 
-		w := httptest.NewRecorder()
-		// Ensure we're using an dynamic endpoint else we won't trigger an instance lookup.
-		r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Path, nil)
+			r.RemoteAddr = "10.10.10.10:0"
 
-		// Invalidate the RemoteAddr of the request.
-		r.RemoteAddr = invalidIP
+			router.ServeHTTP(w, r)
 
-		router.ServeHTTP(w, r)
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
 
-		if w.Code != http.StatusBadRequest {
-			t.Fatalf("Expected: 400; Received: %d", w.Code)
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Unexpected body: %q", body)
+			}
+		})
+	}
+}
+
+func TestNotFoundParentListing(t *testing.T) {
+	const operatingSystem = "distro\nimage_tag\nlicense_activation/\nslug\nversion"
+
+	cases := []struct {
+		Name             string
+		Path             string
+		ExpectStrictCode int
+		ExpectStrictBody string
+		ExpectCode       int
+		ExpectBody       string
+	}{
+		{
+			Name:             "UnknownLeaf",
+			Path:             "/2009-04-04/meta-data/operating-system/bogus",
+			ExpectStrictCode: http.StatusNotFound,
+			ExpectStrictBody: "metadata item not found: /2009-04-04/meta-data/operating-system/bogus",
+			ExpectCode:       http.StatusOK,
+			ExpectBody:       operatingSystem,
+		},
+		{
+			Name:             "UnknownLeafTrailingSlash",
+			Path:             "/2009-04-04/meta-data/operating-system/bogus/",
+			ExpectStrictCode: http.StatusNotFound,
+			ExpectStrictBody: "metadata item not found: /2009-04-04/meta-data/operating-system/bogus/",
+			ExpectCode:       http.StatusOK,
+			ExpectBody:       operatingSystem,
+		},
+		{
+			Name:             "UnknownNestedLeaf",
+			Path:             "/2009-04-04/meta-data/operating-system/license_activation/bogus",
+			ExpectStrictCode: http.StatusNotFound,
+			ExpectStrictBody: "metadata item not found: /2009-04-04/meta-data/operating-system/license_activation/bogus",
+			ExpectCode:       http.StatusOK,
+			ExpectBody:       "state",
+		},
+		{
+			// The nearest directory is used when the parent is unknown too.
+			Name:             "UnknownDirectory",
+			Path:             "/2009-04-04/meta-data/operating-system/bogus/bogus",
+			ExpectStrictCode: http.StatusNotFound,
+			ExpectStrictBody: "metadata item not found: /2009-04-04/meta-data/operating-system/bogus/bogus",
+			ExpectCode:       http.StatusOK,
+			ExpectBody:       operatingSystem,
+		},
+		{
+			Name:             "UnsupportedVersion",
+			Path:             "/2021-01-03/meta-data/operating-system/bogus",
+			ExpectStrictCode: http.StatusBadRequest,
+			ExpectStrictBody: "unsupported metadata API version: 2021-01-03",
+			ExpectCode:       http.StatusBadRequest,
+			ExpectBody:       "unsupported metadata API version: 2021-01-03",
+		},
+	}
+
+	for _, tc := range cases {
+		for _, mode := range []struct {
+			Name       string
+			Enabled    bool
+			ExpectCode int
+			ExpectBody string
+		}{
+			{Name: "Strict", ExpectCode: tc.ExpectStrictCode, ExpectBody: tc.ExpectStrictBody},
+			{Name: "Lenient", Enabled: true, ExpectCode: tc.ExpectCode, ExpectBody: tc.ExpectBody},
+		} {
+			t.Run(tc.Name+"/"+mode.Name, func(t *testing.T) {
+				ctrl := gomock.NewController(t)
+				client := NewMockClient(ctrl)
+
+				router := gin.New()
+
+				fe := New(client, WithParentListing(mode.Enabled))
+				fe.Configure(router)
+				router.NoRoute(fe.NotFound)
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", tc.Path, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != mode.ExpectCode {
+					t.Fatalf("Expected: %d; Received: %d", mode.ExpectCode, w.Code)
+				}
+
+				if body := w.Body.String(); body != mode.ExpectBody {
+					t.Fatalf("Unexpected body: %q", body)
+				}
+			})
 		}
 	}
 }
+
+func TestClientDisconnected(t *testing.T) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+
+	// Simulate the client disconnecting while the backend is retrieving the instance.
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(context.Context, string) (Instance, error) {
+			cancel()
+			return Instance{Metadata: Metadata{Hostname: "hostname"}}, nil
+		})
+
+	router := gin.New()
+
+	var errs []*gin.Error
+	router.Use(func(ctx *gin.Context) {
+		ctx.Next()
+		errs = ctx.Errors
+	})
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil).WithContext(reqCtx)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != 499 {
+		t.Fatalf("Expected: 499; Received: %d", w.Code)
+	}
+
+	if body := w.Body.String(); body != "" {
+		t.Fatalf("Expected empty body; Received: %q", body)
+	}
+
+	if len(errs) != 1 || !errors.Is(errs[0], context.Canceled) {
+		t.Fatalf("Expected context.Canceled error; Received: %v", errs)
+	}
+}
+
+func Test500OnGenericError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{}, errors.New("generic error"))
+
+	router := gin.New()
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	// Ensure we're using an dynamic endpoint else we won't trigger an instance lookup.
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+
+	// RemoteAddr must be valid for us to perform a lookup successfully. Because we're
+	// mocking the client the address value doesn't matter.
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected: 500; Received: %d", w.Code)
+	}
+
+	// Backend errors may contain internal details so shouldn't be served to clients.
+	if body := w.Body.String(); body != "failed to retrieve instance" {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+}
+
+func Test400OnInvalidRemoteAddr(t *testing.T) {
+	cases := []string{
+		"invalid",
+		"",
+		// Remote addresses with a port but no usable IP.
+		":0",
+		"invalid:0",
+	}
+
+	for _, invalidIP := range cases {
+		ctrl := gomock.NewController(t)
+		client := NewMockClient(ctrl)
+
+		registry := prometheus.NewRegistry()
+
+		router := gin.New()
+		router.Use(metrics.InstrumentErrors(registry))
+
+		fe := New(client)
+		fe.Configure(router)
+
+		w := httptest.NewRecorder()
+		// Ensure we're using an dynamic endpoint else we won't trigger an instance lookup.
+		r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+
+		// Invalidate the RemoteAddr of the request.
+		r.RemoteAddr = invalidIP
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected: 400; Received: %d (RemoteAddr=%q)", w.Code, invalidIP)
+		}
+
+		if body := w.Body.String(); body != "unable to determine the source IP of the request" {
+			t.Fatalf("Unexpected body: %q", body)
+		}
+
+		expect := `
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="metadata",kind="request"} 1
+`
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_errors_total"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test500OnFilterError(t *testing.T) {
+	restore := SetFilter("/meta-data/hostname", func(Instance) (string, error) {
+		return "", errors.New("filter error")
+	})
+	defer restore()
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{}, nil)
+
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	router.Use(metrics.InstrumentErrors(registry))
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+
+	// RemoteAddr must be valid for us to perform a lookup successfully. Because we're
+	// mocking the client the address value doesn't matter.
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected: 500; Received: %d", w.Code)
+	}
+
+	// The body should describe the failure without exposing the filter error.
+	if body := w.Body.String(); body != "failed to produce data for /2009-04-04/meta-data/hostname" {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+
+	expect := `
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="metadata",kind="filter"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Hostname string
+		Defaults map[string]string
+		Expect   string
+	}{
+		{
+			Name:     "EmptyWithDefault",
+			Defaults: map[string]string{"/meta-data/hostname": "unknown"},
+			Expect:   "unknown",
+		},
+		{
+			Name:     "PresentWithDefault",
+			Hostname: "worker-1",
+			Defaults: map[string]string{"/meta-data/hostname": "unknown"},
+			Expect:   "worker-1",
+		},
+		{
+			Name:     "EmptyWithDefaultForOtherEndpoint",
+			Defaults: map[string]string{"/meta-data/local-hostname": "unknown"},
+			Expect:   "",
+		},
+		{
+			Name:   "EmptyWithoutDefaults",
+			Expect: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+
+			var instance Instance
+			instance.Metadata.Hostname = tc.Hostname
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(instance, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithDefaults(tc.Defaults))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if body := w.Body.String(); body != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, body)
+			}
+		})
+	}
+}
+
+func TestPathAliases(t *testing.T) {
+	aliases := map[string]string{
+		"/meta-data/host-name": "/meta-data/hostname",
+		"/user_data":           "/user-data",
+	}
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Defaults map[string]string
+		Instance Instance
+		Expect   string
+	}{
+		{
+			Name:     "Canonical",
+			Endpoint: "/2009-04-04/meta-data/hostname",
+			Instance: Instance{Metadata: Metadata{Hostname: "worker-1"}},
+			Expect:   "worker-1",
+		},
+		{
+			Name:     "Alias",
+			Endpoint: "/2009-04-04/meta-data/host-name",
+			Instance: Instance{Metadata: Metadata{Hostname: "worker-1"}},
+			Expect:   "worker-1",
+		},
+		{
+			Name:     "AliasWithDefault",
+			Endpoint: "/2009-04-04/meta-data/host-name",
+			Defaults: map[string]string{"/meta-data/hostname": "unknown"},
+			Expect:   "unknown",
+		},
+		{
+			Name:     "AliasUserData",
+			Endpoint: "/2009-04-04/user_data?encoding=base64",
+			Instance: Instance{Userdata: "#!/bin/sh\n"},
+			Expect:   base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(tc.Instance, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithPathAliases(aliases), WithDefaults(tc.Defaults))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if body := w.Body.String(); body != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, body)
+			}
+		})
+	}
+}
+
+func TestPathAliasesOmittedFromListings(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Metadata: Metadata{Hostname: "worker-1"}}, nil)
+
+	router := gin.New()
+
+	fe := New(client, WithPathAliases(map[string]string{"/meta-data/host-name": "/meta-data/hostname"}))
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	if strings.Contains(w.Body.String(), "host-name") {
+		t.Fatalf("Expected alias to be omitted from listing: %q", w.Body.String())
+	}
+}
+
+func TestValidatePathAliases(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Aliases     map[string]string
+		ExpectError bool
+	}{
+		{
+			Name:    "Valid",
+			Aliases: map[string]string{"/meta-data/host-name": "/meta-data/hostname"},
+		},
+		{
+			Name: "None",
+		},
+		{
+			Name:        "UnknownEndpoint",
+			Aliases:     map[string]string{"/meta-data/host-name": "/meta-data/bogus"},
+			ExpectError: true,
+		},
+		{
+			Name:        "ParameterizedEndpoint",
+			Aliases:     map[string]string{"/meta-data/key": "/meta-data/public-keys/:index"},
+			ExpectError: true,
+		},
+		{
+			Name:        "ExistingEndpoint",
+			Aliases:     map[string]string{"/meta-data/local-hostname": "/meta-data/hostname"},
+			ExpectError: true,
+		},
+		{
+			Name:        "ExistingDirectory",
+			Aliases:     map[string]string{"/meta-data/placement": "/meta-data/hostname"},
+			ExpectError: true,
+		},
+		{
+			Name:        "ServedByParameterizedEndpoint",
+			Aliases:     map[string]string{"/meta-data/public-keys/0": "/meta-data/hostname"},
+			ExpectError: true,
+		},
+		{
+			Name:        "Relative",
+			Aliases:     map[string]string{"meta-data/host-name": "/meta-data/hostname"},
+			ExpectError: true,
+		},
+		{
+			Name:        "Parameter",
+			Aliases:     map[string]string{"/meta-data/:name": "/meta-data/hostname"},
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidatePathAliases(tc.Aliases)
+			if tc.ExpectError != (err != nil) {
+				t.Fatalf("Expected error: %v; Received: %v", tc.ExpectError, err)
+			}
+		})
+	}
+}
+
+func TestUserDataMultipartMerge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{
+			UserdataFragments: []string{"#cloud-config\npackages:\n  - curl\n"},
+			Userdata:          "#!/bin/sh\necho hello\n",
+		}, nil)
+
+	router := gin.New()
+
+	fe := New(client, WithUserDataContentTypeSniffing(true))
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "multipart/mixed" {
+		t.Fatalf("Expected Content-Type: multipart/mixed; Received: %v", ct)
+	}
+
+	// Parse the body as cloud-init would, as a MIME message.
+	msg, err := mail.ReadMessage(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/mixed" {
+		t.Fatalf("Expected: multipart/mixed; Received: %v", mediaType)
+	}
+
+	type part struct {
+		ContentType string
+		Body        string
+	}
+	var parts []part
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part{ContentType: p.Header.Get("Content-Type"), Body: string(body)})
+	}
+
+	expect := []part{
+		{ContentType: "text/cloud-config", Body: "#cloud-config\npackages:\n  - curl\n"},
+		{ContentType: "text/x-shellscript", Body: "#!/bin/sh\necho hello\n"},
+	}
+	if !cmp.Equal(parts, expect) {
+		t.Fatal(cmp.Diff(expect, parts))
+	}
+}
+
+func TestUserDataConcatMerge(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Instance Instance
+		Expect   string
+	}{
+		{
+			Name: "TwoFragments",
+			Instance: Instance{
+				UserdataFragments: []string{"#!/bin/sh\necho base", "echo node\n"},
+				Userdata:          "echo override",
+			},
+			Expect: "#!/bin/sh\necho base\necho node\necho override\n",
+		},
+		{
+			Name: "EmptyUserdata",
+			Instance: Instance{
+				UserdataFragments: []string{"#!/bin/sh\necho base"},
+			},
+			Expect: "#!/bin/sh\necho base",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(tc.Instance, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithUserDataMerge(UserDataMergeConcat))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if body := w.Body.String(); body != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, body)
+			}
+		})
+	}
+}
+
+func TestUserDataTemplates(t *testing.T) {
+	instance := Instance{
+		Userdata: "#cloud-config\nhostname: {{ .Hostname }}\n",
+		Metadata: Metadata{InstanceID: "i-sm01", Hostname: "sm01"},
+	}
+
+	cases := []struct {
+		Name         string
+		Options      []Option
+		Endpoint     string
+		Userdata     string
+		ExpectStatus int
+		ExpectBody   string
+		// ExpectTreeUserData is the user-data expected in recursive responses in place of
+		// ExpectBody.
+		ExpectTreeUserData string
+	}{
+		{
+			Name:         "Hostname",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/user-data",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "#cloud-config\nhostname: sm01\n",
+		},
+		{
+			Name:         "InstanceID",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/user-data",
+			Userdata:     "#!/bin/sh\necho {{ .InstanceID }}\n",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "#!/bin/sh\necho i-sm01\n",
+		},
+		{
+			Name:         "Base64Encoded",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/user-data?encoding=base64",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "I2Nsb3VkLWNvbmZpZwpob3N0bmFtZTogc20wMQo=",
+		},
+		{
+			Name:               "Recursive",
+			Options:            []Option{WithUserDataTemplates(true)},
+			Endpoint:           "/2009-04-04?recursive=true",
+			Userdata:           "{{ .Hostname }}",
+			ExpectStatus:       http.StatusOK,
+			ExpectTreeUserData: "sm01",
+		},
+		{
+			Name:         "HotPath",
+			Options:      []Option{WithUserDataTemplates(true), WithHotPathTTL(time.Minute)},
+			Endpoint:     "/2009-04-04/user-data",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "#cloud-config\nhostname: sm01\n",
+		},
+		{
+			Name:         "Disabled",
+			Endpoint:     "/2009-04-04/user-data",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "#cloud-config\nhostname: {{ .Hostname }}\n",
+		},
+		{
+			Name:         "BadReference",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/user-data",
+			Userdata:     "#cloud-config\nhostname: {{ .Hostname }}\nfqdn: {{ .Bogus }}\n",
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectBody: "failed to render user-data template: template: user-data:3:9: executing \"user-data\" at <.Bogus>: " +
+				"can't evaluate field Bogus in type ec2.Metadata",
+		},
+		{
+			Name:         "Malformed",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/user-data",
+			Userdata:     "hostname: {{ .Hostname",
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectBody:   "failed to render user-data template: template: user-data:1: unclosed action",
+		},
+		{
+			Name:         "BadReferenceRecursive",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04?recursive=true",
+			Userdata:     "{{ .Bogus }}",
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectBody:   "failed to produce data for /2009-04-04",
+		},
+		{
+			// Only user-data is a template.
+			Name:         "OtherEndpoint",
+			Options:      []Option{WithUserDataTemplates(true)},
+			Endpoint:     "/2009-04-04/meta-data/hostname",
+			Userdata:     "{{ .Bogus }}",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   "sm01",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			instance := instance
+			if tc.Userdata != "" {
+				instance.Userdata = tc.Userdata
+			}
+
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(instance, nil)
+
+			router := gin.New()
+			New(client, tc.Options...).Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if tc.ExpectTreeUserData != "" {
+				var tree map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+					t.Fatal(err)
+				}
+				if tree["user-data"] != tc.ExpectTreeUserData {
+					t.Fatalf("Expected: %v; Received: %v", tc.ExpectTreeUserData, tree["user-data"])
+				}
+				return
+			}
+
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, body)
+			}
+		})
+	}
+}
+
+func TestUserDataTemplatesChanged(t *testing.T) {
+	// Parsed templates are reused per instance until its user-data changes.
+	userdata := []string{"{{ .Hostname }}", "{{ .Hostname }}", "{{ .InstanceID }}"}
+	expect := []string{"sm01", "sm01", "i-sm01"}
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	for _, u := range userdata {
+		client.EXPECT().
+			GetEC2Instance(gomock.Any(), gomock.Any()).
+			Return(Instance{Userdata: u, Metadata: Metadata{InstanceID: "i-sm01", Hostname: "sm01"}}, nil)
+	}
+
+	router := gin.New()
+	New(client, WithUserDataTemplates(true)).Configure(router)
+
+	for i := range userdata {
+		validate(t, router, "/2009-04-04/user-data", expect[i])
+	}
+}
+
+func TestUserDataTemplatesSignature(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{
+			Userdata: "hostname: {{ .Hostname }}",
+			Metadata: Metadata{InstanceID: "i-sm01", Hostname: "sm01"},
+		}, nil)
+
+	router := gin.New()
+	New(client, WithUserDataSigningKey(key), WithUserDataTemplates(true)).Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/user-data.sig", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	signature, err := base64.StdEncoding.DecodeString(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signatures cover the user-data as served.
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), []byte("i-sm01\n/user-data\nhostname: sm01"), signature) {
+		t.Fatalf("Signature doesn't verify: %v", w.Body.String())
+	}
+}
+
+func TestUserAgentPhases(t *testing.T) {
+	instance := Instance{
+		Userdata: "#cloud-config\nhostname: sm01\n",
+		UserdataPhases: map[string]string{
+			"ipxe": "#!ipxe\nchain http://boot.example.com/auto.ipxe\n",
+		},
+	}
+
+	phases := []UserAgentPhase{
+		{Pattern: regexp.MustCompile(`^iPXE/`), Phase: "ipxe"},
+		{Pattern: regexp.MustCompile(`^Cloud-Init/`), Phase: "os"},
+	}
+
+	cases := []struct {
+		Name       string
+		Options    []Option
+		UserAgent  string
+		ExpectBody string
+	}{
+		{
+			Name:       "IPXE",
+			Options:    []Option{WithUserAgentPhases(phases)},
+			UserAgent:  "iPXE/1.21.1",
+			ExpectBody: "#!ipxe\nchain http://boot.example.com/auto.ipxe\n",
+		},
+		{
+			// The instance has no user-data for the os phase so is served its user-data.
+			Name:       "CloudInit",
+			Options:    []Option{WithUserAgentPhases(phases)},
+			UserAgent:  "Cloud-Init/23.1.2",
+			ExpectBody: "#cloud-config\nhostname: sm01\n",
+		},
+		{
+			Name:       "NoMatch",
+			Options:    []Option{WithUserAgentPhases(phases)},
+			UserAgent:  "curl/8.0.1",
+			ExpectBody: "#cloud-config\nhostname: sm01\n",
+		},
+		{
+			Name:       "Disabled",
+			UserAgent:  "iPXE/1.21.1",
+			ExpectBody: "#cloud-config\nhostname: sm01\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(instance, nil)
+
+			router := gin.New()
+			New(client, tc.Options...).Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			r.Header.Set("User-Agent", tc.UserAgent)
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, body)
+			}
+		})
+	}
+}
+
+func TestUserAgentPhasesHotPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{
+			Userdata:       "#cloud-config\n",
+			UserdataPhases: map[string]string{"ipxe": "#!ipxe\n"},
+		}, nil).
+		Times(2)
+
+	phases := []UserAgentPhase{{Pattern: regexp.MustCompile(`^iPXE/`), Phase: "ipxe"}}
+
+	router := gin.New()
+	New(client, WithUserAgentPhases(phases), WithHotPathTTL(time.Minute)).Configure(router)
+
+	get := func(userAgent string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		r.Header.Set("User-Agent", userAgent)
+		router.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	// Data cached for one phase isn't served to requests in another so each retrieves the
+	// instance once.
+	for i := 0; i < 2; i++ {
+		if body := get("iPXE/1.21.1"); body != "#!ipxe\n" {
+			t.Fatalf("Expected: %q; Received: %q", "#!ipxe\n", body)
+		}
+		if body := get("Cloud-Init/23.1.2"); body != "#cloud-config\n" {
+			t.Fatalf("Expected: %q; Received: %q", "#cloud-config\n", body)
+		}
+	}
+}
+
+func TestValidateUserAgentPhases(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Phases []UserAgentPhase
+		Valid  bool
+	}{
+		{
+			Name:   "Valid",
+			Phases: []UserAgentPhase{{Pattern: regexp.MustCompile("iPXE"), Phase: "ipxe"}},
+			Valid:  true,
+		},
+		{
+			Name:  "None",
+			Valid: true,
+		},
+		{
+			Name:   "NoPattern",
+			Phases: []UserAgentPhase{{Phase: "ipxe"}},
+		},
+		{
+			Name:   "NoPhase",
+			Phases: []UserAgentPhase{{Pattern: regexp.MustCompile("iPXE")}},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateUserAgentPhases(tc.Phases)
+			if tc.Valid && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tc.Valid && err == nil {
+				t.Fatal("Expected an error")
+			}
+		})
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Facility     string
+		Region       string
+		Regions      map[string]string
+		ExpectAZ     string
+		ExpectRegion string
+	}{
+		{
+			Name:         "MappedFacility",
+			Facility:     "sv15",
+			Regions:      map[string]string{"sv15": "us-west"},
+			ExpectAZ:     "sv15",
+			ExpectRegion: "us-west",
+		},
+		{
+			Name:         "EC2StyleAvailabilityZone",
+			Facility:     "us-east-1a",
+			ExpectAZ:     "us-east-1a",
+			ExpectRegion: "us-east-1",
+		},
+		{
+			Name:         "UnmappedFacility",
+			Facility:     "sv15",
+			Regions:      map[string]string{"da11": "us-central"},
+			ExpectAZ:     "sv15",
+			ExpectRegion: "sv15",
+		},
+		{
+			Name:         "BackendRegion",
+			Facility:     "sv15",
+			Region:       "us-west-2",
+			Regions:      map[string]string{"sv15": "us-west"},
+			ExpectAZ:     "sv15",
+			ExpectRegion: "us-west-2",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Facility: tc.Facility, Region: tc.Region}}, nil).
+				Times(2)
+
+			router := gin.New()
+
+			fe := New(client, WithFacilityRegions(tc.Regions))
+			fe.Configure(router)
+
+			for endpoint, expect := range map[string]string{
+				"/2009-04-04/meta-data/placement/availability-zone": tc.ExpectAZ,
+				"/2009-04-04/meta-data/placement/region":            tc.ExpectRegion,
+			} {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", endpoint, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("Expected: 200; Received: %d (Endpoint=%v)", w.Code, endpoint)
+				}
+
+				if body := w.Body.String(); body != expect {
+					t.Fatalf("Expected: %q; Received: %q (Endpoint=%v)", expect, body, endpoint)
+				}
+			}
+		})
+	}
+}
+
+func TestNetworkInterfaces(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Interfaces []NetworkInterface
+		Expect     map[string]string
+		NotFound   []string
+	}{
+		{
+			Name: "OneInterface",
+			Interfaces: []NetworkInterface{
+				{MAC: "3C:EC:EF:4C:4F:54", LocalIPv4s: []string{"10.10.10.10"}, PublicIPv4s: []string{"139.178.0.10"}},
+			},
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/mac":                                                     "3c:ec:ef:4c:4f:54",
+				"/2009-04-04/meta-data/network/interfaces/macs":                                 "3c:ec:ef:4c:4f:54/",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54":               "device-number\nlocal-ipv4s\nmac\npublic-ipv4s",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/mac":           "3c:ec:ef:4c:4f:54",
+				"/2009-04-04/meta-data/network/interfaces/macs/3C:EC:EF:4C:4F:54/mac":           "3c:ec:ef:4c:4f:54",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/device-number": "0",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/local-ipv4s":   "10.10.10.10",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/public-ipv4s":  "139.178.0.10",
+			},
+			NotFound: []string{
+				"/2009-04-04/meta-data/network/interfaces/macs/00:00:00:00:00:00/mac",
+				"/2009-04-04/meta-data/network/interfaces/macs/invalid/mac",
+			},
+		},
+		{
+			Name: "TwoInterfaces",
+			Interfaces: []NetworkInterface{
+				{MAC: "3c:ec:ef:4c:4f:54", LocalIPv4s: []string{"10.10.10.10"}, PublicIPv4s: []string{"139.178.0.10"}},
+				{MAC: "3c:ec:ef:4c:4f:55", LocalIPv4s: []string{"10.10.20.10", "10.10.20.11"}},
+			},
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/mac":                                                     "3c:ec:ef:4c:4f:54",
+				"/2009-04-04/meta-data/network/interfaces/macs":                                 "3c:ec:ef:4c:4f:54/\n3c:ec:ef:4c:4f:55/",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:54/device-number": "0",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:55/device-number": "1",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:55/mac":           "3c:ec:ef:4c:4f:55",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:55/local-ipv4s":   "10.10.20.10\n10.10.20.11",
+				"/2009-04-04/meta-data/network/interfaces/macs/3c:ec:ef:4c:4f:55/public-ipv4s":  "",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Interfaces: tc.Interfaces}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			for endpoint, expect := range tc.Expect {
+				validate(t, router, endpoint, expect)
+			}
+
+			for _, endpoint := range tc.NotFound {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", endpoint, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != http.StatusNotFound {
+					t.Fatalf("Expected: 404; Received: %d (Endpoint=%v)", w.Code, endpoint)
+				}
+			}
+		})
+	}
+}
+
+func TestSpot(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Spot      *Spot
+		ListsSpot bool
+		Expect    map[string]string
+		NotFound  []string
+	}{
+		{
+			Name: "SpotWithTerminationTime",
+			Spot: &Spot{
+				TerminationTime: time.Date(2015, time.January, 5, 10, 2, 0, 0, time.FixedZone("PST", -8*60*60)),
+			},
+			ListsSpot: true,
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/spot":                  "termination-time",
+				"/2009-04-04/meta-data/spot/termination-time": "2015-01-05T18:02:00Z",
+			},
+		},
+		{
+			Name:      "SpotWithoutTerminationTime",
+			Spot:      &Spot{},
+			ListsSpot: true,
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/spot": "termination-time",
+			},
+			NotFound: []string{
+				"/2009-04-04/meta-data/spot/termination-time",
+			},
+		},
+		{
+			Name: "NotSpot",
+			NotFound: []string{
+				"/2009-04-04/meta-data/spot",
+				"/2009-04-04/meta-data/spot/",
+				"/2009-04-04/meta-data/spot?recursive=true",
+				"/2009-04-04/meta-data/spot/termination-time",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Spot: tc.Spot}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			for endpoint, expect := range tc.Expect {
+				validate(t, router, endpoint, expect)
+			}
+
+			for _, endpoint := range tc.NotFound {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", endpoint, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != http.StatusNotFound {
+					t.Fatalf("Expected: 404; Received: %d (Endpoint=%v)", w.Code, endpoint)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(w, r)
+
+			listed := false
+			for _, child := range strings.Split(w.Body.String(), "\n") {
+				listed = listed || child == "spot/"
+			}
+			if listed != tc.ListsSpot {
+				t.Fatalf("Expected spot/ listed: %v; Received: %v", tc.ListsSpot, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestBlockDeviceMapping(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Disks      []string
+		ListsDisks bool
+		Expect     map[string]string
+		NotFound   []string
+	}{
+		{
+			Name:       "OneDisk",
+			Disks:      []string{"/dev/sda"},
+			ListsDisks: true,
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/block-device-mapping":      "ami\nroot",
+				"/2009-04-04/meta-data/block-device-mapping/ami":  "/dev/sda",
+				"/2009-04-04/meta-data/block-device-mapping/root": "/dev/sda",
+			},
+			NotFound: []string{
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral0",
+			},
+		},
+		{
+			Name:       "ThreeDisks",
+			Disks:      []string{"/dev/nvme0n1", "/dev/sda", "/dev/sdb"},
+			ListsDisks: true,
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/block-device-mapping":            "ami\nephemeral0\nephemeral1\nroot",
+				"/2009-04-04/meta-data/block-device-mapping/ami":        "/dev/nvme0n1",
+				"/2009-04-04/meta-data/block-device-mapping/root":       "/dev/nvme0n1",
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral0": "/dev/sda",
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral1": "/dev/sdb",
+			},
+			NotFound: []string{
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral2",
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral-1",
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral01",
+				"/2009-04-04/meta-data/block-device-mapping/swap",
+			},
+		},
+		{
+			Name: "NoDisks",
+			NotFound: []string{
+				"/2009-04-04/meta-data/block-device-mapping",
+				"/2009-04-04/meta-data/block-device-mapping/",
+				"/2009-04-04/meta-data/block-device-mapping/ami",
+				"/2009-04-04/meta-data/block-device-mapping/root",
+				"/2009-04-04/meta-data/block-device-mapping/ephemeral0",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Disks: tc.Disks}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			for endpoint, expect := range tc.Expect {
+				validate(t, router, endpoint, expect)
+			}
+
+			for _, endpoint := range tc.NotFound {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", endpoint, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != http.StatusNotFound {
+					t.Fatalf("Expected: 404; Received: %d (Endpoint=%v)", w.Code, endpoint)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(w, r)
+
+			listed := false
+			for _, child := range strings.Split(w.Body.String(), "\n") {
+				listed = listed || child == "block-device-mapping"
+			}
+			if listed != tc.ListsDisks {
+				t.Fatalf("Expected block-device-mapping listed: %v; Received: %v", tc.ListsDisks, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInstanceAction(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Action string
+		Expect map[string]string
+	}{
+		{
+			Name: "Default",
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/instance-action":              "none",
+				"/2009-04-04/meta-data/events/maintenance":           "scheduled",
+				"/2009-04-04/meta-data/events/maintenance/scheduled": "[]",
+			},
+		},
+		{
+			Name:   "Reboot",
+			Action: "reboot",
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/instance-action": "reboot",
+				"/2009-04-04/meta-data/events/maintenance/scheduled": `[{"Code":"instance-reboot",` +
+					`"Description":"The instance is scheduled for reboot","State":"active"}]`,
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{InstanceAction: tc.Action}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			for endpoint, expect := range tc.Expect {
+				validate(t, router, endpoint, expect)
+			}
+		})
+	}
+}
+
+func TestServices(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Options  []Option
+		Services Services
+		Expect   map[string]string
+	}{
+		{
+			Name: "Default",
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/services":           "domain\npartition",
+				"/2009-04-04/meta-data/services/domain":    "amazonaws.com",
+				"/2009-04-04/meta-data/services/partition": "aws",
+			},
+		},
+		{
+			Name:    "Configured",
+			Options: []Option{WithServices("tinkerbell.local", "tinkerbell")},
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/services/domain":    "tinkerbell.local",
+				"/2009-04-04/meta-data/services/partition": "tinkerbell",
+			},
+		},
+		{
+			Name:    "ConfiguredPartitionOnly",
+			Options: []Option{WithServices("", "tinkerbell")},
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/services/domain":    "amazonaws.com",
+				"/2009-04-04/meta-data/services/partition": "tinkerbell",
+			},
+		},
+		{
+			Name:     "Instance",
+			Options:  []Option{WithServices("tinkerbell.local", "tinkerbell")},
+			Services: Services{Domain: "example.com"},
+			Expect: map[string]string{
+				"/2009-04-04/meta-data/services/domain":    "example.com",
+				"/2009-04-04/meta-data/services/partition": "tinkerbell",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Services: tc.Services}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client, tc.Options...)
+			fe.Configure(router)
+
+			for endpoint, expect := range tc.Expect {
+				validate(t, router, endpoint, expect)
+			}
+		})
+	}
+}
+
+func TestUserDataEncoding(t *testing.T) {
+	const userdata = "#cloud-config\nhostname: sm01\n"
+
+	cases := []struct {
+		Name         string
+		Query        string
+		ExpectStatus int
+		ExpectBody   string
+	}{
+		{
+			Name:         "Default",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   userdata,
+		},
+		{
+			Name:         "Raw",
+			Query:        "?encoding=raw",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   userdata,
+		},
+		{
+			Name:         "Base64",
+			Query:        "?encoding=base64",
+			ExpectStatus: http.StatusOK,
+			ExpectBody:   base64.StdEncoding.EncodeToString([]byte(userdata)),
+		},
+		{
+			Name:         "Unknown",
+			Query:        "?encoding=hex",
+			ExpectStatus: http.StatusBadRequest,
+			ExpectBody:   "unsupported encoding: hex",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Userdata: userdata}, nil)
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/user-data"+tc.Query, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, body)
+			}
+		})
+	}
+}
+
+func TestUserDataContentType(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Userdata string
+		Sniff    bool
+		Expect   string
+	}{
+		{
+			Name:     "ShellScript",
+			Userdata: "#!/bin/sh\necho hello",
+			Sniff:    true,
+			Expect:   "text/x-shellscript",
+		},
+		{
+			Name:     "CloudConfig",
+			Userdata: "#cloud-config\npackages:\n  - curl",
+			Sniff:    true,
+			Expect:   "text/cloud-config",
+		},
+		{
+			Name:     "Ignition",
+			Userdata: `{"ignition": {"version": "3.3.0"}}`,
+			Sniff:    true,
+			Expect:   "application/vnd.coreos.ignition+json",
+		},
+		{
+			Name:     "JSON",
+			Userdata: `{"foo": "bar"}`,
+			Sniff:    true,
+			Expect:   "application/json",
+		},
+		{
+			Name:     "Unrecognized",
+			Userdata: "userdata",
+			Sniff:    true,
+			Expect:   "text/plain; charset=utf-8",
+		},
+		{
+			Name:     "SniffingDisabled",
+			Userdata: "#!/bin/sh\necho hello",
+			Expect:   "text/plain; charset=utf-8",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Userdata: tc.Userdata}, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithUserDataContentTypeSniffing(tc.Sniff))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != tc.Expect {
+				t.Fatalf("Expected Content-Type: %v; Received: %v", tc.Expect, ct)
+			}
+
+			if w.Body.String() != tc.Userdata {
+				t.Fatalf("Expected body: %v; Received: %v", tc.Userdata, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestFilterRequestVars(t *testing.T) {
+	instance := Instance{
+		Metadata: Metadata{
+			LocalIPv4:  "10.10.10.10",
+			PublicIPv4: "192.168.0.10",
+		},
+	}
+
+	// The filter selects the instance address the request was made from.
+	remove := AddRequestRoute("/meta-data/client-address", func(i Instance, clientIP, path string) (string, error) {
+		if path != "/2009-04-04/meta-data/client-address" {
+			return "", fmt.Errorf("unexpected path: %v", path)
+		}
+		for _, addr := range []string{i.Metadata.LocalIPv4, i.Metadata.PublicIPv4} {
+			if addr == clientIP {
+				return addr, nil
+			}
+		}
+		return "", errors.New("no matching address")
+	})
+	defer remove()
+
+	for _, clientIP := range []string{"10.10.10.10", "192.168.0.10"} {
+		t.Run(clientIP, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), clientIP).
+				Return(instance, nil)
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data/client-address", nil)
+			r.RemoteAddr = clientIP + ":0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if w.Body.String() != clientIP {
+				t.Fatalf("Expected: %v; Received: %v", clientIP, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestDirectoryListingsSorted(t *testing.T) {
+	endpoints := []string{
+		"/2009-04-04",
+		"/2009-04-04/meta-data",
+		"/2009-04-04/meta-data/operating-system",
+	}
+
+	for _, endpoint := range endpoints {
+		t.Run(endpoint, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", endpoint, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			listing := strings.Split(w.Body.String(), "\n")
+			if !sort.StringsAreSorted(listing) {
+				t.Fatalf("Expected sorted listing; Received: %v", listing)
+			}
+		})
+	}
+}
+
+func TestIdentityStrategyMAC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+
+	// Requests identified by MAC are looked up by MAC without consulting the source IP.
+	client.EXPECT().
+		GetEC2InstanceByMAC(gomock.Any(), "3c:ec:ef:4c:4f:54").
+		Return(Instance{Metadata: Metadata{InstanceID: "by-mac"}}, nil)
+
+	strategy, err := identity.MACHeader("X-Hegel-MAC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(identity.Middleware(strategy, identity.SourceIP()))
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/instance-id", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	r.Header.Set("X-Hegel-MAC", "3C:EC:EF:4C:4F:54")
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+	if body := w.Body.String(); body != "by-mac" {
+		t.Fatalf("Expected: by-mac; Received: %v", body)
+	}
+}
+
+// idClient is a Client that can retrieve instances by instance ID.
+type idClient struct {
+	*MockClient
+	*MockIDClient
+}
+
+func TestIdentityStrategyInstanceID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := idClient{NewMockClient(ctrl), NewMockIDClient(ctrl)}
+
+	// Requests identified by instance ID are looked up by instance ID without consulting the
+	// source IP.
+	client.MockIDClient.EXPECT().
+		GetEC2InstanceByID(gomock.Any(), "sm01").
+		Return(Instance{Metadata: Metadata{Hostname: "by-id"}}, nil)
+
+	router := gin.New()
+	router.Use(identity.Middleware(identity.DebugQuery(), identity.SourceIP()))
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname?instance_id=sm01", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+	if body := w.Body.String(); body != "by-id" {
+		t.Fatalf("Expected: by-id; Received: %v", body)
+	}
+}
+
+func TestIdentityStrategyInstanceIDUnsupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	// The client can't look up instances by instance ID.
+	client := NewMockClient(ctrl)
+
+	router := gin.New()
+	router.Use(identity.Middleware(identity.DebugQuery(), identity.SourceIP()))
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname?instance_id=sm01", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Fatalf("Expected: 501; Received: %d", w.Code)
+	}
+}
+
+func TestIdentityStrategyInstanceIDErrors(t *testing.T) {
+	cases := []struct {
+		Name             string
+		Err              error
+		ExpectCode       int
+		ExpectRetryAfter string
+	}{
+		{Name: "NotFound", Err: ErrInstanceNotFound, ExpectCode: http.StatusNotFound},
+		{Name: "NotReady", Err: ErrBackendNotReady, ExpectCode: http.StatusServiceUnavailable, ExpectRetryAfter: "1"},
+		{Name: "Backend", Err: errors.New("connection refused"), ExpectCode: http.StatusInternalServerError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := idClient{NewMockClient(ctrl), NewMockIDClient(ctrl)}
+			client.MockIDClient.EXPECT().
+				GetEC2InstanceByID(gomock.Any(), "sm01").
+				Return(Instance{}, tc.Err)
+
+			router := gin.New()
+			router.Use(identity.Middleware(identity.DebugQuery(), identity.SourceIP()))
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data/hostname?instance_id=sm01", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+			if retryAfter := w.Header().Get("Retry-After"); retryAfter != tc.ExpectRetryAfter {
+				t.Fatalf("Expected Retry-After: %q; Received: %q", tc.ExpectRetryAfter, retryAfter)
+			}
+		})
+	}
+}
+
+func TestMACHeaderFallback(t *testing.T) {
+	const header = "X-Hegel-MAC"
+
+	cases := []struct {
+		Name       string
+		RemoteAddr string
+		MAC        string
+		Configure  func(client *MockClient)
+		ExpectCode int
+		ExpectBody string
+	}{
+		{
+			Name:       "IPNotFound",
+			RemoteAddr: "10.10.10.10:0",
+			MAC:        "3C:EC:EF:4C:4F:54",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{}, ErrInstanceNotFound)
+				client.EXPECT().
+					GetEC2InstanceByMAC(gomock.Any(), "3c:ec:ef:4c:4f:54").
+					Return(Instance{Metadata: Metadata{InstanceID: "by-mac"}}, nil)
+			},
+			ExpectCode: http.StatusOK,
+			ExpectBody: "by-mac",
+		},
+		{
+			Name:       "InvalidRemoteAddr",
+			RemoteAddr: "@",
+			MAC:        "3c:ec:ef:4c:4f:54",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2InstanceByMAC(gomock.Any(), "3c:ec:ef:4c:4f:54").
+					Return(Instance{Metadata: Metadata{InstanceID: "by-mac"}}, nil)
+			},
+			ExpectCode: http.StatusOK,
+			ExpectBody: "by-mac",
+		},
+		{
+			Name:       "IgnoredWhenIPFound",
+			RemoteAddr: "10.10.10.10:0",
+			MAC:        "3c:ec:ef:4c:4f:54",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{Metadata: Metadata{InstanceID: "by-ip"}}, nil)
+			},
+			ExpectCode: http.StatusOK,
+			ExpectBody: "by-ip",
+		},
+		{
+			Name:       "IgnoredOnBackendError",
+			RemoteAddr: "10.10.10.10:0",
+			MAC:        "3c:ec:ef:4c:4f:54",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{}, errors.New("backend failure"))
+			},
+			ExpectCode: http.StatusInternalServerError,
+		},
+		{
+			Name:       "MACNotFound",
+			RemoteAddr: "10.10.10.10:0",
+			MAC:        "3c:ec:ef:4c:4f:54",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{}, ErrInstanceNotFound)
+				client.EXPECT().
+					GetEC2InstanceByMAC(gomock.Any(), "3c:ec:ef:4c:4f:54").
+					Return(Instance{}, ErrInstanceNotFound)
+			},
+			ExpectCode: http.StatusNotFound,
+		},
+		{
+			Name:       "InvalidMAC",
+			RemoteAddr: "10.10.10.10:0",
+			MAC:        "invalid",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{}, ErrInstanceNotFound)
+			},
+			ExpectCode: http.StatusBadRequest,
+		},
+		{
+			Name:       "NoHeader",
+			RemoteAddr: "10.10.10.10:0",
+			Configure: func(client *MockClient) {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{}, ErrInstanceNotFound)
+			},
+			ExpectCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			tc.Configure(client)
+
+			router := gin.New()
+
+			fe := New(client, WithMACHeader(header))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data/instance-id", nil)
+			r.RemoteAddr = tc.RemoteAddr
+			if tc.MAC != "" {
+				r.Header.Set(header, tc.MAC)
+			}
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+
+			if tc.ExpectBody != "" && w.Body.String() != tc.ExpectBody {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPaths(t *testing.T) {
+	var expect []string
+	for _, endpoint := range Endpoints() {
+		expect = append(expect, "/2009-04-04"+endpoint)
+	}
+	sort.Strings(expect)
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+
+	router := gin.New()
+
+	fe := New(client)
+	fe.ConfigurePaths(router)
+
+	// Paths don't require an instance lookup so the remote address is irrelevant.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/paths", nil)
+	r.Header.Set("Accept", "application/json")
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var paths []string
+	if err := json.Unmarshal(w.Body.Bytes(), &paths); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(expect, paths) {
+		t.Fatal(cmp.Diff(expect, paths))
+	}
+}
+
+func TestRecursiveTree(t *testing.T) {
+	instance := Instance{
+		Userdata: "userdata",
+		Metadata: Metadata{
+			InstanceID: "instance-id",
+			Hostname:   "hostname",
+			Tags:       []string{"tag1", "tag2"},
+			OperatingSystem: OperatingSystem{
+				Slug: "ubuntu_20_04",
+				LicenseActivation: LicenseActivation{
+					State: "active",
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Expect   map[string]any
+	}{
+		{
+			Name:     "MetadataOperatingSystem",
+			Endpoint: "/2009-04-04/meta-data/operating-system?recursive=true",
+			Expect: map[string]any{
+				"slug":      "ubuntu_20_04",
+				"distro":    "",
+				"version":   "",
+				"image_tag": "",
+				"license_activation": map[string]any{
+					"state": "active",
+				},
+			},
+		},
+		{
+			Name:     "Metadata",
+			Endpoint: "/2009-04-04/meta-data/?recursive=true",
+			Expect: map[string]any{
+				"instance-id":      "instance-id",
+				"hostname":         "hostname",
+				"local-hostname":   "",
+				"iqn":              "",
+				"plan":             "",
+				"instance-type":    "",
+				"ami-id":           "ubuntu_20_04",
+				"ami-launch-index": "0",
+				"instance-action":  "none",
+				"facility":         "",
+				"tags":             []any{"tag1", "tag2"},
+				"public-ipv4":      "",
+				"public-ipv6":      "",
+				"local-ipv4":       "",
+				"public-keys":      []any{},
+				"mac":              "",
+				"network": map[string]any{
+					"interfaces": map[string]any{
+						"macs": []any{},
+					},
+				},
+				"services": map[string]any{
+					"domain":    "amazonaws.com",
+					"partition": "aws",
+				},
+				"events": map[string]any{
+					"maintenance": map[string]any{
+						"scheduled": "[]",
+					},
+				},
+				"placement": map[string]any{
+					"availability-zone": "",
+					"region":            "",
+				},
+				"operating-system": map[string]any{
+					"slug":      "ubuntu_20_04",
+					"distro":    "",
+					"version":   "",
+					"image_tag": "",
+					"license_activation": map[string]any{
+						"state": "active",
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(instance, nil)
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Fatalf("Unexpected Content-Type: %v", ct)
+			}
+
+			var tree map[string]any
+			if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(tc.Expect, tree) {
+				t.Fatal(cmp.Diff(tc.Expect, tree))
+			}
+		})
+	}
+}
+
+func TestRecursiveTreeInstanceNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{}, ErrInstanceNotFound)
+
+	router := gin.New()
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data?recursive=true", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+}
+
+func TestFilterErrorStatus(t *testing.T) {
+	cases := []struct {
+		Name                string
+		Err                 error
+		ExpectCode          int
+		ExpectKind          string
+		ExpectRecursiveCode int
+		ExpectRecursiveKind string
+	}{
+		{
+			Name:                "NoResults",
+			Err:                 fmt.Errorf("%w: no plan", ErrNoResults),
+			ExpectCode:          http.StatusNotFound,
+			ExpectKind:          "not_found",
+			ExpectRecursiveCode: http.StatusOK,
+		},
+		{
+			Name:                "Unclassified",
+			Err:                 errors.New("filter error"),
+			ExpectCode:          http.StatusInternalServerError,
+			ExpectKind:          "filter",
+			ExpectRecursiveCode: http.StatusInternalServerError,
+			ExpectRecursiveKind: "filter",
+		},
+	}
+
+	for _, tc := range cases {
+		for _, recursive := range []bool{false, true} {
+			name, endpoint := tc.Name+"/Data", "/2009-04-04/meta-data/plan"
+			expectCode, expectKind := tc.ExpectCode, tc.ExpectKind
+			if recursive {
+				name, endpoint = tc.Name+"/Recursive", "/2009-04-04/meta-data?recursive=true"
+				expectCode, expectKind = tc.ExpectRecursiveCode, tc.ExpectRecursiveKind
+			}
+
+			t.Run(name, func(t *testing.T) {
+				restore := SetFilter("/meta-data/plan", func(Instance) (string, error) {
+					return "", tc.Err
+				})
+				defer restore()
+
+				ctrl := gomock.NewController(t)
+				client := NewMockClient(ctrl)
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), gomock.Any()).
+					Return(Instance{Metadata: Metadata{InstanceID: "instance-id"}}, nil)
+
+				registry := prometheus.NewRegistry()
+
+				router := gin.New()
+				router.Use(metrics.InstrumentErrors(registry))
+
+				fe := New(client)
+				fe.Configure(router)
+
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", endpoint, nil)
+				r.RemoteAddr = "10.10.10.10:0"
+
+				router.ServeHTTP(w, r)
+
+				if w.Code != expectCode {
+					t.Fatalf("Expected: %d; Received: %d", expectCode, w.Code)
+				}
+
+				expect := ""
+				if expectKind != "" {
+					expect = fmt.Sprintf(`
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="metadata",kind=%q} 1
+`, expectKind)
+				}
+				if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_errors_total"); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+	}
+}
+
+func TestRecursiveTreeFilterError(t *testing.T) {
+	cases := []struct {
+		Name       string
+		SkipFailed bool
+		ExpectCode int
+		ExpectKind string
+	}{
+		{
+			Name:       "Fail",
+			ExpectCode: http.StatusInternalServerError,
+			ExpectKind: "filter",
+		},
+		{
+			Name:       "SkipFailed",
+			SkipFailed: true,
+			ExpectCode: http.StatusOK,
+			ExpectKind: "skipped_value",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			restore := SetFilter("/meta-data/plan", func(Instance) (string, error) {
+				return "", errors.New("filter error")
+			})
+			defer restore()
+
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{InstanceID: "instance-id"}}, nil)
+
+			registry := prometheus.NewRegistry()
+
+			router := gin.New()
+			router.Use(metrics.InstrumentErrors(registry))
+
+			fe := New(client, WithSkipFailedTreeValues(tc.SkipFailed))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/2009-04-04/meta-data?recursive=true", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+
+			if tc.ExpectCode == http.StatusOK {
+				var tree map[string]any
+				if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+					t.Fatal(err)
+				}
+				if _, ok := tree["plan"]; ok {
+					t.Fatal("Expected plan to be omitted")
+				}
+				if tree["instance-id"] != "instance-id" {
+					t.Fatalf("Expected instance-id to be served; Received: %v", tree["instance-id"])
+				}
+			}
+
+			expect := fmt.Sprintf(`
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="metadata",kind=%q} 1
+`, tc.ExpectKind)
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUserDataSignature(t *testing.T) {
+	// A fixed seed produces a known key so signatures are reproducible.
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	publicKey := key.Public().(ed25519.PublicKey)
+
+	const userData = "#!/bin/sh\necho hello\n"
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Header   bool
+	}{
+		{
+			Name:     "Detached",
+			Endpoint: "/2009-04-04/user-data.sig",
+		},
+		{
+			Name:     "Header",
+			Endpoint: "/2009-04-04/user-data",
+			Header:   true,
+		},
+		{
+			// Signatures cover the raw user-data regardless of encoding.
+			Name:     "HeaderBase64Encoded",
+			Endpoint: "/2009-04-04/user-data?encoding=base64",
+			Header:   true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Userdata: userData, Metadata: Metadata{InstanceID: "i-sm01"}}, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithUserDataSigningKey(key))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			encoded := w.Body.String()
+			if tc.Header {
+				encoded = w.Header().Get(UserDataSignatureHeader)
+			}
+
+			signature, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Signatures bind the user-data to the instance and endpoint.
+			if !ed25519.Verify(publicKey, []byte("i-sm01\n/user-data\n"+userData), signature) {
+				t.Fatalf("Signature doesn't verify: %v", encoded)
+			}
+
+			if ed25519.Verify(publicKey, []byte("i-sm01\n/user-data\n"+userData+"tampered"), signature) {
+				t.Fatal("Signature verifies tampered user-data")
+			}
+
+			if ed25519.Verify(publicKey, []byte("i-sm02\n/user-data\n"+userData), signature) {
+				t.Fatal("Signature verifies user-data for another instance")
+			}
+		})
+	}
+}
+
+func TestUserDataSignatureDisabled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "#!/bin/sh\n"}, nil)
+
+	router := gin.New()
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if v := w.Header().Get(UserDataSignatureHeader); v != "" {
+		t.Fatalf("Unexpected signature: %v", v)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/2009-04-04/user-data.sig", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected: 404; Received: %d", w.Code)
+	}
+}
+
+func TestLargeUserData(t *testing.T) {
+	// Multi-megabyte user-data must be served intact however it's rendered.
+	userData := "#cloud-config\n" + strings.Repeat("# padding to exceed a few megabytes\n", 128<<10)
+
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Accept   string
+		Decode   func(t *testing.T, body []byte) string
+	}{
+		{
+			Name:     "Text",
+			Endpoint: "/2009-04-04/user-data",
+			Decode: func(_ *testing.T, body []byte) string {
+				return string(body)
+			},
+		},
+		{
+			Name:     "TextBase64",
+			Endpoint: "/2009-04-04/user-data?encoding=base64",
+			Decode: func(t *testing.T, body []byte) string {
+				decoded, err := base64.StdEncoding.DecodeString(string(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(decoded)
+			},
+		},
+		{
+			Name:     "JSONBase64",
+			Endpoint: "/2009-04-04/user-data?encoding=base64",
+			Accept:   "application/json",
+			Decode: func(t *testing.T, body []byte) string {
+				var encoded string
+				if err := json.Unmarshal(body, &encoded); err != nil {
+					t.Fatal(err)
+				}
+				decoded, err := base64.StdEncoding.DecodeString(encoded)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(decoded)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Userdata: userData}, nil)
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			r.Header.Set("Accept", tc.Accept)
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if received := tc.Decode(t, w.Body.Bytes()); received != userData {
+				t.Fatalf("Expected %d bytes of user-data; Received %d bytes", len(userData), len(received))
+			}
+		})
+	}
+}
+
+func TestMaxUserDataSize(t *testing.T) {
+	const max = 1 << 20
+
+	cases := []struct {
+		Name         string
+		Endpoint     string
+		Userdata     string
+		ExpectStatus int
+		ExpectErrors int
+	}{
+		{
+			Name:         "WithinLimit",
+			Endpoint:     "/2009-04-04/user-data",
+			Userdata:     strings.Repeat("a", max),
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "ExceedsLimit",
+			Endpoint:     "/2009-04-04/user-data",
+			Userdata:     strings.Repeat("a", max+1),
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectErrors: 1,
+		},
+		{
+			Name:         "ExceedsLimitBase64",
+			Endpoint:     "/2009-04-04/user-data?encoding=base64",
+			Userdata:     strings.Repeat("a", max+1),
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectErrors: 1,
+		},
+		{
+			Name:         "ExceedsLimitUnrelatedEndpoint",
+			Endpoint:     "/2009-04-04/meta-data/hostname",
+			Userdata:     strings.Repeat("a", max+1),
+			ExpectStatus: http.StatusOK,
+		},
+		{
+			Name:         "ExceedsLimitRecursive",
+			Endpoint:     "/2009-04-04?recursive=true",
+			Userdata:     strings.Repeat("a", max+1),
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectErrors: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Userdata: tc.Userdata, Metadata: Metadata{Hostname: "sm01"}}, nil)
+
+			registry := prometheus.NewRegistry()
+
+			router := gin.New()
+			router.Use(metrics.InstrumentErrors(registry))
+
+			fe := New(client, WithMaxUserDataSize(max))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if tc.ExpectErrors == 0 {
+				return
+			}
+
+			expect := fmt.Sprintf(`
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="metadata",kind="oversized_user_data"} %d
+`, tc.ExpectErrors)
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_errors_total"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMaxUserDataSizeSkipFailedTreeValues(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "oversized", Metadata: Metadata{Hostname: "sm01"}}, nil)
+
+	router := gin.New()
+
+	fe := New(client, WithMaxUserDataSize(1), WithSkipFailedTreeValues(true))
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04?recursive=true", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var tree map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// Oversized user-data is omitted while the rest of the tree is served.
+	if _, ok := tree["user-data"]; ok {
+		t.Fatal("Expected oversized user-data to be omitted")
+	}
+	if _, ok := tree["meta-data"]; !ok {
+		t.Fatal("Expected meta-data to be served")
+	}
+}
+
+func TestReload(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Userdata: "#cloud-config\n"}, nil).
+		AnyTimes()
+
+	router := gin.New()
+
+	fe := New(client, WithDefaults(map[string]string{"/meta-data/hostname": "before"}))
+	fe.Configure(router)
+
+	validate(t, router, "/2009-04-04/meta-data/hostname", "before")
+
+	if err := fe.Reload(WithDefaults(map[string]string{"/meta-data/hostname": "after"})); err != nil {
+		t.Fatal(err)
+	}
+	validate(t, router, "/2009-04-04/meta-data/hostname", "after")
+
+	// Invalid settings are rejected, retaining the previous settings.
+	invalid := [][]Option{
+		{WithDefaults(nil), WithUserDataMerge("unknown")},
+		{WithDefaults(nil), WithMaxUserDataSize(-1)},
+	}
+	for _, opts := range invalid {
+		if err := fe.Reload(opts...); err == nil {
+			t.Fatal("Expected error; Received: nil")
+		}
+		validate(t, router, "/2009-04-04/meta-data/hostname", "after")
+	}
+
+	// Settings that aren't specified revert to their defaults.
+	if err := fe.Reload(WithMaxUserDataSize(1)); err != nil {
+		t.Fatal(err)
+	}
+	validate(t, router, "/2009-04-04/meta-data/hostname", "")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/user-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected: 500; Received: %d", w.Code)
+	}
+}
+
+func TestReloadHotPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Metadata: Metadata{Hostname: "sm01"}}, nil).
+		Times(2)
+
+	router := gin.New()
+
+	fe := New(client, WithHotPathTTL(time.Hour))
+	fe.Configure(router)
+
+	// Reloading discards cached data so the second request retrieves the instance again.
+	validate(t, router, "/2009-04-04/meta-data/hostname", "sm01")
+	if err := fe.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	validate(t, router, "/2009-04-04/meta-data/hostname", "sm01")
+}
+
+func TestReloadAtomic(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(Instance{Metadata: Metadata{Facility: "sv15"}}, nil).
+		AnyTimes()
+
+	// Each set of settings produces a region and services domain that identify it.
+	settings := [][]Option{
+		{WithFacilityRegions(map[string]string{"sv15": "a"}), WithServices("a", "")},
+		{WithFacilityRegions(map[string]string{"sv15": "b"}), WithServices("b", "")},
+	}
+
+	router := gin.New()
+
+	fe := New(client, settings[0]...)
+	fe.Configure(router)
+
+	done := make(chan struct{})
+	reloaded := make(chan error)
+	go func() {
+		defer close(reloaded)
+		for i := 1; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := fe.Reload(settings[i%2]...); err != nil {
+				reloaded <- err
+				return
+			}
+		}
+	}()
+
+	// Every request must be served with one set of settings throughout.
+	for i := 0; i < 500; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/2009-04-04/meta-data?recursive=true", nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected: 200; Received: %d", w.Code)
+		}
+
+		var tree struct {
+			Placement struct {
+				Region string `json:"region"`
+			} `json:"placement"`
+			Services struct {
+				Domain string `json:"domain"`
+			} `json:"services"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+			t.Fatal(err)
+		}
+
+		if tree.Placement.Region != tree.Services.Domain {
+			t.Fatalf("Served with mixed settings: region=%v; services domain=%v", tree.Placement.Region, tree.Services.Domain)
+		}
+	}
+
+	close(done)
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Endpoint string
+		Instance Instance
+		Expect   string
+	}{
+		{
+			Name:     "Stable",
+			Endpoint: "/2009-04-04/meta-data/instance-id",
+			Expect:   "private, max-age=86400",
+		},
+		{
+			Name:     "StableAlias",
+			Endpoint: "/2009-04-04/meta-data/instance_id",
+			Expect:   "private, max-age=86400",
+		},
+		{
+			Name:     "StableDirectory",
+			Endpoint: "/2009-04-04/meta-data/placement/region",
+			Expect:   "private, max-age=86400",
+		},
+		{
+			Name:     "Volatile",
+			Endpoint: "/2009-04-04/meta-data/spot/termination-time",
+			Instance: Instance{Metadata: Metadata{Spot: &Spot{TerminationTime: time.Now()}}},
+			Expect:   "no-store",
+		},
+		{
+			Name:     "ContainsVolatile",
+			Endpoint: "/2009-04-04/meta-data?recursive=true",
+			Expect:   "no-store",
+		},
+		{
+			Name:     "NoPolicy",
+			Endpoint: "/2009-04-04/meta-data/hostname",
+		},
+		{
+			// Failures aren't cached in place of the data.
+			Name:     "NotFound",
+			Endpoint: "/2009-04-04/meta-data/spot/termination-time",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(tc.Instance, nil)
+
+			router := gin.New()
+
+			fe := New(client, WithPathAliases(map[string]string{"/meta-data/instance_id": "/meta-data/instance-id"}))
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if cc := w.Header().Get("Cache-Control"); cc != tc.Expect {
+				t.Fatalf("Expected Cache-Control: %q; Received: %q (status %d)", tc.Expect, cc, w.Code)
+			}
+		})
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Err          error
+		ExpectStatus int
+		ExpectKind   string
+	}{
+		{
+			Name:         "NotFound",
+			Err:          fmt.Errorf("lookup: %w", ErrInstanceNotFound),
+			ExpectStatus: http.StatusNotFound,
+			ExpectKind:   "not_found",
+		},
+		{
+			Name:         "NotReady",
+			Err:          fmt.Errorf("lookup: %w", ErrBackendNotReady),
+			ExpectStatus: http.StatusServiceUnavailable,
+			ExpectKind:   "not_ready",
+		},
+		{
+			Name:         "Unsupported",
+			Err:          fmt.Errorf("lookup: %w", ErrLookupUnsupported),
+			ExpectStatus: http.StatusNotImplemented,
+			ExpectKind:   "backend",
+		},
+		{
+			Name:         "Backend",
+			Err:          errors.New("connection refused"),
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectKind:   "backend",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			status, kind := ErrorStatus(tc.Err)
+			if status != tc.ExpectStatus || kind != tc.ExpectKind {
+				t.Fatalf("Expected: %d, %v; Received: %d, %v", tc.ExpectStatus, tc.ExpectKind, status, kind)
+			}
+		})
+	}
+}
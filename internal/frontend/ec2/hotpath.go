@@ -0,0 +1,241 @@
+package ec2
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/identity"
+	"github.com/tinkerbell/hegel/internal/tenant"
+)
+
+// hotEndpoints are the endpoints cloud-init requests, one after another, on every boot.
+var hotEndpoints = []string{
+	"/meta-data/instance-id",
+	"/meta-data/hostname",
+	"/meta-data/public-keys",
+	userDataEndpoint,
+}
+
+// hotPath caches the data of hotEndpoints for instances once they've been retrieved so the
+// requests that follow are served without retrieving the instance again. Entries are keyed by
+// everything used to identify the instance so a hit serves the data a retrieval would have. A nil
+// hotPath caches nothing.
+type hotPath struct {
+	ttl       time.Duration
+	macHeader string
+	templates *userDataTemplates
+	phases    []UserAgentPhase
+	now       func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]hotEntry
+
+	// nextSweep is the earliest time store sweeps expired entries.
+	nextSweep time.Time
+}
+
+// hotEntry is the data of the hot endpoints that exist for an instance.
+type hotEntry struct {
+	// ip is the remote address of the request that cached the entry so it can be flushed by IP.
+	ip         string
+	instanceID string
+	values     map[string]value
+	expires    time.Time
+}
+
+// newHotPath creates a hotPath caching data for ttl. macHeader is the header, if any, instances
+// may be retrieved by as configured with WithMACHeader. templates, if not nil, renders user-data
+// as a template as configured with WithUserDataTemplates. phases select the phase of requests as
+// configured with WithUserAgentPhases.
+func newHotPath(ttl time.Duration, macHeader string, templates *userDataTemplates, phases []UserAgentPhase) *hotPath {
+	return &hotPath{
+		ttl:       ttl,
+		macHeader: macHeader,
+		templates: templates,
+		phases:    phases,
+		now:       time.Now,
+		entries:   make(map[string]hotEntry),
+	}
+}
+
+// get retrieves the cached data of endpoint for the instance identified by ctx and r, and the
+// instance's ID.
+func (h *hotPath) get(ctx context.Context, r *http.Request, endpoint string) (value, string, bool) {
+	if h == nil {
+		return nil, "", false
+	}
+
+	key, ok := h.key(ctx, r)
+	if !ok {
+		return nil, "", false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, ok := h.entries[key]
+	if !ok {
+		return nil, "", false
+	}
+
+	if !h.now().Before(entry.expires) {
+		delete(h.entries, key)
+		return nil, "", false
+	}
+
+	v, ok := entry.values[endpoint]
+	return v, entry.instanceID, ok
+}
+
+// store caches the data of the hot endpoints for i, the instance identified by ctx and r, if
+// endpoint, the endpoint requested by r, is hot. v is the data already produced for endpoint.
+// Endpoints that don't exist for i, or whose filter fails, aren't cached so requests for them
+// retrieve the instance and are served as usual.
+func (h *hotPath) store(ctx context.Context, r *http.Request, i Instance, endpoint string, v value) {
+	if h == nil || !isHot(endpoint) {
+		return
+	}
+
+	key, ok := h.key(ctx, r)
+	if !ok {
+		return
+	}
+
+	values := map[string]value{endpoint: v}
+	for _, route := range dataRoutes {
+		if route.Endpoint == endpoint || !isHot(route.Endpoint) || !exists(route.Endpoint, i) {
+			continue
+		}
+		v, err := route.Filter(i)
+		if err == nil {
+			v, err = h.templates.render(route.Endpoint, i, v)
+		}
+		if err == nil {
+			values[route.Endpoint] = v
+		}
+	}
+
+	// The remote address has been validated by key.
+	ip, _ := request.RemoteAddrIP(r)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+
+	// Expired entries are evicted when looked up. Sweep the rest, at most once per TTL so the cost
+	// is amortized across stores, to stop instances that are never requested again accumulating.
+	if !now.Before(h.nextSweep) {
+		for k, entry := range h.entries {
+			if !now.Before(entry.expires) {
+				delete(h.entries, k)
+			}
+		}
+		h.nextSweep = now.Add(h.ttl)
+	}
+
+	h.entries[key] = hotEntry{ip: ip, instanceID: i.Metadata.InstanceID, values: values, expires: now.Add(h.ttl)}
+}
+
+// flush discards the entries cached by requests from ip, or every entry if ip is empty, and
+// returns the number discarded.
+func (h *hotPath) flush(ip string) int {
+	if h == nil {
+		return 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if ip == "" {
+		n := len(h.entries)
+		h.entries = make(map[string]hotEntry)
+		return n
+	}
+
+	var n int
+	for key, entry := range h.entries {
+		if entry.ip == ip {
+			delete(h.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// size returns the number of entries in h and the approximate size of their keys and data in
+// bytes.
+func (h *hotPath) size() (entries, bytes int) {
+	if h == nil {
+		return 0, 0
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for key, entry := range h.entries {
+		bytes += len(key)
+		for endpoint, v := range entry.values {
+			bytes += len(endpoint) + valueSize(v)
+		}
+	}
+	return len(h.entries), bytes
+}
+
+// valueSize returns the approximate size of v's data in bytes.
+func valueSize(v value) int {
+	switch v := v.(type) {
+	case scalar:
+		return len(v)
+	case userData:
+		return len(v)
+	case list:
+		var n int
+		for _, s := range v {
+			n += len(s)
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// key returns a key identifying the instance ctx and r are retrieved by, as described by
+// getInstance, including the tenant lookups are scoped to and the phase of r as the instance's
+// user-data may differ by phase. If r has no valid remote address it returns false.
+func (h *hotPath) key(ctx context.Context, r *http.Request) (string, bool) {
+	t, _ := tenant.FromContext(ctx)
+	phase := requestPhase(h.phases, r)
+
+	if key, ok := identity.FromContext(ctx); ok && key.Kind != identity.KindIP {
+		return strings.Join([]string{t, phase, string(key.Kind), key.Value}, "\x00"), true
+	}
+
+	ip, err := request.RemoteAddrIP(r)
+	if err != nil {
+		return "", false
+	}
+
+	// The MAC header is only used if the instance can't be retrieved by IP but requests with
+	// differing headers may still be served different instances.
+	var mac string
+	if h.macHeader != "" {
+		mac = r.Header.Get(h.macHeader)
+	}
+
+	return strings.Join([]string{t, phase, string(identity.KindIP), ip, mac}, "\x00"), true
+}
+
+// isHot returns true if endpoint is one of hotEndpoints.
+func isHot(endpoint string) bool {
+	for _, e := range hotEndpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package ec2_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/tenant"
+)
+
+// hotPathInstance has data for every hot endpoint.
+var hotPathInstance = Instance{
+	Userdata: "#cloud-config\n",
+	Metadata: Metadata{
+		InstanceID: "instance-id",
+		Hostname:   "sm01",
+		PublicKeys: []string{"ssh-ed25519 AAAA"},
+	},
+}
+
+func TestHotPath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.10").
+		Return(hotPathInstance, nil).
+		Times(1)
+
+	router := gin.New()
+	New(client, WithHotPathTTL(time.Minute)).Configure(router)
+
+	// The sequence of requests cloud-init makes on boot. Only the first retrieves the instance.
+	requests := []struct {
+		Endpoint string
+		Expect   string
+	}{
+		{Endpoint: "/2009-04-04/meta-data/instance-id", Expect: "instance-id"},
+		{Endpoint: "/2009-04-04/meta-data/hostname", Expect: "sm01"},
+		{Endpoint: "/2009-04-04/meta-data/public-keys", Expect: "ssh-ed25519 AAAA"},
+		{Endpoint: "/2009-04-04/user-data", Expect: "#cloud-config\n"},
+		{Endpoint: "/2009-04-04/user-data?encoding=base64", Expect: "I2Nsb3VkLWNvbmZpZwo="},
+	}
+
+	for _, req := range requests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, req.Endpoint, nil)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: Expected: 200; Received: %d", req.Endpoint, w.Code)
+		}
+
+		if body := w.Body.String(); body != req.Expect {
+			t.Fatalf("%v: Expected: %q; Received: %q", req.Endpoint, req.Expect, body)
+		}
+	}
+}
+
+// hotPathRequest is a request for Endpoint from RemoteAddr on behalf of Tenant, if any.
+type hotPathRequest struct {
+	Endpoint   string
+	RemoteAddr string
+	Tenant     string
+}
+
+func TestHotPathLookups(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Options []Option
+		// Requests are made in order.
+		Requests      []hotPathRequest
+		ExpectLookups int
+	}{
+		{
+			Name:    "NonHotEndpoint",
+			Options: []Option{WithHotPathTTL(time.Minute)},
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+				{Endpoint: "/2009-04-04/meta-data/tags", RemoteAddr: "10.10.10.10:0"},
+			},
+			ExpectLookups: 2,
+		},
+		{
+			Name:    "NonHotEndpointFirst",
+			Options: []Option{WithHotPathTTL(time.Minute)},
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/tags", RemoteAddr: "10.10.10.10:0"},
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+			},
+			ExpectLookups: 2,
+		},
+		{
+			Name:    "DifferentIPs",
+			Options: []Option{WithHotPathTTL(time.Minute)},
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.11:0"},
+			},
+			ExpectLookups: 2,
+		},
+		{
+			Name:    "DifferentTenants",
+			Options: []Option{WithHotPathTTL(time.Minute)},
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0", Tenant: "a"},
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0", Tenant: "b"},
+			},
+			ExpectLookups: 2,
+		},
+		{
+			Name:    "Expired",
+			Options: []Option{WithHotPathTTL(time.Nanosecond)},
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+			},
+			ExpectLookups: 2,
+		},
+		{
+			Name: "Disabled",
+			Requests: []hotPathRequest{
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+				{Endpoint: "/2009-04-04/meta-data/hostname", RemoteAddr: "10.10.10.10:0"},
+			},
+			ExpectLookups: 2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Hostname: "sm01", Tags: []string{"tag"}}}, nil).
+				Times(tc.ExpectLookups)
+
+			source, err := tenant.Header("X-Hegel-Tenant")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			router := gin.New()
+			router.Use(tenant.Middleware(source))
+			New(client, tc.Options...).Configure(router)
+
+			for _, req := range tc.Requests {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest(http.MethodGet, req.Endpoint, nil)
+				r.RemoteAddr = req.RemoteAddr
+				if req.Tenant != "" {
+					r.Header.Set("X-Hegel-Tenant", req.Tenant)
+				}
+				router.ServeHTTP(w, r)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("%v: Expected: 200; Received: %d", req.Endpoint, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestHotPathCacheSize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(hotPathInstance, nil).
+		Times(2)
+
+	fe := New(client, WithHotPathTTL(time.Minute))
+	router := gin.New()
+	fe.Configure(router)
+
+	if entries, bytes := fe.CacheSize(); entries != 0 || bytes != 0 {
+		t.Fatalf("Expected empty cache; Received: %v entries, %v bytes", entries, bytes)
+	}
+
+	var sizes []int
+	for _, addr := range []string{"10.10.10.10:0", "10.10.10.11:0"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/hostname", nil)
+		r.RemoteAddr = addr
+		router.ServeHTTP(w, r)
+
+		_, bytes := fe.CacheSize()
+		sizes = append(sizes, bytes)
+	}
+
+	// Each instance retrieved caches an entry holding the data of every hot endpoint.
+	entries, _ := fe.CacheSize()
+	if entries != 2 {
+		t.Fatalf("Expected 2 entries; Received: %v", entries)
+	}
+	if sizes[0] == 0 || sizes[1] != 2*sizes[0] {
+		t.Fatalf("Expected each entry to be the same non-zero size; Received: %v", sizes)
+	}
+}
+
+func TestHotPathFlush(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), gomock.Any()).
+		Return(hotPathInstance, nil).
+		Times(3)
+
+	fe := New(client, WithHotPathTTL(time.Minute))
+	router := gin.New()
+	fe.Configure(router)
+
+	get := func(addr string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/hostname", nil)
+		r.RemoteAddr = addr
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected: 200; Received: %d", w.Code)
+		}
+	}
+
+	get("10.10.10.10:0")
+	get("10.10.10.11:0")
+
+	if n := fe.Flush("10.10.10.10"); n != 1 {
+		t.Fatalf("Expected 1 entry flushed; Received: %v", n)
+	}
+
+	// Only the flushed IP retrieves the instance again.
+	get("10.10.10.10:0")
+	get("10.10.10.11:0")
+
+	if n := fe.Flush(""); n != 2 {
+		t.Fatalf("Expected 2 entries flushed; Received: %v", n)
+	}
+}
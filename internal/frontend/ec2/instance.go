@@ -1,5 +1,7 @@
 package ec2
 
+import "time"
+
 // Instance is a struct that contains the hardware data exposed from the EC2 API endpoints. For
 // an explanation of the endpoints refer to the AWS EC2 Instance Metadata documentation.
 //
@@ -9,7 +11,24 @@ package ec2
 // Deviations from the AWS EC2 Instance Metadata should be documented here.
 type Instance struct {
 	Userdata string
+
+	// UserdataFragments are ordered user-data fragments, such as a base template shared by many
+	// instances, composed before Userdata when serving user-data.
+	UserdataFragments []string
+
+	// UserdataPhases are served, in place of Userdata and UserdataFragments, to requests made in
+	// the boot phase they're keyed by, such as ipxe. Phases are selected by WithUserAgentPhases.
+	UserdataPhases map[string]string
+
+	// Vendordata is platform provided data, such as cloud-init defaults, served separately from
+	// Userdata so operators can provide defaults without clobbering Userdata.
+	Vendordata string
+
 	Metadata Metadata
+
+	// Tenant is the tenant the instance belongs to in multi-tenant clusters. It isn't served to
+	// instances.
+	Tenant string
 }
 
 // Metadata is a part of Instance.
@@ -20,12 +39,56 @@ type Metadata struct {
 	IQN             string
 	Plan            string
 	Facility        string
+	Region          string // Region containing Facility. Derived from Facility by the Frontend if empty.
 	Tags            []string
 	PublicKeys      []string
 	PublicIPv4      string
 	PublicIPv6      string
 	LocalIPv4       string
 	OperatingSystem OperatingSystem
+
+	// Interfaces are the instance's network interfaces ordered by device number. The first
+	// interface is the primary interface.
+	Interfaces []NetworkInterface
+
+	// Spot is the spot market data of spot instances. It is nil for instances that aren't spot
+	// instances in which case the spot endpoints don't exist.
+	Spot *Spot
+
+	// Services describes the domain and partition of the cloud the instance is in. Fields are
+	// derived from the Frontend configuration if empty.
+	Services Services
+
+	// InstanceAction is an action pending on the instance, such as reboot or reinstall, that
+	// operators use to signal the instance. It is empty if no action is pending.
+	InstanceAction string
+
+	// Disks are the device names of the instance's disks, such as /dev/sda, with the root disk
+	// first. The block device mapping endpoints don't exist for instances without disks.
+	Disks []string
+}
+
+// Spot is part of Metadata.
+type Spot struct {
+	// TerminationTime is when the instance will be terminated. It is the zero value if no
+	// termination is scheduled.
+	TerminationTime time.Time
+}
+
+// Services is part of Metadata.
+type Services struct {
+	// Domain is the DNS domain of the cloud's services such as amazonaws.com.
+	Domain string
+
+	// Partition is the partition the instance is in such as aws.
+	Partition string
+}
+
+// NetworkInterface is part of Metadata.
+type NetworkInterface struct {
+	MAC         string
+	LocalIPv4s  []string
+	PublicIPv4s []string
 }
 
 // OperatingSystem is part of Metadata.
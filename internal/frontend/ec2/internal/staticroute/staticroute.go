@@ -48,7 +48,8 @@ func (b Builder) FromEndpoint(endpoint string) {
 
 // Build returns a slice of Route objects containing an Endpoint and its associated child
 // elements for the response body. The root route is identified by an empty string for the
-// Endpoint field of Route.
+// Endpoint field of Route. Children are sorted lexically, including any trailing slash, so
+// listings are ordered consistently regardless of the order endpoints were added.
 func (b Builder) Build() []Route {
 	var routes sortableRoutes
 
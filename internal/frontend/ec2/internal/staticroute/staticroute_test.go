@@ -105,6 +105,24 @@ func TestBuilder(t *testing.T) {
 				},
 			},
 		},
+		{
+			Name:      "UnorderedEndpoints",
+			Endpoints: []string{"/foo/qux", "/bar", "/foo/baz/qux", "/baz"},
+			Routes: []Route{
+				{
+					Endpoint: "",
+					Children: []string{"bar", "baz", "foo/"},
+				},
+				{
+					Endpoint: "/foo",
+					Children: []string{"baz/", "qux"},
+				},
+				{
+					Endpoint: "/foo/baz",
+					Children: []string{"qux"},
+				},
+			},
+		},
 	}
 
 	for _, tc := range cases {
@@ -0,0 +1,155 @@
+package ec2
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2/internal/staticroute"
+)
+
+// RouteKind identifies the kind of data served by a route.
+type RouteKind string
+
+const (
+	// RouteKindDirectory routes list the routes beneath them.
+	RouteKindDirectory RouteKind = "directory"
+
+	// RouteKindLeaf routes serve instance data.
+	RouteKindLeaf RouteKind = "leaf"
+)
+
+// ManifestRoute describes a route served by Configure.
+type ManifestRoute struct {
+	// Path is the route path including the API version prefix.
+	Path string `json:"path"`
+
+	Kind RouteKind `json:"kind"`
+
+	// Parameters are the names of the path's named parameters, such as index for
+	// /2009-04-04/meta-data/public-keys/:index, in the order they appear.
+	Parameters []string `json:"parameters,omitempty"`
+
+	// Conditional is true if the route only exists for some instances.
+	Conditional bool `json:"conditional,omitempty"`
+}
+
+// Manifest describes every route served by Configure. It is generated from the routes Configure
+// serves so it's always consistent with them.
+type Manifest struct {
+	Routes []ManifestRoute `json:"routes"`
+}
+
+// NewManifest generates a Manifest of the routes served by Configure sorted by path. Routes enabled
+// by Options, such as /user-data.sig, aren't included.
+func NewManifest() Manifest {
+	var m Manifest
+
+	staticRoutes := staticroute.NewBuilder()
+	for _, r := range dataRoutes {
+		staticRoutes.FromEndpoint(r.Endpoint)
+		m.Routes = append(m.Routes, ManifestRoute{
+			Path:        APIVersionPrefix + r.Endpoint,
+			Kind:        RouteKindLeaf,
+			Conditional: isConditional(r.Endpoint, nil),
+		})
+	}
+
+	for _, r := range paramRoutes {
+		m.Routes = append(m.Routes, ManifestRoute{
+			Path:        APIVersionPrefix + r.Endpoint,
+			Kind:        RouteKindLeaf,
+			Parameters:  pathParameters(r.Endpoint),
+			Conditional: isConditional(r.Endpoint, nil),
+		})
+	}
+
+	for _, r := range staticRoutes.Build() {
+		m.Routes = append(m.Routes, ManifestRoute{
+			Path:        APIVersionPrefix + r.Endpoint,
+			Kind:        RouteKindDirectory,
+			Conditional: isConditional(r.Endpoint, r.Children),
+		})
+	}
+
+	sortRoutes(m.Routes)
+
+	return m
+}
+
+// sortRoutes sorts routes by path.
+func sortRoutes(routes []ManifestRoute) {
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Path < routes[j].Path
+	})
+}
+
+// pathParameters returns the names of the named parameters in endpoint.
+func pathParameters(endpoint string) []string {
+	var params []string
+	for _, segment := range strings.Split(endpoint, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+		}
+	}
+	return params
+}
+
+// Paths returns the sorted paths of every data endpoint served by Configure including the API
+// version prefix: the paths of the leaf routes of NewManifest. Endpoints with named parameters
+// include the parameter placeholder, for example /2009-04-04/meta-data/public-keys/:index.
+// Endpoints enabled by Options, such as /user-data.sig, aren't included.
+func Paths() []string {
+	return NewManifest().leafPaths()
+}
+
+// leafPaths returns the paths of the leaf routes in m in order.
+func (m Manifest) leafPaths() []string {
+	var paths []string
+	for _, r := range m.Routes {
+		if r.Kind == RouteKindLeaf {
+			paths = append(paths, r.Path)
+		}
+	}
+	return paths
+}
+
+// manifest returns the Manifest of the routes served by Configure including those enabled by f's
+// Options.
+func (f Frontend) manifest() Manifest {
+	m := NewManifest()
+	if f.userDataKey != nil {
+		m.Routes = append(m.Routes, ManifestRoute{
+			Path: APIVersionPrefix + userDataSignatureEndpoint,
+			Kind: RouteKindLeaf,
+		})
+		sortRoutes(m.Routes)
+	}
+	return m
+}
+
+// ConfigurePaths configures router with a /paths endpoint that lists every data endpoint path
+// served by Configure, including those enabled by f's Options, sorted. The paths are the leaf
+// routes of the manifest served by ConfigureRouteManifest so the two can't disagree. The listing
+// describes the API surface only so it doesn't require an instance lookup. It is intended for
+// operators and integrators rather than instances.
+func (f Frontend) ConfigurePaths(router gin.IRouter) {
+	paths := f.manifest().leafPaths()
+
+	router.GET("/paths", func(ctx *gin.Context) {
+		f.render(ctx, list(paths), false)
+	})
+}
+
+// ConfigureRouteManifest configures router with a /debug/routes endpoint serving the Manifest,
+// including the routes enabled by f's Options, as JSON. Like ConfigurePaths, it describes the API
+// surface only so it doesn't require an instance lookup. It is intended for operators and
+// integrators rather than instances.
+func (f Frontend) ConfigureRouteManifest(router gin.IRouter) {
+	manifest := f.manifest()
+
+	router.GET("/debug/routes", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, manifest)
+	})
+}
@@ -0,0 +1,124 @@
+package ec2_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestRouteManifest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+
+	router := gin.New()
+
+	fe := New(client)
+	fe.ConfigureRouteManifest(router)
+
+	// The manifest doesn't require an instance lookup so the remote address is irrelevant.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/debug/routes", nil)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(w.Body.Bytes(), &manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := map[string]ManifestRoute{}
+	for _, r := range manifest.Routes {
+		routes[r.Path] = r
+	}
+
+	// Every data endpoint must be described so the manifest can't drift from the served routes.
+	for _, endpoint := range Endpoints() {
+		r, ok := routes["/2009-04-04"+endpoint]
+		if !ok {
+			t.Fatalf("Missing route: %v", endpoint)
+		}
+		if r.Kind != RouteKindLeaf {
+			t.Fatalf("Expected: %v; Received: %v (Endpoint=%v)", RouteKindLeaf, r.Kind, endpoint)
+		}
+	}
+
+	expect := []ManifestRoute{
+		{Path: "/2009-04-04", Kind: RouteKindDirectory},
+		{Path: "/2009-04-04/meta-data", Kind: RouteKindDirectory, Conditional: true},
+		{Path: "/2009-04-04/meta-data/hostname", Kind: RouteKindLeaf},
+		{Path: "/2009-04-04/meta-data/spot", Kind: RouteKindDirectory, Conditional: true},
+		{Path: "/2009-04-04/meta-data/spot/termination-time", Kind: RouteKindLeaf, Conditional: true},
+		{
+			Path:       "/2009-04-04/meta-data/public-keys/:index/openssh-key",
+			Kind:       RouteKindLeaf,
+			Parameters: []string{"index"},
+		},
+	}
+
+	for _, e := range expect {
+		if !cmp.Equal(routes[e.Path], e) {
+			t.Fatal(cmp.Diff(e, routes[e.Path]))
+		}
+	}
+}
+
+func TestPathsMatchRouteManifest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+
+	// Routes enabled by Options must be listed by both endpoints.
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+	router := gin.New()
+	fe := New(client, WithUserDataSigningKey(key))
+	fe.ConfigurePaths(router)
+	fe.ConfigureRouteManifest(router)
+
+	get := func(path string, v any) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept", "application/json")
+
+		router.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: Expected: 200; Received: %d", path, w.Code)
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var paths []string
+	get("/paths", &paths)
+
+	var manifest Manifest
+	get("/debug/routes", &manifest)
+
+	var expect []string
+	for _, r := range manifest.Routes {
+		if r.Kind == RouteKindLeaf {
+			expect = append(expect, r.Path)
+		}
+	}
+
+	if !cmp.Equal(expect, paths) {
+		t.Fatal(cmp.Diff(expect, paths))
+	}
+	if !slices.Contains(paths, "/2009-04-04/user-data.sig") {
+		t.Fatalf("Expected /2009-04-04/user-data.sig in: %v", paths)
+	}
+}
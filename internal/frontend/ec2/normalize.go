@@ -0,0 +1,58 @@
+package ec2
+
+import "net"
+
+// Normalize returns i in the canonical form filters are written against so they behave the same
+// regardless of the backend data model i was converted from. Backends should normalize instances
+// after converting them from their data model.
+//
+// IP addresses are in their canonical text form, for example 2001:db8::1 rather than
+// 2001:0db8:0:0:0:0:0:1, and MAC addresses are lower case and colon separated. Invalid addresses
+// are retained as is so they're still visible to instances and validation. Empty lists are nil.
+func Normalize(i Instance) Instance {
+	i.UserdataFragments = normalizeList(i.UserdataFragments)
+
+	i.Metadata.Tags = normalizeList(i.Metadata.Tags)
+	i.Metadata.PublicKeys = normalizeList(i.Metadata.PublicKeys)
+	i.Metadata.Disks = normalizeList(i.Metadata.Disks)
+	i.Metadata.PublicIPv4 = normalizeIP(i.Metadata.PublicIPv4)
+	i.Metadata.PublicIPv6 = normalizeIP(i.Metadata.PublicIPv6)
+	i.Metadata.LocalIPv4 = normalizeIP(i.Metadata.LocalIPv4)
+
+	// Copy interfaces so the caller's instance isn't modified.
+	var ifaces []NetworkInterface
+	for _, iface := range i.Metadata.Interfaces {
+		ifaces = append(ifaces, NetworkInterface{
+			MAC:         normalizeMAC(iface.MAC),
+			LocalIPv4s:  normalizeIPs(iface.LocalIPv4s),
+			PublicIPv4s: normalizeIPs(iface.PublicIPv4s),
+		})
+	}
+	i.Metadata.Interfaces = ifaces
+
+	return i
+}
+
+// normalizeIP returns ip in its canonical text form. Invalid IPs are returned unchanged.
+func normalizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	return parsed.String()
+}
+
+func normalizeIPs(ips []string) []string {
+	var normalized []string
+	for _, ip := range ips {
+		normalized = append(normalized, normalizeIP(ip))
+	}
+	return normalized
+}
+
+func normalizeList(l []string) []string {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
@@ -0,0 +1,52 @@
+package ec2_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestNormalize(t *testing.T) {
+	instance := Instance{
+		UserdataFragments: []string{},
+		Metadata: Metadata{
+			Tags:       []string{},
+			PublicKeys: []string{"key1"},
+			PublicIPv4: "139.178.0.10",
+			PublicIPv6: "2001:0db8:0:0:0:0:0:1",
+			LocalIPv4:  "invalid",
+			Interfaces: []NetworkInterface{
+				{
+					MAC:         "3C-EC-EF-4C-4F-54",
+					LocalIPv4s:  []string{"10.10.10.10"},
+					PublicIPv4s: []string{},
+				},
+			},
+		},
+	}
+
+	expect := Instance{
+		Metadata: Metadata{
+			PublicKeys: []string{"key1"},
+			PublicIPv4: "139.178.0.10",
+			PublicIPv6: "2001:db8::1",
+			LocalIPv4:  "invalid",
+			Interfaces: []NetworkInterface{
+				{
+					MAC:        "3c:ec:ef:4c:4f:54",
+					LocalIPv4s: []string{"10.10.10.10"},
+				},
+			},
+		},
+	}
+
+	if normalized := Normalize(instance); !cmp.Equal(normalized, expect) {
+		t.Fatal(cmp.Diff(expect, normalized))
+	}
+
+	// The original instance is unmodified.
+	if mac := instance.Metadata.Interfaces[0].MAC; mac != "3C-EC-EF-4C-4F-54" {
+		t.Fatalf("Expected: 3C-EC-EF-4C-4F-54; Received: %v", mac)
+	}
+}
@@ -0,0 +1,56 @@
+package ec2
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+// UserAgentPhase selects the boot phase of requests whose User-Agent matches Pattern, such as the
+// iPXE firmware or the installed OS's cloud-init.
+type UserAgentPhase struct {
+	Pattern *regexp.Regexp
+	Phase   string
+}
+
+// ValidateUserAgentPhases returns an error if phases can't be used with WithUserAgentPhases.
+func ValidateUserAgentPhases(phases []UserAgentPhase) error {
+	for _, p := range phases {
+		if p.Pattern == nil {
+			return errors.New("user agent phase has no pattern")
+		}
+		if p.Phase == "" {
+			return errors.New("user agent phase has no phase")
+		}
+	}
+	return nil
+}
+
+// requestPhase returns the phase of the first of phases whose pattern matches the User-Agent of
+// r. If none match, or r has no User-Agent, it returns an empty string.
+func requestPhase(phases []UserAgentPhase, r *http.Request) string {
+	ua := r.UserAgent()
+	if ua == "" {
+		return ""
+	}
+	for _, p := range phases {
+		if p.Pattern.MatchString(ua) {
+			return p.Phase
+		}
+	}
+	return ""
+}
+
+// applyPhase returns i with the user-data of phase, if i has any, in place of its user-data. Phase
+// user-data is served as is, without user-data fragments, as fragments are written for the
+// installed OS rather than, for example, the iPXE firmware.
+func applyPhase(i Instance, phase string) Instance {
+	if phase == "" {
+		return i
+	}
+	if u, ok := i.UserdataPhases[phase]; ok {
+		i.Userdata = u
+		i.UserdataFragments = nil
+	}
+	return i
+}
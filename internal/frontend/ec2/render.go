@@ -0,0 +1,204 @@
+package ec2
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// Renderer renders the values produced by filters to an HTTP response body. Renderers decouple
+// the response format from the data an endpoint serves.
+type Renderer interface {
+	// ContentType is the value of the Content-Type header for responses rendered by the Renderer.
+	ContentType() string
+
+	// Scalar writes a single value to w.
+	Scalar(w io.Writer, v string) error
+
+	// List writes a list of values, such as a directory listing, to w.
+	List(w io.Writer, v []string) error
+}
+
+// TextRenderer renders values as plain text as defined by the AWS EC2 Instance Metadata API.
+// Scalars are written as is and lists are newline separated without a trailing newline.
+type TextRenderer struct {
+	// TrailingNewline terminates scalars and non-empty lists with a newline, unlike AWS, for
+	// clients that expect one. Scalars that already end with a newline aren't given another.
+	TrailingNewline bool
+}
+
+// ContentType satisfies Renderer.
+func (TextRenderer) ContentType() string {
+	return "text/plain; charset=utf-8"
+}
+
+// Scalar satisfies Renderer.
+func (t TextRenderer) Scalar(w io.Writer, v string) error {
+	if _, err := io.WriteString(w, v); err != nil {
+		return err
+	}
+	if t.TrailingNewline && !strings.HasSuffix(v, "\n") {
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return nil
+}
+
+// List satisfies Renderer.
+func (t TextRenderer) List(w io.Writer, v []string) error {
+	// Write each value rather than joining them to avoid allocating the joined string.
+	for i, s := range v {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, s); err != nil {
+			return err
+		}
+	}
+	if t.TrailingNewline && len(v) > 0 {
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return nil
+}
+
+// JSONRenderer renders scalars as JSON strings and lists as JSON arrays of strings.
+type JSONRenderer struct{}
+
+// ContentType satisfies Renderer.
+func (JSONRenderer) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+// Scalar satisfies Renderer.
+func (JSONRenderer) Scalar(w io.Writer, v string) error {
+	return encodeJSON(w, v)
+}
+
+// List satisfies Renderer.
+func (JSONRenderer) List(w io.Writer, v []string) error {
+	// Ensure an empty list is rendered as an empty array rather than null.
+	if v == nil {
+		v = []string{}
+	}
+	return encodeJSON(w, v)
+}
+
+// encodeJSON writes v to w as JSON without escaping HTML characters as data such as user-data
+// may legitimately contain them.
+func encodeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// value is data produced by a filter that can be rendered with a Renderer.
+type value interface {
+	render(w io.Writer, r Renderer) error
+}
+
+// scalar is a single value such as an instance ID.
+type scalar string
+
+func (s scalar) render(w io.Writer, r Renderer) error {
+	return r.Scalar(w, string(s))
+}
+
+// list is a set of values such as the tags of an instance or a directory listing.
+type list []string
+
+func (l list) render(w io.Writer, r Renderer) error {
+	return r.List(w, l)
+}
+
+// selectRenderer selects a Renderer based on the media types listed in an Accept header value.
+// The first media type, in the order listed, matching a supported Renderer is used. Quality
+// values are ignored. If there are no matches it returns a TextRenderer.
+func selectRenderer(accept string) Renderer {
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json":
+			return JSONRenderer{}
+		case "text/plain":
+			return TextRenderer{}
+		}
+	}
+
+	return TextRenderer{}
+}
+
+// isSingleValue returns true if v is a single value rather than a list.
+func isSingleValue(v value) bool {
+	switch v.(type) {
+	case scalar, userData, base64UserData:
+		return true
+	default:
+		return false
+	}
+}
+
+// isUserData returns true if v is user-data or vendor-data, which are served as is.
+func isUserData(v value) bool {
+	switch v.(type) {
+	case userData, base64UserData:
+		return true
+	default:
+		return false
+	}
+}
+
+// sizeHint estimates the number of bytes needed to render v so buffers can be preallocated.
+func sizeHint(v value) int {
+	switch v := v.(type) {
+	case scalar:
+		return len(v)
+	case userData:
+		return len(v)
+	case base64UserData:
+		return base64.StdEncoding.EncodedLen(len(v))
+	case list:
+		n := 0
+		for _, s := range v {
+			n += len(s) + 1
+		}
+		return n
+	default:
+		return 0
+	}
+}
+
+// maxPooledBufferSize is the capacity of the largest buffer returned to bufferPool. Larger
+// buffers, such as those used to render large user-data, are released so the pool doesn't retain
+// them.
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool pools the buffers responses are rendered to.
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer) //nolint:forcetypeassert // The pool only contains buffers.
+}
+
+// putBuffer returns buf to bufferPool. buf must not be used after it is returned.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,295 @@
+package ec2_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestTextRenderer(t *testing.T) {
+	cases := []struct {
+		Name            string
+		TrailingNewline bool
+		Render          func(Renderer, *bytes.Buffer) error
+		Expect          string
+	}{
+		{
+			Name:   "Scalar",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "hostname") },
+			Expect: "hostname",
+		},
+		{
+			Name:   "MultilineScalar",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "#!/bin/bash\necho hello\n") },
+			Expect: "#!/bin/bash\necho hello\n",
+		},
+		{
+			Name:   "EmptyScalar",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "") },
+			Expect: "",
+		},
+		{
+			Name:   "List",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.List(b, []string{"meta-data/", "user-data"}) },
+			Expect: "meta-data/\nuser-data",
+		},
+		{
+			Name:   "SingleItemList",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.List(b, []string{"tag"}) },
+			Expect: "tag",
+		},
+		{
+			Name:   "EmptyList",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.List(b, nil) },
+			Expect: "",
+		},
+		{
+			Name:            "ScalarTrailingNewline",
+			TrailingNewline: true,
+			Render:          func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "hostname") },
+			Expect:          "hostname\n",
+		},
+		{
+			// Scalars that already end with a newline aren't given another.
+			Name:            "MultilineScalarTrailingNewline",
+			TrailingNewline: true,
+			Render:          func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "#!/bin/bash\necho hello\n") },
+			Expect:          "#!/bin/bash\necho hello\n",
+		},
+		{
+			Name:            "ListTrailingNewline",
+			TrailingNewline: true,
+			Render:          func(r Renderer, b *bytes.Buffer) error { return r.List(b, []string{"meta-data/", "user-data"}) },
+			Expect:          "meta-data/\nuser-data\n",
+		},
+		{
+			Name:            "EmptyListTrailingNewline",
+			TrailingNewline: true,
+			Render:          func(r Renderer, b *bytes.Buffer) error { return r.List(b, nil) },
+			Expect:          "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.Render(TextRenderer{TrailingNewline: tc.TrailingNewline}, &buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), []byte(tc.Expect)) {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, buf.String())
+			}
+		})
+	}
+}
+
+func TestJSONRenderer(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Render func(Renderer, *bytes.Buffer) error
+		Expect string
+	}{
+		{
+			Name:   "Scalar",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.Scalar(b, "<hostname>") },
+			Expect: "\"<hostname>\"\n",
+		},
+		{
+			Name:   "List",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.List(b, []string{"meta-data/", "user-data"}) },
+			Expect: "[\"meta-data/\",\"user-data\"]\n",
+		},
+		{
+			Name:   "EmptyList",
+			Render: func(r Renderer, b *bytes.Buffer) error { return r.List(b, nil) },
+			Expect: "[]\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tc.Render(JSONRenderer{}, &buf); err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, buf.String())
+			}
+		})
+	}
+}
+
+func TestRendererSelection(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Accept      string
+		Endpoint    string
+		ContentType string
+		Expect      string
+	}{
+		{
+			Name:        "NoAccept",
+			Endpoint:    "/2009-04-04/meta-data/tags",
+			ContentType: TextRenderer{}.ContentType(),
+			Expect:      "tag1\ntag2",
+		},
+		{
+			Name:        "Wildcard",
+			Accept:      "*/*",
+			Endpoint:    "/2009-04-04/meta-data/tags",
+			ContentType: TextRenderer{}.ContentType(),
+			Expect:      "tag1\ntag2",
+		},
+		{
+			Name:        "JSONDataEndpoint",
+			Accept:      "application/json",
+			Endpoint:    "/2009-04-04/meta-data/tags",
+			ContentType: JSONRenderer{}.ContentType(),
+			Expect:      "[\"tag1\",\"tag2\"]\n",
+		},
+		{
+			Name:        "JSONStaticEndpoint",
+			Accept:      "text/html, application/json;q=0.9",
+			Endpoint:    "/2009-04-04",
+			ContentType: JSONRenderer{}.ContentType(),
+			Expect:      "[\"meta-data/\",\"user-data\",\"vendor-data\"]\n",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(Instance{Metadata: Metadata{Tags: []string{"tag1", "tag2"}}}, nil).
+				AnyTimes()
+
+			router := gin.New()
+
+			fe := New(client)
+			fe.Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", tc.Endpoint, nil)
+			r.Header.Set("Accept", tc.Accept)
+
+			// RemoteAddr must be valid for us to perform a lookup successfully. Because we're
+			// mocking the client the address value doesn't matter.
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != tc.ContentType {
+				t.Fatalf("Expected Content-Type: %v; Received: %v", tc.ContentType, ct)
+			}
+
+			if w.Body.String() != tc.Expect {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestTrailingNewlines(t *testing.T) {
+	instance := Instance{
+		Userdata: "#cloud-config",
+		Metadata: Metadata{
+			Hostname:   "sm01",
+			PublicKeys: []string{"ssh-ed25519 AAAA", "ssh-ed25519 BBBB"},
+		},
+	}
+
+	cases := []struct {
+		Name      string
+		Endpoints []string
+		Path      string
+		Expect    string
+	}{
+		{
+			// AWS serves scalars as is and listings without a trailing newline.
+			Name:   "DefaultScalar",
+			Path:   "/2009-04-04/meta-data/hostname",
+			Expect: "sm01",
+		},
+		{
+			Name:   "DefaultListing",
+			Path:   "/2009-04-04/meta-data/public-keys",
+			Expect: "ssh-ed25519 AAAA\nssh-ed25519 BBBB",
+		},
+		{
+			Name:      "Scalar",
+			Endpoints: []string{"/meta-data/hostname"},
+			Path:      "/2009-04-04/meta-data/hostname",
+			Expect:    "sm01\n",
+		},
+		{
+			Name:      "Listing",
+			Endpoints: []string{"/meta-data/public-keys"},
+			Path:      "/2009-04-04/meta-data/public-keys",
+			Expect:    "ssh-ed25519 AAAA\nssh-ed25519 BBBB\n",
+		},
+		{
+			Name:      "Directory",
+			Endpoints: []string{"/meta-data"},
+			Path:      "/2009-04-04/meta-data/hostname",
+			Expect:    "sm01\n",
+		},
+		{
+			Name:      "OtherEndpoint",
+			Endpoints: []string{"/meta-data/public-keys"},
+			Path:      "/2009-04-04/meta-data/hostname",
+			Expect:    "sm01",
+		},
+		{
+			Name:      "All",
+			Endpoints: []string{"/"},
+			Path:      "/2009-04-04/",
+			Expect:    "meta-data/\nuser-data\nvendor-data\n",
+		},
+		{
+			Name:      "UserData",
+			Endpoints: []string{"/"},
+			Path:      "/2009-04-04/user-data",
+			Expect:    "#cloud-config",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			client.EXPECT().
+				GetEC2Instance(gomock.Any(), gomock.Any()).
+				Return(instance, nil).
+				AnyTimes()
+
+			router := gin.New()
+			New(client, WithTrailingNewlines(tc.Endpoints)).Configure(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.Path, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+
+			if !bytes.Equal(w.Body.Bytes(), []byte(tc.Expect)) {
+				t.Fatalf("Expected: %q; Received: %q", tc.Expect, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,115 +1,553 @@
 package ec2
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tinkerbell/hegel/internal/http/httperror"
+)
+
 // TODO(chrisdoherty4) Figure out a better way to model routes; this approach is clunky and
 // error prone. Ideally we have a way to define routes and retrieve the children of a route without
 // manually defining everything.
 
-type filterFunc func(i Instance) string
+// filterFunc retrieves the data for an endpoint from i. If the data cannot be produced, it returns
+// an error classified by one of the filter errors.
+type filterFunc func(i Instance) (value, error)
+
+// ignoreVars adapts f to a requestFilterFunc.
+func (f filterFunc) ignoreVars() requestFilterFunc {
+	return func(i Instance, _ requestVars) (value, error) {
+		return f(i)
+	}
+}
+
+// params provides access to the named parameters of a request path such as ":index".
+type params interface {
+	ByName(name string) string
+}
+
+// requestVars are request scoped variables available to filters in addition to the instance.
+type requestVars struct {
+	// ClientIP is the IP address the instance was identified by.
+	ClientIP string
+
+	// Path is the requested path including the API version prefix. For example,
+	// /2009-04-04/meta-data/public-keys/0.
+	Path string
+
+	// Params are the named parameters of the request path.
+	Params params
+}
+
+// requestFilterFunc is a filterFunc with access to request scoped variables. It is used for
+// endpoints containing named parameters and filters whose data depends on the requesting client.
+type requestFilterFunc func(i Instance, v requestVars) (value, error)
+
+// ErrNoResults indicates the instance has no data for the endpoint. Filters wrap it, for example
+// with fmt.Errorf and %w, and it is served as a 404. Other filter errors are served as a 500.
+var ErrNoResults = errors.New("no results")
+
+// filterError classifies err as ErrNoResults while retaining err's message.
+type filterError struct {
+	kind error
+	err  error
+}
+
+// Error satisfies the error interface.
+func (e *filterError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns both the classification and the underlying error for errors.Is and errors.As.
+func (e *filterError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// noResultsf returns an ErrNoResults error with a message formatted with fmt.Sprintf. The message
+// is served to the client.
+func noResultsf(format string, args ...any) error {
+	return httperror.Wrap(http.StatusNotFound, &filterError{
+		kind: ErrNoResults,
+		err:  fmt.Errorf(format, args...),
+	})
+}
+
+// filterErrorStatus maps err, returned by a filter, to the HTTP status it is served with and an
+// error kind for metrics.
+func filterErrorStatus(err error) (status int, kind string) {
+	var httpErr *httperror.E
+	switch {
+	case errors.Is(err, ErrNoResults):
+		return http.StatusNotFound, statusErrorKind(http.StatusNotFound)
+	case errors.Is(err, errOversizedUserData):
+		return http.StatusInternalServerError, "oversized_user_data"
+	case errors.Is(err, errUserDataTemplate):
+		return http.StatusInternalServerError, "user_data_template"
+	case errors.As(err, &httpErr):
+		return httpErr.StatusCode, statusErrorKind(httpErr.StatusCode)
+	default:
+		return http.StatusInternalServerError, "filter"
+	}
+}
 
 var dataRoutes = []struct {
 	Endpoint string
 	Filter   filterFunc
 }{
 	{
-		Endpoint: "/user-data",
-		Filter: func(i Instance) string {
-			return i.Userdata
+		Endpoint: userDataEndpoint,
+		Filter: func(i Instance) (value, error) {
+			return userData(i.Userdata), nil
+		},
+	},
+	{
+		Endpoint: "/vendor-data",
+		Filter: func(i Instance) (value, error) {
+			return userData(i.Vendordata), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/instance-id",
-		Filter: func(i Instance) string {
-			return i.Metadata.InstanceID
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.InstanceID), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/hostname",
-		Filter: func(i Instance) string {
-			return i.Metadata.Hostname
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Hostname), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/local-hostname",
-		Filter: func(i Instance) string {
-			return i.Metadata.LocalHostname
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.LocalHostname), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/iqn",
-		Filter: func(i Instance) string {
-			return i.Metadata.IQN
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.IQN), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/plan",
-		Filter: func(i Instance) string {
-			return i.Metadata.Plan
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Plan), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/instance-type",
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Plan), nil
+		},
+	},
+	{
+		// Hardware isn't launched from an AMI so the operating system image is the closest
+		// equivalent.
+		Endpoint: "/meta-data/ami-id",
+		Filter: func(i Instance) (value, error) {
+			if i.Metadata.OperatingSystem.ImageTag != "" {
+				return scalar(i.Metadata.OperatingSystem.ImageTag), nil
+			}
+			return scalar(i.Metadata.OperatingSystem.Slug), nil
+		},
+	},
+	{
+		// Each instance is launched on its own so is always the first of its launch.
+		Endpoint: "/meta-data/ami-launch-index",
+		Filter: func(Instance) (value, error) {
+			return scalar("0"), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/facility",
-		Filter: func(i Instance) string {
-			return i.Metadata.Facility
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Facility), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/placement/availability-zone",
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Facility), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/placement/region",
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Region), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/services/domain",
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Services.Domain), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/services/partition",
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.Services.Partition), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/tags",
-		Filter: func(i Instance) string {
-			return join(i.Metadata.Tags)
+		Filter: func(i Instance) (value, error) {
+			return list(i.Metadata.Tags), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/public-ipv4",
-		Filter: func(i Instance) string {
-			return i.Metadata.PublicIPv4
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.PublicIPv4), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/public-ipv6",
-		Filter: func(i Instance) string {
-			return i.Metadata.PublicIPv6
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.PublicIPv6), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/local-ipv4",
-		Filter: func(i Instance) string {
-			return i.Metadata.LocalIPv4
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.LocalIPv4), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/public-keys",
-		Filter: func(i Instance) string {
-			return join(i.Metadata.PublicKeys)
+		Filter: func(i Instance) (value, error) {
+			return list(i.Metadata.PublicKeys), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/mac",
+		Filter: func(i Instance) (value, error) {
+			if len(i.Metadata.Interfaces) == 0 {
+				return scalar(""), nil
+			}
+			return scalar(normalizeMAC(i.Metadata.Interfaces[0].MAC)), nil
+		},
+	},
+	{
+		// Each MAC is listed as a directory, "<mac>/", as its data is served beneath it.
+		Endpoint: "/meta-data/network/interfaces/macs",
+		Filter: func(i Instance) (value, error) {
+			macs := list{}
+			for _, iface := range i.Metadata.Interfaces {
+				macs = append(macs, normalizeMAC(iface.MAC)+"/")
+			}
+			return macs, nil
+		},
+	},
+	{
+		// The termination time is served in the UTC RFC 3339 form AWS uses, for example
+		// 2015-01-05T18:02:00Z.
+		Endpoint: "/meta-data/spot/termination-time",
+		Filter: func(i Instance) (value, error) {
+			spot, err := spot(i)
+			if err != nil {
+				return nil, err
+			}
+			if spot.TerminationTime.IsZero() {
+				return nil, noResultsf("no spot termination scheduled")
+			}
+			return scalar(spot.TerminationTime.UTC().Format(time.RFC3339)), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/instance-action",
+		Filter: func(i Instance) (value, error) {
+			return scalar(instanceAction(i)), nil
+		},
+	},
+	{
+		// Scheduled events are a JSON document, as served by AWS, describing the pending instance
+		// action, if any.
+		Endpoint: "/meta-data/events/maintenance/scheduled",
+		Filter: func(i Instance) (value, error) {
+			events := []maintenanceEvent{}
+			if action := instanceAction(i); action != noInstanceAction {
+				events = append(events, maintenanceEvent{
+					Code:        "instance-" + action,
+					Description: "The instance is scheduled for " + action,
+					State:       "active",
+				})
+			}
+
+			b, err := json.Marshal(events)
+			if err != nil {
+				return nil, err
+			}
+			return scalar(b), nil
+		},
+	},
+	{
+		// Each block device is listed by its mapping name; the device name is served beneath it.
+		Endpoint: "/meta-data/block-device-mapping",
+		Filter: func(i Instance) (value, error) {
+			return list(blockDeviceNames(i)), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/operating-system/slug",
-		Filter: func(i Instance) string {
-			return i.Metadata.OperatingSystem.Slug
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.OperatingSystem.Slug), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/operating-system/distro",
-		Filter: func(i Instance) string {
-			return i.Metadata.OperatingSystem.Distro
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.OperatingSystem.Distro), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/operating-system/version",
-		Filter: func(i Instance) string {
-			return i.Metadata.OperatingSystem.Version
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.OperatingSystem.Version), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/operating-system/image_tag",
-		Filter: func(i Instance) string {
-			return i.Metadata.OperatingSystem.ImageTag
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.OperatingSystem.ImageTag), nil
 		},
 	},
 	{
 		Endpoint: "/meta-data/operating-system/license_activation/state",
-		Filter: func(i Instance) string {
-			return i.Metadata.OperatingSystem.LicenseActivation.State
+		Filter: func(i Instance) (value, error) {
+			return scalar(i.Metadata.OperatingSystem.LicenseActivation.State), nil
+		},
+	},
+}
+
+// conditionalDirectories are directories that only exist for instances satisfying a condition.
+// Requests for a conditional directory, or anything beneath it, are not found for other instances
+// and their parent directory listings omit them.
+var conditionalDirectories = map[string]func(i Instance) bool{
+	"/meta-data/spot": func(i Instance) bool {
+		return i.Metadata.Spot != nil
+	},
+	"/meta-data/block-device-mapping": func(i Instance) bool {
+		return len(i.Metadata.Disks) > 0
+	},
+}
+
+// exists returns true if endpoint exists for i. Endpoints beneath a conditional directory exist
+// only if i satisfies the directory's condition.
+func exists(endpoint string, i Instance) bool {
+	for dir, cond := range conditionalDirectories {
+		if (endpoint == dir || strings.HasPrefix(endpoint, dir+"/")) && !cond(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConditional returns true if whether endpoint, or any of its children, exists depends on the
+// instance.
+func isConditional(endpoint string, children []string) bool {
+	for dir := range conditionalDirectories {
+		if endpoint == dir || strings.HasPrefix(endpoint, dir+"/") {
+			return true
+		}
+		for _, child := range children {
+			if endpoint+"/"+strings.TrimSuffix(child, "/") == dir {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// paramRoutes are data routes containing named parameters. They don't contribute to static routes
+// because their parent directories are served by dataRoutes.
+var paramRoutes = []struct {
+	Endpoint string
+	Filter   requestFilterFunc
+}{
+	{
+		// The indexed public key form, "public-keys/<N>/openssh-key", is what cloud-init expects.
+		// Indices follow the order keys are defined on the instance so they're stable across
+		// requests.
+		Endpoint: "/meta-data/public-keys/:index",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			if _, err := publicKey(i, v.Params.ByName("index")); err != nil {
+				return nil, err
+			}
+			return list{"openssh-key"}, nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/public-keys/:index/openssh-key",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			key, err := publicKey(i, v.Params.ByName("index"))
+			if err != nil {
+				return nil, err
+			}
+			return scalar(key), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/block-device-mapping/:device",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			device, err := blockDevice(i, v.Params.ByName("device"))
+			if err != nil {
+				return nil, err
+			}
+			return scalar(device), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/network/interfaces/macs/:mac",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			if _, _, err := networkInterface(i, v.Params.ByName("mac")); err != nil {
+				return nil, err
+			}
+			return list{"device-number", "local-ipv4s", "mac", "public-ipv4s"}, nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/network/interfaces/macs/:mac/device-number",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			idx, _, err := networkInterface(i, v.Params.ByName("mac"))
+			if err != nil {
+				return nil, err
+			}
+			return scalar(strconv.Itoa(idx)), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/network/interfaces/macs/:mac/local-ipv4s",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			_, iface, err := networkInterface(i, v.Params.ByName("mac"))
+			if err != nil {
+				return nil, err
+			}
+			return list(iface.LocalIPv4s), nil
+		},
+	},
+	{
+		Endpoint: "/meta-data/network/interfaces/macs/:mac/mac",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			_, iface, err := networkInterface(i, v.Params.ByName("mac"))
+			if err != nil {
+				return nil, err
+			}
+			return scalar(normalizeMAC(iface.MAC)), nil
 		},
 	},
+	{
+		Endpoint: "/meta-data/network/interfaces/macs/:mac/public-ipv4s",
+		Filter: func(i Instance, v requestVars) (value, error) {
+			_, iface, err := networkInterface(i, v.Params.ByName("mac"))
+			if err != nil {
+				return nil, err
+			}
+			return list(iface.PublicIPv4s), nil
+		},
+	},
+}
+
+// publicKey retrieves the public key at index from i. If index isn't a valid index for the
+// instances public keys it returns an ErrNoResults error.
+func publicKey(i Instance, index string) (string, error) {
+	idx, err := strconv.Atoi(index)
+	if err != nil || idx < 0 || idx >= len(i.Metadata.PublicKeys) {
+		return "", noResultsf("public key not found: %v", index)
+	}
+	return i.Metadata.PublicKeys[idx], nil
+}
+
+// ephemeralBlockDevicePrefix prefixes the mapping names of disks other than the root disk. They're
+// numbered from 0 in the order the instance defines them, for example ephemeral0.
+const ephemeralBlockDevicePrefix = "ephemeral"
+
+// blockDeviceNames returns the block device mapping names of i's disks in the order they're
+// listed. The root disk is mapped as both the ami and root devices, as AWS does for instances
+// booted from their image's root device, and every other disk is an ephemeral device.
+func blockDeviceNames(i Instance) []string {
+	if len(i.Metadata.Disks) == 0 {
+		return nil
+	}
+
+	names := []string{"ami"}
+	for idx := range i.Metadata.Disks[1:] {
+		names = append(names, ephemeralBlockDevicePrefix+strconv.Itoa(idx))
+	}
+	return append(names, "root")
+}
+
+// blockDevice retrieves the device name of the disk mapped as name from i. If no disk is mapped
+// as name it returns an ErrNoResults error.
+func blockDevice(i Instance, name string) (string, error) {
+	disks := i.Metadata.Disks
+	if len(disks) > 0 {
+		if name == "ami" || name == "root" {
+			return disks[0], nil
+		}
+		for idx, disk := range disks[1:] {
+			if name == ephemeralBlockDevicePrefix+strconv.Itoa(idx) {
+				return disk, nil
+			}
+		}
+	}
+	return "", noResultsf("block device not found: %v", name)
+}
+
+// noInstanceAction is the instance action served when no action is pending.
+const noInstanceAction = "none"
+
+// instanceAction retrieves the action pending on i defaulting to noInstanceAction.
+func instanceAction(i Instance) string {
+	if i.Metadata.InstanceAction == "" {
+		return noInstanceAction
+	}
+	return i.Metadata.InstanceAction
+}
+
+// maintenanceEvent is a scheduled maintenance event in the form served by AWS.
+type maintenanceEvent struct {
+	Code        string `json:"Code"`
+	Description string `json:"Description"`
+	State       string `json:"State"`
+}
+
+// spot retrieves the spot data of i. If i isn't a spot instance it returns an ErrNoResults error.
+func spot(i Instance) (Spot, error) {
+	if i.Metadata.Spot == nil {
+		return Spot{}, noResultsf("not a spot instance")
+	}
+	return *i.Metadata.Spot, nil
+}
+
+// networkInterface retrieves the network interface with mac, and its device number, from i. MACs
+// are compared in their normalized form so requests may use any case. If no interface has mac it
+// returns an ErrNoResults error.
+func networkInterface(i Instance, mac string) (int, NetworkInterface, error) {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		for idx, iface := range i.Metadata.Interfaces {
+			if normalizeMAC(iface.MAC) == hw.String() {
+				return idx, iface, nil
+			}
+		}
+	}
+	return 0, NetworkInterface{}, noResultsf("network interface not found: %v", mac)
+}
+
+// normalizeMAC returns mac in the lower case, colon separated form EC2 uses. Invalid MACs are
+// returned unchanged.
+func normalizeMAC(mac string) string {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return mac
+	}
+	return hw.String()
 }
@@ -0,0 +1,81 @@
+package ec2_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	"github.com/google/go-cmp/cmp"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	// The Frontend uses the global TracerProvider so we must replace it. The global TracerProvider
+	// can't be reset so it remains set for the remainder of the test run.
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	ctrl := gomock.NewController(t)
+	client := NewMockClient(ctrl)
+	client.EXPECT().
+		GetEC2Instance(gomock.Any(), "10.10.10.10").
+		Return(Instance{Metadata: Metadata{InstanceID: "instance-id"}}, nil)
+
+	// Middleware, such as metrics, observe the handler's span through the request context.
+	var observed trace.SpanContext
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Next()
+		observed = trace.SpanContextFromContext(ctx.Request.Context())
+	})
+
+	fe := New(client)
+	fe.Configure(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/2009-04-04/meta-data/instance-id", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+
+	// Spans are exported as they end so children precede their parent.
+	var names []string
+	for _, s := range spans {
+		names = append(names, s.Name)
+	}
+	expect := []string{"ec2.GetEC2Instance", "ec2.filter", "ec2.render", "ec2.metadata"}
+	if !cmp.Equal(names, expect) {
+		t.Fatal(cmp.Diff(expect, names))
+	}
+
+	root := spans[len(spans)-1]
+	for _, s := range spans[:len(spans)-1] {
+		if s.Parent.SpanID() != root.SpanContext.SpanID() {
+			t.Fatalf("Expected %v to be a child of %v", s.Name, root.Name)
+		}
+	}
+
+	if !observed.Equal(root.SpanContext) {
+		t.Fatalf("Expected middleware to observe %v; Received: %v", root.SpanContext.SpanID(), observed.SpanID())
+	}
+
+	attrs := map[string]string{}
+	for _, a := range root.Attributes {
+		attrs[string(a.Key)] = a.Value.Emit()
+	}
+	if attrs["client.address"] != "10.10.10.10" || attrs["url.path"] != "/2009-04-04/meta-data/instance-id" {
+		t.Fatalf("Unexpected attributes: %v", attrs)
+	}
+}
@@ -0,0 +1,84 @@
+package ec2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// buildTree assembles the data of every data endpoint beneath directory into a nested object
+// mirroring the endpoint hierarchy. directory is relative to the API version prefix, for example
+// "/meta-data", and is empty for the root. Scalars are represented as strings and lists as string
+// slices. Endpoints that don't exist for i, or whose filter returns ErrNoResults, are omitted.
+// User-data is rendered as a template by templates, if not nil, and user-data larger than
+// maxUserDataSize bytes, unless it is 0, fails like a filter. If skipFailed is true, endpoints
+// whose filter fails are also omitted and their errors are returned as skipped rather than failing
+// the tree.
+func buildTree(
+	i Instance,
+	directory string,
+	maxUserDataSize int,
+	templates *userDataTemplates,
+	skipFailed bool,
+) (tree map[string]any, skipped []error, err error) {
+	tree = map[string]any{}
+	prefix := directory + "/"
+
+	for _, r := range dataRoutes {
+		if !strings.HasPrefix(r.Endpoint, prefix) || !exists(r.Endpoint, i) {
+			continue
+		}
+
+		v, err := r.Filter(i)
+		if err == nil {
+			v, err = templates.render(r.Endpoint, i, v)
+		}
+		if u, ok := v.(userData); ok && err == nil {
+			err = checkUserDataSize(u, maxUserDataSize)
+		}
+		if err != nil {
+			if errors.Is(err, ErrNoResults) {
+				continue
+			}
+			if skipFailed {
+				skipped = append(skipped, fmt.Errorf("%v: %w", r.Endpoint, err))
+				continue
+			}
+			return nil, nil, err
+		}
+
+		insert(tree, strings.Split(strings.TrimPrefix(r.Endpoint, prefix), "/"), treeValue(v))
+	}
+
+	return tree, skipped, nil
+}
+
+// insert adds v to tree at the path described by keys creating intermediate objects as needed.
+func insert(tree map[string]any, keys []string, v any) {
+	for _, k := range keys[:len(keys)-1] {
+		child, ok := tree[k].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			tree[k] = child
+		}
+		tree = child
+	}
+	tree[keys[len(keys)-1]] = v
+}
+
+// treeValue converts v to its representation in a tree.
+func treeValue(v value) any {
+	switch v := v.(type) {
+	case scalar:
+		return string(v)
+	case userData:
+		return string(v)
+	case list:
+		if v == nil {
+			return []string{}
+		}
+		return []string(v)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,122 @@
+package ec2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/http/request"
+)
+
+// upstreamHeaders are the request headers forwarded upstream. Other headers, such as those used to
+// identify instances to Hegel, are dropped so they aren't disclosed to the upstream.
+var upstreamHeaders = []string{
+	"Accept",
+	"User-Agent",
+
+	// AWS IMDSv2 session tokens and their requested lifetime.
+	"X-Aws-Ec2-Metadata-Token",
+	"X-Aws-Ec2-Metadata-Token-Ttl-Seconds",
+}
+
+// upstreamResponseHeaders are the response headers returned from upstream. Other headers, such as
+// cookies or those describing the upstream's infrastructure, are dropped so they aren't disclosed
+// to instances.
+var upstreamResponseHeaders = []string{
+	"Cache-Control",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+	"Retry-After",
+
+	// The lifetime of AWS IMDSv2 session tokens.
+	"X-Aws-Ec2-Metadata-Token-Ttl-Seconds",
+}
+
+// upstreamResponseHeaderTimeout is how long to wait for the upstream's response headers before
+// failing the request so an unresponsive upstream can't hold requests open indefinitely.
+var upstreamResponseHeaderTimeout = 10 * time.Second
+
+// ValidateUpstream ensures target is suitable for WithUpstream.
+func ValidateUpstream(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("upstream must be an http or https URL, got %q", target.String())
+	}
+	if target.Host == "" {
+		return fmt.Errorf("upstream must include a host, got %q", target.String())
+	}
+	return nil
+}
+
+// newUpstream creates a reverse proxy forwarding requests to the same path beneath target. The
+// method and body are forwarded with the upstreamHeaders and the client's IP in
+// X-Forwarded-For so an upstream Hegel can identify the instance. Responses are streamed back as
+// they're received with only the upstreamResponseHeaders. Requests whose response headers aren't
+// received within upstreamResponseHeaderTimeout fail.
+func newUpstream(target *url.URL) *httputil.ReverseProxy {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = upstreamResponseHeaderTimeout
+
+	return &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+
+			pr.Out.Header = http.Header{}
+			for _, h := range upstreamHeaders {
+				if v, ok := pr.In.Header[h]; ok {
+					pr.Out.Header[h] = v
+				}
+			}
+			if ip, err := request.RemoteAddrIP(pr.In); err == nil {
+				pr.Out.Header.Set("X-Forwarded-For", ip)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			header := http.Header{}
+			for _, h := range upstreamResponseHeaders {
+				if v, ok := resp.Header[h]; ok {
+					header[h] = v
+				}
+			}
+			resp.Header = header
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			ctx, ok := r.Context().Value(upstreamContextKey{}).(*gin.Context)
+			if !ok {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			abort(ctx, http.StatusBadGateway, "upstream", err, "upstream request failed for "+ctx.Request.URL.Path)
+		},
+	}
+}
+
+// upstreamContextKey is the request context key of the gin.Context a request is proxied for so
+// proxy errors can be recorded like other errors.
+type upstreamContextKey struct{}
+
+// proxy forwards the request of ctx upstream and streams the response back.
+func (f Frontend) proxy(ctx *gin.Context) {
+	r := ctx.Request.WithContext(context.WithValue(ctx.Request.Context(), upstreamContextKey{}, ctx))
+	f.upstream.ServeHTTP(upstreamWriter{ctx.Writer}, r)
+	ctx.Abort()
+}
+
+// upstreamWriter exposes only the parts of gin's writer the proxy needs. gin's writer claims to
+// implement http.CloseNotifier regardless of the underlying writer and panics when it doesn't.
+type upstreamWriter struct {
+	w gin.ResponseWriter
+}
+
+func (w upstreamWriter) Header() http.Header         { return w.w.Header() }
+func (w upstreamWriter) Write(b []byte) (int, error) { return w.w.Write(b) }
+func (w upstreamWriter) WriteHeader(code int)        { w.w.WriteHeader(code) }
+func (w upstreamWriter) Flush()                      { w.w.Flush() }
@@ -0,0 +1,256 @@
+package ec2_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+	. "github.com/tinkerbell/hegel/internal/frontend/ec2"
+)
+
+func TestUpstream(t *testing.T) {
+	// The upstream serves paths Hegel doesn't, echoing what it received.
+	var received *http.Request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Clone(r.Context())
+
+		// Headers outside the allowlist aren't returned to instances.
+		w.Header().Set("Set-Cookie", "session=secret")
+
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/meta-data/iam/info":
+			_, _ = io.WriteString(w, "latest")
+		case r.Method == http.MethodGet && r.URL.Path == "/2009-04-04/meta-data/iam/info":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"Code":"Success"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name            string
+		Method          string
+		Path            string
+		Options         []Option
+		ExpectCode      int
+		ExpectBody      string
+		ExpectProxied   bool
+		ExpectGetLookup bool
+	}{
+		{
+			Name:          "UnknownPath",
+			Method:        http.MethodGet,
+			Path:          "/2009-04-04/meta-data/iam/info",
+			ExpectCode:    http.StatusOK,
+			ExpectBody:    `{"Code":"Success"}`,
+			ExpectProxied: true,
+		},
+		{
+			Name:          "LatestAPIVersion",
+			Method:        http.MethodPut,
+			Path:          "/latest/meta-data/iam/info",
+			ExpectCode:    http.StatusOK,
+			ExpectBody:    "latest",
+			ExpectProxied: true,
+		},
+		{
+			Name:          "UpstreamNotFound",
+			Method:        http.MethodGet,
+			Path:          "/2009-04-04/meta-data/bogus",
+			ExpectCode:    http.StatusNotFound,
+			ExpectBody:    "404 page not found\n",
+			ExpectProxied: true,
+		},
+		{
+			Name:          "PrecedesParentListing",
+			Method:        http.MethodGet,
+			Path:          "/2009-04-04/meta-data/iam/info",
+			Options:       []Option{WithParentListing(true)},
+			ExpectCode:    http.StatusOK,
+			ExpectBody:    `{"Code":"Success"}`,
+			ExpectProxied: true,
+		},
+		{
+			Name:            "KnownPath",
+			Method:          http.MethodGet,
+			Path:            "/2009-04-04/meta-data/hostname",
+			ExpectCode:      http.StatusOK,
+			ExpectBody:      "sm01",
+			ExpectGetLookup: true,
+		},
+		{
+			// Paths that aren't metadata aren't proxied so Hegel isn't an open proxy.
+			Name:       "NotMetadata",
+			Method:     http.MethodGet,
+			Path:       "/bogus",
+			ExpectCode: http.StatusNotFound,
+			ExpectBody: "404 page not found",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			received = nil
+
+			ctrl := gomock.NewController(t)
+			client := NewMockClient(ctrl)
+			if tc.ExpectGetLookup {
+				client.EXPECT().
+					GetEC2Instance(gomock.Any(), "10.10.10.10").
+					Return(Instance{Metadata: Metadata{Hostname: "sm01"}}, nil)
+			}
+
+			router := gin.New()
+			fe := New(client, append(tc.Options, WithUpstream(target))...)
+			fe.Configure(router)
+			router.NoRoute(fe.NotFound)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.Method, tc.Path, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			r.Header.Set("X-Aws-Ec2-Metadata-Token", "token")
+			r.Header.Set("X-Hegel-Mac", "3c:ec:ef:4c:4f:54")
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectCode {
+				t.Fatalf("Expected: %d; Received: %d", tc.ExpectCode, w.Code)
+			}
+			if body := w.Body.String(); body != tc.ExpectBody {
+				t.Fatalf("Expected: %q; Received: %q", tc.ExpectBody, body)
+			}
+
+			if !tc.ExpectProxied {
+				if received != nil {
+					t.Fatalf("Unexpected upstream request: %v", received.URL)
+				}
+				return
+			}
+
+			if received == nil {
+				t.Fatal("Expected upstream request")
+			}
+			if received.Method != tc.Method || received.URL.Path != tc.Path {
+				t.Fatalf("Expected: %v %v; Received: %v %v", tc.Method, tc.Path, received.Method, received.URL.Path)
+			}
+			if v := received.Header.Get("X-Aws-Ec2-Metadata-Token"); v != "token" {
+				t.Fatalf("Expected token to be forwarded; Received: %q", v)
+			}
+			if v := received.Header.Get("X-Forwarded-For"); v != "10.10.10.10" {
+				t.Fatalf("Expected X-Forwarded-For: 10.10.10.10; Received: %q", v)
+			}
+			if v := received.Header.Get("X-Hegel-Mac"); v != "" {
+				t.Fatalf("Expected X-Hegel-Mac to be dropped; Received: %q", v)
+			}
+			if v := w.Header().Get("Set-Cookie"); v != "" {
+				t.Fatalf("Expected Set-Cookie to be dropped; Received: %q", v)
+			}
+			if w.Header().Get("Content-Type") == "" {
+				t.Fatal("Expected Content-Type to be returned")
+			}
+		})
+	}
+}
+
+func TestUpstreamUnavailable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.Close()
+
+	router := gin.New()
+	fe := New(NewMockClient(gomock.NewController(t)), WithUpstream(target))
+	fe.Configure(router)
+	router.NoRoute(fe.NotFound)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/iam/info", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("Expected: 502; Received: %d", w.Code)
+	}
+	if body := w.Body.String(); body != "upstream request failed for /2009-04-04/meta-data/iam/info" {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	restore := SetUpstreamResponseHeaderTimeout(10 * time.Millisecond)
+	defer restore()
+
+	// The upstream never responds until it's closed.
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		<-unblock
+	}))
+	defer upstream.Close()
+	defer close(unblock)
+
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	fe := New(NewMockClient(gomock.NewController(t)), WithUpstream(target))
+	fe.Configure(router)
+	router.NoRoute(fe.NotFound)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/2009-04-04/meta-data/iam/info", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("Expected: 502; Received: %d", w.Code)
+	}
+}
+
+func TestValidateUpstream(t *testing.T) {
+	cases := []struct {
+		URL         string
+		ExpectError bool
+	}{
+		{URL: "http://169.254.169.254"},
+		{URL: "https://hegel.example.com/prefix"},
+		{URL: "ftp://hegel.example.com", ExpectError: true},
+		{URL: "hegel.example.com", ExpectError: true},
+		{URL: "http://", ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(strings.ReplaceAll(tc.URL, "/", "_"), func(t *testing.T) {
+			u, err := url.Parse(tc.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ValidateUpstream(u)
+			if tc.ExpectError && err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+			if !tc.ExpectError && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
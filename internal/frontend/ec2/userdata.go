@@ -0,0 +1,309 @@
+package ec2
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/tinkerbell/hegel/internal/http/httperror"
+)
+
+// userData is an instance's user-data. Its media type may be sniffed from its content.
+type userData string
+
+func (u userData) render(w io.Writer, r Renderer) error {
+	return r.Scalar(w, string(u))
+}
+
+// User-data encodings clients may request with the encoding query parameter.
+const (
+	userDataEncodingRaw    = "raw"
+	userDataEncodingBase64 = "base64"
+)
+
+// base64UserData is user-data served base64 encoded. Text renderings are encoded as they're
+// written so the encoded user-data, which is larger than the user-data, is never held in memory.
+type base64UserData userData
+
+func (u base64UserData) render(w io.Writer, r Renderer) error {
+	if _, ok := r.(TextRenderer); !ok {
+		return r.Scalar(w, base64.StdEncoding.EncodeToString([]byte(u)))
+	}
+
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+
+	// Copy the user-data through a fixed buffer as converting it to a byte slice for the encoder
+	// would copy all of it.
+	var chunk [3 << 10]byte
+	for i := 0; i < len(u); {
+		n := copy(chunk[:], u[i:])
+		if _, err := enc.Write(chunk[:n]); err != nil {
+			return err
+		}
+		i += n
+	}
+
+	return enc.Close()
+}
+
+// errOversizedUserData indicates user-data exceeds the maximum size configured with
+// WithMaxUserDataSize.
+var errOversizedUserData = errors.New("user-data exceeds the maximum size")
+
+// checkUserDataSize returns an error wrapping errOversizedUserData if u is larger than max bytes. A
+// max of 0 or less is unlimited.
+func checkUserDataSize(u userData, max int) error {
+	if max > 0 && len(u) > max {
+		return fmt.Errorf("%w: %d bytes exceeds %d", errOversizedUserData, len(u), max)
+	}
+	return nil
+}
+
+// errUserDataTemplate indicates user-data couldn't be rendered as a template.
+var errUserDataTemplate = errors.New("failed to render user-data template")
+
+// userDataTemplates renders user-data as templates. The template parsed from each instance's
+// user-data is cached, keyed by instance ID, so requests for unchanged user-data aren't parsed
+// again. A nil *userDataTemplates doesn't render templates.
+type userDataTemplates struct {
+	mu     sync.Mutex
+	parsed map[string]parsedTemplate
+}
+
+// parsedTemplate is a template and the user-data it was parsed from.
+type parsedTemplate struct {
+	source string
+	tmpl   *template.Template
+}
+
+// newUserDataTemplates creates a userDataTemplates with an empty cache.
+func newUserDataTemplates() *userDataTemplates {
+	return &userDataTemplates{parsed: map[string]parsedTemplate{}}
+}
+
+// render returns v, the data of endpoint for i, rendered as a text/template with i's Metadata as
+// the data if endpoint serves user-data so, for example, {{ .Hostname }} is replaced with the
+// instance's hostname. Other data is returned as is. If the template can't be parsed or executed,
+// such as when it references an unknown field, it returns an error wrapping errUserDataTemplate
+// and nothing is rendered.
+func (t *userDataTemplates) render(endpoint string, i Instance, v value) (value, error) {
+	u, ok := v.(userData)
+	if t == nil || !ok || endpoint != userDataEndpoint {
+		return v, nil
+	}
+
+	tmpl, err := t.parse(i.Metadata.InstanceID, string(u))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUserDataTemplate, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, i.Metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUserDataTemplate, err)
+	}
+
+	return userData(b.String()), nil
+}
+
+// parse returns the template parsed from source, the user-data of the instance identified by id,
+// reusing the template cached for the instance if its user-data is unchanged. Instances without
+// an ID aren't cached.
+func (t *userDataTemplates) parse(id, source string) (*template.Template, error) {
+	t.mu.Lock()
+	cached, ok := t.parsed[id]
+	t.mu.Unlock()
+
+	if ok && cached.source == source {
+		return cached.tmpl, nil
+	}
+
+	tmpl, err := template.New("user-data").Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if id != "" {
+		t.mu.Lock()
+		t.parsed[id] = parsedTemplate{source: source, tmpl: tmpl}
+		t.mu.Unlock()
+	}
+
+	return tmpl, nil
+}
+
+// UserDataSignatureHeader is the header carrying the signature of user-data responses when a
+// signing key is configured. See WithUserDataSigningKey.
+const UserDataSignatureHeader = "X-Hegel-User-Data-Signature"
+
+// Endpoints serving user-data and its detached signature.
+const (
+	userDataEndpoint          = "/user-data"
+	userDataSignatureEndpoint = "/user-data.sig"
+)
+
+// signUserData returns the base64 encoded Ed25519 signature by key of u, served by endpoint for
+// the instance identified by instanceID. The signed message is "<instanceID>\n<endpoint>\n<u>" so
+// signatures can't be replayed for other instances or endpoints. See WithUserDataSigningKey.
+func signUserData(key ed25519.PrivateKey, instanceID, endpoint string, u userData) string {
+	msg := instanceID + "\n" + endpoint + "\n" + string(u)
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(msg)))
+}
+
+// encodeUserData encodes u using encoding. An empty encoding is equivalent to raw which returns u
+// unchanged. Encoded user-data isn't a userData as its media type can no longer be sniffed. Unknown
+// encodings return a bad request error.
+func encodeUserData(u userData, encoding string) (value, error) {
+	switch encoding {
+	case "", userDataEncodingRaw:
+		return u, nil
+	case userDataEncodingBase64:
+		return base64UserData(u), nil
+	default:
+		return nil, httperror.Newf(http.StatusBadRequest, "unsupported encoding: %v", encoding)
+	}
+}
+
+// User-data media types as understood by cloud-init and Ignition.
+const (
+	shellScriptContentType   = "text/x-shellscript"
+	cloudConfigContentType   = "text/cloud-config"
+	cloudBoothookContentType = "text/cloud-boothook"
+	includeURLContentType    = "text/x-include-url"
+	ignitionContentType      = "application/vnd.coreos.ignition+json"
+	jsonContentType          = "application/json"
+	plainTextContentType     = "text/plain; charset=utf-8"
+	multipartContentType     = "multipart/mixed"
+)
+
+// sniffUserDataContentType determines the media type of user-data based on the leading characters
+// used by cloud-init and Ignition to identify the payload format. JSON payloads containing a
+// top level "ignition" key are considered Ignition configs. If the format isn't recognized, it
+// returns a plain text media type.
+func sniffUserDataContentType(data string) string {
+	switch {
+	case strings.HasPrefix(data, "#!"):
+		return shellScriptContentType
+	case strings.HasPrefix(data, "#cloud-config"):
+		return cloudConfigContentType
+	case strings.HasPrefix(data, "#cloud-boothook"):
+		return cloudBoothookContentType
+	case strings.HasPrefix(data, "#include"):
+		return includeURLContentType
+	case strings.HasPrefix(data, "Content-Type: multipart/"):
+		return multipartContentType
+	case strings.HasPrefix(strings.TrimSpace(data), "{"):
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(data), &doc); err == nil {
+			if _, ok := doc["ignition"]; ok {
+				return ignitionContentType
+			}
+		}
+		return jsonContentType
+	default:
+		return plainTextContentType
+	}
+}
+
+// UserDataMerge is a strategy for composing user-data fragments with an instance's user-data.
+type UserDataMerge string
+
+const (
+	// UserDataMergeMultipart composes user-data as a cloud-init multipart MIME archive with a part
+	// for each fragment followed by the instance's user-data.
+	UserDataMergeMultipart UserDataMerge = "multipart"
+
+	// UserDataMergeConcat concatenates fragments and the instance's user-data separated by new
+	// lines. It is only suitable for formats that remain valid when concatenated.
+	UserDataMergeConcat UserDataMerge = "concat"
+)
+
+// Validate ensures m is a known strategy.
+func (m UserDataMerge) Validate() error {
+	switch m {
+	case UserDataMergeMultipart, UserDataMergeConcat:
+		return nil
+	default:
+		return fmt.Errorf("unknown user-data merge strategy: %q", m)
+	}
+}
+
+// mergeUserData composes fragments and data, in order, using strategy m. Empty parts are omitted.
+// If there is a single part it is returned as is.
+func mergeUserData(m UserDataMerge, fragments []string, data string) (string, error) {
+	var parts []string
+	for _, p := range fragments {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if data != "" {
+		parts = append(parts, data)
+	}
+
+	switch {
+	case len(parts) == 0:
+		return "", nil
+	case len(parts) == 1:
+		return parts[0], nil
+	case m == UserDataMergeConcat:
+		var b strings.Builder
+		for _, p := range parts {
+			b.WriteString(p)
+			if !strings.HasSuffix(p, "\n") {
+				b.WriteString("\n")
+			}
+		}
+		return b.String(), nil
+	default:
+		return multipartUserData(parts)
+	}
+}
+
+// multipartUserData builds a cloud-init multipart MIME archive from parts. The boundary is derived
+// from the parts so identical user-data renders identically.
+func multipartUserData(parts []string) (string, error) {
+	hash := sha256.New()
+	for _, p := range parts {
+		hash.Write([]byte(p))
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.SetBoundary(hex.EncodeToString(hash.Sum(nil))[:32]); err != nil {
+		return "", err
+	}
+
+	for i, p := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", sniffUserDataContentType(p))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="part-%03d"`, i+1))
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(pw, p); err != nil {
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n%s",
+		w.Boundary(), body.String()), nil
+}
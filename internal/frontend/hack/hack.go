@@ -8,13 +8,24 @@ package hack
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	ginrender "github.com/gin-gonic/gin/render"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/ginutil"
 	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+// metricsHandler is the handler label used when recording errors for the /metadata endpoint.
+const metricsHandler = "hack"
+
 // Client is a backend for retrieving hack instance data.
 type Client interface {
 	GetHackInstance(ctx context.Context, ip string) (Instance, error)
@@ -52,18 +63,63 @@ type Instance struct {
 
 // Configure configures router with a `/metadata` endpoint using client to retrieve instance data.
 func Configure(router gin.IRouter, client Client) {
+	tracer := otel.Tracer("github.com/tinkerbell/hegel/internal/frontend/hack")
+
 	router.GET("/metadata", func(ctx *gin.Context) {
+		reqCtx, span := ginutil.StartServerSpan(ctx, tracer, "hack.metadata")
+		defer span.End()
+
 		ip, err := request.RemoteAddrIP(ctx.Request)
 		if err != nil {
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("invalid remote address"))
+			_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+				Handler: metricsHandler,
+				Kind:    "request",
+			})
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "unable to determine the source IP of the request",
+			})
+			return
 		}
+		span.SetAttributes(attribute.String("client.address", ip))
 
-		instance, err := client.GetHackInstance(ctx, ip)
+		lookupCtx, lookupSpan := tracer.Start(reqCtx, "hack.GetHackInstance")
+		instance, err := client.GetHackInstance(lookupCtx, ip)
 		if err != nil {
-			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			lookupSpan.RecordError(err)
+			lookupSpan.SetStatus(codes.Error, err.Error())
+			lookupSpan.End()
+
+			// The hack frontend shares the EC2 backends so their errors apply.
+			status, _ := ec2.ErrorStatus(err)
+			_ = ctx.AbortWithError(status, err)
 			return
 		}
+		lookupSpan.End()
 
-		ctx.JSON(200, instance)
+		render(ctx, instance)
 	})
 }
+
+// render writes instance as JSON or, if preferred by the Accept header, as MessagePack. MessagePack
+// is a compact binary encoding for bandwidth constrained networks. It is produced from the JSON
+// document so both formats have identical keys and structure.
+func render(ctx *gin.Context, instance Instance) {
+	switch ctx.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK) {
+	case binding.MIMEMSGPACK2, binding.MIMEMSGPACK:
+		raw, err := json.Marshal(instance)
+		if err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		var doc map[string]any
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			_ = ctx.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		ctx.Render(http.StatusOK, ginrender.MsgPack{Data: doc})
+	default:
+		ctx.JSON(http.StatusOK, instance)
+	}
+}
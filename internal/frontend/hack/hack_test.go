@@ -0,0 +1,140 @@
+package hack_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/frontend/hack"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"github.com/ugorji/go/codec"
+)
+
+type fakeClient struct {
+	instance Instance
+}
+
+func (c fakeClient) GetHackInstance(context.Context, string) (Instance, error) {
+	return c.instance, nil
+}
+
+// lookupFailClient fails the test if an instance is looked up.
+type lookupFailClient struct {
+	t *testing.T
+}
+
+func (c lookupFailClient) GetHackInstance(_ context.Context, ip string) (Instance, error) {
+	c.t.Fatalf("Unexpected lookup: %q", ip)
+	return Instance{}, nil
+}
+
+func TestMetadataInvalidRemoteAddr(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	router.Use(metrics.InstrumentErrors(registry))
+	Configure(router, lookupFailClient{t: t})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+	r.RemoteAddr = "invalid"
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status: 400; Received status: %d", w.Code)
+	}
+
+	if body := w.Body.String(); body != `{"error":"unable to determine the source IP of the request"}` {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+
+	expect := `
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="hack",kind="request"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_errors_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetadataMessagePack(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var instance Instance
+	err := json.Unmarshal([]byte(`{"metadata": {"instance": {"storage": {
+		"disks": [{
+			"device": "/dev/sda",
+			"partitions": [{"label": "ROOT", "number": 1, "size": 4096}],
+			"wipe_table": true
+		}],
+		"filesystems": [{"mount": {
+			"create": {"options": ["-L", "ROOT"]},
+			"device": "/dev/sda1",
+			"format": "ext4",
+			"point": "/"
+		}}]
+	}}}}`), &instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	Configure(router, fakeClient{instance: instance})
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+		r.Header.Set("Accept", accept)
+		r.RemoteAddr = "10.10.10.10:0"
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+		}
+		return w
+	}
+
+	// JSON remains the default.
+	jsonResp := get("*/*")
+	if ct := jsonResp.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Fatalf("Unexpected Content-Type: %v", ct)
+	}
+
+	var expect map[string]any
+	if err := json.Unmarshal(jsonResp.Body.Bytes(), &expect); err != nil {
+		t.Fatal(err)
+	}
+
+	msgpackResp := get("application/msgpack")
+	if ct := msgpackResp.Header().Get("Content-Type"); ct != "application/msgpack; charset=utf-8" {
+		t.Fatalf("Unexpected Content-Type: %v", ct)
+	}
+
+	if msgpackResp.Body.Len() >= jsonResp.Body.Len() {
+		t.Fatalf("Expected MessagePack (%d bytes) to be smaller than JSON (%d bytes)",
+			msgpackResp.Body.Len(), jsonResp.Body.Len())
+	}
+
+	handle := &codec.MsgpackHandle{}
+	handle.MapType = reflect.TypeOf(map[string]any(nil))
+	handle.RawToString = true
+
+	var received map[string]any
+	if err := codec.NewDecoderBytes(msgpackResp.Body.Bytes(), handle).Decode(&received); err != nil {
+		t.Fatal(err)
+	}
+
+	if !cmp.Equal(received, expect) {
+		t.Fatal(cmp.Diff(expect, received))
+	}
+}
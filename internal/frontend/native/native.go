@@ -0,0 +1,293 @@
+/*
+Package native contains a frontend that provides a /metadata endpoint serving the instance
+metadata document in the native Tinkerbell format, the shape of the Hardware resource, for tools
+that already understand it. Unlike the EC2 frontend, the document is served as produced by the
+backend rather than as a tree of endpoints.
+
+The native document is a superset of the document served by the hack frontend so the rootio hub
+action continues to work when the native frontend is served in its place.
+*/
+package native
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/ginutil"
+	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// metricsHandler is the handler label used when recording errors for the /metadata endpoint.
+const metricsHandler = "native"
+
+// Client is a backend for retrieving native instance metadata.
+type Client interface {
+	// GetNativeMetadata retrieves the native JSON metadata document of the instance associated
+	// with ip. If no instance can be found, it should return ec2.ErrInstanceNotFound.
+	GetNativeMetadata(ctx context.Context, ip string) ([]byte, error)
+}
+
+// GetMetadata retrieves the native metadata document of the instance associated with ip from
+// client. If client isn't a Client it returns ec2.ErrLookupUnsupported. Backend wrappers use it to
+// forward native metadata lookups to the client they wrap.
+func GetMetadata(ctx context.Context, client ec2.Client, ip string) ([]byte, error) {
+	c, ok := client.(Client)
+	if !ok {
+		return nil, ec2.ErrLookupUnsupported
+	}
+	return c.GetNativeMetadata(ctx, ip)
+}
+
+// Notifier notifies the frontend of instance changes.
+type Notifier interface {
+	// Changes returns a channel closed on the next change to the instance associated with ip.
+	// Changes may be reported for instances whose document is unchanged.
+	Changes(ip string) <-chan struct{}
+}
+
+// errTooManyWatchers indicates a watch request was rejected because the maximum number of
+// requests are already waiting for changes.
+var errTooManyWatchers = errors.New("too many watchers")
+
+// retryAfterSeconds is the Retry-After header value sent with watch requests rejected with
+// errTooManyWatchers.
+const retryAfterSeconds = "1"
+
+// Option configures the /metadata endpoints.
+type Option func(*config)
+
+type config struct {
+	longPollTimeout time.Duration
+	notifier        Notifier
+	maxWatchers     int
+
+	// watchers holds a token for each watch request waiting for changes. It's nil when watchers
+	// aren't limited.
+	watchers chan struct{}
+}
+
+// WithLongPoll configures a /metadata/watch endpoint that holds requests open for up to timeout
+// until the document changes. Changes are detected by comparing the document's ETag with the
+// If-None-Match header each time notifier reports a change.
+func WithLongPoll(timeout time.Duration, notifier Notifier) Option {
+	return func(c *config) {
+		c.longPollTimeout = timeout
+		c.notifier = notifier
+	}
+}
+
+// WithMaxWatchers limits the /metadata/watch endpoint to n concurrent requests so idle agents
+// can't exhaust connections. Requests beyond the limit are rejected with a 429. An n of 0 doesn't
+// limit requests.
+func WithMaxWatchers(n int) Option {
+	return func(c *config) {
+		c.maxWatchers = n
+	}
+}
+
+// Configure configures router with a `/metadata` endpoint using client to retrieve the native
+// metadata document. The document is served as retrieved from client unless the pretty=true query
+// parameter is specified, in which case it is indented. Clients may select part of the document
+// with the jq query parameter, a jq path expression such as jq=.metadata.instance.hostname, so the
+// whole document isn't transferred. Only object keys and array indexes are supported; other
+// expressions are rejected with a 400.
+//
+// Responses carry an ETag of the served document. Requests whose If-None-Match header matches it
+// are served a 304 without a body.
+//
+// If WithLongPoll is specified, a `/metadata/watch` endpoint accepting the same query parameters
+// is configured. While the served document's ETag matches the If-None-Match header the request is
+// held open. It's served the document as soon as it changes or a 304 if it doesn't change before
+// the timeout, so agents can react to changes without repeatedly polling.
+func Configure(router gin.IRouter, client Client, opts ...Option) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	router.GET("/metadata", metadata(client, config{}))
+
+	if cfg.longPollTimeout > 0 && cfg.notifier != nil {
+		if cfg.maxWatchers > 0 {
+			cfg.watchers = make(chan struct{}, cfg.maxWatchers)
+		}
+		router.GET("/metadata/watch", metadata(client, cfg))
+	}
+}
+
+// metadata returns a handler serving the native metadata document. If cfg configures long
+// polling, the handler waits for the document to change.
+func metadata(client Client, cfg config) gin.HandlerFunc {
+	tracer := otel.Tracer("github.com/tinkerbell/hegel/internal/frontend/native")
+
+	return func(ctx *gin.Context) {
+		reqCtx, span := ginutil.StartServerSpan(ctx, tracer, "native.metadata")
+		defer span.End()
+
+		ip, err := request.RemoteAddrIP(ctx.Request)
+		if err != nil {
+			abort(ctx, http.StatusBadRequest, "request", err, "unable to determine the source IP of the request")
+			return
+		}
+		span.SetAttributes(attribute.String("client.address", ip))
+
+		// Validate the selector before the lookup so invalid requests don't load the backend.
+		var sel selector
+		if expr, ok := ctx.GetQuery("jq"); ok {
+			if sel, err = parseSelector(expr); err != nil {
+				abort(ctx, http.StatusBadRequest, "request", err, err.Error())
+				return
+			}
+		}
+
+		retrieve := func() ([]byte, bool) {
+			lookupCtx, lookupSpan := tracer.Start(reqCtx, "native.GetNativeMetadata")
+			doc, err := client.GetNativeMetadata(lookupCtx, ip)
+			if err != nil {
+				lookupSpan.RecordError(err)
+				lookupSpan.SetStatus(codes.Error, err.Error())
+				lookupSpan.End()
+
+				// The native frontend shares the EC2 backends so their errors apply.
+				status, kind := ec2.ErrorStatus(err)
+				abort(ctx, status, kind, err, http.StatusText(status))
+				return nil, false
+			}
+			lookupSpan.End()
+
+			return render(ctx, doc, sel)
+		}
+
+		if cfg.longPollTimeout <= 0 {
+			if doc, ok := retrieve(); ok {
+				serve(ctx, doc)
+			}
+			return
+		}
+
+		if cfg.watchers != nil {
+			select {
+			case cfg.watchers <- struct{}{}:
+				defer func() { <-cfg.watchers }()
+			default:
+				ctx.Header("Retry-After", retryAfterSeconds)
+				abort(ctx, http.StatusTooManyRequests, "watchers", errTooManyWatchers, errTooManyWatchers.Error())
+				return
+			}
+		}
+
+		timeout := time.NewTimer(cfg.longPollTimeout)
+		defer timeout.Stop()
+
+		for {
+			// Take the channel before the lookup so a change during the lookup isn't missed.
+			changed := cfg.notifier.Changes(ip)
+
+			doc, ok := retrieve()
+			if !ok {
+				return
+			}
+
+			if !etagMatches(ctx.GetHeader("If-None-Match"), etag(doc)) {
+				serve(ctx, doc)
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-timeout.C:
+				serve(ctx, doc)
+				return
+			case <-reqCtx.Done():
+				return
+			}
+		}
+	}
+}
+
+// render renders doc as requested, selecting part of it with sel and indenting it if the
+// pretty=true query parameter is specified. If rendering fails the request is aborted and it
+// returns false.
+func render(ctx *gin.Context, doc []byte, sel selector) ([]byte, bool) {
+	var err error
+	if sel != nil {
+		if doc, err = sel.apply(doc); err != nil {
+			if errors.Is(err, errNotSelectable) {
+				abort(ctx, http.StatusBadRequest, "request", err, err.Error())
+			} else {
+				abort(ctx, http.StatusInternalServerError, "render", err, "failed to render metadata")
+			}
+			return nil, false
+		}
+	}
+
+	if ctx.Query("pretty") == "true" {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, doc, "", "  "); err != nil {
+			abort(ctx, http.StatusInternalServerError, "render", err, "failed to render metadata")
+			return nil, false
+		}
+		doc = buf.Bytes()
+	}
+
+	return doc, true
+}
+
+// serve serves doc with its ETag. If the request's If-None-Match header matches the ETag it
+// serves a 304 instead.
+func serve(ctx *gin.Context, doc []byte) {
+	tag := etag(doc)
+	ctx.Header("ETag", tag)
+
+	if etagMatches(ctx.GetHeader("If-None-Match"), tag) {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+
+	ctx.Data(http.StatusOK, binding.MIMEJSON, doc)
+}
+
+// etag returns the strong entity tag of doc.
+func etag(doc []byte) string {
+	sum := sha256.Sum256(doc)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether the If-None-Match header value matches tag using weak comparison.
+func etagMatches(ifNoneMatch, tag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// abort aborts the request with status and a JSON body containing msg. err is recorded on ctx,
+// classified by kind, for logging and metrics.
+func abort(ctx *gin.Context, status int, kind string, err error, msg string) {
+	_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    kind,
+	})
+	ctx.AbortWithStatusJSON(status, gin.H{"error": msg})
+}
@@ -0,0 +1,544 @@
+package native_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	. "github.com/tinkerbell/hegel/internal/frontend/native"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+type fakeClient struct {
+	doc []byte
+	err error
+}
+
+func (c fakeClient) GetNativeMetadata(context.Context, string) ([]byte, error) {
+	return c.doc, c.err
+}
+
+// lookupFailClient fails the test if an instance is looked up.
+type lookupFailClient struct {
+	t *testing.T
+}
+
+func (c lookupFailClient) GetNativeMetadata(_ context.Context, ip string) ([]byte, error) {
+	c.t.Fatalf("Unexpected lookup: %q", ip)
+	return nil, nil
+}
+
+// doc is deliberately compact with keys out of order to show it isn't re-encoded.
+const doc = `{"metadata":{"instance":{"id":"instance-id","hostname":"sm01"}},"interfaces":[]}`
+
+func TestMetadata(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Query  string
+		Expect string
+	}{
+		{
+			Name:   "AsIs",
+			Expect: doc,
+		},
+		{
+			Name:  "Pretty",
+			Query: "?pretty=true",
+			Expect: `{
+  "metadata": {
+    "instance": {
+      "id": "instance-id",
+      "hostname": "sm01"
+    }
+  },
+  "interfaces": []
+}`,
+		},
+		{
+			// Selected values are served as they appear in the document.
+			Name:   "Select",
+			Query:  "?jq=.metadata.instance",
+			Expect: `{"id":"instance-id","hostname":"sm01"}`,
+		},
+		{
+			Name:   "SelectScalar",
+			Query:  "?jq=.metadata.instance.hostname",
+			Expect: `"sm01"`,
+		},
+		{
+			Name:   "SelectQuotedKey",
+			Query:  "?jq=" + url.QueryEscape(`.metadata["instance"].id`),
+			Expect: `"instance-id"`,
+		},
+		{
+			Name:   "SelectIdentity",
+			Query:  "?jq=.",
+			Expect: doc,
+		},
+		{
+			Name:   "SelectMissing",
+			Query:  "?jq=.metadata.facility.code",
+			Expect: `null`,
+		},
+		{
+			Name:   "SelectIndexOutOfRange",
+			Query:  "?jq=.interfaces[0]",
+			Expect: `null`,
+		},
+		{
+			Name:  "SelectPretty",
+			Query: "?jq=.metadata.instance&pretty=true",
+			Expect: `{
+  "id": "instance-id",
+  "hostname": "sm01"
+}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			router := gin.New()
+			Configure(router, fakeClient{doc: []byte(doc)})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/metadata"+tc.Query, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+			}
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("Unexpected Content-Type: %v", ct)
+			}
+
+			if body := w.Body.String(); body != tc.Expect {
+				t.Fatalf("Expected: %s; Received: %s", tc.Expect, body)
+			}
+		})
+	}
+}
+
+func TestMetadataErrors(t *testing.T) {
+	cases := []struct {
+		Name         string
+		Client       Client
+		RemoteAddr   string
+		Query        string
+		ExpectStatus int
+		ExpectKind   string
+	}{
+		{
+			Name:         "InvalidRemoteAddr",
+			Client:       lookupFailClient{t: t},
+			RemoteAddr:   "invalid",
+			ExpectStatus: http.StatusBadRequest,
+			ExpectKind:   "request",
+		},
+		{
+			Name:         "NotFound",
+			Client:       fakeClient{err: ec2.ErrInstanceNotFound},
+			ExpectStatus: http.StatusNotFound,
+			ExpectKind:   "not_found",
+		},
+		{
+			Name:         "NotReady",
+			Client:       fakeClient{err: ec2.ErrBackendNotReady},
+			ExpectStatus: http.StatusServiceUnavailable,
+			ExpectKind:   "not_ready",
+		},
+		{
+			Name:         "Backend",
+			Client:       fakeClient{err: errors.New("backend error")},
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectKind:   "backend",
+		},
+		{
+			Name:         "MalformedDocument",
+			Client:       fakeClient{doc: []byte(`{"metadata":`)},
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectKind:   "render",
+		},
+		{
+			Name:         "MalformedDocumentSelect",
+			Client:       fakeClient{doc: []byte(`{"metadata":`)},
+			Query:        "?jq=.metadata",
+			ExpectStatus: http.StatusInternalServerError,
+			ExpectKind:   "render",
+		},
+		{
+			Name:         "InvalidSelector",
+			Client:       lookupFailClient{t: t},
+			Query:        "?jq=.metadata|length",
+			ExpectStatus: http.StatusBadRequest,
+			ExpectKind:   "request",
+		},
+		{
+			Name:         "NotSelectable",
+			Client:       fakeClient{doc: []byte(doc)},
+			Query:        "?jq=.interfaces.name",
+			ExpectStatus: http.StatusBadRequest,
+			ExpectKind:   "request",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+
+			router := gin.New()
+			router.Use(metrics.InstrumentErrors(registry))
+			Configure(router, tc.Client)
+
+			w := httptest.NewRecorder()
+			query := "?pretty=true"
+			if tc.Query != "" {
+				query = tc.Query
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/metadata"+query, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			if tc.RemoteAddr != "" {
+				r.RemoteAddr = tc.RemoteAddr
+			}
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected status: %d; Received status: %d", tc.ExpectStatus, w.Code)
+			}
+
+			expect := fmt.Sprintf(`
+# HELP http_server_errors_total Count of errors encountered while serving HTTP requests
+# TYPE http_server_errors_total counter
+http_server_errors_total{handler="native",kind=%q} 1
+`, tc.ExpectKind)
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_errors_total"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestMetadataSelectorRejected(t *testing.T) {
+	// Expressions beyond object keys and array indexes, including those that can be arbitrarily
+	// expensive, are rejected without looking up the instance.
+	cases := []string{
+		"",
+		"metadata",
+		"..",
+		".[]",
+		".metadata | length",
+		"[range(1e9)]",
+		"def f: f; f",
+		".metadata[",
+		".interfaces[0:1]",
+		"." + strings.Repeat("a", 256),
+	}
+
+	for _, expr := range cases {
+		t.Run(expr, func(t *testing.T) {
+			router := gin.New()
+			Configure(router, lookupFailClient{t: t})
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/metadata?jq="+url.QueryEscape(expr), nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status: 400; Received status: %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestMetadataETag(t *testing.T) {
+	router := gin.New()
+	Configure(router, fakeClient{doc: []byte(doc)})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	router.ServeHTTP(w, r)
+
+	tag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || tag == "" {
+		t.Fatalf("Expected status 200 with an ETag; Received status: %d; ETag: %q", w.Code, tag)
+	}
+
+	cases := []struct {
+		Name         string
+		IfNoneMatch  string
+		ExpectStatus int
+	}{
+		{Name: "Match", IfNoneMatch: tag, ExpectStatus: http.StatusNotModified},
+		{Name: "WeakMatch", IfNoneMatch: "W/" + tag, ExpectStatus: http.StatusNotModified},
+		{Name: "ListMatch", IfNoneMatch: `"stale", ` + tag, ExpectStatus: http.StatusNotModified},
+		{Name: "Wildcard", IfNoneMatch: "*", ExpectStatus: http.StatusNotModified},
+		{Name: "Stale", IfNoneMatch: `"stale"`, ExpectStatus: http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+			r.RemoteAddr = "10.10.10.10:0"
+			r.Header.Set("If-None-Match", tc.IfNoneMatch)
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected status: %d; Received status: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if w.Header().Get("ETag") != tag {
+				t.Fatalf("Expected ETag: %v; Received: %v", tag, w.Header().Get("ETag"))
+			}
+
+			if tc.ExpectStatus == http.StatusNotModified && w.Body.Len() != 0 {
+				t.Fatalf("Expected no body; Received: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMetadataWatchWakesOnChange(t *testing.T) {
+	client := &mutableClient{doc: []byte(doc)}
+	notifier := newFakeNotifier()
+
+	router := gin.New()
+	Configure(router, client, WithLongPoll(time.Minute, notifier))
+
+	tag := getETag(t, router)
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- watch(router, tag)
+	}()
+
+	// Changes that don't affect the document leave the request waiting.
+	notifier.awaitWaiting(t)
+	notifier.notify()
+	notifier.awaitWaiting(t)
+
+	const updated = `{"metadata":{"instance":{"id":"instance-id","hostname":"sm02"}},"interfaces":[]}`
+	client.set([]byte(updated))
+	notifier.notify()
+
+	var w *httptest.ResponseRecorder
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the change to wake the request")
+	}
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+
+	if body := w.Body.String(); body != updated {
+		t.Fatalf("Expected: %s; Received: %s", updated, body)
+	}
+
+	if w.Header().Get("ETag") == tag {
+		t.Fatalf("Expected a new ETag; Received: %v", tag)
+	}
+
+	if ip := notifier.lastIP(); ip != "10.10.10.10" {
+		t.Fatalf("Expected changes for 10.10.10.10; Received: %v", ip)
+	}
+}
+
+func TestMetadataWatchMaxWatchers(t *testing.T) {
+	client := &mutableClient{doc: []byte(doc)}
+	notifier := newFakeNotifier()
+
+	router := gin.New()
+	Configure(router, client, WithLongPoll(time.Minute, notifier), WithMaxWatchers(1))
+
+	tag := getETag(t, router)
+
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		done <- watch(router, tag)
+	}()
+	notifier.awaitWaiting(t)
+
+	// The limit is reached so further requests are rejected rather than held.
+	w := watch(router, tag)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status: 429; Received status: %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("Expected Retry-After header")
+	}
+
+	client.set([]byte(`{}`))
+	notifier.notify()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the change to wake the request")
+	}
+
+	// The completed request released its place.
+	if w := watch(router, `"stale"`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+}
+
+func TestMetadataWatchTimeout(t *testing.T) {
+	router := gin.New()
+	Configure(router, fakeClient{doc: []byte(doc)}, WithLongPoll(10*time.Millisecond, newFakeNotifier()))
+
+	tag := getETag(t, router)
+
+	w := watch(router, tag)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("Expected status: 304; Received status: %d", w.Code)
+	}
+
+	if w.Header().Get("ETag") != tag {
+		t.Fatalf("Expected ETag: %v; Received: %v", tag, w.Header().Get("ETag"))
+	}
+}
+
+func TestMetadataWatchStale(t *testing.T) {
+	// Requests with a stale ETag are served the document without waiting.
+	router := gin.New()
+	Configure(router, fakeClient{doc: []byte(doc)}, WithLongPoll(time.Minute, newFakeNotifier()))
+
+	w := watch(router, `"stale"`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+
+	if body := w.Body.String(); body != doc {
+		t.Fatalf("Expected: %s; Received: %s", doc, body)
+	}
+}
+
+func TestMetadataWatchDisabled(t *testing.T) {
+	router := gin.New()
+	Configure(router, fakeClient{doc: []byte(doc)})
+
+	if w := watch(router, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status: 404; Received status: %d", w.Code)
+	}
+}
+
+func getETag(t *testing.T, router http.Handler) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metadata", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	router.ServeHTTP(w, r)
+
+	tag := w.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("Expected an ETag")
+	}
+	return tag
+}
+
+func watch(router http.Handler, tag string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/metadata/watch", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+	if tag != "" {
+		r.Header.Set("If-None-Match", tag)
+	}
+	router.ServeHTTP(w, r)
+	return w
+}
+
+// mutableClient serves a document that can be replaced concurrently with lookups.
+type mutableClient struct {
+	mu  sync.Mutex
+	doc []byte
+}
+
+func (c *mutableClient) GetNativeMetadata(context.Context, string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.doc, nil
+}
+
+func (c *mutableClient) set(doc []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.doc = doc
+}
+
+// fakeNotifier reports changes when notify is called and signals waiting each time Changes is
+// called.
+type fakeNotifier struct {
+	mu      sync.Mutex
+	ip      string
+	changed chan struct{}
+	waiting chan struct{}
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{
+		changed: make(chan struct{}),
+		waiting: make(chan struct{}, 10),
+	}
+}
+
+func (n *fakeNotifier) Changes(ip string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ip = ip
+
+	select {
+	case n.waiting <- struct{}{}:
+	default:
+	}
+	return n.changed
+}
+
+func (n *fakeNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// lastIP returns the IP changes were last requested for.
+func (n *fakeNotifier) lastIP() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.ip
+}
+
+// awaitWaiting waits for a request to take the channel it waits on for changes.
+func (n *fakeNotifier) awaitWaiting(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-n.waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the request to wait for changes")
+	}
+}
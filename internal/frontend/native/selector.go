@@ -0,0 +1,151 @@
+package native
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxSelectorLength is the length, in bytes, of the longest selector accepted.
+const maxSelectorLength = 256
+
+var (
+	// errInvalidSelector indicates a selector isn't a supported path expression.
+	errInvalidSelector = errors.New("invalid selector")
+
+	// errNotSelectable indicates a selector selects a key or index of a value that isn't an
+	// object or array respectively.
+	errNotSelectable = errors.New("value not selectable")
+)
+
+// selector is a parsed path expression selecting a value from a JSON document. Each step is a
+// string, an object key, or an int, an array index.
+type selector []any
+
+// parseSelector parses expr, a jq path expression such as .metadata.instance.hostname,
+// .interfaces[0] or .metadata["instance"]. Only the identity, object keys and array indexes are
+// supported so selecting a value is linear in the size of the document; jq constructs that can
+// be arbitrarily expensive, such as pipes, functions and recursive descent, are rejected.
+func parseSelector(expr string) (selector, error) {
+	if len(expr) > maxSelectorLength {
+		return nil, fmt.Errorf("%w: longer than %v bytes", errInvalidSelector, maxSelectorLength)
+	}
+
+	if expr == "" || expr[0] != '.' {
+		return nil, fmt.Errorf("%w: must begin with .", errInvalidSelector)
+	}
+
+	var s selector
+	i := 0
+	for i < len(expr) {
+		switch {
+		case expr[i] == '.' && i+1 < len(expr) && isIdentStart(expr[i+1]):
+			j := i + 2
+			for j < len(expr) && isIdent(expr[j]) {
+				j++
+			}
+			s = append(s, expr[i+1:j])
+			i = j
+
+		case expr[i] == '.' && i == 0:
+			// The identity. Only valid at the start; .. is recursive descent.
+			i++
+
+		case expr[i] == '[':
+			end := strings.IndexByte(expr[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated [", errInvalidSelector)
+			}
+			step, err := parseIndex(expr[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, step)
+			i += end + 1
+
+		default:
+			return nil, fmt.Errorf("%w: unsupported expression at offset %v", errInvalidSelector, i)
+		}
+	}
+
+	return s, nil
+}
+
+// parseIndex parses the contents of brackets, either an integer array index or a quoted object
+// key.
+func parseIndex(s string) (any, error) {
+	if len(s) > 0 && s[0] == '"' {
+		key, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed key %v", errInvalidSelector, s)
+		}
+		return key, nil
+	}
+
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed index [%v]", errInvalidSelector, s)
+	}
+	return index, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// apply returns the value of doc selected by s as it appears in doc. As with jq, keys missing from
+// objects and indexes outside arrays select null; negative indexes count from the end of arrays.
+// Selecting a key or index from a value that isn't an object or array respectively is an
+// errNotSelectable error. If doc is malformed, apply returns the error decoding it.
+func (s selector) apply(doc []byte) ([]byte, error) {
+	v := json.RawMessage(doc)
+	for _, step := range s {
+		if bytes.Equal(bytes.TrimSpace(v), []byte("null")) {
+			return v, nil
+		}
+
+		switch step := step.(type) {
+		case string:
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(v, &obj); err != nil {
+				return nil, notSelectable(err, "cannot select key %q of a non-object", step)
+			}
+			var ok bool
+			if v, ok = obj[step]; !ok {
+				return []byte("null"), nil
+			}
+
+		case int:
+			var arr []json.RawMessage
+			if err := json.Unmarshal(v, &arr); err != nil {
+				return nil, notSelectable(err, "cannot select index %v of a non-array", step)
+			}
+			if step < 0 {
+				step += len(arr)
+			}
+			if step < 0 || step >= len(arr) {
+				return []byte("null"), nil
+			}
+			v = arr[step]
+		}
+	}
+	return v, nil
+}
+
+// notSelectable returns an errNotSelectable error with a message formatted with fmt.Sprintf if err
+// indicates the decoded value had the wrong type. Otherwise the value is malformed and err is
+// returned.
+func notSelectable(err error, format string, args ...any) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errNotSelectable, fmt.Sprintf(format, args...))
+}
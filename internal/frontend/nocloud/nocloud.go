@@ -0,0 +1,81 @@
+/*
+Package nocloud contains a frontend that serves seed files for the cloud-init NoCloud datasource.
+Instances configured with a NoCloud seedfrom URL pointing at the frontend base path retrieve their
+meta-data, user-data and vendor-data files from Hegel.
+
+	https://cloudinit.readthedocs.io/en/latest/reference/datasources/nocloud.html
+*/
+package nocloud
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"gopkg.in/yaml.v2"
+)
+
+// metricsHandler is the handler label used when recording errors.
+const metricsHandler = "nocloud"
+
+// MetaData is the NoCloud meta-data file.
+type MetaData struct {
+	InstanceID    string   `yaml:"instance-id"`
+	LocalHostname string   `yaml:"local-hostname,omitempty"`
+	PublicKeys    []string `yaml:"public-keys,omitempty"`
+}
+
+// Configure configures router with meta-data, user-data and vendor-data endpoints beneath
+// basePath, such as /nocloud, serving the instances retrieved by fe so they're consistent with the
+// EC2 endpoints.
+func Configure(router gin.IRouter, fe ec2.Frontend, basePath string) {
+	files := map[string]func(ec2.Instance) ([]byte, error){
+		"meta-data": func(i ec2.Instance) ([]byte, error) {
+			return yaml.Marshal(toMetaData(i))
+		},
+		"user-data": func(i ec2.Instance) ([]byte, error) {
+			return []byte(i.Userdata), nil
+		},
+		"vendor-data": func(i ec2.Instance) ([]byte, error) {
+			return []byte(i.Vendordata), nil
+		},
+	}
+
+	for name, file := range files {
+		file := file
+		router.GET(path.Join("/", basePath, name), func(ctx *gin.Context) {
+			instance, err := fe.Instance(ctx.Request.Context(), ctx.Request)
+			if err != nil {
+				status, kind := ec2.ErrorStatus(err)
+				abort(ctx, status, kind, err)
+				return
+			}
+
+			data, err := file(instance)
+			if err != nil {
+				abort(ctx, http.StatusInternalServerError, "render", err)
+				return
+			}
+
+			ctx.Data(http.StatusOK, "text/plain; charset=utf-8", data)
+		})
+	}
+}
+
+// toMetaData converts i to the NoCloud meta-data.
+func toMetaData(i ec2.Instance) MetaData {
+	return MetaData{
+		InstanceID:    i.Metadata.InstanceID,
+		LocalHostname: i.Metadata.LocalHostname,
+		PublicKeys:    i.Metadata.PublicKeys,
+	}
+}
+
+func abort(ctx *gin.Context, status int, kind string, err error) {
+	_ = ctx.AbortWithError(status, err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    kind,
+	})
+}
@@ -0,0 +1,108 @@
+package nocloud_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	. "github.com/tinkerbell/hegel/internal/frontend/nocloud"
+)
+
+type fakeClient map[string]ec2.Instance
+
+func (c fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	instance, ok := c[ip]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func (c fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return ec2.Instance{}, ec2.ErrInstanceNotFound
+}
+
+func TestSeedFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	instance := ec2.Instance{
+		Userdata:   "#cloud-config\npackages:\n  - curl\n",
+		Vendordata: "#cloud-config\nntp:\n  enabled: true\n",
+	}
+	instance.Metadata.InstanceID = "i-1234"
+	instance.Metadata.LocalHostname = "worker-1"
+	instance.Metadata.PublicKeys = []string{"ssh-ed25519 AAAA key1"}
+
+	router := gin.New()
+	Configure(router, ec2.New(fakeClient{"10.10.10.10": instance}), "/nocloud")
+
+	for _, name := range []string{"meta-data", "user-data", "vendor-data"} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/nocloud/"+name, nil)
+			r.RemoteAddr = "10.10.10.10:0"
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+			}
+
+			golden, err := os.ReadFile(filepath.Join("testdata", name+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !cmp.Equal(w.Body.String(), string(golden)) {
+				t.Fatal(cmp.Diff(string(golden), w.Body.String()))
+			}
+		})
+	}
+}
+
+func TestSeedFilesInstanceNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	Configure(router, ec2.New(fakeClient{}), "/nocloud")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/nocloud/meta-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status: 404; Received status: %d", w.Code)
+	}
+}
+
+func TestSeedFilesServedAsEC2(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	instance := ec2.Instance{Userdata: "hostname: {{ .Hostname }}"}
+	instance.Metadata.Hostname = "worker-1"
+
+	// User-data is rendered as it is for the EC2 endpoints.
+	router := gin.New()
+	Configure(router, ec2.New(fakeClient{"10.10.10.10": instance}, ec2.WithUserDataTemplates(true)), "/nocloud")
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/nocloud/user-data", nil)
+	r.RemoteAddr = "10.10.10.10:0"
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+	if body := w.Body.String(); body != "hostname: worker-1" {
+		t.Fatalf("Expected: %q; Received: %q", "hostname: worker-1", body)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Package phonehome contains a frontend that provides a /phone-home endpoint for the cloud-init
+phone_home module. cloud-init posts to the endpoint when an instance completes booting giving
+operators a boot completion signal.
+*/
+package phonehome
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/http/request"
+	"github.com/tinkerbell/hegel/internal/metrics"
+)
+
+// metricsHandler is the handler label used when recording errors.
+const metricsHandler = "phone_home"
+
+// maxBodyBytes limits the size of phone home requests. Requests contain a handful of host keys
+// so are small.
+const maxBodyBytes = 64 << 10
+
+// hostKeyFieldPrefix prefixes the form fields containing SSH host public keys. For example,
+// pub_key_ed25519.
+const hostKeyFieldPrefix = "pub_key_"
+
+// Report is the data posted by the cloud-init phone_home module.
+type Report struct {
+	InstanceID string
+	Hostname   string
+	FQDN       string
+
+	// HostKeys is a map of SSH host key type, such as ed25519, to public key.
+	HostKeys map[string]string
+}
+
+// Configure configures router with a POST /phone-home endpoint. Reports, including the reported SSH
+// host keys, are logged and attributed to the instance fe retrieves for the request so they're
+// attributed to the instance the EC2 endpoints serve. Boot completions are counted with a metric
+// registered with registrar.
+func Configure(router gin.IRouter, fe ec2.Frontend, logger logr.Logger, registrar prometheus.Registerer) {
+	reports := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "phone_home_reports_total",
+		Help: "Count of phone home reports received from instances that completed booting",
+	})
+
+	registrar.MustRegister(reports)
+
+	router.POST("/phone-home", func(ctx *gin.Context) {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBodyBytes)
+		if err := ctx.Request.ParseForm(); err != nil {
+			abort(ctx, http.StatusBadRequest, "request", err)
+			return
+		}
+
+		instance, err := fe.Instance(ctx.Request.Context(), ctx.Request)
+		if err != nil {
+			status, kind := ec2.ErrorStatus(err)
+			abort(ctx, status, kind, err)
+			return
+		}
+
+		report := parseReport(ctx.Request.PostForm)
+
+		// Attribute the report to the instance ID known to the backend rather than the reported ID
+		// so clients can't impersonate other instances.
+		instanceID := instance.Metadata.InstanceID
+		if instanceID == "" {
+			instanceID, _ = request.RemoteAddrIP(ctx.Request)
+		}
+
+		reports.Inc()
+
+		logger.Info("Instance phoned home",
+			"instance_id", instanceID,
+			"reported_instance_id", report.InstanceID,
+			"hostname", report.Hostname,
+			"fqdn", report.FQDN,
+			"host_keys", report.HostKeys,
+		)
+
+		ctx.Status(http.StatusOK)
+	})
+}
+
+// parseReport parses the cloud-init phone_home form fields.
+func parseReport(form map[string][]string) Report {
+	get := func(k string) string {
+		if v := form[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	r := Report{
+		InstanceID: get("instance_id"),
+		Hostname:   get("hostname"),
+		FQDN:       get("fqdn"),
+	}
+
+	for k, v := range form {
+		// cloud-init posts N/A for keys it couldn't read.
+		if !strings.HasPrefix(k, hostKeyFieldPrefix) || len(v) == 0 || v[0] == "" || v[0] == "N/A" {
+			continue
+		}
+		if r.HostKeys == nil {
+			r.HostKeys = map[string]string{}
+		}
+		r.HostKeys[strings.TrimPrefix(k, hostKeyFieldPrefix)] = strings.TrimSpace(v[0])
+	}
+
+	return r
+}
+
+func abort(ctx *gin.Context, status int, kind string, err error) {
+	_ = ctx.AbortWithError(status, err).SetMeta(metrics.ErrorLabels{
+		Handler: metricsHandler,
+		Kind:    kind,
+	})
+}
@@ -0,0 +1,121 @@
+package phonehome_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	. "github.com/tinkerbell/hegel/internal/frontend/phonehome"
+)
+
+type fakeClient struct {
+	instances map[string]ec2.Instance
+}
+
+func (c *fakeClient) GetEC2Instance(_ context.Context, ip string) (ec2.Instance, error) {
+	instance, ok := c.instances[ip]
+	if !ok {
+		return ec2.Instance{}, ec2.ErrInstanceNotFound
+	}
+	return instance, nil
+}
+
+func (c *fakeClient) GetEC2InstanceByMAC(context.Context, string) (ec2.Instance, error) {
+	return ec2.Instance{}, ec2.ErrInstanceNotFound
+}
+
+func TestPhoneHome(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var instance ec2.Instance
+	instance.Metadata.InstanceID = "i-1234"
+
+	client := &fakeClient{instances: map[string]ec2.Instance{"10.10.10.10": instance}}
+	registry := prometheus.NewRegistry()
+
+	var logged []string
+	logger := funcr.New(func(_, args string) { logged = append(logged, args) }, funcr.Options{})
+
+	router := gin.New()
+	Configure(router, ec2.New(client), logger, registry)
+
+	form := url.Values{
+		"instance_id":     {"i-1234"},
+		"hostname":        {"worker-1"},
+		"fqdn":            {"worker-1.example.com"},
+		"pub_key_rsa":     {"ssh-rsa AAAArsa root@worker-1\n"},
+		"pub_key_ed25519": {"ssh-ed25519 AAAAed25519 root@worker-1\n"},
+		"pub_key_dsa":     {"N/A"},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/phone-home", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.10.10.10:0"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received status: %d", w.Code)
+	}
+
+	if len(logged) != 1 {
+		t.Fatalf("Expected 1 log; Received: %v", logged)
+	}
+	for _, key := range []string{`"rsa"="ssh-rsa AAAArsa root@worker-1"`, `"ed25519"="ssh-ed25519 AAAAed25519 root@worker-1"`} {
+		if !strings.Contains(logged[0], key) {
+			t.Fatalf("Expected log to contain host key %v; Received: %v", key, logged[0])
+		}
+	}
+	if strings.Contains(logged[0], "dsa") {
+		t.Fatalf("Expected unreadable host keys to be omitted; Received: %v", logged[0])
+	}
+
+	expect := `
+# HELP phone_home_reports_total Count of phone home reports received from instances that completed booting
+# TYPE phone_home_reports_total counter
+phone_home_reports_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "phone_home_reports_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPhoneHomeInstanceNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	client := &fakeClient{}
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	Configure(router, ec2.New(client), logr.Discard(), registry)
+
+	r := httptest.NewRequest(http.MethodPost, "/phone-home", strings.NewReader("hostname=worker-1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.10.10.10:0"
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status: 404; Received status: %d", w.Code)
+	}
+
+	expect := `
+# HELP phone_home_reports_total Count of phone home reports received from instances that completed booting
+# TYPE phone_home_reports_total counter
+phone_home_reports_total 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "phone_home_reports_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,33 @@
+package ginutil
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxDrainBytes is the largest request body DrainRequestBody reads to keep a connection reusable.
+const MaxDrainBytes = 256 << 10
+
+// DrainRequestBody creates middleware that discards the body some clients send with GET and HEAD
+// requests before later handlers run. Handlers never read the body of these requests so, left
+// unread, it would be parsed as the start of the next request on a kept-alive connection. Bodies
+// larger than MaxDrainBytes aren't read; the connection is closed once the response is written
+// instead.
+func DrainRequestBody() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		r := ctx.Request
+		if r.Body == nil || r.Body == http.NoBody ||
+			(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			return
+		}
+
+		n, err := io.Copy(io.Discard, io.LimitReader(r.Body, MaxDrainBytes+1))
+		if err != nil || n > MaxDrainBytes {
+			ctx.Header("Connection", "close")
+		}
+		_ = r.Body.Close()
+		r.Body = http.NoBody
+	}
+}
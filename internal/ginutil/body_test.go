@@ -0,0 +1,116 @@
+package ginutil_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/ginutil"
+)
+
+func TestDrainRequestBodyKeepsConnectionReusable(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.Use(DrainRequestBody())
+
+	var body []byte
+	router.GET("/", func(ctx *gin.Context) {
+		var err error
+		if body, err = io.ReadAll(ctx.Request.Body); err != nil {
+			t.Error(err)
+		}
+		ctx.String(http.StatusOK, "ok")
+	})
+
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	// Issue a GET with a body then a GET without, recording whether each reused a connection.
+	get := func(reqBody io.Reader) (reused bool) {
+		t.Helper()
+
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}
+		ctx := httptrace.WithClientTrace(context.Background(), trace)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, reqBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status: 200; Received: %v", resp.StatusCode)
+		}
+		if resp.Close {
+			t.Fatal("Expected the connection to be kept alive")
+		}
+
+		return reused
+	}
+
+	get(strings.NewReader("unexpected body"))
+	if len(body) != 0 {
+		t.Fatalf("Expected handler to receive an empty body; Received: %q", body)
+	}
+
+	if !get(nil) {
+		t.Fatal("Expected the connection to be reused after a GET with a body")
+	}
+}
+
+func TestDrainRequestBodyClosesConnectionForLargeBodies(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.Use(DrainRequestBody())
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", strings.NewReader(strings.Repeat("a", MaxDrainBytes+1)))
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status: 200; Received: %v", w.Code)
+	}
+	if header := w.Header().Get("Connection"); header != "close" {
+		t.Fatalf("Expected Connection: close; Received: %q", header)
+	}
+}
+
+func TestDrainRequestBodyIgnoresOtherMethods(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	router := gin.New()
+	router.Use(DrainRequestBody())
+
+	var body string
+	router.POST("/", func(ctx *gin.Context) {
+		b, _ := io.ReadAll(ctx.Request.Body)
+		body = string(b)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data")))
+
+	if body != "data" {
+		t.Fatalf("Expected handler to read body: data; Received: %q", body)
+	}
+}
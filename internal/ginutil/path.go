@@ -0,0 +1,33 @@
+package ginutil
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultMaxPathLength is a limit on request path length well above the longest path served, such
+// as an EC2 MAC address endpoint.
+const DefaultMaxPathLength = 1024
+
+// LimitPathLength adds a Counter to registrar and returns a handler that aborts requests whose
+// path is longer than max bytes with a 414 URI Too Long, counting them, so later handlers never
+// parse them. listener labels the count so routers served on different listeners can share
+// registrar.
+func LimitPathLength(registrar prometheus.Registerer, listener string, max int) gin.HandlerFunc {
+	m := prometheus.NewCounter(prometheus.CounterOpts{
+		Name:        "http_server_oversized_paths_total",
+		Help:        "Count of HTTP requests rejected because their path was too long",
+		ConstLabels: prometheus.Labels{"listener": listener},
+	})
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		if len(ctx.Request.URL.Path) > max || len(ctx.Request.URL.RawPath) > max {
+			m.Inc()
+			ctx.AbortWithStatusJSON(http.StatusRequestURITooLong, gin.H{"error": "request path too long"})
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package ginutil_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/ginutil"
+)
+
+func TestLimitPathLength(t *testing.T) {
+	gin.SetMode(gin.ReleaseMode)
+
+	cases := []struct {
+		Name         string
+		Path         string
+		ExpectStatus int
+		ExpectCount  int
+	}{
+		{
+			Name:         "AtLimit",
+			Path:         "/" + strings.Repeat("a", DefaultMaxPathLength-1),
+			ExpectStatus: http.StatusNotFound,
+		},
+		{
+			Name:         "OverLimit",
+			Path:         "/" + strings.Repeat("a", DefaultMaxPathLength),
+			ExpectStatus: http.StatusRequestURITooLong,
+			ExpectCount:  1,
+		},
+		{
+			// Escaped paths are limited by their escaped length too.
+			Name:         "EscapedOverLimit",
+			Path:         "/" + strings.Repeat("%2F", DefaultMaxPathLength/2),
+			ExpectStatus: http.StatusRequestURITooLong,
+			ExpectCount:  1,
+		},
+		{
+			Name:         "Absurd",
+			Path:         "/" + strings.Repeat("a", 1<<20),
+			ExpectStatus: http.StatusRequestURITooLong,
+			ExpectCount:  1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+
+			var served bool
+			router := gin.New()
+			router.Use(LimitPathLength(registry, "metadata", DefaultMaxPathLength))
+			router.NoRoute(func(ctx *gin.Context) {
+				served = true
+				ctx.Status(http.StatusNotFound)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.Path, nil))
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected status: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+
+			if rejected := tc.ExpectCount > 0; served == rejected {
+				t.Fatalf("Expected later handlers to run: %v; Received: %v", !rejected, served)
+			}
+
+			expect := `
+# HELP http_server_oversized_paths_total Count of HTTP requests rejected because their path was too long
+# TYPE http_server_oversized_paths_total counter
+http_server_oversized_paths_total{listener="metadata"} ` + strconv.Itoa(tc.ExpectCount) + `
+`
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
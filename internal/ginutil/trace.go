@@ -0,0 +1,36 @@
+package ginutil
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartServerSpan starts a server span named name with tracer for the request handled by ctx. The
+// span continues any trace propagated by the client, so Hegel's spans are part of it, and carries
+// the request path and attrs. The request context is replaced with the returned context so
+// middleware, such as metrics linking observations to the span, can observe it. Callers must end
+// the span.
+func StartServerSpan(
+	ctx *gin.Context,
+	tracer trace.Tracer,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	reqCtx := otel.GetTextMapPropagator().Extract(
+		ctx.Request.Context(),
+		propagation.HeaderCarrier(ctx.Request.Header),
+	)
+	reqCtx, span := tracer.Start(reqCtx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(append(attrs, attribute.String("url.path", ctx.Request.URL.Path))...),
+	)
+
+	ctx.Request = ctx.Request.WithContext(reqCtx)
+
+	return reqCtx, span
+}
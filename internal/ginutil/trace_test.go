@@ -0,0 +1,72 @@
+package ginutil_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/ginutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestStartServerSpan(t *testing.T) {
+	// The global propagator can't be reset so it remains set for the remainder of the test run.
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer("test")
+
+	// Middleware observe the span through the request context.
+	var observed trace.SpanContext
+	router := gin.New()
+	router.Use(func(ctx *gin.Context) {
+		ctx.Next()
+		observed = trace.SpanContextFromContext(ctx.Request.Context())
+	})
+	router.GET("/metadata", func(ctx *gin.Context) {
+		_, span := StartServerSpan(ctx, tracer, "test.metadata", attribute.String("http.route", ctx.FullPath()))
+		span.End()
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/metadata", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected: 200; Received: %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span; Received: %v", len(spans))
+	}
+	span := spans[0]
+
+	if span.SpanKind != trace.SpanKindServer {
+		t.Fatalf("Expected: %v; Received: %v", trace.SpanKindServer, span.SpanKind)
+	}
+
+	if span.SpanContext.TraceID().String() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("Expected the propagated trace; Received: %v", span.SpanContext.TraceID())
+	}
+
+	attrs := map[attribute.Key]string{}
+	for _, a := range span.Attributes {
+		attrs[a.Key] = a.Value.AsString()
+	}
+	if attrs["url.path"] != "/metadata" || attrs["http.route"] != "/metadata" {
+		t.Fatalf("Expected url.path and http.route attributes; Received: %v", span.Attributes)
+	}
+
+	if !observed.Equal(span.SpanContext) {
+		t.Fatalf("Expected middleware to observe %v; Received: %v", span.SpanContext, observed)
+	}
+}
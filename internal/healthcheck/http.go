@@ -1,8 +1,18 @@
 package healthcheck
 
-import "github.com/gin-gonic/gin"
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
 
 // Configure configures router with a /healthz endpoint using a handler created with NewHandler.
 func Configure(router gin.IRouter, client Client) {
 	router.GET("/healthz", NewHandler(client))
 }
+
+// ConfigureReadiness configures router with a /readyz endpoint using a handler created with
+// NewReadinessHandler.
+func ConfigureReadiness(router gin.IRouter, shutdown context.Context, checkers ...ReadinessChecker) {
+	router.GET("/readyz", NewReadinessHandler(shutdown, checkers...))
+}
@@ -0,0 +1,39 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessChecker may be implemented by a backend that can't serve requests immediately, for
+// example because it must first sync a cache.
+type ReadinessChecker interface {
+	// IsReady returns true if the backend can serve requests.
+	IsReady(context.Context) bool
+}
+
+// NewReadinessHandler returns a gin.HandlerFunc that provides a readiness endpoint behavior. It
+// returns a 200 when every checker is ready and until ctx is done, otherwise it returns a 503.
+// ctx should be the context that signals shutdown so load balancers stop routing requests to
+// Hegel while in-flight requests are drained.
+func NewReadinessHandler(ctx context.Context, checkers ...ReadinessChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		select {
+		case <-ctx.Done():
+			c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+			return
+		default:
+		}
+
+		for _, checker := range checkers {
+			if !checker.IsReady(c) {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"ready": false})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"ready": true})
+	}
+}
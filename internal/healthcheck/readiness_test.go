@@ -0,0 +1,61 @@
+package healthcheck_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/ginutil"
+	. "github.com/tinkerbell/hegel/internal/healthcheck"
+)
+
+func TestReadiness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handler := NewReadinessHandler(ctx)
+
+	w := ginutil.FakeResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler(&gin.Context{Writer: w})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusOK, w.Code)
+	}
+
+	// Signal shutdown and validate the handler immediately reports it isn't ready.
+	cancel()
+
+	w = ginutil.FakeResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler(&gin.Context{Writer: w})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestReadinessCheckers(t *testing.T) {
+	checker := &fakeReadinessChecker{}
+	handler := NewReadinessHandler(context.Background(), checker)
+
+	w := ginutil.FakeResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler(&gin.Context{Writer: w})
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	checker.ready = true
+
+	w = ginutil.FakeResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	handler(&gin.Context{Writer: w})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code: %d; Received status code: %d", http.StatusOK, w.Code)
+	}
+}
+
+type fakeReadinessChecker struct {
+	ready bool
+}
+
+func (f *fakeReadinessChecker) IsReady(context.Context) bool {
+	return f.ready
+}
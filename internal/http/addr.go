@@ -0,0 +1,85 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// LinkLocalMetadataAddr is the link-local address AWS EC2 instance metadata clients, such as
+// cloud-init, query by default. Hegel can serve it directly by using it as the listen address
+// provided 169.254.169.254 is assigned to an interface on the host, either with AddLinkLocalAlias
+// or, for example, `ip addr add 169.254.169.254/32 dev lo`. Binding to port 80 typically requires the
+// CAP_NET_BIND_SERVICE capability.
+//
+// Handlers identify instances using the requests remote address so they are agnostic to the
+// address Hegel listens on.
+const LinkLocalMetadataAddr = "169.254.169.254:80"
+
+// UnixAddrPrefix prefixes listen addresses that identify a Unix domain socket path. For example,
+// unix:/run/hegel/hegel.sock.
+const UnixAddrPrefix = "unix:"
+
+// ValidateAddr validates address is of the form host:port where host is optional and, if
+// specified, is an IP address or syntactically valid hostname and port is a valid TCP port
+// number. Alternatively, address may be a Unix domain socket path prefixed with UnixAddrPrefix.
+//
+// Hostnames aren't resolved so validation doesn't depend on DNS being available at startup.
+func ValidateAddr(address string) error {
+	if path, ok := strings.CutPrefix(address, UnixAddrPrefix); ok {
+		if path == "" {
+			return fmt.Errorf("invalid listen address %q: missing socket path", address)
+		}
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", address, err)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: invalid port", address)
+	}
+
+	// Port 0 would result in a random port being chosen making Hegel unreachable to instances.
+	if p == 0 {
+		return fmt.Errorf("invalid listen address %q: port cannot be 0", address)
+	}
+
+	if host != "" && net.ParseIP(host) == nil && !validHostname(host) {
+		return fmt.Errorf("invalid listen address %q: invalid host", address)
+	}
+
+	return nil
+}
+
+// validHostname returns true if host is a valid RFC 1123 hostname.
+func validHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitAddr returns the network and address to listen on for address.
+func splitAddr(address string) (network, addr string) {
+	if path, ok := strings.CutPrefix(address, UnixAddrPrefix); ok {
+		return "unix", path
+	}
+	return "tcp", address
+}
@@ -0,0 +1,44 @@
+//go:build !integration
+
+package http_test
+
+import (
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/http"
+)
+
+func TestValidateAddr(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Address string
+		Valid   bool
+	}{
+		{Name: "PortOnly", Address: ":50061", Valid: true},
+		{Name: "IPv4", Address: "127.0.0.1:50061", Valid: true},
+		{Name: "IPv6", Address: "[::1]:50061", Valid: true},
+		{Name: "LinkLocal", Address: LinkLocalMetadataAddr, Valid: true},
+		{Name: "Hostname", Address: "localhost:50061", Valid: true},
+		{Name: "UnresolvableHostname", Address: "hegel.invalid:50061", Valid: true},
+		{Name: "InvalidHostname", Address: "hegel_metadata:50061", Valid: false},
+		{Name: "InvalidHostnameLabel", Address: "-hegel.example.com:50061", Valid: false},
+		{Name: "MissingPort", Address: "127.0.0.1", Valid: false},
+		{Name: "ZeroPort", Address: "127.0.0.1:0", Valid: false},
+		{Name: "InvalidPort", Address: "127.0.0.1:http", Valid: false},
+		{Name: "OutOfRangePort", Address: "127.0.0.1:65536", Valid: false},
+		{Name: "UnixSocket", Address: "unix:/run/hegel/hegel.sock", Valid: true},
+		{Name: "UnixSocketMissingPath", Address: "unix:", Valid: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateAddr(tc.Address)
+			if tc.Valid && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !tc.Valid && err == nil {
+				t.Fatal("Expected error, received nil")
+			}
+		})
+	}
+}
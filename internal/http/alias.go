@@ -0,0 +1,17 @@
+package http
+
+import "net"
+
+// linkLocalMetadataIP is the IP of LinkLocalMetadataAddr.
+var linkLocalMetadataIP = net.IPv4(169, 254, 169, 254).To4()
+
+// AddLinkLocalAlias assigns the link-local metadata address, 169.254.169.254/32, to the network
+// interface named iface so Hegel can listen on LinkLocalMetadataAddr without the host being
+// configured beforehand. The kernel adds a local route for the address when it's assigned.
+// Assigning addresses requires the CAP_NET_ADMIN capability and is only supported on Linux.
+//
+// The returned func removes the address. If iface already had the address, it's left in place
+// and the returned func does nothing.
+func AddLinkLocalAlias(iface string) (remove func() error, err error) {
+	return addAlias(iface, linkLocalMetadataIP)
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package http
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// addAlias assigns ip/32 to the interface named iface using a netlink route socket.
+func addAlias(iface string, ip net.IP) (func() error, error) {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("add %v to %v: %w", ip, iface, err)
+	}
+
+	flags := uint16(syscall.NLM_F_CREATE | syscall.NLM_F_EXCL)
+	err = netlinkAddr(syscall.RTM_NEWADDR, flags, link.Index, ip)
+	switch {
+	case errors.Is(err, syscall.EEXIST):
+		return func() error { return nil }, nil
+	case err != nil:
+		return nil, fmt.Errorf("add %v to %v: %w", ip, iface, err)
+	}
+
+	return func() error {
+		if err := netlinkAddr(syscall.RTM_DELADDR, 0, link.Index, ip); err != nil {
+			return fmt.Errorf("remove %v from %v: %w", ip, iface, err)
+		}
+		return nil
+	}, nil
+}
+
+// netlinkAddr sends an address message of kind op for the IPv4 address ip/32 on the interface with
+// index and waits for the kernel to acknowledge it.
+func netlinkAddr(op, flags uint16, index int, ip net.IP) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	// The message is a header, an ifaddrmsg and IFA_LOCAL and IFA_ADDRESS attributes. Each
+	// attribute is a 4 byte header followed by the 4 byte address.
+	const attrLen = syscall.SizeofRtAttr + net.IPv4len
+	msg := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofIfAddrmsg+2*attrLen)
+
+	endian := binary.NativeEndian
+	endian.PutUint32(msg[0:4], uint32(len(msg)))
+	endian.PutUint16(msg[4:6], op)
+	endian.PutUint16(msg[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK|flags)
+	endian.PutUint32(msg[8:12], 1)
+
+	ifa := msg[syscall.NLMSG_HDRLEN:]
+	ifa[0] = syscall.AF_INET
+	ifa[1] = 8 * net.IPv4len
+	endian.PutUint32(ifa[4:8], uint32(index))
+
+	attrs := ifa[syscall.SizeofIfAddrmsg:]
+	for i, kind := range []uint16{syscall.IFA_LOCAL, syscall.IFA_ADDRESS} {
+		attr := attrs[i*attrLen:]
+		endian.PutUint16(attr[0:2], attrLen)
+		endian.PutUint16(attr[2:4], kind)
+		copy(attr[syscall.SizeofRtAttr:attrLen], ip.To4())
+	}
+
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+
+		replies, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, reply := range replies {
+			if reply.Header.Type != syscall.NLMSG_ERROR || len(reply.Data) < 4 {
+				continue
+			}
+
+			// The acknowledgement carries the negated errno, 0 on success.
+			if errno := -int32(endian.Uint32(reply.Data[0:4])); errno != 0 {
+				return syscall.Errno(errno)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package http
+
+import (
+	"errors"
+	"net"
+)
+
+func addAlias(string, net.IP) (func() error, error) {
+	return nil, errors.New("assigning interface addresses is only supported on Linux")
+}
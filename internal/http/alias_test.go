@@ -0,0 +1,38 @@
+//go:build integration && linux
+
+package http_test
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/http"
+)
+
+// TestAddLinkLocalAlias validates the link-local metadata address can be assigned to an interface,
+// listened on and removed. It requires the CAP_NET_ADMIN capability.
+func TestAddLinkLocalAlias(t *testing.T) {
+	remove, err := AddLinkLocalAlias("lo")
+	if errors.Is(err, syscall.EPERM) {
+		t.Skip("CAP_NET_ADMIN is required to assign addresses")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "169.254.169.254:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	if err := remove(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := net.Listen("tcp", "169.254.169.254:0"); err == nil {
+		t.Fatal("Expected listening to fail once the address is removed")
+	}
+}
@@ -0,0 +1,24 @@
+package http
+
+import (
+	"net"
+	"net/http"
+)
+
+// NewServer exposes newServer, and configureHTTP2, for testing.
+func NewServer(handler http.Handler, opts ...Option) *http.Server {
+	cfg := newConfig(opts...)
+	server := newServer(handler, cfg)
+	if err := configureHTTP2(server, cfg); err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// WithListening configures a func Serve calls with the address it's listening on so tests can
+// listen on port 0.
+func WithListening(f func(net.Addr)) Option {
+	return func(c *config) {
+		c.listening = f
+	}
+}
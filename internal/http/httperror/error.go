@@ -39,3 +39,8 @@ func Wrap(code int, err error) error {
 func (e *E) Error() string {
 	return e.E.Error()
 }
+
+// Unwrap returns the wrapped error so errors.Is and errors.As can inspect it.
+func (e *E) Unwrap() error {
+	return e.E
+}
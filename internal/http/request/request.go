@@ -1,15 +1,25 @@
 package request
 
 import (
+	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 )
 
-// RemoteAddrIP retrieves the remote address IP from r.
+// RemoteAddrIP retrieves the remote address IP from r. It returns an error if the remote address
+// doesn't contain an IP, for example because it is empty or only has a port, as the source of the
+// request can't be determined.
 func RemoteAddrIP(r *http.Request) (string, error) {
 	addr, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return "", err
 	}
+
+	// Permit zoned IPv6 addresses, such as fe80::1%eth0, from link-local clients.
+	if _, err := netip.ParseAddr(addr); err != nil {
+		return "", fmt.Errorf("remote address %q has no IP: %w", r.RemoteAddr, err)
+	}
+
 	return addr, nil
 }
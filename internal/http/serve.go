@@ -2,32 +2,177 @@ package http
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-logr/logr"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
-// Serve is a blocking call that begins serving the provided handler on port. When ctx is cancelled
-// it will attempt to gracefully shutdown. If graceful shutdown fails, it will force shutdown
-// and return an error.
-func Serve(ctx context.Context, logger logr.Logger, address string, handler http.Handler) error {
-	server := http.Server{
-		Addr:    address,
-		Handler: handler,
+// DefaultShutdownGracePeriod is the default time Serve waits for in-flight requests to complete
+// when shutting down.
+const DefaultShutdownGracePeriod = 5 * time.Second
 
-		// Mitigate Slowloris attacks. 20 seconds is based on Apache's recommended 20-40
-		// recommendation. Hegel doesn't really have many headers so 20s should be plenty of time.
-		// https://en.wikipedia.org/wiki/Slowloris_(computer_security)
-		ReadHeaderTimeout: 20 * time.Second,
+// Server defaults. Metadata requests and responses are small so the defaults are tight, limiting
+// the resources a slow or misbehaving client can hold.
+const (
+	// DefaultReadHeaderTimeout mitigates Slowloris attacks. 20 seconds is based on Apache's
+	// recommended 20-40 recommendation. Hegel doesn't really have many headers so 20s should be
+	// plenty of time.
+	// https://en.wikipedia.org/wiki/Slowloris_(computer_security)
+	DefaultReadHeaderTimeout = 20 * time.Second
+
+	// DefaultReadTimeout is the default time allowed to read an entire request.
+	DefaultReadTimeout = 30 * time.Second
+
+	// DefaultWriteTimeout is the default time allowed to write a response.
+	DefaultWriteTimeout = 30 * time.Second
+
+	// DefaultIdleTimeout is the default time a keep-alive connection may sit idle.
+	DefaultIdleTimeout = 60 * time.Second
+
+	// DefaultMaxHeaderBytes is the default limit on the size of request headers.
+	DefaultMaxHeaderBytes = 16 << 10
+)
+
+// Option configures Serve.
+type Option func(*config)
+
+type config struct {
+	shutdownGracePeriod time.Duration
+	tlsConfig           *tls.Config
+	readHeaderTimeout   time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	idleTimeout         time.Duration
+	maxHeaderBytes      int
+	http2               bool
+
+	// listening, if set, is called with the address Serve is listening on once it's listening.
+	listening func(net.Addr)
+}
+
+func newConfig(opts ...Option) config {
+	cfg := config{
+		shutdownGracePeriod: DefaultShutdownGracePeriod,
+		readHeaderTimeout:   DefaultReadHeaderTimeout,
+		readTimeout:         DefaultReadTimeout,
+		writeTimeout:        DefaultWriteTimeout,
+		idleTimeout:         DefaultIdleTimeout,
+		maxHeaderBytes:      DefaultMaxHeaderBytes,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithShutdownGracePeriod configures the time Serve waits for in-flight requests to complete when
+// ctx is cancelled before forcefully closing connections.
+func WithShutdownGracePeriod(d time.Duration) Option {
+	return func(c *config) {
+		c.shutdownGracePeriod = d
+	}
+}
+
+// WithTLSConfig configures Serve to serve HTTPS using cfg. See LoadTLSConfig.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithReadHeaderTimeout configures the time allowed to read request headers.
+func WithReadHeaderTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.readHeaderTimeout = d
+	}
+}
+
+// WithReadTimeout configures the time allowed to read an entire request, including the body.
+func WithReadTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.readTimeout = d
+	}
+}
+
+// WithWriteTimeout configures the time allowed to write a response.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout configures the time a keep-alive connection may wait for the next request.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.idleTimeout = d
+	}
+}
+
+// WithMaxHeaderBytes configures the maximum size of request headers.
+func WithMaxHeaderBytes(n int) Option {
+	return func(c *config) {
+		c.maxHeaderBytes = n
+	}
+}
+
+// WithHTTP2 configures whether Serve supports HTTP/2 in addition to HTTP/1.1, letting clients
+// multiplex many requests over a single connection. With TLS, HTTP/2 is negotiated using ALPN.
+// Without TLS, clients must use HTTP/2 over cleartext (h2c) either with prior knowledge or by
+// upgrading an HTTP/1.1 connection. Defaults to false.
+func WithHTTP2(enabled bool) Option {
+	return func(c *config) {
+		c.http2 = enabled
+	}
+}
+
+// Serve is a blocking call that begins serving the provided handler on address. If address is
+// prefixed with UnixAddrPrefix, Serve listens on a Unix domain socket at the prefixed path,
+// replacing any stale socket file, and removes the socket when it returns. When ctx is cancelled
+// it stops accepting new connections and waits for in-flight requests to complete. If in-flight
+// requests don't complete within the shutdown grace period, it will force shutdown and return an
+// error.
+func Serve(ctx context.Context, logger logr.Logger, address string, handler http.Handler, opts ...Option) error {
+	cfg := newConfig(opts...)
+
+	network, addr := splitAddr(address)
+	if network == "unix" {
+		if err := removeStaleSocket(addr); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	server := newServer(handler, cfg)
+	if err := configureHTTP2(server, cfg); err != nil {
+		listener.Close()
+		return err
+	}
+
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	if cfg.listening != nil {
+		cfg.listening(listener.Addr())
 	}
 
 	errChan := make(chan error, 1)
 	go func() {
 		logger.Info(fmt.Sprintf("Listening on %s", address))
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
@@ -39,7 +184,9 @@ func Serve(ctx context.Context, logger logr.Logger, address string, handler http
 		return e
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	logger.Info("Shutting down; draining in-flight requests", "grace_period", cfg.shutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.shutdownGracePeriod)
 	defer cancel()
 
 	// Attempt a graceful shutdown with timeout.
@@ -56,3 +203,49 @@ func Serve(ctx context.Context, logger logr.Logger, address string, handler http
 
 	return nil
 }
+
+// newServer creates a server for handler configured with cfg's timeouts and limits.
+func newServer(handler http.Handler, cfg config) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+		MaxHeaderBytes:    cfg.maxHeaderBytes,
+		TLSConfig:         cfg.tlsConfig,
+	}
+}
+
+// configureHTTP2 configures server to support HTTP/2 if enabled by cfg. server's TLS config is
+// cloned before it's modified so the caller's config isn't changed.
+func configureHTTP2(server *http.Server, cfg config) error {
+	if !cfg.http2 {
+		return nil
+	}
+
+	h2 := &http2.Server{IdleTimeout: cfg.idleTimeout}
+
+	if server.TLSConfig == nil {
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+		return nil
+	}
+
+	server.TLSConfig = server.TLSConfig.Clone()
+	return http2.ConfigureServer(server, h2)
+}
+
+// removeStaleSocket removes a socket file left at path by a previous process that didn't exit
+// cleanly. It refuses to remove anything that isn't a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	case info.Mode().Type() != fs.ModeSocket:
+		return fmt.Errorf("%v exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
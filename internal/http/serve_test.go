@@ -5,17 +5,22 @@ package http_test
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/go-logr/zerologr"
 	"github.com/rs/zerolog"
 	. "github.com/tinkerbell/hegel/internal/http"
+	"github.com/tinkerbell/hegel/internal/http/tlstest"
 )
 
 // TestServe validates the Serve function does in-fact serve a functional HTTP server with the
@@ -32,11 +37,12 @@ func TestServe(t *testing.T) {
 		fmt.Fprint(w, "Hello, world!")
 	})
 
-	go Serve(ctx, logger, fmt.Sprintf(":%d", 8080), &mux)
+	addr := make(chan net.Addr, 1)
+	go Serve(ctx, logger, ":0", &mux, WithListening(func(a net.Addr) { addr <- a }))
 
-	time.Sleep(50 * time.Millisecond)
+	port := (<-addr).(*net.TCPAddr).Port
 
-	resp, err := http.Get("http://localhost:8080")
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d", port))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -55,6 +61,243 @@ func TestServe(t *testing.T) {
 	}
 }
 
+// TestServeAlternateAddress validates Serve binds to a configured host address.
+func TestServeAlternateAddress(t *testing.T) {
+	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+	logger := zerologr.New(&zl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello, world!")
+	})
+
+	addr := make(chan net.Addr, 1)
+	go Serve(ctx, logger, "127.0.0.1:0", &mux, WithListening(func(a net.Addr) { addr <- a }))
+
+	resp, err := http.Get("http://" + (<-addr).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("expected status code 200")
+	}
+}
+
+// TestServeUnixSocket validates Serve can serve over a Unix domain socket, replacing a stale
+// socket file, and removes the socket when it returns.
+func TestServeUnixSocket(t *testing.T) {
+	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+	logger := zerologr.New(&zl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	socket := filepath.Join(t.TempDir(), "hegel.sock")
+
+	// Simulate a socket left behind by a process that didn't exit cleanly.
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello, world!")
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, logger, UnixAddrPrefix+socket, &mux)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://hegel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	io.Copy(&buf, resp.Body)
+
+	if buf.String() != "Hello, world!" {
+		t.Fatal("expected body to be 'Hello, world!'")
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(socket); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected socket to be removed: %v", err)
+	}
+}
+
+// TestServeTLS validates Serve serves HTTPS when configured with a TLS configuration.
+func TestServeTLS(t *testing.T) {
+	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+	logger := zerologr.New(&zl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ca := tlstest.NewCA(t)
+	cert := ca.Issue(t, "hegel", net.ParseIP("127.0.0.1"))
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello, world!")
+	})
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	go Serve(ctx, logger, "127.0.0.1:8484", &mux, WithTLSConfig(cfg))
+
+	time.Sleep(50 * time.Millisecond)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.Pool(), MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	resp, err := client.Get("https://127.0.0.1:8484")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	io.Copy(&buf, resp.Body)
+
+	if buf.String() != "Hello, world!" {
+		t.Fatal("expected body to be 'Hello, world!'")
+	}
+}
+
+// TestServeHTTP2TLS validates Serve negotiates HTTP/2 with ALPN when HTTP/2 is enabled and
+// serving TLS.
+func TestServeHTTP2TLS(t *testing.T) {
+	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+	logger := zerologr.New(&zl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ca := tlstest.NewCA(t)
+	cert := ca.Issue(t, "hegel", net.ParseIP("127.0.0.1"))
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	go Serve(ctx, logger, "127.0.0.1:8585", &mux, WithTLSConfig(cfg), WithHTTP2(true))
+
+	time.Sleep(50 * time.Millisecond)
+
+	client := http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: ca.Pool(), MinVersion: tls.VersionTLS12},
+			ForceAttemptHTTP2: true,
+		},
+	}
+
+	resp, err := client.Get("https://127.0.0.1:8585")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	io.Copy(&buf, resp.Body)
+
+	if buf.String() != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2.0; received: %v", buf.String())
+	}
+
+	if len(cfg.NextProtos) != 0 {
+		t.Fatalf("expected the TLS config to be unmodified; received NextProtos: %v", cfg.NextProtos)
+	}
+}
+
+// TestServeDrainsInFlightRequests validates requests that are in-flight when shutdown begins are
+// allowed to complete.
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
+	logger := zerologr.New(&zl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan struct{})
+
+	var mux http.ServeMux
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+
+		// Give the server time to begin shutting down before responding.
+		time.Sleep(200 * time.Millisecond)
+		fmt.Fprint(w, "Hello, world!")
+	})
+
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, logger, "127.0.0.1:8383", &mux, WithShutdownGracePeriod(time.Second))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://127.0.0.1:8383")
+		responses <- result{resp, err}
+	}()
+
+	// Begin shutting down once the request is in-flight.
+	<-received
+	cancel()
+
+	res := <-responses
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer res.resp.Body.Close()
+
+	var buf bytes.Buffer
+	io.Copy(&buf, res.resp.Body)
+
+	if buf.String() != "Hello, world!" {
+		t.Fatal("expected body to be 'Hello, world!'")
+	}
+
+	if err := <-served; err != nil {
+		t.Fatalf("expected graceful shutdown: %v", err)
+	}
+}
+
 func TestServerFailure(t *testing.T) {
 	zl := zerolog.New(os.Stdout).With().Timestamp().Caller().Logger()
 	logger := zerologr.New(&zl)
@@ -0,0 +1,129 @@
+//go:build !integration
+
+package http_test
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/tinkerbell/hegel/internal/http"
+	"golang.org/x/net/http2"
+)
+
+func TestNewServerDefaults(t *testing.T) {
+	server := NewServer(http.NotFoundHandler())
+
+	for name, v := range map[string]time.Duration{
+		"ReadHeaderTimeout": server.ReadHeaderTimeout,
+		"ReadTimeout":       server.ReadTimeout,
+		"WriteTimeout":      server.WriteTimeout,
+		"IdleTimeout":       server.IdleTimeout,
+	} {
+		if v <= 0 {
+			t.Errorf("Expected non-zero %v; Received: %v", name, v)
+		}
+	}
+
+	if server.MaxHeaderBytes <= 0 {
+		t.Errorf("Expected non-zero MaxHeaderBytes; Received: %v", server.MaxHeaderBytes)
+	}
+}
+
+func TestNewServerOptions(t *testing.T) {
+	server := NewServer(
+		http.NotFoundHandler(),
+		WithReadHeaderTimeout(time.Second),
+		WithReadTimeout(2*time.Second),
+		WithWriteTimeout(3*time.Second),
+		WithIdleTimeout(4*time.Second),
+		WithMaxHeaderBytes(1024),
+	)
+
+	if server.ReadHeaderTimeout != time.Second {
+		t.Errorf("ReadHeaderTimeout: Expected: %v; Received: %v", time.Second, server.ReadHeaderTimeout)
+	}
+	if server.ReadTimeout != 2*time.Second {
+		t.Errorf("ReadTimeout: Expected: %v; Received: %v", 2*time.Second, server.ReadTimeout)
+	}
+	if server.WriteTimeout != 3*time.Second {
+		t.Errorf("WriteTimeout: Expected: %v; Received: %v", 3*time.Second, server.WriteTimeout)
+	}
+	if server.IdleTimeout != 4*time.Second {
+		t.Errorf("IdleTimeout: Expected: %v; Received: %v", 4*time.Second, server.IdleTimeout)
+	}
+	if server.MaxHeaderBytes != 1024 {
+		t.Errorf("MaxHeaderBytes: Expected: 1024; Received: %v", server.MaxHeaderBytes)
+	}
+}
+
+func TestNewServerH2C(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Options     []Option
+		ExpectError bool
+	}{
+		{
+			Name:    "Enabled",
+			Options: []Option{WithHTTP2(true)},
+		},
+		{
+			// HTTP/1.1 only servers don't understand the HTTP/2 connection preface.
+			Name:        "Default",
+			ExpectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/2009-04-04/meta-data/hostname", func(w http.ResponseWriter, r *http.Request) {
+				if r.ProtoMajor != 2 {
+					t.Errorf("Expected HTTP/2; Received: %v", r.Proto)
+				}
+				_, _ = io.WriteString(w, "sm01")
+			})
+
+			server := httptest.NewServer(NewServer(mux, tc.Options...).Handler)
+			defer server.Close()
+
+			// Use HTTP/2 over cleartext with prior knowledge.
+			client := http.Client{
+				Transport: &http2.Transport{
+					AllowHTTP: true,
+					DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, network, addr)
+					},
+				},
+			}
+
+			resp, err := client.Get(server.URL + "/2009-04-04/meta-data/hostname")
+			if tc.ExpectError {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.ProtoMajor != 2 || string(body) != "sm01" {
+				t.Fatalf("Expected: HTTP/2 sm01; Received: %v %s", resp.Proto, body)
+			}
+		})
+	}
+}
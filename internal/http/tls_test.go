@@ -0,0 +1,153 @@
+//go:build !integration
+
+package http_test
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/tinkerbell/hegel/internal/http"
+	"github.com/tinkerbell/hegel/internal/http/tlstest"
+)
+
+func TestLoadTLSConfigHandshake(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	certFile, keyFile := tlstest.WriteFiles(t, ca.Issue(t, "hegel", net.ParseIP("127.0.0.1")))
+
+	cfg, err := LoadTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTLSServer(t, cfg)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.Pool(), MinVersion: tls.VersionTLS12},
+		},
+	}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "Hello, world!" {
+		t.Fatalf("Unexpected body: %q", body)
+	}
+}
+
+func TestLoadTLSConfigRequiresClientCert(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	certFile, keyFile := tlstest.WriteFiles(t, ca.Issue(t, "hegel", net.ParseIP("127.0.0.1")))
+
+	clientCAFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(clientCAFile, ca.PEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadTLSConfig(certFile, keyFile, clientCAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newTLSServer(t, cfg)
+
+	cases := []struct {
+		Name         string
+		Certificates []tls.Certificate
+		ExpectError  bool
+	}{
+		{
+			Name:         "ClientCertificate",
+			Certificates: []tls.Certificate{ca.Issue(t, "client")},
+		},
+		{
+			Name:        "NoClientCertificate",
+			ExpectError: true,
+		},
+		{
+			Name:         "UntrustedClientCertificate",
+			Certificates: []tls.Certificate{tlstest.NewCA(t).Issue(t, "client")},
+			ExpectError:  true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			client := &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{
+						RootCAs:      ca.Pool(),
+						Certificates: tc.Certificates,
+						MinVersion:   tls.VersionTLS12,
+					},
+				},
+			}
+
+			resp, err := client.Get(server.URL)
+			if tc.ExpectError {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("Expected error, received nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestLoadTLSConfigInvalidFiles(t *testing.T) {
+	ca := tlstest.NewCA(t)
+	certFile, keyFile := tlstest.WriteFiles(t, ca.Issue(t, "hegel"))
+
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	if err := os.WriteFile(invalid, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name                string
+		Cert, Key, ClientCA string
+	}{
+		{Name: "MissingCert", Cert: "missing.crt", Key: keyFile},
+		{Name: "InvalidKey", Cert: certFile, Key: invalid},
+		{Name: "MissingClientCA", Cert: certFile, Key: keyFile, ClientCA: "missing.crt"},
+		{Name: "InvalidClientCA", Cert: certFile, Key: keyFile, ClientCA: invalid},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if _, err := LoadTLSConfig(tc.Cert, tc.Key, tc.ClientCA); err == nil {
+				t.Fatal("Expected error, received nil")
+			}
+		})
+	}
+}
+
+func newTLSServer(t *testing.T, cfg *tls.Config) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "Hello, world!")
+	}))
+	server.TLS = cfg
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server
+}
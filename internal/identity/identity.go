@@ -0,0 +1,452 @@
+/*
+Package identity resolves the instance a request is made on behalf of using configurable
+strategies.
+
+A Strategy inspects a request and, if it can, produces a Key identifying the instance, such as
+its IP or MAC address. Strategies are consulted in order by Middleware and the first to identify
+the instance wins. Frontends retrieve the Key with FromContext and look the instance up using the
+backend method appropriate for the Key's Kind.
+*/
+package identity
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/packethost/xff"
+	"github.com/tinkerbell/hegel/internal/clientcert"
+	"github.com/tinkerbell/hegel/internal/dhcplease"
+	"github.com/tinkerbell/hegel/internal/metrics"
+	"github.com/tinkerbell/hegel/internal/unixsocket"
+)
+
+// metricsHandler is the handler label used when recording requests rejected by Middleware.
+const metricsHandler = "identity"
+
+// Kind is the kind of identifier a Key holds.
+type Kind string
+
+const (
+	// KindIP identifies an instance by one of its IP addresses.
+	KindIP Kind = "ip"
+
+	// KindMAC identifies an instance by the lower case, colon separated MAC address of one of its
+	// network interfaces.
+	KindMAC Kind = "mac"
+
+	// KindInstanceID identifies an instance by its instance ID.
+	KindInstanceID Kind = "instance-id"
+)
+
+// Key identifies an instance.
+type Key struct {
+	Kind  Kind
+	Value string
+}
+
+// Strategy resolves the identity of the instance a request is made on behalf of.
+type Strategy interface {
+	// Resolve returns the Key identifying the instance r is made on behalf of. If the instance
+	// can't be identified from r it returns false.
+	Resolve(r *http.Request) (Key, bool)
+}
+
+// Verifier is implemented by strategies that identify instances using data that could be forged,
+// such as a signed header, so must be verified.
+type Verifier interface {
+	// Verify returns an error if r presents data identifying an instance that fails verification.
+	// Requests that don't present such data are verified.
+	Verify(r *http.Request) error
+}
+
+// StrategyFunc adapts a func to a Strategy.
+type StrategyFunc func(r *http.Request) (Key, bool)
+
+// Resolve satisfies Strategy.
+func (f StrategyFunc) Resolve(r *http.Request) (Key, bool) {
+	return f(r)
+}
+
+// Strategy names used to select strategies with configuration.
+const (
+	SourceIPStrategy     = "source-ip"
+	ForwardedForStrategy = "xff"
+	ClientCertStrategy   = "client-cert"
+	MACHeaderStrategy    = "mac-header"
+	UnixSocketStrategy   = "unix-socket"
+	DHCPLeaseStrategy    = "dhcp-lease"
+	NodeHintStrategy     = "node-hint"
+)
+
+// StrategyNames returns the name of every strategy.
+func StrategyNames() []string {
+	return []string{
+		SourceIPStrategy,
+		ForwardedForStrategy,
+		ClientCertStrategy,
+		MACHeaderStrategy,
+		UnixSocketStrategy,
+		DHCPLeaseStrategy,
+		NodeHintStrategy,
+	}
+}
+
+// SourceIP identifies instances by the IP the request was received from.
+func SourceIP() Strategy {
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || net.ParseIP(host) == nil {
+			return Key{}, false
+		}
+		return Key{Kind: KindIP, Value: host}, true
+	})
+}
+
+// ForwardedFor identifies instances by the X-Forwarded-For header of requests received from
+// proxies. proxies is a slice of CIDR blocks as produced by xff.Parse in this module. Requests
+// received from other sources aren't identified.
+func ForwardedFor(proxies []string) (Strategy, error) {
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("%v strategy requires trusted proxies", ForwardedForStrategy)
+	}
+
+	var subnets []*net.IPNet
+	for _, p := range proxies {
+		_, subnet, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %v", p)
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	trusted := func(ip string) bool {
+		parsed := net.ParseIP(ip)
+		for _, s := range subnets {
+			if parsed != nil && s.Contains(parsed) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		if r.Header.Get("X-Forwarded-For") == "" {
+			return Key{}, false
+		}
+
+		addr := xff.GetRemoteAddrIfAllowed(r, trusted)
+		if addr == r.RemoteAddr {
+			return Key{}, false
+		}
+
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return Key{}, false
+		}
+		return Key{Kind: KindIP, Value: host}, true
+	}), nil
+}
+
+// ClientCert identifies instances by the verified client certificate presented with the request.
+// See clientcert.Identity.
+func ClientCert() Strategy {
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		ip, ok := clientcert.RequestIdentity(r)
+		if !ok {
+			return Key{}, false
+		}
+		return Key{Kind: KindIP, Value: ip.String()}, true
+	})
+}
+
+// MACHeader identifies instances by the MAC address in header. MACs are normalized to their lower
+// case, colon separated form. Requests without a valid MAC in header aren't identified.
+func MACHeader(header string) (Strategy, error) {
+	if header == "" {
+		return nil, fmt.Errorf("%v strategy requires a header", MACHeaderStrategy)
+	}
+
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		mac, err := net.ParseMAC(r.Header.Get(header))
+		if err != nil {
+			return Key{}, false
+		}
+		return Key{Kind: KindMAC, Value: mac.String()}, true
+	}), nil
+}
+
+// UnixSocket identifies instances making requests over a Unix domain socket as configured by
+// opts. See unixsocket.Identity.
+func UnixSocket(opts unixsocket.Options) (Strategy, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		ip, ok := unixsocket.Identity(r, opts)
+		if !ok {
+			return Key{}, false
+		}
+		return Key{Kind: KindIP, Value: ip.String()}, true
+	}), nil
+}
+
+// DHCPLease identifies instances by the MAC address of the network interface the IP the request was
+// received from is leased to by source. Requests from IPs without an active lease aren't identified.
+// Zoned IPv6 addresses, such as fe80::1%eth0 of link-local clients, are looked up with their zone.
+func DHCPLease(source dhcplease.Source) Strategy {
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return Key{}, false
+		}
+
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return Key{}, false
+		}
+
+		mac, ok := source.Lookup(ip)
+		if !ok {
+			return Key{}, false
+		}
+		return Key{Kind: KindMAC, Value: mac.String()}, true
+	})
+}
+
+// MinNodeHintKeySize is the minimum size, in bytes, of keys used to sign node hints.
+const MinNodeHintKeySize = 32
+
+// ErrInvalidNodeHint indicates a node hint is malformed or its signature is invalid.
+var ErrInvalidNodeHint = errors.New("invalid node hint")
+
+// NodeHint identifies instances by a signed hint in header so instances presenting the same
+// source IP, such as those behind NAT, can be told apart. Hints are of the form
+// "<kind>:<value>.<issued>.<signature>" where kind is the Kind of value, such as mac, issued is
+// the Unix time, in seconds, the hint was issued at and signature is the unpadded base64url
+// encoded HMAC-SHA256 of "<kind>:<value>.<issued>" keyed by key. See SignNodeHint.
+//
+// Hints issued more than maxAge before, or after, a request are rejected so intercepted hints
+// can't be replayed indefinitely.
+//
+// The strategy is a Verifier so requests presenting a hint that fails verification are rejected
+// rather than identified by another strategy.
+func NodeHint(header string, key []byte, maxAge time.Duration) (Strategy, error) {
+	if header == "" {
+		return nil, fmt.Errorf("%v strategy requires a header", NodeHintStrategy)
+	}
+	if len(key) < MinNodeHintKeySize {
+		return nil, fmt.Errorf("%v strategy requires a key of at least %d bytes", NodeHintStrategy, MinNodeHintKeySize)
+	}
+	if maxAge <= 0 {
+		return nil, fmt.Errorf("%v strategy requires a positive max age", NodeHintStrategy)
+	}
+	return nodeHint{header: header, key: key, maxAge: maxAge}, nil
+}
+
+// SignNodeHint returns a hint, for use with NodeHint, identifying the instance identified by k
+// issued at issued and signed with key.
+func SignNodeHint(k Key, issued time.Time, key []byte) string {
+	payload := string(k.Kind) + ":" + k.Value + "." + strconv.FormatInt(issued.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(nodeHintMAC(payload, key))
+}
+
+// nodeHint is the Strategy created by NodeHint.
+type nodeHint struct {
+	header string
+	key    []byte
+	maxAge time.Duration
+}
+
+// Resolve satisfies Strategy.
+func (h nodeHint) Resolve(r *http.Request) (Key, bool) {
+	hint := r.Header.Get(h.header)
+	if hint == "" {
+		return Key{}, false
+	}
+
+	key, err := h.parse(hint)
+	if err != nil {
+		return Key{}, false
+	}
+	return key, true
+}
+
+// Verify satisfies Verifier.
+func (h nodeHint) Verify(r *http.Request) error {
+	hint := r.Header.Get(h.header)
+	if hint == "" {
+		return nil
+	}
+
+	_, err := h.parse(hint)
+	return err
+}
+
+// parse verifies hint and returns the Key it contains.
+func (h nodeHint) parse(hint string) (Key, error) {
+	// Signatures and issued times don't contain dots but values, such as instance IDs, may.
+	i := strings.LastIndex(hint, ".")
+	if i < 0 {
+		return Key{}, ErrInvalidNodeHint
+	}
+	payload, encoded := hint[:i], hint[i+1:]
+
+	signature, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || !hmac.Equal(signature, nodeHintMAC(payload, h.key)) {
+		return Key{}, ErrInvalidNodeHint
+	}
+
+	i = strings.LastIndex(payload, ".")
+	if i < 0 {
+		return Key{}, ErrInvalidNodeHint
+	}
+	payload, encoded = payload[:i], payload[i+1:]
+
+	issued, err := strconv.ParseInt(encoded, 10, 64)
+	if err != nil {
+		return Key{}, ErrInvalidNodeHint
+	}
+	if age := time.Since(time.Unix(issued, 0)); age > h.maxAge || age < -h.maxAge {
+		return Key{}, ErrInvalidNodeHint
+	}
+
+	kind, value, _ := strings.Cut(payload, ":")
+	switch Kind(kind) {
+	case KindIP:
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return Key{}, ErrInvalidNodeHint
+		}
+		return Key{Kind: KindIP, Value: ip.String()}, nil
+	case KindMAC:
+		mac, err := net.ParseMAC(value)
+		if err != nil {
+			return Key{}, ErrInvalidNodeHint
+		}
+		return Key{Kind: KindMAC, Value: mac.String()}, nil
+	case KindInstanceID:
+		if value == "" {
+			return Key{}, ErrInvalidNodeHint
+		}
+		return Key{Kind: KindInstanceID, Value: value}, nil
+	}
+
+	return Key{}, ErrInvalidNodeHint
+}
+
+// nodeHintMAC returns the HMAC-SHA256 of payload keyed by key.
+func nodeHintMAC(payload string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// Query parameters used by DebugQuery to override the identity of requests.
+const (
+	InstanceIDQueryParam = "instance_id"
+	IPQueryParam         = "ip"
+)
+
+// DebugQuery identifies instances by the instance_id or ip query parameter so operators can
+// retrieve the data of any instance without spoofing its address. instance_id takes precedence.
+// It bypasses the binding of requests to the instance they're made from so must only be used for
+// debugging.
+func DebugQuery() Strategy {
+	return StrategyFunc(func(r *http.Request) (Key, bool) {
+		query := r.URL.Query()
+		if id := query.Get(InstanceIDQueryParam); id != "" {
+			return Key{Kind: KindInstanceID, Value: id}, true
+		}
+		if ip := net.ParseIP(query.Get(IPQueryParam)); ip != nil {
+			return Key{Kind: KindIP, Value: ip.String()}, true
+		}
+		return Key{}, false
+	})
+}
+
+// Resolve returns the Key produced by the first of strategies to identify the instance r is made
+// on behalf of. If none do, it returns false. Strategies that are Verifiers are verified before
+// they're consulted; if verification fails, it returns the error.
+func Resolve(r *http.Request, strategies ...Strategy) (Key, bool, error) {
+	for _, s := range strategies {
+		if v, ok := s.(Verifier); ok {
+			if err := v.Verify(r); err != nil {
+				return Key{}, false, err
+			}
+		}
+		if key, ok := s.Resolve(r); ok {
+			return key, true, nil
+		}
+	}
+	return Key{}, false, nil
+}
+
+// ErrUnidentified indicates none of the strategies used by StrictMiddleware identified a request.
+var ErrUnidentified = errors.New("instance could not be identified")
+
+// Middleware creates a Gin middleware that resolves the identity of each request using
+// strategies and stores it in the request context for retrieval with FromContext. IP identities
+// also replace the http.Request.RemoteAddr so handlers that identify instances by remote address
+// honor the strategies. Requests that aren't identified are unaltered so they're identified by
+// their remote address. Requests that fail verification are aborted with a 403 so they can't be
+// identified as another instance.
+func Middleware(strategies ...Strategy) gin.HandlerFunc {
+	return middleware(false, strategies)
+}
+
+// StrictMiddleware creates a Gin middleware like Middleware except requests that aren't identified
+// by strategies are also aborted with a 403 rather than falling back to their remote address.
+func StrictMiddleware(strategies ...Strategy) gin.HandlerFunc {
+	return middleware(true, strategies)
+}
+
+func middleware(strict bool, strategies []Strategy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key, ok, err := Resolve(ctx.Request, strategies...)
+		if err == nil && !ok && strict {
+			err = ErrUnidentified
+		}
+		if err != nil {
+			_ = ctx.Error(err).SetMeta(metrics.ErrorLabels{
+				Handler: metricsHandler,
+				Kind:    "forbidden",
+			})
+			ctx.Data(http.StatusForbidden, "text/plain; charset=utf-8", []byte(err.Error()))
+			ctx.Abort()
+			return
+		}
+		if !ok {
+			return
+		}
+
+		if key.Kind == KindIP {
+			ctx.Request.RemoteAddr = net.JoinHostPort(key.Value, "0")
+		}
+		ctx.Request = ctx.Request.WithContext(NewContext(ctx.Request.Context(), key))
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying key.
+func NewContext(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the Key stored in ctx by Middleware, if any.
+func FromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(Key)
+	return key, ok
+}
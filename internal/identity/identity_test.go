@@ -0,0 +1,482 @@
+package identity_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/identity"
+	"github.com/tinkerbell/hegel/internal/unixsocket"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestStrategies(t *testing.T) {
+	forwardedFor, err := ForwardedFor([]string{"10.0.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwardedForSingleIP, err := ForwardedFor([]string{"10.0.0.1/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	macHeader, err := MACHeader("X-Hegel-MAC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unixSocket, err := UnixSocket(unixsocket.Options{IdentityIP: "10.10.10.12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dhcpLease := DHCPLease(stubLeases{
+		"10.10.10.13":  "3c:ec:ef:4c:4f:55",
+		"fe80::1%eth0": "3c:ec:ef:4c:4f:56",
+	})
+
+	nodeHint, err := NodeHint("X-Hegel-Node-Hint", nodeHintKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name       string
+		Strategy   Strategy
+		RemoteAddr string
+		Query      string
+		Header     http.Header
+		UnixSocket bool
+		Expect     Key
+		ExpectOK   bool
+	}{
+		{
+			Name:       "SourceIP",
+			Strategy:   SourceIP(),
+			RemoteAddr: "10.10.10.10:1234",
+			Expect:     Key{Kind: KindIP, Value: "10.10.10.10"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "ForwardedForTrustedProxy",
+			Strategy:   forwardedFor,
+			RemoteAddr: "10.0.0.1:1234",
+			Header:     http.Header{"X-Forwarded-For": {"10.10.10.11"}},
+			Expect:     Key{Kind: KindIP, Value: "10.10.10.11"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "ForwardedForUntrustedProxy",
+			Strategy:   forwardedFor,
+			RemoteAddr: "10.0.1.1:1234",
+			Header:     http.Header{"X-Forwarded-For": {"10.10.10.11"}},
+		},
+		{
+			Name:       "ForwardedForSingleTrustedIP",
+			Strategy:   forwardedForSingleIP,
+			RemoteAddr: "10.0.0.1:1234",
+			Header:     http.Header{"X-Forwarded-For": {"10.10.10.11"}},
+			Expect:     Key{Kind: KindIP, Value: "10.10.10.11"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "ForwardedForOutsideSingleTrustedIP",
+			Strategy:   forwardedForSingleIP,
+			RemoteAddr: "10.0.0.2:1234",
+			Header:     http.Header{"X-Forwarded-For": {"10.10.10.11"}},
+		},
+		{
+			Name:       "ForwardedForNoHeader",
+			Strategy:   forwardedFor,
+			RemoteAddr: "10.0.0.1:1234",
+		},
+		{
+			Name:       "MACHeader",
+			Strategy:   macHeader,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Mac": {"3C:EC:EF:4C:4F:54"}},
+			Expect:     Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "MACHeaderInvalid",
+			Strategy:   macHeader,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Mac": {"invalid"}},
+		},
+		{
+			Name:       "UnixSocket",
+			Strategy:   unixSocket,
+			RemoteAddr: "@",
+			UnixSocket: true,
+			Expect:     Key{Kind: KindIP, Value: "10.10.10.12"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "UnixSocketOverTCP",
+			Strategy:   unixSocket,
+			RemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			Name:       "DHCPLease",
+			Strategy:   dhcpLease,
+			RemoteAddr: "10.10.10.13:1234",
+			Expect:     Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:55"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "DHCPLeaseZoned",
+			Strategy:   dhcpLease,
+			RemoteAddr: "[fe80::1%eth0]:1234",
+			Expect:     Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:56"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "DHCPLeaseNoLease",
+			Strategy:   dhcpLease,
+			RemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			Name:       "DHCPLeaseInvalidRemoteAddr",
+			Strategy:   dhcpLease,
+			RemoteAddr: "@",
+		},
+		{
+			Name:       "ClientCertWithoutTLS",
+			Strategy:   ClientCert(),
+			RemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			Name:       "DebugQueryInstanceID",
+			Strategy:   DebugQuery(),
+			RemoteAddr: "10.10.10.10:1234",
+			Query:      "?instance_id=sm01&ip=10.10.10.11",
+			Expect:     Key{Kind: KindInstanceID, Value: "sm01"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "DebugQueryIP",
+			Strategy:   DebugQuery(),
+			RemoteAddr: "10.10.10.10:1234",
+			Query:      "?ip=10.10.10.11",
+			Expect:     Key{Kind: KindIP, Value: "10.10.10.11"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "DebugQueryInvalidIP",
+			Strategy:   DebugQuery(),
+			RemoteAddr: "10.10.10.10:1234",
+			Query:      "?ip=invalid",
+		},
+		{
+			Name:       "DebugQueryNoQuery",
+			Strategy:   DebugQuery(),
+			RemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			Name:       "NodeHintMAC",
+			Strategy:   nodeHint,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Node-Hint": {SignNodeHint(Key{Kind: KindMAC, Value: "3C:EC:EF:4C:4F:54"}, time.Now(), nodeHintKey)}},
+			Expect:     Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "NodeHintInstanceID",
+			Strategy:   nodeHint,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Node-Hint": {SignNodeHint(Key{Kind: KindInstanceID, Value: "i-0.sm01"}, time.Now(), nodeHintKey)}},
+			Expect:     Key{Kind: KindInstanceID, Value: "i-0.sm01"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "NodeHintIP",
+			Strategy:   nodeHint,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Node-Hint": {SignNodeHint(Key{Kind: KindIP, Value: "192.168.1.10"}, time.Now(), nodeHintKey)}},
+			Expect:     Key{Kind: KindIP, Value: "192.168.1.10"},
+			ExpectOK:   true,
+		},
+		{
+			Name:       "NodeHintForged",
+			Strategy:   nodeHint,
+			RemoteAddr: "10.10.10.10:1234",
+			Header:     http.Header{"X-Hegel-Node-Hint": {forgedNodeHint}},
+		},
+		{
+			Name:       "NodeHintNoHeader",
+			Strategy:   nodeHint,
+			RemoteAddr: "10.10.10.10:1234",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/"+tc.Query, nil)
+			r.RemoteAddr = tc.RemoteAddr
+			for k, v := range tc.Header {
+				r.Header[k] = v
+			}
+			if tc.UnixSocket {
+				r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Net: "unix"}))
+			}
+
+			key, ok := tc.Strategy.Resolve(r)
+			if ok != tc.ExpectOK {
+				t.Fatalf("Expected ok: %v; Received: %v", tc.ExpectOK, ok)
+			}
+			if key != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, key)
+			}
+		})
+	}
+}
+
+// nodeHintKey is the key node hints are signed with.
+var nodeHintKey = []byte("0123456789abcdef0123456789abcdef")
+
+// hmacSHA256 returns the HMAC-SHA256 of payload keyed by nodeHintKey.
+func hmacSHA256(payload string) []byte {
+	mac := hmac.New(sha256.New, nodeHintKey)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// forgedNodeHint is a node hint signed with a key other than nodeHintKey.
+var forgedNodeHint = SignNodeHint(Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"}, time.Now(), []byte("fedcba9876543210fedcba9876543210"))
+
+func TestNodeHintVerify(t *testing.T) {
+	strategy, err := NodeHint("X-Hegel-Node-Hint", nodeHintKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := SignNodeHint(Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"}, time.Now(), nodeHintKey)
+	_, signature, _ := strings.Cut(valid, ".")
+
+	cases := []struct {
+		Name        string
+		Hint        string
+		ExpectError bool
+	}{
+		{Name: "Valid", Hint: valid},
+		{Name: "NoHint"},
+		{Name: "Forged", Hint: forgedNodeHint, ExpectError: true},
+		{Name: "TamperedValue", Hint: "mac:3c:ec:ef:4c:4f:55." + signature, ExpectError: true},
+		{Name: "TamperedKind", Hint: "instance-id:3c:ec:ef:4c:4f:54." + signature, ExpectError: true},
+		{Name: "Unsigned", Hint: "mac:3c:ec:ef:4c:4f:54", ExpectError: true},
+		{Name: "InvalidEncoding", Hint: "mac:3c:ec:ef:4c:4f:54.!!!", ExpectError: true},
+		{Name: "UnknownKind", Hint: SignNodeHint(Key{Kind: "hostname", Value: "sm01"}, time.Now(), nodeHintKey), ExpectError: true},
+		{Name: "InvalidMAC", Hint: SignNodeHint(Key{Kind: KindMAC, Value: "invalid"}, time.Now(), nodeHintKey), ExpectError: true},
+		{Name: "InvalidIP", Hint: SignNodeHint(Key{Kind: KindIP, Value: "invalid"}, time.Now(), nodeHintKey), ExpectError: true},
+		{Name: "Expired", Hint: SignNodeHint(Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"}, time.Now().Add(-2*time.Minute), nodeHintKey), ExpectError: true},
+		{Name: "IssuedInFuture", Hint: SignNodeHint(Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"}, time.Now().Add(2*time.Minute), nodeHintKey), ExpectError: true},
+		{Name: "NoIssuedTime", Hint: "mac:3c:ec:ef:4c:4f:54." + base64.RawURLEncoding.EncodeToString(hmacSHA256("mac:3c:ec:ef:4c:4f:54")), ExpectError: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.Hint != "" {
+				r.Header.Set("X-Hegel-Node-Hint", tc.Hint)
+			}
+
+			err := strategy.(Verifier).Verify(r)
+			if tc.ExpectError && err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+			if !tc.ExpectError && err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Hints that fail verification never identify an instance.
+			if _, ok := strategy.Resolve(r); ok != (tc.Hint != "" && !tc.ExpectError) {
+				t.Fatalf("Unexpected ok: %v", ok)
+			}
+		})
+	}
+}
+
+func TestNodeHintErrors(t *testing.T) {
+	if _, err := NodeHint("", nodeHintKey, time.Minute); err == nil {
+		t.Fatal("No header: Expected error; Received: nil")
+	}
+	if _, err := NodeHint("X-Hegel-Node-Hint", nodeHintKey[:MinNodeHintKeySize-1], time.Minute); err == nil {
+		t.Fatal("Short key: Expected error; Received: nil")
+	}
+	if _, err := NodeHint("X-Hegel-Node-Hint", nodeHintKey, 0); err == nil {
+		t.Fatal("No max age: Expected error; Received: nil")
+	}
+}
+
+func TestForwardedForErrors(t *testing.T) {
+	cases := [][]string{
+		nil,
+		{"dsadsa"},
+		{"256.256.256.256/16"},
+		{"192.168.0.0/33"},
+	}
+
+	for i, proxies := range cases {
+		t.Run(fmt.Sprintf("%v", i), func(t *testing.T) {
+			if _, err := ForwardedFor(proxies); err == nil {
+				t.Fatal("Expected error; Received: nil")
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	nodeHint, err := NodeHint("X-Hegel-Node-Hint", nodeHintKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	macHeader, err := MACHeader("X-Hegel-MAC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name             string
+		Header           http.Header
+		ExpectStatus     int
+		ExpectKey        Key
+		ExpectRemoteAddr string
+	}{
+		{
+			Name:             "FirstStrategyWins",
+			Header:           http.Header{"X-Hegel-Mac": {"3c:ec:ef:4c:4f:54"}},
+			ExpectStatus:     http.StatusOK,
+			ExpectKey:        Key{Kind: KindMAC, Value: "3c:ec:ef:4c:4f:54"},
+			ExpectRemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			Name:             "FallsThrough",
+			ExpectStatus:     http.StatusOK,
+			ExpectKey:        Key{Kind: KindIP, Value: "10.10.10.10"},
+			ExpectRemoteAddr: "10.10.10.10:0",
+		},
+		{
+			// Later strategies would identify the instance by its IP, which may be shared.
+			Name: "UnverifiedRejected",
+			Header: http.Header{
+				"X-Hegel-Node-Hint": {forgedNodeHint},
+				"X-Hegel-Mac":       {"3c:ec:ef:4c:4f:54"},
+			},
+			ExpectStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var key Key
+			var remoteAddr string
+
+			router := gin.New()
+			router.Use(Middleware(nodeHint, macHeader, SourceIP()))
+			router.GET("/", func(ctx *gin.Context) {
+				key, _ = FromContext(ctx.Request.Context())
+				remoteAddr = ctx.Request.RemoteAddr
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.10.10.10:1234"
+			for k, v := range tc.Header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected status: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+			if key != tc.ExpectKey {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectKey, key)
+			}
+			if remoteAddr != tc.ExpectRemoteAddr {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectRemoteAddr, remoteAddr)
+			}
+		})
+	}
+}
+
+func TestStrictMiddleware(t *testing.T) {
+	macHeader, err := MACHeader("X-Hegel-MAC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name             string
+		Header           http.Header
+		ExpectStatus     int
+		ExpectRemoteAddr string
+	}{
+		{
+			Name:             "Identified",
+			Header:           http.Header{"X-Hegel-Mac": {"3c:ec:ef:4c:4f:54"}},
+			ExpectStatus:     http.StatusOK,
+			ExpectRemoteAddr: "10.10.10.10:1234",
+		},
+		{
+			// The instance at the source IP mustn't be served in place of the unidentified one.
+			Name:         "UnidentifiedRejected",
+			ExpectStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var remoteAddr string
+			router := gin.New()
+			router.Use(StrictMiddleware(macHeader))
+			router.GET("/", func(ctx *gin.Context) {
+				remoteAddr = ctx.Request.RemoteAddr
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "10.10.10.10:1234"
+			for k, v := range tc.Header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.ExpectStatus {
+				t.Fatalf("Expected status: %d; Received: %d", tc.ExpectStatus, w.Code)
+			}
+			if remoteAddr != tc.ExpectRemoteAddr {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectRemoteAddr, remoteAddr)
+			}
+		})
+	}
+}
+
+// stubLeases maps IPs to the MAC address they're leased to.
+type stubLeases map[string]string
+
+func (s stubLeases) Lookup(ip netip.Addr) (net.HardwareAddr, bool) {
+	mac, ok := s[ip.String()]
+	if !ok {
+		return nil, false
+	}
+	hw, _ := net.ParseMAC(mac)
+	return hw, true
+}
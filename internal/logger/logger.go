@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"context"
 	"errors"
 	"strings"
 	"time"
@@ -40,10 +41,19 @@ func Middleware(logger logr.Logger) gin.HandlerFunc {
 			"latency", end.Sub(start),
 		)
 
-		// If we received a non-error status code Info else error it.
-		if c.Writer.Status() < 500 {
+		// If the client disconnected there was nobody to respond to, otherwise if we received a
+		// non-error status code Info else error it.
+		switch {
+		case errors.Is(c.Request.Context().Err(), context.Canceled):
+			event.Info("Client disconnected")
+		case c.Writer.Status() < 500:
+			// Errors may be recorded for requests that were served, such as data omitted from a
+			// response, so include them as warnings.
+			if len(c.Errors) > 0 {
+				event = event.WithValues("warnings", c.Errors.Errors())
+			}
 			event.Info("")
-		} else {
+		default:
 			msg := "No error message specified"
 			errs := strings.Join(c.Errors.Errors(), "; ")
 			if len(c.Errors.Errors()) > 0 {
@@ -0,0 +1,16 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// RegisterAmbiguousLookups adds a Counter to registrar counting instance lookups that matched more
+// than one hardware record and returns it.
+func RegisterAmbiguousLookups(registrar prometheus.Registerer) prometheus.Counter {
+	m := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "backend_ambiguous_lookups_total",
+		Help: "Count of instance lookups that matched more than one hardware record",
+	})
+
+	registrar.MustRegister(m)
+
+	return m
+}
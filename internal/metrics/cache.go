@@ -0,0 +1,39 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CacheSizer is a cache that reports its size.
+type CacheSizer interface {
+	// CacheSize returns the number of entries in the cache and their approximate size in bytes.
+	CacheSize() (entries, bytes int)
+}
+
+// RegisterCacheSize adds Gauges to registrar reporting the number of entries and approximate size
+// of each of caches, labelled by the name they're keyed by, such as negative. Sizes are read
+// whenever metrics are gathered so they're updated every scrape without a background goroutine.
+func RegisterCacheSize(registrar prometheus.Registerer, caches map[string]CacheSizer) {
+	for name, c := range caches {
+		c := c
+		labels := prometheus.Labels{"cache": name}
+
+		entries := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cache_entries",
+			Help:        "Number of entries in a cache",
+			ConstLabels: labels,
+		}, func() float64 {
+			n, _ := c.CacheSize()
+			return float64(n)
+		})
+
+		size := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cache_size_bytes",
+			Help:        "Approximate size of the entries in a cache in bytes",
+			ConstLabels: labels,
+		}, func() float64 {
+			_, n := c.CacheSize()
+			return float64(n)
+		})
+
+		registrar.MustRegister(entries, size)
+	}
+}
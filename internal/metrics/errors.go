@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	handlerLabel = "handler"
+	kindLabel    = "kind"
+)
+
+// ErrorLabels classifies an error recorded on a gin.Context. Handlers attach it to errors using
+// gin.Error.SetMeta so InstrumentErrors can count the error against the correct labels.
+type ErrorLabels struct {
+	// Handler identifies the handler that raised the error. For example, "metadata".
+	Handler string
+
+	// Kind identifies the class of error. For example, "backend" or "filter".
+	Kind string
+}
+
+// InstrumentErrors adds a CounterVec to registrar and returns a handler that increments the
+// count for every error recorded on the gin.Context during request processing. Errors without
+// ErrorLabels metadata are counted with an "unknown" kind.
+func InstrumentErrors(registrar prometheus.Registerer) gin.HandlerFunc {
+	m := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_server_errors_total",
+			Help: "Count of errors encountered while serving HTTP requests",
+		},
+		[]string{handlerLabel, kindLabel},
+	)
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		for _, err := range ctx.Errors {
+			labels, ok := err.Meta.(ErrorLabels)
+			if !ok {
+				labels = ErrorLabels{Kind: "unknown"}
+			}
+			m.WithLabelValues(labels.Handler, labels.Kind).Inc()
+		}
+	}
+}
@@ -7,8 +7,11 @@ import (
 )
 
 // Configure configures router with a /metrics endpoint that serves prometheus metrics sourced from
-// registry.
+// registry. Scrapers that negotiate the OpenMetrics format also receive exemplars.
 func Configure(router gin.IRouter, registry *prometheus.Registry) {
-	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		Registry:          registry,
+		EnableOpenMetrics: true,
+	})
 	router.GET("/metrics", gin.WrapH(handler))
 }
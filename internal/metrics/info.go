@@ -0,0 +1,21 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// RegisterInfo adds a Gauge to registrar, always 1, whose labels describe the running Hegel: the
+// data model instances are retrieved from, such as kubernetes, and the build version. Dashboards
+// join it with other metrics to group them by data model or version.
+func RegisterInfo(registrar prometheus.Registerer, dataModel, version string) {
+	m := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "info",
+		Help: "Constant 1 labelled with the data model and build version of Hegel",
+		ConstLabels: prometheus.Labels{
+			"data_model": dataModel,
+			"version":    version,
+		},
+	})
+
+	registrar.MustRegister(m)
+
+	m.Set(1)
+}
@@ -1,24 +1,32 @@
 package metrics
 
 import (
+	"context"
+	"errors"
+	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	routeLabel      = "route"
 	methodLabel     = "method"
 	statusCodeLabel = "status_code"
+	pathLabel       = "path"
+
+	// traceIDExemplarLabel is the exemplar label histogram observations are linked to traces by.
+	traceIDExemplarLabel = "trace_id"
 )
 
 // InstrumentRequestCount adds a CounterVec to registrar and returns a handler that increments
 // the count with every request.
 func InstrumentRequestCount(registrar prometheus.Registerer) gin.HandlerFunc {
-	m := promauto.NewCounterVec(
+	m := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "http_server_requests_total",
 			Help: "Count of HTTP requests",
@@ -38,7 +46,8 @@ func InstrumentRequestCount(registrar prometheus.Registerer) gin.HandlerFunc {
 }
 
 // InstrumentReuqestDuration adds a HistogramVec to registrar and returns a handler that records
-// request durations with every request.
+// request durations with every request. Observations for requests with a sampled trace have the
+// trace ID attached as an exemplar.
 func InstrumentRequestDuration(registrar prometheus.Registerer) gin.HandlerFunc {
 	m := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
@@ -54,10 +63,126 @@ func InstrumentRequestDuration(registrar prometheus.Registerer) gin.HandlerFunc
 	return func(ctx *gin.Context) {
 		start := time.Now()
 		ctx.Next()
-		m.WithLabelValues(
+		observe(ctx.Request.Context(), m.WithLabelValues(
 			ctx.FullPath(),
 			ctx.Request.Method,
 			strconv.Itoa(ctx.Writer.Status()),
-		).Observe(time.Since(start).Seconds())
+		), time.Since(start).Seconds())
+	}
+}
+
+// InstrumentResponseSize adds a HistogramVec to registrar and returns a handler that records the
+// number of response body bytes written for every request labelled by route. Bytes are counted as
+// they're written so streamed responses are measured in full. Observations for requests with a
+// sampled trace have the trace ID attached as an exemplar.
+func InstrumentResponseSize(registrar prometheus.Registerer) gin.HandlerFunc {
+	m := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_server_response_size_bytes",
+			Help:    "Histogram of HTTP response body sizes in bytes",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		},
+		[]string{routeLabel},
+	)
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		// Size is -1 if nothing was written.
+		size := ctx.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		observe(ctx.Request.Context(), m.WithLabelValues(ctx.FullPath()), float64(size))
+	}
+}
+
+// observe records v with o. If ctx carries a sampled span, its trace ID is attached as an exemplar
+// so operators can navigate from the observation to the trace. Handlers make their span available
+// by storing it in the request context. Unsampled traces aren't exported so they aren't linked.
+func observe(ctx context.Context, o prometheus.Observer, v float64) {
+	sc := trace.SpanContextFromContext(ctx)
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && sc.IsValid() && sc.IsSampled() {
+		eo.ObserveWithExemplar(v, prometheus.Labels{traceIDExemplarLabel: sc.TraceID().String()})
+		return
+	}
+	o.Observe(v)
+}
+
+// InstrumentInFlightRequests adds a Gauge to registrar and returns a handler that tracks the
+// number of requests currently being served. The gauge is decremented however the request
+// completes, including requests aborted early or that don't match a route.
+func InstrumentInFlightRequests(registrar prometheus.Registerer) gin.HandlerFunc {
+	m := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_server_requests_in_flight",
+		Help: "Number of HTTP requests currently being served",
+	})
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		m.Inc()
+		defer m.Dec()
+		ctx.Next()
+	}
+}
+
+// InstrumentPathRequests adds a CounterVec to registrar and returns a handler that counts
+// successful requests for routes beneath any of prefixes labelled by route, such as
+// /2009-04-04/meta-data/public-keys/:index. Requests that don't match a route are counted with an
+// "invalid" path so clients can't inflate the label cardinality.
+func InstrumentPathRequests(registrar prometheus.Registerer, prefixes ...string) gin.HandlerFunc {
+	m := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_server_path_requests_total",
+			Help: "Count of successful HTTP requests by path",
+		},
+		[]string{pathLabel},
+	)
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		// Routes may be registered with and without a trailing slash; count them as one path.
+		route := ctx.FullPath()
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+
+		if route == "" {
+			m.WithLabelValues("invalid").Inc()
+			return
+		}
+		if ctx.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(route, prefix) {
+				m.WithLabelValues(route).Inc()
+				return
+			}
+		}
+	}
+}
+
+// InstrumentClientDisconnects adds a Counter to registrar and returns a handler that counts
+// requests whose client disconnected before the request was served.
+func InstrumentClientDisconnects(registrar prometheus.Registerer) gin.HandlerFunc {
+	m := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_server_client_disconnects_total",
+		Help: "Count of HTTP requests whose client disconnected before the request was served",
+	})
+
+	registrar.MustRegister(m)
+
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		if errors.Is(ctx.Request.Context().Err(), context.Canceled) {
+			m.Inc()
+		}
 	}
 }
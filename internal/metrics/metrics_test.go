@@ -0,0 +1,332 @@
+package metrics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/tinkerbell/hegel/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestInstrumentInFlightRequests(t *testing.T) {
+	const requests = 5
+
+	registry := prometheus.NewRegistry()
+
+	// The stub handler blocks until released so we can observe requests in-flight.
+	entered := make(chan struct{}, requests)
+	release := make(chan struct{})
+
+	router := gin.New()
+	router.Use(InstrumentInFlightRequests(registry))
+	router.GET("/blocking", func(ctx *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		ctx.String(http.StatusOK, "ok")
+	})
+	router.GET("/aborted", func(ctx *gin.Context) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/blocking", nil))
+		}()
+	}
+
+	for i := 0; i < requests; i++ {
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for requests to be in-flight")
+		}
+	}
+
+	expectInFlight(t, registry, requests)
+
+	// Requests that exit early, including those that don't match a route, should not leave the
+	// gauge incremented.
+	for _, path := range []string{"/aborted", "/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	expectInFlight(t, registry, requests)
+
+	close(release)
+	wg.Wait()
+
+	expectInFlight(t, registry, 0)
+}
+
+func expectInFlight(t *testing.T, registry *prometheus.Registry, n int) {
+	t.Helper()
+
+	expect := `
+# HELP http_server_requests_in_flight Number of HTTP requests currently being served
+# TYPE http_server_requests_in_flight gauge
+http_server_requests_in_flight ` + strconv.Itoa(n) + `
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstrumentPathRequests(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	router.Use(InstrumentPathRequests(registry, "/2009-04-04", "/latest"))
+
+	ok := func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") }
+	router.GET("/2009-04-04/meta-data/hostname", ok)
+	router.GET("/latest/meta-data/hostname", ok)
+	router.GET("/2009-04-04/meta-data", ok)
+	router.GET("/2009-04-04/meta-data/", ok)
+	router.GET("/2009-04-04/meta-data/public-keys/:index", func(ctx *gin.Context) {
+		ctx.AbortWithStatus(http.StatusNotFound)
+	})
+	router.GET("/healthz", ok)
+
+	requests := []string{
+		"/2009-04-04/meta-data/hostname",
+		"/2009-04-04/meta-data/hostname",
+		"/latest/meta-data/hostname",
+		"/2009-04-04/meta-data",
+		"/2009-04-04/meta-data/",
+		"/2009-04-04/meta-data/public-keys/10",
+		"/2009-04-04/meta-data/unknown",
+		"/unknown",
+		"/healthz",
+	}
+	for _, path := range requests {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	expect := `
+# HELP http_server_path_requests_total Count of successful HTTP requests by path
+# TYPE http_server_path_requests_total counter
+http_server_path_requests_total{path="/2009-04-04/meta-data"} 2
+http_server_path_requests_total{path="/2009-04-04/meta-data/hostname"} 2
+http_server_path_requests_total{path="/latest/meta-data/hostname"} 1
+http_server_path_requests_total{path="invalid"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstrumentClientDisconnects(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	router.Use(InstrumentClientDisconnects(registry))
+	router.GET("/", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "ok")
+	})
+
+	// A request served normally shouldn't be counted.
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// Simulate a client that disconnected before the request was served.
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(reqCtx))
+
+	expect := `
+# HELP http_server_client_disconnects_total Count of HTTP requests whose client disconnected before the request was served
+# TYPE http_server_client_disconnects_total counter
+http_server_client_disconnects_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), "http_server_client_disconnects_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewRegisterer(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Namespace string
+		Subsystem string
+		Expect    string
+	}{
+		{
+			Name:      "Default",
+			Namespace: DefaultNamespace,
+			Expect:    "hegel_http_server_requests_total",
+		},
+		{
+			Name:      "CustomNamespaceAndSubsystem",
+			Namespace: "tinkerbell",
+			Subsystem: "metadata",
+			Expect:    "tinkerbell_metadata_http_server_requests_total",
+		},
+		{
+			Name:      "SubsystemOnly",
+			Subsystem: "metadata",
+			Expect:    "metadata_http_server_requests_total",
+		},
+		{
+			Name:   "Unprefixed",
+			Expect: "http_server_requests_total",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+
+			router := gin.New()
+			router.Use(InstrumentRequestCount(NewRegisterer(registry, tc.Namespace, tc.Subsystem)))
+			router.GET("/", func(ctx *gin.Context) {
+				ctx.String(http.StatusOK, "ok")
+			})
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+			expect := `
+# HELP ` + tc.Expect + ` Count of HTTP requests
+# TYPE ` + tc.Expect + ` counter
+` + tc.Expect + `{method="GET",status_code="200"} 1
+`
+			if err := testutil.GatherAndCompare(registry, strings.NewReader(expect), tc.Expect); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestRegisterInfo(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	RegisterInfo(NewRegisterer(registry, DefaultNamespace, ""), "kubernetes", "abc123")
+
+	expect := `
+# HELP hegel_info Constant 1 labelled with the data model and build version of Hegel
+# TYPE hegel_info gauge
+hegel_info{data_model="kubernetes",version="abc123"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstrumentResponseSize(t *testing.T) {
+	const userdata = "#cloud-config\nhostname: sm01\n"
+
+	registry := prometheus.NewRegistry()
+
+	router := gin.New()
+	router.Use(InstrumentResponseSize(registry))
+	router.GET("/2009-04-04/user-data", func(ctx *gin.Context) {
+		// Write in chunks to simulate a streamed response.
+		for _, line := range strings.SplitAfter(userdata, "\n") {
+			_, _ = ctx.Writer.WriteString(line)
+			ctx.Writer.Flush()
+		}
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/2009-04-04/user-data", nil))
+
+	expect := `
+# HELP http_server_response_size_bytes Histogram of HTTP response body sizes in bytes
+# TYPE http_server_response_size_bytes histogram
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="64"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="256"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="1024"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="4096"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="16384"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="65536"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="262144"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="1.048576e+06"} 1
+http_server_response_size_bytes_bucket{route="/2009-04-04/user-data",le="+Inf"} 1
+http_server_response_size_bytes_sum{route="/2009-04-04/user-data"} ` + strconv.Itoa(len(userdata)) + `
+http_server_response_size_bytes_count{route="/2009-04-04/user-data"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expect)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExemplars(t *testing.T) {
+	traceID := trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36}
+	spanContext := func(flags trace.TraceFlags) trace.SpanContext {
+		return trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    traceID,
+			SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+			TraceFlags: flags,
+		})
+	}
+
+	cases := []struct {
+		Name           string
+		SpanContext    trace.SpanContext
+		ExpectExemplar bool
+	}{
+		{
+			Name:           "Sampled",
+			SpanContext:    spanContext(trace.FlagsSampled),
+			ExpectExemplar: true,
+		},
+		{
+			Name:        "Unsampled",
+			SpanContext: spanContext(0),
+		},
+		{
+			Name: "NoTrace",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			registry := prometheus.NewRegistry()
+
+			router := gin.New()
+			router.Use(InstrumentRequestDuration(registry), InstrumentResponseSize(registry))
+			router.GET("/2009-04-04/user-data", func(ctx *gin.Context) {
+				// Handlers make their span available by storing it in the request context.
+				if tc.SpanContext.IsValid() {
+					ctx.Request = ctx.Request.WithContext(
+						trace.ContextWithSpanContext(ctx.Request.Context(), tc.SpanContext),
+					)
+				}
+				ctx.String(http.StatusOK, "#cloud-config\n")
+			})
+			Configure(router, registry)
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/2009-04-04/user-data", nil))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+			router.ServeHTTP(w, r)
+
+			exemplar := `# {trace_id="` + traceID.String() + `"}`
+			for _, name := range []string{"http_server_request_duration_seconds", "http_server_response_size_bytes"} {
+				var found bool
+				for _, line := range strings.Split(w.Body.String(), "\n") {
+					if strings.HasPrefix(line, name+"_bucket") && strings.Contains(line, exemplar) {
+						found = true
+					}
+				}
+				if found != tc.ExpectExemplar {
+					t.Fatalf("Expected %v exemplar: %v; Received: %v\n%v", name, tc.ExpectExemplar, found, w.Body.String())
+				}
+			}
+		})
+	}
+}
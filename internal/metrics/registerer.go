@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace is the default namespace Hegel's metric names are prefixed with.
+const DefaultNamespace = "hegel"
+
+// NewRegisterer returns a prometheus.Registerer that registers metrics with registrar with their
+// names prefixed by namespace and subsystem. For example, a namespace of "hegel" and subsystem of
+// "metadata" registers http_server_requests_total as hegel_metadata_http_server_requests_total.
+// Empty components are omitted; if both are empty registrar is returned unchanged.
+func NewRegisterer(registrar prometheus.Registerer, namespace, subsystem string) prometheus.Registerer {
+	var parts []string
+	for _, p := range []string{namespace, subsystem} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+
+	if len(parts) == 0 {
+		return registrar
+	}
+
+	return prometheus.WrapRegistererWithPrefix(strings.Join(parts, "_")+"_", registrar)
+}
@@ -0,0 +1,115 @@
+/*
+Package tenant scopes requests to a tenant so clusters shared by tenants with overlapping IP ranges
+serve each instance only its own tenant's data.
+
+A Source determines the tenant a request is made on behalf of, such as from a header, the verified
+client certificate or the listener the request was received on. Middleware stores the tenant in
+the request context and backends retrieve it with FromContext to scope their lookups.
+*/
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Source determines the tenant of requests.
+type Source interface {
+	// Tenant returns the tenant r is made on behalf of. If the tenant can't be determined from r
+	// it returns false.
+	Tenant(r *http.Request) (string, bool)
+}
+
+// SourceFunc adapts a func to a Source.
+type SourceFunc func(r *http.Request) (string, bool)
+
+// Tenant satisfies Source.
+func (f SourceFunc) Tenant(r *http.Request) (string, bool) {
+	return f(r)
+}
+
+// Source names used to select a source with configuration.
+const (
+	HeaderSource     = "header"
+	ClientCertSource = "client-cert"
+	StaticSource     = "static"
+)
+
+// SourceNames returns the name of every source.
+func SourceNames() []string {
+	return []string{
+		HeaderSource,
+		ClientCertSource,
+		StaticSource,
+	}
+}
+
+// Header determines the tenant from header. Requests without header have no tenant.
+//
+// The header is supplied by the client so it must only be used behind a proxy that sets it.
+func Header(header string) (Source, error) {
+	if header == "" {
+		return nil, fmt.Errorf("%v source requires a header", HeaderSource)
+	}
+
+	return SourceFunc(func(r *http.Request) (string, bool) {
+		tenant := r.Header.Get(header)
+		return tenant, tenant != ""
+	}), nil
+}
+
+// ClientCert determines the tenant from the first organization in the subject of the verified
+// client certificate presented with the request. Requests without a verified client certificate,
+// or whose certificate has no organization, have no tenant.
+func ClientCert() Source {
+	return SourceFunc(func(r *http.Request) (string, bool) {
+		state := r.TLS
+		if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+			return "", false
+		}
+
+		org := state.VerifiedChains[0][0].Subject.Organization
+		if len(org) == 0 || org[0] == "" {
+			return "", false
+		}
+		return org[0], true
+	})
+}
+
+// Static assigns every request to tenant. It binds a listener to a single tenant.
+func Static(tenant string) (Source, error) {
+	if tenant == "" {
+		return nil, fmt.Errorf("%v source requires a tenant", StaticSource)
+	}
+
+	return SourceFunc(func(*http.Request) (string, bool) {
+		return tenant, true
+	}), nil
+}
+
+// Middleware creates a Gin middleware that determines the tenant of each request using source and
+// stores it in the request context for retrieval with FromContext. Requests whose tenant can't be
+// determined are unaltered; tenant scoped lookups made on their behalf find no instances.
+func Middleware(source Source) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if tenant, ok := source.Tenant(ctx.Request); ok {
+			ctx.Request = ctx.Request.WithContext(NewContext(ctx.Request.Context(), tenant))
+		}
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying tenant.
+func NewContext(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext returns the tenant stored in ctx by Middleware, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(contextKey{}).(string)
+	return tenant, ok
+}
@@ -0,0 +1,165 @@
+package tenant_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/tinkerbell/hegel/internal/tenant"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func TestSources(t *testing.T) {
+	header, err := Header("X-Hegel-Tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static, err := Static("tenant-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verified := func(org ...string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{Organization: org}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	cases := []struct {
+		Name     string
+		Source   Source
+		Header   http.Header
+		TLS      *tls.ConnectionState
+		Expect   string
+		ExpectOK bool
+	}{
+		{
+			Name:     "Header",
+			Source:   header,
+			Header:   http.Header{"X-Hegel-Tenant": {"tenant-a"}},
+			Expect:   "tenant-a",
+			ExpectOK: true,
+		},
+		{
+			Name:   "HeaderMissing",
+			Source: header,
+		},
+		{
+			Name:     "ClientCert",
+			Source:   ClientCert(),
+			TLS:      verified("tenant-a", "tenant-b"),
+			Expect:   "tenant-a",
+			ExpectOK: true,
+		},
+		{
+			Name:   "ClientCertWithoutOrganization",
+			Source: ClientCert(),
+			TLS:    verified(),
+		},
+		{
+			Name:   "ClientCertUnverified",
+			Source: ClientCert(),
+			TLS:    &tls.ConnectionState{},
+		},
+		{
+			Name:   "ClientCertWithoutTLS",
+			Source: ClientCert(),
+		},
+		{
+			Name:     "Static",
+			Source:   static,
+			Header:   http.Header{"X-Hegel-Tenant": {"tenant-b"}},
+			Expect:   "tenant-a",
+			ExpectOK: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tc.TLS
+			for k, v := range tc.Header {
+				r.Header[k] = v
+			}
+
+			tenant, ok := tc.Source.Tenant(r)
+			if ok != tc.ExpectOK {
+				t.Fatalf("Expected ok: %v; Received: %v", tc.ExpectOK, ok)
+			}
+			if tenant != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, tenant)
+			}
+		})
+	}
+}
+
+func TestSourceRequiresConfiguration(t *testing.T) {
+	if _, err := Header(""); err == nil {
+		t.Fatal("Expected error for empty header")
+	}
+	if _, err := Static(""); err == nil {
+		t.Fatal("Expected error for empty tenant")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	header, err := Header("X-Hegel-Tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name     string
+		Header   http.Header
+		Expect   string
+		ExpectOK bool
+	}{
+		{
+			Name:     "Tenant",
+			Header:   http.Header{"X-Hegel-Tenant": {"tenant-a"}},
+			Expect:   "tenant-a",
+			ExpectOK: true,
+		},
+		{
+			Name: "NoTenant",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var tenant string
+			var ok bool
+
+			router := gin.New()
+			router.Use(Middleware(header))
+			router.GET("/", func(ctx *gin.Context) {
+				tenant, ok = FromContext(ctx.Request.Context())
+			})
+
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range tc.Header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected: 200; Received: %d", w.Code)
+			}
+			if ok != tc.ExpectOK {
+				t.Fatalf("Expected ok: %v; Received: %v", tc.ExpectOK, ok)
+			}
+			if tenant != tc.Expect {
+				t.Fatalf("Expected: %v; Received: %v", tc.Expect, tenant)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+// Package unixsocket provides instance identification for requests received over a Unix domain
+// socket.
+package unixsocket
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// DefaultIdentityHeader is the default header clients use to identify the instance a request
+// received over a Unix domain socket is made on behalf of.
+const DefaultIdentityHeader = "X-Hegel-Instance-IP"
+
+// Options configures Identity.
+type Options struct {
+	// IdentityHeader is the name of a header containing the IP address of the instance a request is
+	// made on behalf of. When empty, headers are not used to identify instances.
+	IdentityHeader string
+
+	// IdentityIP is the IP address of the instance used for requests that don't specify an identity
+	// using IdentityHeader. When empty, requests must specify an identity using IdentityHeader.
+	IdentityIP string
+}
+
+// Validate validates opts.
+func (opts Options) Validate() error {
+	if opts.IdentityIP != "" && net.ParseIP(opts.IdentityIP) == nil {
+		return fmt.Errorf("invalid unix socket identity ip: %v", opts.IdentityIP)
+	}
+	return nil
+}
+
+// Identity returns the IP of the instance r, received over a Unix domain socket, is made on
+// behalf of. The remote address of requests received over a Unix domain socket is meaningless so
+// the identity is taken from the Options.IdentityHeader header, if present, else
+// Options.IdentityIP. It returns false for requests received over TCP and requests without an
+// identity.
+func Identity(r *http.Request, opts Options) (net.IP, bool) {
+	if !isUnixSocketRequest(r) {
+		return nil, false
+	}
+
+	identity := opts.IdentityIP
+	if opts.IdentityHeader != "" {
+		if v := r.Header.Get(opts.IdentityHeader); v != "" {
+			identity = v
+		}
+	}
+
+	ip := net.ParseIP(identity)
+	return ip, ip != nil
+}
+
+func isUnixSocketRequest(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
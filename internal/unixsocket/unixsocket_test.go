@@ -0,0 +1,157 @@
+package unixsocket_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tinkerbell/hegel/internal/backend/flatfile"
+	"github.com/tinkerbell/hegel/internal/frontend/ec2"
+	"github.com/tinkerbell/hegel/internal/identity"
+	. "github.com/tinkerbell/hegel/internal/unixsocket"
+)
+
+func init() {
+	gin.SetMode(gin.ReleaseMode)
+}
+
+const instances = `
+- metadata:
+    id: instance-a
+    ipv4:
+      public: 10.10.10.10
+- metadata:
+    id: instance-b
+    ipv4:
+      public: 10.10.10.11
+`
+
+func TestIdentityOverUnixSocket(t *testing.T) {
+	cases := []struct {
+		Name       string
+		Options    Options
+		Header     string
+		ExpectCode int
+		ExpectBody string
+	}{
+		{
+			Name:       "ConfiguredIdentity",
+			Options:    Options{IdentityIP: "10.10.10.10"},
+			ExpectCode: http.StatusOK,
+			ExpectBody: "instance-a",
+		},
+		{
+			Name:       "HeaderIdentity",
+			Options:    Options{IdentityHeader: DefaultIdentityHeader},
+			Header:     "10.10.10.11",
+			ExpectCode: http.StatusOK,
+			ExpectBody: "instance-b",
+		},
+		{
+			Name:       "HeaderOverridesConfiguredIdentity",
+			Options:    Options{IdentityHeader: DefaultIdentityHeader, IdentityIP: "10.10.10.10"},
+			Header:     "10.10.10.11",
+			ExpectCode: http.StatusOK,
+			ExpectBody: "instance-b",
+		},
+		{
+			Name:       "HeaderIgnoredWhenNotConfigured",
+			Options:    Options{IdentityIP: "10.10.10.10"},
+			Header:     "10.10.10.11",
+			ExpectCode: http.StatusOK,
+			ExpectBody: "instance-a",
+		},
+		{
+			Name:       "NoIdentity",
+			Options:    Options{IdentityHeader: DefaultIdentityHeader},
+			ExpectCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.Name, func(t *testing.T) {
+			s, err := identity.UnixSocket(tc.Options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := serveUnixSocket(t, identity.Middleware(s))
+
+			req, err := http.NewRequest(http.MethodGet, "http://hegel/2009-04-04/meta-data/instance-id", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.Header != "" {
+				req.Header.Set(DefaultIdentityHeader, tc.Header)
+			}
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.ExpectCode {
+				t.Fatalf("Expected status: %v; Received status: %v", tc.ExpectCode, resp.StatusCode)
+			}
+
+			if tc.ExpectCode != http.StatusOK {
+				return
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(body) != tc.ExpectBody {
+				t.Fatalf("Expected: %v; Received: %v", tc.ExpectBody, string(body))
+			}
+		})
+	}
+}
+
+func TestValidateInvalidIdentityIP(t *testing.T) {
+	if err := (Options{IdentityIP: "invalid"}).Validate(); err == nil {
+		t.Fatal("Expected error, received nil")
+	}
+}
+
+// serveUnixSocket serves the EC2 frontend, backed by instances, over a Unix socket with mw
+// installed. It returns a client that dials the socket.
+func serveUnixSocket(t *testing.T, mw gin.HandlerFunc) *http.Client {
+	t.Helper()
+
+	backend, err := flatfile.FromYAML(strings.NewReader(instances))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(mw)
+	ec2.New(backend).Configure(router)
+
+	socket := filepath.Join(t.TempDir(), "hegel.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := http.Server{Handler: router} //nolint:gosec // Test server.
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+}
@@ -1,14 +1,9 @@
 package xff_test
 
 import (
-	"fmt"
-	"net/http"
-	"net/http/httptest"
 	"testing"
 
-	"github.com/gin-gonic/gin"
 	"github.com/google/go-cmp/cmp"
-	"github.com/tinkerbell/hegel/internal/ginutil"
 	. "github.com/tinkerbell/hegel/internal/xff"
 )
 
@@ -120,99 +115,3 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
-
-func TestMiddleware(t *testing.T) {
-	cases := []struct {
-		Name               string
-		AllowedSubnets     []string
-		RemoteAddr         string
-		XFFAddr            string
-		ExpectedRemoteAddr string
-		Err                bool
-	}{
-		{
-			Name:               "XFF with trusted range",
-			AllowedSubnets:     []string{"192.168.0.0/16"},
-			RemoteAddr:         "192.168.0.1:0",
-			XFFAddr:            "10.10.10.10",
-			ExpectedRemoteAddr: "10.10.10.10:0",
-		},
-		{
-			Name:               "XFF with single trusted IP",
-			AllowedSubnets:     []string{"192.168.0.1/32"},
-			RemoteAddr:         "192.168.0.1:0",
-			XFFAddr:            "10.10.10.10",
-			ExpectedRemoteAddr: "10.10.10.10:0",
-		},
-		{
-			Name:               "XFF without trusted IP",
-			AllowedSubnets:     []string{"192.168.0.0/16"},
-			RemoteAddr:         "192.178.0.1:0",
-			XFFAddr:            "10.10.10.10",
-			ExpectedRemoteAddr: "192.178.0.1:0",
-		},
-		{
-			Name:               "No XFF with trusted range",
-			AllowedSubnets:     []string{"192.168.0.0/16"},
-			RemoteAddr:         "192.168.0.1:0",
-			ExpectedRemoteAddr: "192.168.0.1:0",
-		},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.Name, func(t *testing.T) {
-			// Build the request with the XFF header if specified.
-			req := httptest.NewRequest("GET", "/", nil)
-			req.RemoteAddr = tc.RemoteAddr
-			if tc.XFFAddr != "" {
-				req.Header.Set("X-Forwarded-For", tc.XFFAddr)
-			}
-
-			w := ginutil.FakeResponseWriter{ResponseRecorder: httptest.NewRecorder()}
-
-			ctx := &gin.Context{
-				Request: req,
-				Writer:  w,
-			}
-
-			// Build the middleware.
-			mw, err := Middleware(tc.AllowedSubnets)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			// Serve and check the results.
-			mw(ctx)
-
-			if w.Code != http.StatusOK {
-				t.Fatalf("unexpected status code: %d", w.Code)
-			}
-
-			// The request is passed through as a pointer so we can check the original request.
-			if req.RemoteAddr != tc.ExpectedRemoteAddr {
-				t.Fatalf(
-					"unexpected remote addr: got %s, want %s",
-					req.RemoteAddr,
-					tc.ExpectedRemoteAddr,
-				)
-			}
-		})
-	}
-}
-
-func TestMiddlewareInvalidSubnets(t *testing.T) {
-	cases := []string{
-		"dsadsa",
-		"256.256.256.256/16",
-		"192.168.0.0/33",
-	}
-
-	for i, subnet := range cases {
-		t.Run(fmt.Sprintf("%v", i), func(t *testing.T) {
-			_, err := Middleware([]string{subnet})
-			if err == nil {
-				t.Fatal("expected error, got nil")
-			}
-		})
-	}
-}